@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
 
 	"github.com/BenHesketh21/universal-packages/internal/oci"
 	"github.com/BenHesketh21/universal-packages/internal/packages"
@@ -21,7 +22,7 @@ var installCmd = &cobra.Command{
 		ctx := context.Background()
 		fmt.Println("🧰 Pulling", ref)
 		client := &oci.OrasClientImpl{}
-		workingDir, err := oci.Pull(ctx, client, ref, "./.universal-packages")
+		workingDir, err := oci.Pull(ctx, client, ref, packages.InstallDir)
 		if err != nil {
 			log.Fatalf("could not pull OCI artefact %q: %v", ref, err)
 		}
@@ -63,7 +64,20 @@ var installCmd = &cobra.Command{
 			os.Exit(1)
 		}
 
-		if err := handler.UpdatePackageRef(packageName, filePath, "."); err != nil {
+		options := packages.InstallOptions{
+			Workspace: cmd.Flag("workspace").Value.String(),
+			Section:   cmd.Flag("section").Value.String(),
+			Override:  cmd.Flag("override").Value.String(),
+		}
+		if configurableHandler, ok := handler.(packages.ConfigurablePackageHandler); ok {
+			options.Reference = ref
+			err = configurableHandler.UpdatePackageRefWithOptions(packageName, filePath, ".", options)
+		} else if options != (packages.InstallOptions{}) {
+			err = fmt.Errorf("install options are not supported for package type %q", packageType)
+		} else {
+			err = handler.UpdatePackageRef(packageName, filePath, ".")
+		}
+		if err != nil {
 			log.Fatalf("error updating package reference: %v", err)
 			os.Exit(1)
 		}
@@ -75,10 +89,13 @@ var installCmd = &cobra.Command{
 func init() {
 	rootCmd.AddCommand(installCmd)
 
-	installCmd.Flags().String("type", "", "Package type (npm|pip|nuget)")
+	installCmd.Flags().String("type", "", "Package type ("+strings.Join(packages.SupportedTypes(), "|")+")")
 	if err := installCmd.MarkFlagRequired("type"); err != nil {
 		log.Fatalf("could not mark 'type' flag as required: %v", err)
 	}
 	installCmd.Flags().String("package-name", "", "Name of the package to install, inferred from the package reference if not provided")
 	installCmd.Flags().String("package-version", "", "Version of the package to install, inferred from the package reference if not provided")
+	installCmd.Flags().String("workspace", "", "Name or directory of the workspace package to add the dependency to (npm)")
+	installCmd.Flags().String("section", "", "Section of the package manifest to add the dependency to, e.g. devDependencies (npm)")
+	installCmd.Flags().String("override", "", "How to pin the dependency to the pulled package: local_path (default) or archive (bazel), path (default) or url (zig)")
 }
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
 
 	"github.com/BenHesketh21/universal-packages/internal/oci"
 	"github.com/BenHesketh21/universal-packages/internal/packages"
@@ -48,13 +49,26 @@ var pushCmd = &cobra.Command{
 		if err != nil {
 			return fmt.Errorf("unsupported type %q: %w", packageType, err)
 		}
+		// Files built from the project, such as source archives, are only needed until they are pushed
+		defer func() {
+			if err := packages.RemoveOutputDirs(); err != nil {
+				fmt.Fprintf(os.Stderr, "warning: failed to remove temporary package files: %v\n", err)
+			}
+		}()
 
-		filePath, err := handler.LocatePackage(".", packageName, packageVersion)
+		var filePaths []string
+		if multiFileHandler, ok := handler.(packages.MultiFilePackageHandler); ok {
+			filePaths, err = multiFileHandler.LocatePackageFiles(".", packageName, packageVersion)
+		} else {
+			var filePath string
+			filePath, err = handler.LocatePackage(".", packageName, packageVersion)
+			filePaths = []string{filePath}
+		}
 		if err != nil {
 			return fmt.Errorf("could not resolve file for %q: %w", packageName, err)
 		}
 		client := &oci.OrasClientImpl{}
-		err = oci.Push(ctx, client, ref, filePath)
+		err = oci.Push(ctx, client, ref, filePaths...)
 		if err != nil {
 			return fmt.Errorf("push failed: %w", err)
 		}
@@ -65,7 +79,7 @@ var pushCmd = &cobra.Command{
 }
 
 func init() {
-	pushCmd.Flags().String("type", "", "Package type (e.g., npm, pip, nuget) [required]")
+	pushCmd.Flags().String("type", "", "Package type (e.g., "+strings.Join(packages.SupportedTypes(), ", ")+") [required]")
 	if err := pushCmd.MarkFlagRequired("type"); err != nil {
 		panic(err)
 	}
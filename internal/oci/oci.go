@@ -69,7 +69,11 @@ func Pull(ctx context.Context, client OrasClient, ref string, upRootDir string)
 	return workingDir, nil
 }
 
-func Push(ctx context.Context, orasClient OrasClient, ref string, tarballPath string) error {
+func Push(ctx context.Context, orasClient OrasClient, ref string, filePaths ...string) error {
+	if len(filePaths) == 0 {
+		return fmt.Errorf("no files to push")
+	}
+
 	// 0. Create a file store
 	fs, err := file.New("")
 	if err != nil {
@@ -81,18 +85,20 @@ func Push(ctx context.Context, orasClient OrasClient, ref string, tarballPath st
 		}
 	}()
 
-	// 1. Add files to the file store
-	fileName, err := getBaseName(tarballPath)
-	if err != nil {
-		return fmt.Errorf("failed to get base name from tarball path: %w", err)
-	}
+	// 1. Add files to the file store, one layer per file
 	mediaType := "application/vnd.test.file"
-	fileDescriptors := make([]v1.Descriptor, 0, 1)
-	fileDescriptor, err := fs.Add(ctx, fileName, mediaType, tarballPath)
-	if err != nil {
-		return fmt.Errorf("failed to add tarball: %w", err)
+	fileDescriptors := make([]v1.Descriptor, 0, len(filePaths))
+	for _, filePath := range filePaths {
+		fileName, err := getBaseName(filePath)
+		if err != nil {
+			return fmt.Errorf("failed to get base name from file path: %w", err)
+		}
+		fileDescriptor, err := fs.Add(ctx, fileName, mediaType, filePath)
+		if err != nil {
+			return fmt.Errorf("failed to add file %s: %w", fileName, err)
+		}
+		fileDescriptors = append(fileDescriptors, fileDescriptor)
 	}
-	fileDescriptors = append(fileDescriptors, fileDescriptor)
 
 	// 2. Pack the files and tag the packed manifest
 	artifactType := "application/vnd.test.artifact"
@@ -104,6 +104,42 @@ func TestPush(t *testing.T) {
 	}
 }
 
+func TestPushMultipleFiles(t *testing.T) {
+	dir := "../../testdata"
+
+	// Create temp file for install location
+	tempDir, err := os.MkdirTemp(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tempDir); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to remove temp folder: %v\n", err)
+		}
+	}() // Clean up
+
+	// Create fake Go module proxy files in the directory
+	var filePaths []string
+	for _, name := range []string{"v1.0.0.zip", "v1.0.0.mod", "v1.0.0.info"} {
+		filePath := filepath.Join(tempDir, name)
+		if err := os.WriteFile(filePath, []byte("{}"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		filePaths = append(filePaths, filePath)
+	}
+
+	client := &FakeOrasClient{}
+	ctx := context.Background()
+
+	if err := Push(ctx, client, "localhost:5000/myorg/mypackage:1.0.0", filePaths...); err != nil {
+		t.Fatalf("failed to push package: %v", err)
+	}
+
+	if err := Push(ctx, client, "localhost:5000/myorg/mypackage:1.0.0"); err == nil {
+		t.Error("expected error when pushing no files, got nil")
+	}
+}
+
 func TestGetPackageNameVersionFromRef(t *testing.T) {
 	testCases := []struct {
 		ref             string
@@ -129,6 +165,12 @@ func TestGetPackageNameVersionFromRef(t *testing.T) {
 			expectedVersion: "1.0.0",
 			expectedError:   false,
 		},
+		{
+			ref:             "ghcr.io/myorg/com.example/mypackage:1.0.0",
+			expectedName:    "com.example/mypackage",
+			expectedVersion: "1.0.0",
+			expectedError:   false,
+		},
 		{
 			ref:             "localhost:5000/mypackage",
 			expectedName:    "mypackage",
@@ -0,0 +1,235 @@
+package packages
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type AnsibleHandler struct{}
+
+// ansibleManifest is the part of the MANIFEST.json of a collection artifact that identifies the collection.
+type ansibleManifest struct {
+	CollectionInfo struct {
+		Namespace string `json:"namespace"`
+		Name      string `json:"name"`
+		Version   string `json:"version"`
+	} `json:"collection_info"`
+}
+
+// LocatePackage finds the collection artifact written by `ansible-galaxy collection build`,
+// <namespace>-<name>-<version>.tar.gz, in the specified directory. The package name may be given as
+// namespace.name or namespace-name.
+func (a *AnsibleHandler) LocatePackage(dir string, packageName string, packageVersion string) (string, error) {
+	namespace, name, err := splitAnsibleCollectionName(packageName)
+	if err != nil {
+		return "", err
+	}
+	filename := fmt.Sprintf("%s-%s-%s.tar.gz", namespace, name, packageVersion)
+	packagePath := filepath.Join(dir, filename)
+	if !fileExists(packagePath) {
+		return "", fmt.Errorf("expected package file not found: %s", filename)
+	}
+	return packagePath, nil
+}
+
+// UpdatePackageRef stores the collection artifact next to the nearest requirements.yml and adds or updates
+// its entry in the collections list as a `type: file` requirement, so that `ansible-galaxy collection install
+// -r requirements.yml` installs it. The collection is also installed into the project's collections path,
+// so playbooks can use it straight away. Comments and ordering in requirements.yml are preserved.
+func (a *AnsibleHandler) UpdatePackageRef(packageName string, packageFilePath string, packageRefFilePath string) error {
+	requirementsPath, err := findFileUp(packageRefFilePath, "requirements.yml", "requirements.yaml",
+		"collections/requirements.yml", "collections/requirements.yaml")
+	if err != nil {
+		return fmt.Errorf("finding requirements.yml: %w", err)
+	}
+	// Projects run by AWX keep their requirements in collections/, and run ansible-galaxy from the project root
+	projectDir := filepath.Dir(requirementsPath)
+	if filepath.Base(projectDir) == "collections" {
+		projectDir = filepath.Dir(projectDir)
+	}
+
+	manifestData, err := readTarGzFile(packageFilePath, "MANIFEST.json")
+	if err != nil {
+		return err
+	}
+	var manifest ansibleManifest
+	if err := json.Unmarshal(manifestData, &manifest); err != nil {
+		return fmt.Errorf("parsing MANIFEST.json of %s: %w", filepath.Base(packageFilePath), err)
+	}
+	info := manifest.CollectionInfo
+	namespace, name, err := splitAnsibleCollectionName(packageName)
+	if err != nil {
+		return err
+	}
+	if info.Namespace != namespace || info.Name != name {
+		return fmt.Errorf("package %s contains collection %s.%s", packageName, info.Namespace, info.Name)
+	}
+
+	storeDir := filepath.Join(projectDir, InstallDir, "ansible-collections")
+	if err := removeOtherVersions(storeDir, namespace+"-"+name+"-", ".tar.gz", filepath.Base(packageFilePath)); err != nil {
+		return err
+	}
+	storedPath, err := copyFile(packageFilePath, storeDir)
+	if err != nil {
+		return fmt.Errorf("storing collection: %w", err)
+	}
+
+	collectionsDir, err := ansibleCollectionsDir(projectDir)
+	if err != nil {
+		return err
+	}
+	if err := extractTarGz(packageFilePath, filepath.Join(collectionsDir, namespace, name), 0); err != nil {
+		return fmt.Errorf("installing collection: %w", err)
+	}
+
+	relPath, err := filepath.Rel(projectDir, storedPath)
+	if err != nil {
+		return fmt.Errorf("calculating relative path: %w", err)
+	}
+	data, err := os.ReadFile(requirementsPath)
+	if err != nil {
+		return err
+	}
+	updated := setAnsibleFileRequirement(string(data), namespace, name, filepath.ToSlash(relPath))
+	return os.WriteFile(requirementsPath, []byte(updated), 0644)
+}
+
+// splitAnsibleCollectionName splits a collection name given as namespace.name or namespace-name. Neither
+// part may contain dots or hyphens.
+func splitAnsibleCollectionName(packageName string) (string, string, error) {
+	namespace, name, ok := strings.Cut(packageName, ".")
+	if !ok {
+		namespace, name, ok = strings.Cut(packageName, "-")
+	}
+	if !ok || namespace == "" || name == "" || strings.ContainsAny(namespace+name, ".-") {
+		return "", "", fmt.Errorf("invalid collection name %s, expected namespace.name", packageName)
+	}
+	return namespace, name, nil
+}
+
+// ansibleCollectionsDir returns the ansible_collections directory that collections of the project are
+// installed into: under the first collections path set in the project's ansible.cfg, or under collections/,
+// which Ansible searches next to playbooks.
+func ansibleCollectionsDir(projectDir string) (string, error) {
+	collectionsPath := "collections"
+	cfgPath := filepath.Join(projectDir, "ansible.cfg")
+	if data, err := os.ReadFile(cfgPath); err == nil {
+		section := ""
+		for _, line := range strings.Split(string(data), "\n") {
+			line = strings.TrimSpace(line)
+			if strings.HasPrefix(line, "[") {
+				section = line
+				continue
+			}
+			key, value, ok := strings.Cut(line, "=")
+			key = strings.TrimSpace(key)
+			if ok && section == "[defaults]" && (key == "collections_path" || key == "collections_paths") {
+				collectionsPath, _, _ = strings.Cut(strings.TrimSpace(value), string(os.PathListSeparator))
+			}
+		}
+	}
+
+	if rest, ok := strings.CutPrefix(collectionsPath, "~"); ok {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("resolving collections path %s: %w", collectionsPath, err)
+		}
+		collectionsPath = filepath.Join(home, rest)
+	} else if !filepath.IsAbs(collectionsPath) {
+		// Relative paths in ansible.cfg are relative to the file
+		collectionsPath = filepath.Join(projectDir, collectionsPath)
+	}
+	if filepath.Base(collectionsPath) == "ansible_collections" {
+		return collectionsPath, nil
+	}
+	return filepath.Join(collectionsPath, "ansible_collections"), nil
+}
+
+// setAnsibleFileRequirement replaces the first requirement of the collection in the collections list with a
+// `type: file` requirement on the artifact at artifactPath, removing any other requirement of it, or appends
+// one. Requirements match by collection name, or by the file name of a file or url artifact.
+func setAnsibleFileRequirement(content string, namespace string, name string, artifactPath string) string {
+	lines := strings.Split(content, "\n")
+
+	// The original format of requirements.yml is a bare list of roles
+	for i, line := range lines {
+		if yamlIsBlank(line) || line == "---" {
+			continue
+		}
+		if strings.HasPrefix(line, "-") {
+			lines = yamlInsertLines(lines, i, "roles:")
+		}
+		break
+	}
+
+	keyIdx := yamlFindKey(lines, 0, len(lines), 0, "collections")
+	if keyIdx == -1 {
+		at := len(lines)
+		if lines[at-1] == "" {
+			at--
+		}
+		entry := ansibleFileRequirement(2, 4, artifactPath)
+		return strings.Join(yamlInsertLines(lines, at, append([]string{"collections:"}, entry...)...), "\n")
+	}
+	if _, raw, _ := yamlKeyValue(lines[keyIdx], 0); yamlScalar(raw) == "[]" {
+		lines[keyIdx] = "collections:"
+	}
+
+	end := yamlBlockEnd(lines, keyIdx)
+	items := yamlSequenceItems(lines, keyIdx+1, end)
+	var matches []yamlItem
+	for _, item := range items {
+		if isAnsibleCollectionRequirement(lines, item, namespace, name) {
+			matches = append(matches, item)
+		}
+	}
+	if len(matches) > 0 {
+		// Items are replaced from the end so the positions of earlier ones stay valid
+		for i := len(matches) - 1; i >= 0; i-- {
+			item := matches[i]
+			var entry []string
+			if i == 0 {
+				entry = ansibleFileRequirement(yamlIndent(lines[item.Start]), yamlItemKeyIndent(lines, item), artifactPath)
+			}
+			lines = append(lines[:item.Start], append(entry, lines[item.End:]...)...)
+		}
+		return strings.Join(lines, "\n")
+	}
+
+	dashIndent, keyIndent := 2, 4
+	if len(items) > 0 {
+		dashIndent = yamlIndent(lines[items[0].Start])
+		keyIndent = yamlItemKeyIndent(lines, items[0])
+	}
+	return strings.Join(yamlInsertLines(lines, end, ansibleFileRequirement(dashIndent, keyIndent, artifactPath)...), "\n")
+}
+
+// isAnsibleCollectionRequirement reports whether the collections list item requires the given collection,
+// either by name or as an artifact file named after it.
+func isAnsibleCollectionRequirement(lines []string, item yamlItem, namespace string, name string) bool {
+	keyIndent := yamlItemKeyIndent(lines, item)
+	value := ""
+	if _, _, ok := yamlKeyValue(lines[item.Start], keyIndent); ok {
+		_, value = yamlItemValue(lines, item, "name")
+	} else {
+		// Plain "- namespace.name" item
+		value = yamlScalar(lines[item.Start][keyIndent:])
+	}
+	if value == namespace+"."+name {
+		return true
+	}
+	filename := value[strings.LastIndex(value, "/")+1:]
+	version, ok := strings.CutPrefix(strings.TrimSuffix(filename, ".tar.gz"), namespace+"-"+name+"-")
+	return ok && strings.HasSuffix(filename, ".tar.gz") && version != "" && version[0] >= '0' && version[0] <= '9'
+}
+
+// ansibleFileRequirement returns the lines of a collections list item requiring the artifact at artifactPath.
+func ansibleFileRequirement(dashIndent int, keyIndent int, artifactPath string) []string {
+	return []string{
+		strings.Repeat(" ", dashIndent) + "-" + strings.Repeat(" ", keyIndent-dashIndent-1) + "name: " + yamlQuote(artifactPath),
+		strings.Repeat(" ", keyIndent) + "type: file",
+	}
+}
@@ -0,0 +1,298 @@
+package packages
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAnsibleLocatePackage(t *testing.T) {
+	handler := &AnsibleHandler{}
+	dir := "../../testdata"
+
+	tempDir, err := os.MkdirTemp(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tempDir); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to remove temp folder: %v\n", err)
+		}
+	}() // Clean up
+
+	// Mimic the output of `ansible-galaxy collection build`
+	packagePath := filepath.Join(tempDir, "acme-network-2.3.0.tar.gz")
+	writeTestTarGz(t, packagePath, map[string]string{
+		"MANIFEST.json": `{"collection_info": {"namespace": "acme", "name": "network", "version": "2.3.0"}}`,
+	})
+
+	testCases := []struct {
+		name           string
+		packageName    string
+		packageVersion string
+		expectedPath   string
+		expectedError  bool
+	}{
+		{
+			name:           "find collection by fully qualified name",
+			packageName:    "acme.network",
+			packageVersion: "2.3.0",
+			expectedPath:   packagePath,
+		},
+		{
+			name:           "find collection by artifact name",
+			packageName:    "acme-network",
+			packageVersion: "2.3.0",
+			expectedPath:   packagePath,
+		},
+		{
+			name:           "fail on non-existing version",
+			packageName:    "acme.network",
+			packageVersion: "2.4.0",
+			expectedError:  true,
+		},
+		{
+			name:           "fail on name without namespace",
+			packageName:    "network",
+			packageVersion: "2.3.0",
+			expectedError:  true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			filePath, err := handler.LocatePackage(tempDir, testCase.packageName, testCase.packageVersion)
+			if testCase.expectedError {
+				if err == nil {
+					t.Fatal("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if filePath != testCase.expectedPath {
+				t.Errorf("expected %s, got %s", testCase.expectedPath, filePath)
+			}
+		})
+	}
+}
+
+func TestAnsibleUpdatePackageRef(t *testing.T) {
+	testCases := []struct {
+		name                 string
+		files                map[string]string
+		requirementsPath     string
+		expected             string
+		expectedInstallDir   string
+		expectedRemovedFiles []string
+	}{
+		{
+			name: "adds collections key",
+			files: map[string]string{
+				"requirements.yml": `---
+roles:
+  - name: geerlingguy.docker
+    version: 7.1.0
+`,
+			},
+			requirementsPath: "requirements.yml",
+			expected: `---
+roles:
+  - name: geerlingguy.docker
+    version: 7.1.0
+collections:
+  - name: .universal-packages/ansible-collections/acme-network-2.3.0.tar.gz
+    type: file
+`,
+			expectedInstallDir: "collections/ansible_collections/acme/network",
+		},
+		{
+			name: "appends to collections",
+			files: map[string]string{
+				"requirements.yml": `collections:
+# Public collections
+- name: community.general
+  version: ">=8.0.0"
+- ansible.posix
+
+roles: []
+`,
+			},
+			requirementsPath: "requirements.yml",
+			expected: `collections:
+# Public collections
+- name: community.general
+  version: ">=8.0.0"
+- ansible.posix
+- name: .universal-packages/ansible-collections/acme-network-2.3.0.tar.gz
+  type: file
+
+roles: []
+`,
+			expectedInstallDir: "collections/ansible_collections/acme/network",
+		},
+		{
+			name: "replaces galaxy requirement and removes duplicate",
+			files: map[string]string{
+				"requirements.yml": `collections:
+    - name: acme.network # internal
+      version: 2.2.0
+      source: https://galaxy.acme.internal
+    - name: community.general
+    - acme.network
+`,
+			},
+			requirementsPath: "requirements.yml",
+			expected: `collections:
+    - name: .universal-packages/ansible-collections/acme-network-2.3.0.tar.gz
+      type: file
+    - name: community.general
+`,
+			expectedInstallDir: "collections/ansible_collections/acme/network",
+		},
+		{
+			name: "replaces previous version",
+			files: map[string]string{
+				"requirements.yml": `collections:
+  - name: .universal-packages/ansible-collections/acme-network-2.2.0.tar.gz
+    type: file
+  - name: .universal-packages/ansible-collections/acme-network_tools-1.0.0.tar.gz
+    type: file
+`,
+				".universal-packages/ansible-collections/acme-network-2.2.0.tar.gz":       "",
+				".universal-packages/ansible-collections/acme-network_tools-1.0.0.tar.gz": "",
+				"collections/ansible_collections/acme/network/plugins/old.py":             "",
+			},
+			requirementsPath: "requirements.yml",
+			expected: `collections:
+  - name: .universal-packages/ansible-collections/acme-network-2.3.0.tar.gz
+    type: file
+  - name: .universal-packages/ansible-collections/acme-network_tools-1.0.0.tar.gz
+    type: file
+`,
+			expectedInstallDir: "collections/ansible_collections/acme/network",
+			expectedRemovedFiles: []string{
+				".universal-packages/ansible-collections/acme-network-2.2.0.tar.gz",
+				"collections/ansible_collections/acme/network/plugins/old.py",
+			},
+		},
+		{
+			name: "converts roles list",
+			files: map[string]string{
+				"requirements.yml": `---
+- src: geerlingguy.docker
+  version: 7.1.0
+`,
+			},
+			requirementsPath: "requirements.yml",
+			expected: `---
+roles:
+- src: geerlingguy.docker
+  version: 7.1.0
+collections:
+  - name: .universal-packages/ansible-collections/acme-network-2.3.0.tar.gz
+    type: file
+`,
+			expectedInstallDir: "collections/ansible_collections/acme/network",
+		},
+		{
+			name: "requirements in collections directory",
+			files: map[string]string{
+				"collections/requirements.yml": "collections: []\n",
+				"ansible.cfg":                  "[defaults]\ninventory = inventory\ncollections_path = ./vendor/collections:~/.ansible/collections\n",
+			},
+			requirementsPath: "collections/requirements.yml",
+			expected: `collections:
+  - name: .universal-packages/ansible-collections/acme-network-2.3.0.tar.gz
+    type: file
+`,
+			expectedInstallDir: "vendor/collections/ansible_collections/acme/network",
+		},
+	}
+
+	handler := &AnsibleHandler{}
+	dir := "../../testdata"
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			installTempDir, err := os.MkdirTemp(dir, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				if err := os.RemoveAll(installTempDir); err != nil {
+					fmt.Fprintf(os.Stderr, "warning: failed to remove temp folder: %v\n", err)
+				}
+			}() // Clean up
+
+			for name, content := range testCase.files {
+				path := filepath.Join(installTempDir, filepath.FromSlash(name))
+				if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			packageLocation := filepath.Join(installTempDir, InstallDir, "network", "acme-network-2.3.0.tar.gz")
+			writeTestTarGz(t, packageLocation, map[string]string{
+				"MANIFEST.json":           `{"collection_info": {"namespace": "acme", "name": "network", "version": "2.3.0"}}`,
+				"plugins/modules/vlan.py": "",
+			})
+
+			if err := handler.UpdatePackageRef("acme.network", packageLocation, installTempDir); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			updated, err := os.ReadFile(filepath.Join(installTempDir, filepath.FromSlash(testCase.requirementsPath)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(updated) != testCase.expected {
+				t.Errorf("expected:\n%s\ngot:\n%s", testCase.expected, updated)
+			}
+
+			if !fileExists(filepath.Join(installTempDir, InstallDir, "ansible-collections", "acme-network-2.3.0.tar.gz")) {
+				t.Error("expected collection artifact to be stored")
+			}
+			installDir := filepath.Join(installTempDir, filepath.FromSlash(testCase.expectedInstallDir))
+			if !fileExists(filepath.Join(installDir, "plugins", "modules", "vlan.py")) {
+				t.Errorf("expected collection to be installed into %s", testCase.expectedInstallDir)
+			}
+			for _, name := range testCase.expectedRemovedFiles {
+				if fileExists(filepath.Join(installTempDir, filepath.FromSlash(name))) {
+					t.Errorf("expected %s to be removed", name)
+				}
+			}
+		})
+	}
+}
+
+func TestAnsibleUpdatePackageRefMismatchedCollection(t *testing.T) {
+	handler := &AnsibleHandler{}
+	dir := "../../testdata"
+
+	installTempDir, err := os.MkdirTemp(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(installTempDir); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to remove temp folder: %v\n", err)
+		}
+	}() // Clean up
+
+	if err := os.WriteFile(filepath.Join(installTempDir, "requirements.yml"), []byte("collections: []\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	packageLocation := filepath.Join(installTempDir, "acme-network-2.3.0.tar.gz")
+	writeTestTarGz(t, packageLocation, map[string]string{
+		"MANIFEST.json": `{"collection_info": {"namespace": "acme", "name": "firewall", "version": "2.3.0"}}`,
+	})
+
+	if err := handler.UpdatePackageRef("acme.network", packageLocation, installTempDir); err == nil {
+		t.Fatal("expected error, got nil")
+	}
+}
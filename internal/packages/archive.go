@@ -0,0 +1,270 @@
+package packages
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// extractTarGz extracts a gzip-compressed tarball into destDir, which is replaced if it already exists.
+// The first strip leading path components of every entry are removed, like tar --strip-components.
+func extractTarGz(archivePath string, destDir string, strip int) error {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", filepath.Base(archivePath), err)
+	}
+	defer func() {
+		_ = gz.Close()
+	}()
+	return extractTar(gz, destDir, strip)
+}
+
+// readTarGzFile returns the content of the file with the given name in a gzip-compressed tarball.
+func readTarGzFile(archivePath string, name string) ([]byte, error) {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", filepath.Base(archivePath), err)
+	}
+	defer func() {
+		_ = gz.Close()
+	}()
+	return readTarFile(gz, filepath.Base(archivePath), name)
+}
+
+// readTarFile returns the content of the file with the given name in the tar stream r, read from the archive
+// named archiveName.
+func readTarFile(r io.Reader, archiveName string, name string) ([]byte, error) {
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("%s contains no %s", archiveName, name)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", archiveName, err)
+		}
+		if header.Typeflag == tar.TypeReg && path.Clean(header.Name) == name {
+			return io.ReadAll(tr)
+		}
+	}
+}
+
+// readTarGzRootFile returns the content of the file with the given name at the root of a gzip-compressed
+// tarball, or else in its top-level directory, as in release archives of a project, along with the name of
+// that directory ("" for the root).
+func readTarGzRootFile(archivePath string, name string) (string, []byte, error) {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return "", nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return "", nil, fmt.Errorf("reading %s: %w", filepath.Base(archivePath), err)
+	}
+	defer func() {
+		_ = gz.Close()
+	}()
+	var prefix string
+	var data []byte
+	found := false
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", nil, fmt.Errorf("reading %s: %w", filepath.Base(archivePath), err)
+		}
+		dir, file := path.Split(path.Clean(strings.TrimPrefix(header.Name, "/")))
+		dir = strings.TrimSuffix(dir, "/")
+		if header.Typeflag != tar.TypeReg || file != name || strings.Contains(dir, "/") || (found && dir != "") {
+			continue
+		}
+		if data, err = io.ReadAll(tr); err != nil {
+			return "", nil, fmt.Errorf("reading %s: %w", filepath.Base(archivePath), err)
+		}
+		prefix, found = dir, true
+		if dir == "" {
+			// A file at the root wins over files in subdirectories, such as examples
+			break
+		}
+	}
+	if !found {
+		return "", nil, fmt.Errorf("%s contains no %s", filepath.Base(archivePath), name)
+	}
+	return prefix, data, nil
+}
+
+// extractTar extracts the tar stream r into destDir. See extractTarGz.
+// Only directories and regular files are extracted; entries escaping destDir are rejected.
+func extractTar(r io.Reader, destDir string, strip int) error {
+	if err := os.RemoveAll(destDir); err != nil {
+		return fmt.Errorf("removing %s: %w", destDir, err)
+	}
+	if err := os.MkdirAll(destDir, 0755); err != nil {
+		return fmt.Errorf("creating %s: %w", destDir, err)
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("reading archive: %w", err)
+		}
+
+		target, ok, err := archiveEntryPath(destDir, header.Name, strip)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := writeArchiveFile(target, tr, header.FileInfo().Mode()); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// archiveEntryPath maps an archive entry name to a path within destDir, after stripping leading components.
+// It reports false for entries that are stripped away entirely.
+func archiveEntryPath(destDir string, name string, strip int) (string, bool, error) {
+	cleaned := path.Clean(strings.TrimPrefix(filepath.ToSlash(name), "/"))
+	parts := strings.Split(cleaned, "/")
+	if len(parts) <= strip {
+		return "", false, nil
+	}
+	rel := path.Join(parts[strip:]...)
+	if rel == "." {
+		return "", false, nil
+	}
+	if rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", false, fmt.Errorf("archive entry %s escapes destination", name)
+	}
+	return filepath.Join(destDir, filepath.FromSlash(rel)), true, nil
+}
+
+// writeArchiveFile writes the contents of r to target, creating parent directories as needed.
+func writeArchiveFile(target string, r io.Reader, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode.Perm()|0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// createTarGz writes the contents of srcDir to a gzip-compressed tarball at archivePath, with entry names
+// relative to srcDir. Entries for which skip returns true are left out, along with their contents if they
+// are directories. skip is given the slash-separated relative path.
+func createTarGz(srcDir string, archivePath string, skip func(rel string, entry os.DirEntry) bool) error {
+	out, err := os.Create(archivePath)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(out)
+	tw := tar.NewWriter(gz)
+
+	err = filepath.WalkDir(srcDir, func(p string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(srcDir, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if skip != nil && skip(rel, entry) {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !entry.IsDir() && !entry.Type().IsRegular() {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = rel
+		if entry.IsDir() {
+			header.Name += "/"
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = f.Close()
+		}()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err == nil {
+		err = tw.Close()
+	}
+	if err == nil {
+		err = gz.Close()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("creating %s: %w", filepath.Base(archivePath), err)
+	}
+	return nil
+}
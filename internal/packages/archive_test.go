@@ -0,0 +1,167 @@
+package packages
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+// writeTestTarGz writes a gzip-compressed tarball at path containing the given files.
+func writeTestTarGz(t *testing.T, path string, files map[string]string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		header := &tar.Header{Name: name, Mode: 0644, Size: int64(len(files[name])), Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(files[name])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeTestZip writes a zip archive at path containing the given files.
+func writeTestZip(t *testing.T, path string, files map[string]string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	w := zip.NewWriter(f)
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fw, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fw.Write([]byte(files[name])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExtractTarGz(t *testing.T) {
+	dir := "../../testdata"
+
+	tempDir, err := os.MkdirTemp(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tempDir); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to remove temp folder: %v\n", err)
+		}
+	}() // Clean up
+
+	testCases := []struct {
+		name          string
+		files         map[string]string
+		strip         int
+		expectedFiles []string
+		expectedError bool
+	}{
+		{
+			name:          "strips leading directory",
+			files:         map[string]string{"package/package.json": "{}", "package/lib/index.js": ""},
+			strip:         1,
+			expectedFiles: []string{"lib/index.js", "package.json"},
+		},
+		{
+			name:          "keeps paths without strip",
+			files:         map[string]string{"a.txt": "a", "b/c.txt": "c"},
+			expectedFiles: []string{"a.txt", "b/c.txt"},
+		},
+		{
+			name:          "rejects entries escaping the destination",
+			files:         map[string]string{"../evil.txt": "evil"},
+			expectedError: true,
+		},
+	}
+
+	for i, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			archivePath := filepath.Join(tempDir, fmt.Sprintf("archive-%d.tgz", i))
+			writeTestTarGz(t, archivePath, testCase.files)
+
+			destDir := filepath.Join(tempDir, fmt.Sprintf("out-%d", i))
+			// Stale files from a previous install are removed
+			if err := os.MkdirAll(destDir, 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(destDir, "stale.txt"), []byte("stale"), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			err := extractTarGz(archivePath, destDir, testCase.strip)
+			if testCase.expectedError {
+				if err == nil {
+					t.Fatal("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var extracted []string
+			err = filepath.WalkDir(destDir, func(path string, entry os.DirEntry, err error) error {
+				if err != nil || entry.IsDir() {
+					return err
+				}
+				rel, err := filepath.Rel(destDir, path)
+				extracted = append(extracted, filepath.ToSlash(rel))
+				return err
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fmt.Sprint(extracted) != fmt.Sprint(testCase.expectedFiles) {
+				t.Errorf("expected %v, got %v", testCase.expectedFiles, extracted)
+			}
+		})
+	}
+}
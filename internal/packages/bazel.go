@@ -0,0 +1,264 @@
+package packages
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"oras.land/oras-go/v2/registry"
+)
+
+type BazelHandler struct{}
+
+var (
+	// bazelOverrideCalls are the MODULE.bazel calls that choose where a module comes from. A module may only
+	// have one of them.
+	bazelOverrideCalls  = []string{"archive_override", "git_override", "local_path_override", "single_version_override", "multiple_version_override"}
+	bazelModuleCall     = regexp.MustCompile(`(?m)^[ \t]*module[ \t]*\(`)
+	bazelDepCall        = regexp.MustCompile(`(?m)^[ \t]*bazel_dep[ \t]*\(`)
+	bazelOverrideCall   = regexp.MustCompile(`(?m)^[ \t]*(` + strings.Join(bazelOverrideCalls, "|") + `)[ \t]*\(`)
+	bazelVersionPattern = regexp.MustCompile(`\bversion\s*=\s*("[^"]*"|'[^']*')`)
+)
+
+// starlarkCall is a top-level function call in a Starlark file such as MODULE.bazel, spanning
+// content[Start:End] with its arguments in content[Open+1:End-1].
+type starlarkCall struct {
+	Start int
+	Open  int
+	End   int
+}
+
+// LocatePackage finds <name>-<version>.tar.gz in the specified directory. If it is not present but the
+// directory holds a MODULE.bazel, the module directory itself is packaged into a temporary archive, leaving
+// out Bazel's output symlinks and lockfile.
+func (b *BazelHandler) LocatePackage(dir string, packageName string, packageVersion string) (string, error) {
+	filename := fmt.Sprintf("%s-%s.tar.gz", packageName, packageVersion)
+	packagePath := filepath.Join(dir, filename)
+	if fileExists(packagePath) {
+		return packagePath, nil
+	}
+	if !fileExists(filepath.Join(dir, "MODULE.bazel")) {
+		return "", fmt.Errorf("expected package file not found: %s", filename)
+	}
+
+	outDir, err := newOutputDir("bazel")
+	if err != nil {
+		return "", err
+	}
+	packagePath = filepath.Join(outDir, filename)
+	if err := createTarGz(dir, packagePath, skipBazelEntry); err != nil {
+		return "", err
+	}
+	return packagePath, nil
+}
+
+// UpdatePackageRef adds the module to the nearest MODULE.bazel as a bazel_dep, and pins it to the pulled archive
+// with a local_path_override on the extracted module. See UpdatePackageRefWithOptions.
+func (b *BazelHandler) UpdatePackageRef(packageName string, packageFilePath string, packageRefFilePath string) error {
+	return b.UpdatePackageRefWithOptions(packageName, packageFilePath, packageRefFilePath, InstallOptions{})
+}
+
+// UpdatePackageRefWithOptions is UpdatePackageRef with install options. With the archive override, the module is
+// pinned with an archive_override instead, which has Bazel fetch the archive from the registry's blob URL for the
+// reference the package was pulled from, so that MODULE.bazel can be committed. Its integrity is the sha256 of the
+// pulled archive, which is also the blob's digest. Any other override of the module is replaced.
+func (b *BazelHandler) UpdatePackageRefWithOptions(packageName string, packageFilePath string, packageRefFilePath string, options InstallOptions) error {
+	if options.Workspace != "" || options.Section != "" {
+		return fmt.Errorf("workspace and section are not supported for Bazel modules")
+	}
+	if options.Override != "" && options.Override != "local_path" && options.Override != "archive" {
+		return fmt.Errorf("unsupported override %q, expected local_path or archive", options.Override)
+	}
+
+	modulePath, err := findFileUp(packageRefFilePath, "MODULE.bazel")
+	if err != nil {
+		return fmt.Errorf("finding MODULE.bazel: %w", err)
+	}
+	moduleDir := filepath.Dir(modulePath)
+
+	prefix, moduleFile, err := readTarGzRootFile(packageFilePath, "MODULE.bazel")
+	if err != nil {
+		return err
+	}
+	name, version := bazelModuleInfo(string(moduleFile))
+	if name != packageName {
+		return fmt.Errorf("%s contains module %q, not %s", filepath.Base(packageFilePath), name, packageName)
+	}
+	if version == "" {
+		version = strings.TrimPrefix(strings.TrimSuffix(filepath.Base(packageFilePath), ".tar.gz"), packageName+"-")
+	}
+
+	var override []string
+	if options.Override == "archive" {
+		data, err := os.ReadFile(packageFilePath)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(data)
+		url, err := ociBlobURL(options.Reference, "sha256:"+hex.EncodeToString(sum[:]))
+		if err != nil {
+			return fmt.Errorf("archive override: %w", err)
+		}
+		override = []string{
+			"archive_override(",
+			"    module_name = " + strconv.Quote(packageName) + ",",
+			"    urls = [" + strconv.Quote(url) + "],",
+			"    integrity = " + strconv.Quote("sha256-"+base64.StdEncoding.EncodeToString(sum[:])) + ",",
+		}
+		if prefix != "" {
+			override = append(override, "    strip_prefix = "+strconv.Quote(prefix)+",")
+		}
+	} else {
+		packageDir := filepath.Join(moduleDir, InstallDir, "bazel-modules", packageName)
+		strip := 0
+		if prefix != "" {
+			strip = 1
+		}
+		if err := extractTarGz(packageFilePath, packageDir, strip); err != nil {
+			return fmt.Errorf("extracting module: %w", err)
+		}
+		relPath, err := filepath.Rel(moduleDir, packageDir)
+		if err != nil {
+			return fmt.Errorf("calculating relative path: %w", err)
+		}
+		override = []string{
+			"local_path_override(",
+			"    module_name = " + strconv.Quote(packageName) + ",",
+			"    path = " + strconv.Quote(filepath.ToSlash(relPath)) + ",",
+		}
+	}
+	override = append(override, ")")
+
+	data, err := os.ReadFile(modulePath)
+	if err != nil {
+		return err
+	}
+	updated := setBazelDep(string(data), packageName, version, strings.Join(override, "\n"))
+	return os.WriteFile(modulePath, []byte(updated), 0644)
+}
+
+// ociBlobURL returns the URL of a blob in the repository of an OCI reference, as served by the registry's
+// distribution API. Docker Hub serves the API from registry-1.docker.io.
+func ociBlobURL(reference string, digest string) (string, error) {
+	if reference == "" {
+		return "", fmt.Errorf("the reference the package was pulled from is needed for its blob URL")
+	}
+	ref, err := registry.ParseReference(reference)
+	if err != nil {
+		return "", err
+	}
+	host := ref.Registry
+	if host == "docker.io" {
+		host = "registry-1.docker.io"
+	}
+	return "https://" + host + "/v2/" + ref.Repository + "/blobs/" + digest, nil
+}
+
+// skipBazelEntry reports whether a file or directory of a module should be left out of its archive.
+func skipBazelEntry(rel string, entry os.DirEntry) bool {
+	name := path.Base(rel)
+	if entry.IsDir() {
+		return name == ".git" || name == InstallDir || strings.HasPrefix(rel, "bazel-")
+	}
+	return rel == "MODULE.bazel.lock"
+}
+
+// bazelModuleInfo returns the name and version declared by the module() call of a MODULE.bazel.
+func bazelModuleInfo(content string) (string, string) {
+	code := maskHashComments(content)
+	calls := starlarkCalls(code, bazelModuleCall)
+	if len(calls) == 0 {
+		return "", ""
+	}
+	args := code[calls[0].Open+1 : calls[0].End-1]
+	return starlarkStringArg(args, "name"), starlarkStringArg(args, "version")
+}
+
+// setBazelDep replaces the first override of the module with the given one, removing any other, and sets the
+// version of the module's bazel_dep, or adds one after the last bazel_dep. Without an existing override, the new
+// one follows the bazel_dep.
+func setBazelDep(content string, moduleName string, version string, override string) string {
+	code := maskHashComments(content)
+	var overrides []starlarkCall
+	for _, call := range starlarkCalls(code, bazelOverrideCall) {
+		if starlarkStringArg(code[call.Open+1:call.End-1], "module_name") == moduleName {
+			overrides = append(overrides, call)
+		}
+	}
+	// Overrides are replaced from the end so the positions of earlier ones stay valid
+	for i := len(overrides) - 1; i >= 0; i-- {
+		call := overrides[i]
+		if i == 0 {
+			content = content[:call.Start] + override + content[call.End:]
+			continue
+		}
+		end := call.End
+		if end < len(content) && content[end] == '\n' {
+			end++
+		}
+		content = content[:call.Start] + content[end:]
+	}
+	if len(overrides) > 0 {
+		override = ""
+	} else {
+		override = "\n" + override
+	}
+
+	code = maskHashComments(content)
+	deps := starlarkCalls(code, bazelDepCall)
+	for _, call := range deps {
+		if starlarkStringArg(code[call.Open+1:call.End-1], "name") != moduleName {
+			continue
+		}
+		args := content[call.Open+1 : call.End-1]
+		if loc := bazelVersionPattern.FindStringSubmatchIndex(code[call.Open+1 : call.End-1]); loc != nil {
+			args = args[:loc[2]] + strconv.Quote(version) + args[loc[3]:]
+		} else {
+			args = strings.TrimRight(args, " \t\n,") + ", version = " + strconv.Quote(version)
+		}
+		return content[:call.Open+1] + args + ")" + override + content[call.End:]
+	}
+
+	dep := "bazel_dep(name = " + strconv.Quote(moduleName) + ", version = " + strconv.Quote(version) + ")" + override
+	if len(deps) > 0 {
+		last := deps[len(deps)-1].End
+		return content[:last] + "\n" + dep + content[last:]
+	}
+	content = strings.TrimRight(content, "\n")
+	if content != "" {
+		content += "\n\n"
+	}
+	return content + dep + "\n"
+}
+
+// starlarkCalls returns the calls whose start matches pattern in code, which must have its comments masked.
+func starlarkCalls(code string, pattern *regexp.Regexp) []starlarkCall {
+	var calls []starlarkCall
+	for _, loc := range pattern.FindAllStringIndex(code, -1) {
+		open := loc[1] - 1
+		end := closingBracket(code, open)
+		if end == -1 {
+			continue
+		}
+		start := loc[0] + len(code[loc[0]:open]) - len(strings.TrimLeft(code[loc[0]:open], " \t"))
+		calls = append(calls, starlarkCall{Start: start, Open: open, End: end + 1})
+	}
+	return calls
+}
+
+// starlarkStringArg returns the string value of a keyword argument in a call's arguments, or "" if it is not
+// given as a string literal.
+func starlarkStringArg(args string, key string) string {
+	match := regexp.MustCompile(`(^|[^\w])` + regexp.QuoteMeta(key) + `\s*=\s*("[^"]*"|'[^']*')`).FindStringSubmatch(args)
+	if match == nil {
+		return ""
+	}
+	return match[2][1 : len(match[2])-1]
+}
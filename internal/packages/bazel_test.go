@@ -0,0 +1,362 @@
+package packages
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBazelLocatePackage(t *testing.T) {
+	handler := &BazelHandler{}
+	dir := "../../testdata"
+
+	tempDir, err := os.MkdirTemp(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tempDir); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to remove temp folder: %v\n", err)
+		}
+	}() // Clean up
+
+	packagePath := filepath.Join(tempDir, "rules_acme-1.2.0.tar.gz")
+	writeTestTarGz(t, packagePath, map[string]string{"MODULE.bazel": `module(name = "rules_acme", version = "1.2.0")`})
+
+	moduleDir := filepath.Join(tempDir, "rules_acme")
+	for name, content := range map[string]string{
+		"MODULE.bazel":      `module(name = "rules_acme", version = "1.3.0")`,
+		"MODULE.bazel.lock": "{}",
+		"acme/defs.bzl":     "",
+	} {
+		path := filepath.Join(moduleDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	testCases := []struct {
+		name           string
+		dir            string
+		packageVersion string
+		expectedPath   string
+		expectedFiles  []string
+		expectedError  bool
+	}{
+		{
+			name:           "find module archive",
+			dir:            tempDir,
+			packageVersion: "1.2.0",
+			expectedPath:   packagePath,
+		},
+		{
+			name:           "package module directory",
+			dir:            moduleDir,
+			packageVersion: "1.3.0",
+			expectedFiles:  []string{"MODULE.bazel", "acme/defs.bzl"},
+		},
+		{
+			name:           "fail on non-existing archive",
+			dir:            tempDir,
+			packageVersion: "1.3.0",
+			expectedError:  true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			filePath, err := handler.LocatePackage(testCase.dir, "rules_acme", testCase.packageVersion)
+			if testCase.expectedError {
+				if err == nil {
+					t.Fatal("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if testCase.expectedPath != "" && filePath != testCase.expectedPath {
+				t.Errorf("expected %s, got %s", testCase.expectedPath, filePath)
+			}
+			if testCase.expectedFiles == nil {
+				return
+			}
+			defer func() {
+				_ = os.RemoveAll(filepath.Dir(filePath))
+			}()
+			if filepath.Base(filePath) != "rules_acme-1.3.0.tar.gz" {
+				t.Errorf("unexpected archive name %s", filepath.Base(filePath))
+			}
+			extracted := filepath.Join(tempDir, "extracted")
+			if err := extractTarGz(filePath, extracted, 0); err != nil {
+				t.Fatal(err)
+			}
+			for _, name := range testCase.expectedFiles {
+				if !fileExists(filepath.Join(extracted, filepath.FromSlash(name))) {
+					t.Errorf("expected %s in archive", name)
+				}
+			}
+			if fileExists(filepath.Join(extracted, "MODULE.bazel.lock")) {
+				t.Error("expected MODULE.bazel.lock to be left out of archive")
+			}
+		})
+	}
+}
+
+func TestBazelUpdatePackageRef(t *testing.T) {
+	testCases := []struct {
+		name          string
+		input         string
+		override      string
+		prefix        string
+		expected      string
+		expectedError bool
+	}{
+		{
+			name: "adds bazel_dep and local_path_override",
+			input: `module(name = "infra", version = "0.1.0")
+
+bazel_dep(name = "rules_go", version = "0.46.0")
+bazel_dep(name = "gazelle", version = "0.35.0", repo_name = "bazel_gazelle")
+
+go_sdk = use_extension("@rules_go//go:extensions.bzl", "go_sdk")
+`,
+			expected: `module(name = "infra", version = "0.1.0")
+
+bazel_dep(name = "rules_go", version = "0.46.0")
+bazel_dep(name = "gazelle", version = "0.35.0", repo_name = "bazel_gazelle")
+bazel_dep(name = "rules_acme", version = "1.2.0")
+local_path_override(
+    module_name = "rules_acme",
+    path = ".universal-packages/bazel-modules/rules_acme",
+)
+
+go_sdk = use_extension("@rules_go//go:extensions.bzl", "go_sdk")
+`,
+		},
+		{
+			name: "updates bazel_dep and replaces overrides",
+			input: `module(name = "infra")
+
+bazel_dep(
+    name = "rules_acme",
+    version = "1.1.0",  # internal rules
+    repo_name = "acme",
+)
+
+git_override(
+    module_name = "rules_acme",
+    remote = "https://git.acme.internal/rules_acme.git",
+    commit = "1f2e3d4c",
+)
+single_version_override(module_name = "rules_acme", patches = ["//:acme.patch"])
+`,
+			expected: `module(name = "infra")
+
+bazel_dep(
+    name = "rules_acme",
+    version = "1.2.0",  # internal rules
+    repo_name = "acme",
+)
+
+local_path_override(
+    module_name = "rules_acme",
+    path = ".universal-packages/bazel-modules/rules_acme",
+)
+`,
+		},
+		{
+			name: "adds first bazel_dep",
+			input: `module(name = "infra")
+`,
+			expected: `module(name = "infra")
+
+bazel_dep(name = "rules_acme", version = "1.2.0")
+local_path_override(
+    module_name = "rules_acme",
+    path = ".universal-packages/bazel-modules/rules_acme",
+)
+`,
+		},
+		{
+			name: "adds version to bazel_dep of archive with top-level directory",
+			input: `bazel_dep(name = "rules_acme")
+# local_path_override(module_name = "rules_acme", path = "../rules_acme")
+`,
+			override: "local_path",
+			prefix:   "rules_acme-1.2.0/",
+			expected: `bazel_dep(name = "rules_acme", version = "1.2.0")
+local_path_override(
+    module_name = "rules_acme",
+    path = ".universal-packages/bazel-modules/rules_acme",
+)
+# local_path_override(module_name = "rules_acme", path = "../rules_acme")
+`,
+		},
+		{
+			name: "adds version and archive_override on the registry blob",
+			input: `bazel_dep(name = "rules_acme")
+# local_path_override(module_name = "rules_acme", path = "../rules_acme")
+`,
+			override: "archive",
+			prefix:   "rules_acme-1.2.0/",
+			expected: `bazel_dep(name = "rules_acme", version = "1.2.0")
+archive_override(
+    module_name = "rules_acme",
+    urls = ["https://ghcr.io/v2/myorg/rules_acme/blobs/sha256:%[1]x"],
+    integrity = "sha256-%[2]s",
+    strip_prefix = "rules_acme-1.2.0",
+)
+# local_path_override(module_name = "rules_acme", path = "../rules_acme")
+`,
+		},
+		{
+			name: "fails on unsupported override",
+			input: `module(name = "infra")
+`,
+			override:      "git",
+			expectedError: true,
+		},
+	}
+
+	handler := &BazelHandler{}
+	dir := "../../testdata"
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			installTempDir, err := os.MkdirTemp(dir, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				if err := os.RemoveAll(installTempDir); err != nil {
+					fmt.Fprintf(os.Stderr, "warning: failed to remove temp folder: %v\n", err)
+				}
+			}() // Clean up
+
+			modulePath := filepath.Join(installTempDir, "MODULE.bazel")
+			if err := os.WriteFile(modulePath, []byte(testCase.input), 0644); err != nil {
+				t.Fatal(err)
+			}
+			packageLocation := filepath.Join(installTempDir, InstallDir, "rules_acme", "rules_acme-1.2.0.tar.gz")
+			writeTestTarGz(t, packageLocation, map[string]string{
+				testCase.prefix + "MODULE.bazel":          `module(name = "rules_acme", version = "1.2.0")`,
+				testCase.prefix + "acme/defs.bzl":         "",
+				testCase.prefix + "examples/MODULE.bazel": `module(name = "examples")`,
+			})
+
+			options := InstallOptions{Override: testCase.override, Reference: "ghcr.io/myorg/rules_acme:1.2.0"}
+			err = handler.UpdatePackageRefWithOptions("rules_acme", packageLocation, installTempDir, options)
+			if testCase.expectedError {
+				if err == nil {
+					t.Fatal("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			expected := testCase.expected
+			if testCase.override == "archive" {
+				// The integrity and the blob's digest are both the sha256 of the pulled archive
+				data, err := os.ReadFile(packageLocation)
+				if err != nil {
+					t.Fatal(err)
+				}
+				sum := sha256.Sum256(data)
+				expected = fmt.Sprintf(expected, sum, base64.StdEncoding.EncodeToString(sum[:]))
+			} else if !fileExists(filepath.Join(installTempDir, InstallDir, "bazel-modules", "rules_acme", "acme", "defs.bzl")) {
+				t.Error("expected module to be extracted")
+			}
+
+			updated, err := os.ReadFile(modulePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(updated) != expected {
+				t.Errorf("expected:\n%s\ngot:\n%s", expected, updated)
+			}
+		})
+	}
+}
+
+func TestBazelUpdatePackageRefMismatchedModule(t *testing.T) {
+	handler := &BazelHandler{}
+	dir := "../../testdata"
+
+	installTempDir, err := os.MkdirTemp(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(installTempDir); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to remove temp folder: %v\n", err)
+		}
+	}() // Clean up
+
+	if err := os.WriteFile(filepath.Join(installTempDir, "MODULE.bazel"), []byte(`module(name = "infra")`+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	packageLocation := filepath.Join(installTempDir, "rules_acme-1.2.0.tar.gz")
+	writeTestTarGz(t, packageLocation, map[string]string{"MODULE.bazel": `module(name = "rules_other")`})
+
+	err = handler.UpdatePackageRef("rules_acme", packageLocation, installTempDir)
+	if err == nil || !strings.Contains(err.Error(), "rules_other") {
+		t.Fatalf("expected error naming the archive's module, got %v", err)
+	}
+}
+
+func TestOCIBlobURL(t *testing.T) {
+	testCases := []struct {
+		name          string
+		reference     string
+		expected      string
+		expectedError bool
+	}{
+		{
+			name:      "nested repository",
+			reference: "ghcr.io/myorg/bazel/rules_acme:1.2.0",
+			expected:  "https://ghcr.io/v2/myorg/bazel/rules_acme/blobs/sha256:abc",
+		},
+		{
+			name:      "registry with port",
+			reference: "localhost:5000/rules_acme:1.2.0",
+			expected:  "https://localhost:5000/v2/rules_acme/blobs/sha256:abc",
+		},
+		{
+			name:      "docker hub",
+			reference: "docker.io/myorg/rules_acme:1.2.0",
+			expected:  "https://registry-1.docker.io/v2/myorg/rules_acme/blobs/sha256:abc",
+		},
+		{
+			name:          "fail without reference",
+			expectedError: true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			url, err := ociBlobURL(testCase.reference, "sha256:abc")
+			if testCase.expectedError {
+				if err == nil {
+					t.Fatal("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if url != testCase.expected {
+				t.Errorf("expected %s, got %s", testCase.expected, url)
+			}
+		})
+	}
+}
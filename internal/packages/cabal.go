@@ -0,0 +1,420 @@
+package packages
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+type CabalHandler struct{}
+
+var (
+	cabalFieldPattern       = regexp.MustCompile(`^([ \t]*)([A-Za-z][A-Za-z0-9_-]*)[ \t]*:(.*)$`)
+	cabalStanzaPattern      = regexp.MustCompile(`(?i)^(library|executable|test-suite|benchmark|foreign-library|common)([ \t]+\S+)?[ \t]*$`)
+	cabalDependencyPattern  = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9-]*`)
+	cabalFreezeEntryPattern = regexp.MustCompile(`(any\.([A-Za-z0-9-]+)[ \t]+==)[ \t]*[0-9][0-9.]*`)
+)
+
+// cabalField is a field of a .cabal or cabal.project file, with its value spanning lines[Start:End].
+type cabalField struct {
+	Name   string
+	Indent int
+	Start  int
+	End    int
+}
+
+// LocatePackage finds <name>-<version>.tar.gz in the specified directory, or in dist-newstyle/sdist/ where
+// `cabal sdist` writes it.
+func (c *CabalHandler) LocatePackage(dir string, packageName string, packageVersion string) (string, error) {
+	filename := fmt.Sprintf("%s-%s.tar.gz", packageName, packageVersion)
+	for _, searchDir := range []string{dir, filepath.Join(dir, "dist-newstyle", "sdist")} {
+		packagePath := filepath.Join(searchDir, filename)
+		if fileExists(packagePath) {
+			return packagePath, nil
+		}
+	}
+	return "", fmt.Errorf("expected package file not found: %s", filename)
+}
+
+// UpdatePackageRef unpacks the sdist next to the nearest cabal.project and adds it to the project's packages,
+// replacing any other local package of the same name, and adds the package to the build-depends of the
+// library of the nearest .cabal file, or its first executable. Existing dependencies on the package, in any
+// component, get their version bounds updated instead, as does its constraint in cabal.project.freeze. Without
+// a cabal.project, one is created listing the .cabal file's package and the sdist.
+func (c *CabalHandler) UpdatePackageRef(packageName string, packageFilePath string, packageRefFilePath string) error {
+	cabalPath, err := findFileUp(packageRefFilePath, "*.cabal")
+	if err != nil {
+		return fmt.Errorf("finding .cabal file: %w", err)
+	}
+	projectPath, err := findFileUp(filepath.Dir(cabalPath), "cabal.project")
+	if err != nil {
+		projectPath = filepath.Join(filepath.Dir(cabalPath), "cabal.project")
+	}
+	projectDir := filepath.Dir(projectPath)
+
+	prefix, description, err := readTarGzRootFile(packageFilePath, packageName+".cabal")
+	if err != nil {
+		return err
+	}
+	fields := cabalFields(strings.Split(string(description), "\n"), 0, -1)
+	if name := cabalFieldValue(string(description), fields, "name"); name != packageName {
+		return fmt.Errorf("%s contains package %q, not %s", filepath.Base(packageFilePath), name, packageName)
+	}
+	version := cabalFieldValue(string(description), fields, "version")
+
+	packageDir := filepath.Join(projectDir, InstallDir, "cabal-packages", packageName)
+	strip := 0
+	if prefix != "" {
+		strip = 1
+	}
+	if err := extractTarGz(packageFilePath, packageDir, strip); err != nil {
+		return fmt.Errorf("extracting package: %w", err)
+	}
+	relPath, err := filepath.Rel(projectDir, packageDir)
+	if err != nil {
+		return fmt.Errorf("calculating relative path: %w", err)
+	}
+	cabalDir, err := filepath.Rel(projectDir, filepath.Dir(cabalPath))
+	if err != nil {
+		return fmt.Errorf("calculating relative path: %w", err)
+	}
+
+	data, err := os.ReadFile(cabalPath)
+	if err != nil {
+		return err
+	}
+	updated, err := setCabalDependency(string(data), packageName, "^>="+version)
+	if err != nil {
+		return err
+	}
+
+	project := ""
+	if fileExists(projectPath) {
+		data, err := os.ReadFile(projectPath)
+		if err != nil {
+			return err
+		}
+		project = string(data)
+	}
+	// A local package directory is identified by the .cabal file inside it
+	isOtherCopy := func(entry string) bool {
+		return fileExists(filepath.Join(projectDir, filepath.FromSlash(entry), packageName+".cabal"))
+	}
+	updatedProject := addCabalProjectPackage(project, cabalPackageDir(cabalDir), cabalPackageDir(filepath.ToSlash(relPath)), isOtherCopy)
+	if err := os.WriteFile(projectPath, []byte(updatedProject), 0644); err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(cabalPath, []byte(updated), 0644); err != nil {
+		return err
+	}
+	freezePath := projectPath + ".freeze"
+	if !fileExists(freezePath) {
+		return nil
+	}
+	freeze, err := os.ReadFile(freezePath)
+	if err != nil {
+		return err
+	}
+	updatedFreeze := cabalFreezeEntryPattern.ReplaceAllStringFunc(string(freeze), func(entry string) string {
+		match := cabalFreezeEntryPattern.FindStringSubmatch(entry)
+		if match[2] != packageName {
+			return entry
+		}
+		return match[1] + version
+	})
+	return os.WriteFile(freezePath, []byte(updatedFreeze), 0644)
+}
+
+// cabalPackageDir returns a directory relative to cabal.project as an entry of its packages field.
+func cabalPackageDir(rel string) string {
+	if rel == "." {
+		return "./"
+	}
+	return rel + "/"
+}
+
+// cabalFields returns the fields of a .cabal or cabal.project file at the given indent, starting at lines[from]
+// and ending before the first line indented less, or at the indent of the first field found if indent is -1.
+// A field's value continues on the following lines that are indented deeper than its name.
+func cabalFields(lines []string, from int, indent int) []cabalField {
+	var fields []cabalField
+	for i := from; i < len(lines); i++ {
+		line := lines[i]
+		if cabalIsBlank(line) {
+			continue
+		}
+		lineIndent := yamlIndent(line)
+		if indent == -1 {
+			indent = lineIndent
+		}
+		if lineIndent < indent {
+			break
+		}
+		if lineIndent > indent {
+			continue
+		}
+		match := cabalFieldPattern.FindStringSubmatch(line)
+		if match == nil {
+			// A section such as a conditional or stanza
+			continue
+		}
+		end := i + 1
+		for j := i + 1; j < len(lines); j++ {
+			if cabalIsBlank(lines[j]) {
+				continue
+			}
+			if yamlIndent(lines[j]) <= indent {
+				break
+			}
+			end = j + 1
+		}
+		fields = append(fields, cabalField{Name: strings.ToLower(match[2]), Indent: lineIndent, Start: i, End: end})
+		i = end - 1
+	}
+	return fields
+}
+
+// cabalFieldValue returns the value of the named field, with its lines joined by spaces, or "" if there is none.
+func cabalFieldValue(content string, fields []cabalField, name string) string {
+	lines := strings.Split(content, "\n")
+	for _, field := range fields {
+		if field.Name != name {
+			continue
+		}
+		value := []string{strings.TrimSpace(cabalFieldPattern.FindStringSubmatch(lines[field.Start])[3])}
+		for _, line := range lines[field.Start+1 : field.End] {
+			if !cabalIsBlank(line) {
+				value = append(value, strings.TrimSpace(line))
+			}
+		}
+		return strings.TrimSpace(strings.Join(value, " "))
+	}
+	return ""
+}
+
+// cabalIsBlank reports whether line holds no content, only whitespace or a -- comment.
+func cabalIsBlank(line string) bool {
+	trimmed := strings.TrimSpace(line)
+	return trimmed == "" || strings.HasPrefix(trimmed, "--")
+}
+
+// addCabalProjectPackage adds entry to the packages field of a cabal.project, removing other entries for
+// which isOtherCopy reports true. A new packages field lists the project's own package too.
+func addCabalProjectPackage(content string, ownPackage string, entry string, isOtherCopy func(string) bool) string {
+	lines := strings.Split(content, "\n")
+	for _, field := range cabalFields(lines, 0, 0) {
+		if field.Name != "packages" {
+			continue
+		}
+		// New entries follow the layout of the field as it was, even if the entries on its last line are removed
+		last := lines[field.End-1]
+		multiLine, indent := field.End-field.Start > 1, last[:yamlIndent(last)]
+		found := false
+		for i := field.End - 1; i >= field.Start; i-- {
+			line := lines[i]
+			valueStart := 0
+			if i == field.Start {
+				valueStart = len(line) - len(cabalFieldPattern.FindStringSubmatch(line)[3])
+			}
+			if cabalIsBlank(line[valueStart:]) {
+				continue
+			}
+			var kept []string
+			for _, value := range strings.Fields(line[valueStart:]) {
+				switch {
+				case path.Clean(value) == path.Clean(entry):
+					found = true
+					kept = append(kept, value)
+				case strings.ContainsAny(value, "*?[{") || !isOtherCopy(value):
+					kept = append(kept, value)
+				}
+			}
+			prefix := line[:len(line)-len(strings.TrimLeft(line[valueStart:], " \t"))]
+			if len(kept) == 0 && i != field.Start {
+				lines = append(lines[:i], lines[i+1:]...)
+				field.End--
+				continue
+			}
+			lines[i] = strings.TrimRight(prefix+strings.Join(kept, " "), " \t")
+		}
+		if found {
+			return strings.Join(lines, "\n")
+		}
+		if !multiLine {
+			lines[field.Start] = strings.TrimRight(lines[field.Start], " \t") + " " + entry
+			return strings.Join(lines, "\n")
+		}
+		return strings.Join(yamlInsertLines(lines, field.End, indent+entry), "\n")
+	}
+
+	packages := []string{"packages: " + ownPackage, "          " + entry}
+	if strings.TrimSpace(content) == "" {
+		return strings.Join(packages, "\n") + "\n"
+	}
+	return strings.Join(append(packages, ""), "\n") + "\n" + content
+}
+
+// setCabalDependency sets the version range of every dependency on the package in the build-depends of a
+// .cabal file. If the library, or else the first executable, does not depend on the package, it is added to
+// its build-depends, following the style of the existing entries.
+func setCabalDependency(content string, packageName string, versionRange string) (string, error) {
+	lines := strings.Split(content, "\n")
+	dependency := packageName + " " + versionRange
+
+	library, executable := -1, -1
+	var stanzas []int
+	for i, line := range lines {
+		match := cabalStanzaPattern.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		stanzas = append(stanzas, i)
+		switch kind := strings.ToLower(match[1]); {
+		case kind == "library" && match[2] == "" && library == -1:
+			library = i
+		case kind == "executable" && executable == -1:
+			executable = i
+		}
+	}
+	target := library
+	if target == -1 {
+		target = executable
+	}
+	if target == -1 {
+		return "", fmt.Errorf("no library or executable found in .cabal file")
+	}
+
+	// Existing dependencies, in any component or conditional, get the new version range
+	targetDepends := false
+	for i, line := range lines {
+		start := 0
+		if match := cabalFieldPattern.FindStringSubmatch(line); match != nil {
+			if strings.ToLower(match[2]) != "build-depends" {
+				continue
+			}
+			start = len(line) - len(match[3])
+		} else if !cabalIsContinuation(lines, i, "build-depends") {
+			continue
+		}
+		updated, changed := setCabalDependencyRange(line, start, packageName, dependency)
+		if changed && cabalStanzaOf(stanzas, i) == target {
+			targetDepends = true
+		}
+		lines[i] = updated
+	}
+	if targetDepends {
+		return strings.Join(lines, "\n"), nil
+	}
+
+	fields := cabalFields(lines, target+1, -1)
+	for _, field := range fields {
+		if field.Name != "build-depends" {
+			continue
+		}
+		return strings.Join(appendCabalDependency(lines, field, dependency), "\n"), nil
+	}
+	if len(fields) == 0 {
+		return "", fmt.Errorf("no fields found in stanza %q of .cabal file", strings.TrimSpace(lines[target]))
+	}
+	at := yamlTrimBlankTail(lines, target, cabalStanzaEnd(lines, target))
+	indent := strings.Repeat(" ", fields[0].Indent)
+	return strings.Join(yamlInsertLines(lines, at, indent+"build-depends: "+dependency), "\n"), nil
+}
+
+// setCabalDependencyRange replaces the entries for the package in the comma-separated dependencies in
+// line[start:] with dependency, reporting whether there were any.
+func setCabalDependencyRange(line string, start int, packageName string, dependency string) (string, bool) {
+	changed := false
+	parts := strings.Split(line[start:], ",")
+	for i, part := range parts {
+		trimmed := strings.TrimSpace(part)
+		if cabalDependencyPattern.FindString(trimmed) != packageName || strings.Contains(trimmed, "{") {
+			continue
+		}
+		leading := part[:len(part)-len(strings.TrimLeft(part, " \t"))]
+		trailing := part[len(strings.TrimRight(part, " \t")):]
+		if i == len(parts)-1 {
+			trailing = ""
+		}
+		parts[i] = leading + dependency + trailing
+		changed = true
+	}
+	return line[:start] + strings.Join(parts, ","), changed
+}
+
+// appendCabalDependency adds dependency as the last entry of the build-depends field, on a line of its own in
+// the leading-comma or trailing-comma style of a multi-line field.
+func appendCabalDependency(lines []string, field cabalField, dependency string) []string {
+	value := cabalFieldPattern.FindStringSubmatch(lines[field.Start])[3]
+	if field.End-field.Start == 1 {
+		if strings.TrimSpace(value) == "" {
+			lines[field.Start] = strings.TrimRight(lines[field.Start], " \t") + " " + dependency
+		} else {
+			lines[field.Start] = strings.TrimRight(lines[field.Start], " \t") + ", " + dependency
+		}
+		return lines
+	}
+
+	last := field.End - 1
+	for cabalIsBlank(lines[last]) {
+		last--
+	}
+	for i := field.Start + 1; i < field.End; i++ {
+		if trimmed := strings.TrimSpace(lines[i]); strings.HasPrefix(trimmed, ",") {
+			return yamlInsertLines(lines, last+1, lines[i][:yamlIndent(lines[i])]+", "+dependency)
+		}
+	}
+	if !strings.HasSuffix(strings.TrimRight(lines[last], " \t"), ",") {
+		lines[last] = strings.TrimRight(lines[last], " \t") + ","
+	}
+	indent := lines[last][:yamlIndent(lines[last])]
+	return yamlInsertLines(lines, last+1, indent+dependency)
+}
+
+// cabalIsContinuation reports whether lines[i] continues the value of a field with the given name.
+func cabalIsContinuation(lines []string, i int, name string) bool {
+	if cabalIsBlank(lines[i]) {
+		return false
+	}
+	indent := yamlIndent(lines[i])
+	for j := i - 1; j >= 0; j-- {
+		if cabalIsBlank(lines[j]) {
+			continue
+		}
+		if yamlIndent(lines[j]) >= indent {
+			if cabalFieldPattern.MatchString(lines[j]) {
+				return false
+			}
+			continue
+		}
+		match := cabalFieldPattern.FindStringSubmatch(lines[j])
+		return match != nil && strings.ToLower(match[2]) == name
+	}
+	return false
+}
+
+// cabalStanzaOf returns the line of the stanza containing lines[i], or -1 if it is in the package's top-level
+// fields.
+func cabalStanzaOf(stanzas []int, i int) int {
+	stanza := -1
+	for _, start := range stanzas {
+		if start < i {
+			stanza = start
+		}
+	}
+	return stanza
+}
+
+// cabalStanzaEnd returns the index just past the stanza whose header is lines[start].
+func cabalStanzaEnd(lines []string, start int) int {
+	for i := start + 1; i < len(lines); i++ {
+		if !cabalIsBlank(lines[i]) && yamlIndent(lines[i]) == 0 {
+			return i
+		}
+	}
+	return len(lines)
+}
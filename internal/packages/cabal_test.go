@@ -0,0 +1,283 @@
+package packages
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testCabalDescription is the .cabal file of the package used by the tests.
+const testCabalDescription = `cabal-version:      3.0
+name:               acme-core
+version:            1.2.0
+synopsis:           Shared compiler passes
+
+library
+    exposed-modules:  Acme.Core
+    build-depends:    base >=4.14 && <5
+    hs-source-dirs:   src
+`
+
+func TestCabalLocatePackage(t *testing.T) {
+	handler := &CabalHandler{}
+	dir := "../../testdata"
+
+	tempDir, err := os.MkdirTemp(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tempDir); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to remove temp folder: %v\n", err)
+		}
+	}() // Clean up
+
+	packagePath := filepath.Join(tempDir, "acme-core-1.2.0.tar.gz")
+	writeTestTarGz(t, packagePath, map[string]string{"acme-core-1.2.0/acme-core.cabal": testCabalDescription})
+	sdistPath := filepath.Join(tempDir, "dist-newstyle", "sdist", "acme-core-1.3.0.tar.gz")
+	writeTestTarGz(t, sdistPath, map[string]string{"acme-core-1.3.0/acme-core.cabal": testCabalDescription})
+
+	testCases := []struct {
+		name           string
+		packageVersion string
+		expectedPath   string
+		expectedError  bool
+	}{
+		{
+			name:           "find sdist",
+			packageVersion: "1.2.0",
+			expectedPath:   packagePath,
+		},
+		{
+			name:           "find sdist in cabal output directory",
+			packageVersion: "1.3.0",
+			expectedPath:   sdistPath,
+		},
+		{
+			name:           "fail on non-existing sdist",
+			packageVersion: "1.4.0",
+			expectedError:  true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			filePath, err := handler.LocatePackage(tempDir, "acme-core", testCase.packageVersion)
+			if testCase.expectedError {
+				if err == nil {
+					t.Fatal("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if filePath != testCase.expectedPath {
+				t.Errorf("expected %s, got %s", testCase.expectedPath, filePath)
+			}
+		})
+	}
+}
+
+func TestCabalUpdatePackageRef(t *testing.T) {
+	testCases := []struct {
+		name            string
+		input           string
+		expected        string
+		expectedError   bool
+		project         string
+		expectedProject string
+	}{
+		{
+			name: "adds to leading-comma build-depends of library",
+			input: `cabal-version: 3.0
+name:          tiger
+version:       0.1.0
+
+executable tiger
+  main-is:       Main.hs
+  build-depends: base, tiger
+
+library
+  exposed-modules: Tiger.Parser
+  build-depends:
+      base >=4.14 && <5
+    , containers ^>=0.6
+  -- , acme-core
+  default-language: Haskell2010
+
+test-suite spec
+  type:          exitcode-stdio-1.0
+  main-is:       Spec.hs
+  build-depends: base, acme-core
+`,
+			expected: `cabal-version: 3.0
+name:          tiger
+version:       0.1.0
+
+executable tiger
+  main-is:       Main.hs
+  build-depends: base, tiger
+
+library
+  exposed-modules: Tiger.Parser
+  build-depends:
+      base >=4.14 && <5
+    , containers ^>=0.6
+    , acme-core ^>=1.2.0
+  -- , acme-core
+  default-language: Haskell2010
+
+test-suite spec
+  type:          exitcode-stdio-1.0
+  main-is:       Spec.hs
+  build-depends: base, acme-core ^>=1.2.0
+`,
+			project: `packages: ./
+          vendor/acme-core/
+
+with-compiler: ghc-9.6.4
+`,
+			expectedProject: `packages: ./
+          .universal-packages/cabal-packages/acme-core/
+
+with-compiler: ghc-9.6.4
+`,
+		},
+		{
+			name: "updates version range of existing dependency",
+			input: `cabal-version: 3.0
+name:          tiger
+version:       0.1.0
+
+library
+  exposed-modules: Tiger.Parser
+  build-depends:
+    base >=4.14 && <5,
+    acme-core >=1.1 && <1.2,
+    containers
+  if flag(dev)
+    build-depends: acme-core >=1.1
+`,
+			expected: `cabal-version: 3.0
+name:          tiger
+version:       0.1.0
+
+library
+  exposed-modules: Tiger.Parser
+  build-depends:
+    base >=4.14 && <5,
+    acme-core ^>=1.2.0,
+    containers
+  if flag(dev)
+    build-depends: acme-core ^>=1.2.0
+`,
+			project: `packages: ./ .universal-packages/cabal-packages/acme-core/
+`,
+			expectedProject: `packages: ./ .universal-packages/cabal-packages/acme-core/
+`,
+		},
+		{
+			name: "adds build-depends to executable",
+			input: `cabal-version: 3.0
+name:          tiger
+version:       0.1.0
+
+executable tiger
+  main-is:          Main.hs
+  default-language: Haskell2010
+`,
+			expected: `cabal-version: 3.0
+name:          tiger
+version:       0.1.0
+
+executable tiger
+  main-is:          Main.hs
+  default-language: Haskell2010
+  build-depends: acme-core ^>=1.2.0
+`,
+			expectedProject: `packages: ./
+          .universal-packages/cabal-packages/acme-core/
+`,
+		},
+		{
+			name: "fails without library or executable",
+			input: `cabal-version: 3.0
+name:          tiger
+version:       0.1.0
+`,
+			expectedError: true,
+		},
+	}
+
+	handler := &CabalHandler{}
+	dir := "../../testdata"
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			installTempDir, err := os.MkdirTemp(dir, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				if err := os.RemoveAll(installTempDir); err != nil {
+					fmt.Fprintf(os.Stderr, "warning: failed to remove temp folder: %v\n", err)
+				}
+			}() // Clean up
+
+			cabalPath := filepath.Join(installTempDir, "tiger.cabal")
+			if err := os.WriteFile(cabalPath, []byte(testCase.input), 0644); err != nil {
+				t.Fatal(err)
+			}
+			projectPath := filepath.Join(installTempDir, "cabal.project")
+			if testCase.project != "" {
+				if err := os.WriteFile(projectPath, []byte(testCase.project), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// A checkout of the package in the project, which the pulled package replaces
+			otherCopy := filepath.Join(installTempDir, "vendor", "acme-core")
+			if err := os.MkdirAll(otherCopy, 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(otherCopy, "acme-core.cabal"), []byte(testCabalDescription), 0644); err != nil {
+				t.Fatal(err)
+			}
+			packageLocation := filepath.Join(installTempDir, ".upkg", "acme-core-1.2.0.tar.gz")
+			writeTestTarGz(t, packageLocation, map[string]string{
+				"acme-core-1.2.0/acme-core.cabal":  testCabalDescription,
+				"acme-core-1.2.0/src/Acme/Core.hs": "",
+			})
+
+			err = handler.UpdatePackageRef("acme-core", packageLocation, installTempDir)
+			if testCase.expectedError {
+				if err == nil {
+					t.Fatal("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			updated, err := os.ReadFile(cabalPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(updated) != testCase.expected {
+				t.Errorf("expected:\n%s\ngot:\n%s", testCase.expected, updated)
+			}
+			project, err := os.ReadFile(projectPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(project) != testCase.expectedProject {
+				t.Errorf("expected:\n%s\ngot:\n%s", testCase.expectedProject, project)
+			}
+			if !fileExists(filepath.Join(installTempDir, InstallDir, "cabal-packages", "acme-core", "src", "Acme", "Core.hs")) {
+				t.Error("expected package to be extracted")
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package packages
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type CargoHandler struct{}
+
+var cargoVersionKeyPattern = regexp.MustCompile(`\bversion\s*=\s*`)
+
+// LocatePackage finds <name>-<version>.crate in the specified directory, or in target/package
+// where `cargo package` writes its output.
+func (c *CargoHandler) LocatePackage(dir string, packageName string, packageVersion string) (string, error) {
+	filename := fmt.Sprintf("%s-%s.crate", packageName, packageVersion)
+	for _, searchDir := range []string{dir, filepath.Join(dir, "target", "package")} {
+		packagePath := filepath.Join(searchDir, filename)
+		if fileExists(packagePath) {
+			return packagePath, nil
+		}
+	}
+	return "", fmt.Errorf("expected package file not found: %s", filename)
+}
+
+// UpdatePackageRef unpacks the crate into a vendor directory next to the nearest Cargo.toml, writes the
+// .cargo-checksum.json that cargo expects of vendored crates, and points Cargo.toml at it: the dependency's
+// version is set in [dependencies] and a path override is added to [patch.crates-io], so that other crates
+// depending on it resolve to the vendored copy too.
+func (c *CargoHandler) UpdatePackageRef(packageName string, packageFilePath string, packageRefFilePath string) error {
+	manifestPath, err := findFileUp(packageRefFilePath, "Cargo.toml")
+	if err != nil {
+		return fmt.Errorf("finding Cargo.toml: %w", err)
+	}
+
+	stem := strings.TrimSuffix(filepath.Base(packageFilePath), ".crate")
+	version, ok := strings.CutPrefix(stem, packageName+"-")
+	if !ok || version == "" {
+		return fmt.Errorf("unexpected package file name %s for package %s", filepath.Base(packageFilePath), packageName)
+	}
+
+	// .crate files contain a single <name>-<version>/ directory
+	vendorDir := filepath.Join(filepath.Dir(manifestPath), InstallDir, "cargo-vendor", packageName)
+	if err := extractTarGz(packageFilePath, vendorDir, 1); err != nil {
+		return fmt.Errorf("unpacking crate: %w", err)
+	}
+	if err := writeCargoChecksum(vendorDir, packageFilePath); err != nil {
+		return err
+	}
+
+	relPath, err := filepath.Rel(filepath.Dir(manifestPath), vendorDir)
+	if err != nil {
+		return fmt.Errorf("calculating relative path: %w", err)
+	}
+	data, err := os.ReadFile(manifestPath)
+	if err != nil {
+		return err
+	}
+	updated, err := updateCargoDependency(string(data), packageName, version)
+	if err != nil {
+		return fmt.Errorf("updating %s: %w", manifestPath, err)
+	}
+	updated, err = setTOMLTableKey(updated, "patch.crates-io", packageName, "{ path = "+strconv.Quote(filepath.ToSlash(relPath))+" }")
+	if err != nil {
+		return fmt.Errorf("updating %s: %w", manifestPath, err)
+	}
+	return os.WriteFile(manifestPath, []byte(updated), 0644)
+}
+
+// updateCargoDependency sets the version requirement of a dependency, keeping any other keys
+// (features, optional, ...) of an inline or dotted table declaration.
+func updateCargoDependency(content string, packageName string, version string) (string, error) {
+	quoted := strconv.Quote(version)
+	if _, _, ok := tomlTableBody(content, "dependencies."+packageName); ok {
+		return setTOMLTableKey(content, "dependencies."+packageName, "version", quoted)
+	}
+
+	start, end, ok := tomlTableBody(content, "dependencies")
+	if !ok {
+		return setTOMLTableKey(content, "dependencies", packageName, quoted)
+	}
+	valueStart := tomlKeyValue(content, start, end, packageName)
+	if valueStart == -1 || content[valueStart] != '{' {
+		return setTOMLTableKey(content, "dependencies", packageName, quoted)
+	}
+
+	valueEnd, err := tomlValueEnd(content, valueStart)
+	if err != nil {
+		return "", err
+	}
+	inline := content[valueStart:valueEnd]
+	if loc := cargoVersionKeyPattern.FindStringIndex(inline); loc != nil {
+		versionStart := valueStart + loc[1]
+		versionEnd, err := tomlValueEnd(content, versionStart)
+		if err != nil {
+			return "", err
+		}
+		return content[:versionStart] + quoted + content[versionEnd:], nil
+	}
+	afterBrace := valueStart + 1 + len(inline[1:]) - len(strings.TrimLeft(inline[1:], " \t"))
+	return content[:afterBrace] + "version = " + quoted + ", " + content[afterBrace:], nil
+}
+
+// writeCargoChecksum writes .cargo-checksum.json for an unpacked crate: the sha256 of every file,
+// and of the .crate archive itself, as `cargo vendor` does.
+func writeCargoChecksum(crateDir string, cratePath string) error {
+	files := make(map[string]string)
+	err := filepath.WalkDir(crateDir, func(path string, entry os.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(crateDir, path)
+		if err != nil {
+			return err
+		}
+		if rel == ".cargo-checksum.json" {
+			return nil
+		}
+		sum, err := sha256File(path)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = sum
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("hashing crate files: %w", err)
+	}
+
+	packageSum, err := sha256File(cratePath)
+	if err != nil {
+		return err
+	}
+	checksum, err := json.Marshal(struct {
+		Files   map[string]string `json:"files"`
+		Package string            `json:"package"`
+	}{Files: files, Package: packageSum})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(crateDir, ".cargo-checksum.json"), checksum, 0644)
+}
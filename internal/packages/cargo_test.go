@@ -0,0 +1,198 @@
+package packages
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCargoLocatePackage(t *testing.T) {
+	handler := &CargoHandler{}
+	dir := "../../testdata"
+
+	tempDir, err := os.MkdirTemp(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tempDir); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to remove temp folder: %v\n", err)
+		}
+	}() // Clean up
+
+	// Mimic the output of `cargo package`
+	packagePath := filepath.Join(tempDir, "target", "package", "my-proto-0.3.1.crate")
+	writeTestTarGz(t, packagePath, map[string]string{"my-proto-0.3.1/Cargo.toml": ""})
+
+	testCases := []struct {
+		name           string
+		packageName    string
+		packageVersion string
+		expectedPath   string
+		expectedError  bool
+	}{
+		{
+			name:           "find crate in target/package",
+			packageName:    "my-proto",
+			packageVersion: "0.3.1",
+			expectedPath:   packagePath,
+		},
+		{
+			name:           "fail on non-existing crate",
+			packageName:    "my-proto",
+			packageVersion: "0.4.0",
+			expectedError:  true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			filePath, err := handler.LocatePackage(tempDir, testCase.packageName, testCase.packageVersion)
+			if testCase.expectedError {
+				if err == nil {
+					t.Fatal("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if filePath != testCase.expectedPath {
+				t.Errorf("expected %s, got %s", testCase.expectedPath, filePath)
+			}
+		})
+	}
+}
+
+func TestCargoUpdatePackageRef(t *testing.T) {
+	testCases := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name: "adds dependency and patch",
+			input: `[package]
+name = "gateway"
+version = "0.1.0"
+
+[dependencies]
+serde = "1" # serialisation
+`,
+			expected: `[package]
+name = "gateway"
+version = "0.1.0"
+
+[dependencies]
+serde = "1" # serialisation
+my-proto = "0.3.1"
+
+[patch.crates-io]
+my-proto = { path = ".universal-packages/cargo-vendor/my-proto" }
+`,
+		},
+		{
+			name: "updates inline table dependency and existing patch",
+			input: `[dependencies]
+my-proto = { version = "0.2", features = ["async"] }
+
+[patch.crates-io]
+other = { path = "../other" }
+my-proto = { path = "old" }
+
+[dev-dependencies]
+tokio = "1"
+`,
+			expected: `[dependencies]
+my-proto = { version = "0.3.1", features = ["async"] }
+
+[patch.crates-io]
+other = { path = "../other" }
+my-proto = { path = ".universal-packages/cargo-vendor/my-proto" }
+
+[dev-dependencies]
+tokio = "1"
+`,
+		},
+		{
+			name: "updates dotted table dependency",
+			input: `[dependencies.my-proto]
+version = "0.2"
+default-features = false
+`,
+			expected: `[dependencies.my-proto]
+version = "0.3.1"
+default-features = false
+
+[patch.crates-io]
+my-proto = { path = ".universal-packages/cargo-vendor/my-proto" }
+`,
+		},
+	}
+
+	handler := &CargoHandler{}
+	dir := "../../testdata"
+
+	installTempDir, err := os.MkdirTemp(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(installTempDir); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to remove temp folder: %v\n", err)
+		}
+	}() // Clean up
+
+	packageLocation := filepath.Join(installTempDir, ".upkg", "my-proto-0.3.1.crate")
+	writeTestTarGz(t, packageLocation, map[string]string{
+		"my-proto-0.3.1/Cargo.toml": "[package]\nname = \"my-proto\"\n",
+		"my-proto-0.3.1/src/lib.rs": "",
+	})
+	manifestPath := filepath.Join(installTempDir, "Cargo.toml")
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if err := os.WriteFile(manifestPath, []byte(testCase.input), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := handler.UpdatePackageRef("my-proto", packageLocation, installTempDir); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			updated, err := os.ReadFile(manifestPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(updated) != testCase.expected {
+				t.Errorf("expected:\n%s\ngot:\n%s", testCase.expected, updated)
+			}
+		})
+	}
+
+	// The vendored crate carries a checksum covering every unpacked file and the .crate itself
+	vendorDir := filepath.Join(installTempDir, InstallDir, "cargo-vendor", "my-proto")
+	data, err := os.ReadFile(filepath.Join(vendorDir, ".cargo-checksum.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var checksum struct {
+		Files   map[string]string `json:"files"`
+		Package string            `json:"package"`
+	}
+	if err := json.Unmarshal(data, &checksum); err != nil {
+		t.Fatal(err)
+	}
+	expectedPackageSum, err := sha256File(packageLocation)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checksum.Package != expectedPackageSum {
+		t.Errorf("expected package checksum %s, got %s", expectedPackageSum, checksum.Package)
+	}
+	if len(checksum.Files) != 2 || checksum.Files["src/lib.rs"] == "" {
+		t.Errorf("unexpected file checksums: %v", checksum.Files)
+	}
+}
@@ -0,0 +1,230 @@
+package packages
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+type ComposerHandler struct{}
+
+// composerArchiveNamePattern matches the characters `composer archive` replaces in package names.
+var composerArchiveNamePattern = regexp.MustCompile(`[^a-zA-Z0-9_-]`)
+
+// LocatePackage finds the zip written by `composer archive --format=zip` in the specified directory:
+// <vendor>-<name>-<version>[-<ref>].zip. Composer's artifact repository reads the version from the
+// archived composer.json, so if it has none the archive is copied into a temporary directory with
+// the version added.
+func (c *ComposerHandler) LocatePackage(dir string, packageName string, packageVersion string) (string, error) {
+	base := composerArchiveNamePattern.ReplaceAllString(packageName, "-")
+	prefixes := []string{base + "-" + packageVersion, base + "-v" + packageVersion}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", fmt.Errorf("reading %s: %w", dir, err)
+	}
+	packagePath := ""
+	for _, entry := range entries {
+		stem, ok := strings.CutSuffix(entry.Name(), ".zip")
+		if !ok || entry.IsDir() {
+			continue
+		}
+		for _, prefix := range prefixes {
+			// Archives of packages without a vendor prefix are matched on the trailing part of the name
+			rest, ok := strings.CutPrefix(stem, prefix)
+			if !ok && !strings.Contains(packageName, "/") {
+				if i := strings.Index(stem, "-"+prefix); i != -1 {
+					rest, ok = stem[i+1+len(prefix):], true
+				}
+			}
+			if ok && (rest == "" || strings.HasPrefix(rest, "-")) {
+				packagePath = filepath.Join(dir, entry.Name())
+			}
+		}
+	}
+	if packagePath == "" {
+		return "", fmt.Errorf("expected package file not found: %s-%s.zip", base, packageVersion)
+	}
+
+	_, manifest, err := readComposerArchiveManifest(packagePath)
+	if err != nil {
+		return "", err
+	}
+	if gjson.GetBytes(manifest, "version").String() != "" {
+		return packagePath, nil
+	}
+
+	outDir, err := newOutputDir("composer")
+	if err != nil {
+		return "", err
+	}
+	versionedPath := filepath.Join(outDir, filepath.Base(packagePath))
+	if err := setComposerArchiveVersion(packagePath, versionedPath, packageVersion); err != nil {
+		return "", err
+	}
+	return versionedPath, nil
+}
+
+// UpdatePackageRef copies the archive into an artifacts directory next to the nearest composer.json, makes sure
+// composer.json has an artifact repository for that directory and requires the archived version of the package.
+// The package name and version are read from the archived composer.json.
+func (c *ComposerHandler) UpdatePackageRef(packageName string, packageFilePath string, packageRefFilePath string) error {
+	composerJSONPath, err := findFileUp(packageRefFilePath, "composer.json")
+	if err != nil {
+		return fmt.Errorf("finding composer.json: %w", err)
+	}
+
+	_, manifest, err := readComposerArchiveManifest(packageFilePath)
+	if err != nil {
+		return err
+	}
+	name := gjson.GetBytes(manifest, "name").String()
+	version := gjson.GetBytes(manifest, "version").String()
+	if name == "" || version == "" {
+		return fmt.Errorf("composer.json in %s must declare a name and version", filepath.Base(packageFilePath))
+	}
+
+	artifactsDir := filepath.Join(filepath.Dir(composerJSONPath), InstallDir, "composer-artifacts")
+	if _, err := copyFile(packageFilePath, artifactsDir); err != nil {
+		return fmt.Errorf("adding package to artifacts directory: %w", err)
+	}
+	relPath, err := filepath.Rel(filepath.Dir(composerJSONPath), artifactsDir)
+	if err != nil {
+		return fmt.Errorf("calculating relative path: %w", err)
+	}
+
+	data, err := os.ReadFile(composerJSONPath)
+	if err != nil {
+		return err
+	}
+	data, err = addComposerArtifactRepository(data, filepath.ToSlash(relPath)+"/")
+	if err != nil {
+		return err
+	}
+	data, err = sjson.SetBytes(data, "require."+escapeJSONPath(name), strings.TrimPrefix(version, "v"))
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(composerJSONPath, data, 0644)
+}
+
+// addComposerArtifactRepository adds an artifact repository for url to composer.json, unless one exists.
+// Repositories may be declared as a list or as an object keyed by name.
+func addComposerArtifactRepository(data []byte, url string) ([]byte, error) {
+	repositories := gjson.GetBytes(data, "repositories")
+	exists := false
+	repositories.ForEach(func(_, repository gjson.Result) bool {
+		if repository.Get("type").String() == "artifact" && strings.TrimSuffix(repository.Get("url").String(), "/") == strings.TrimSuffix(url, "/") {
+			exists = true
+		}
+		return !exists
+	})
+	if exists {
+		return data, nil
+	}
+
+	repository, err := json.Marshal(struct {
+		Type string `json:"type"`
+		URL  string `json:"url"`
+	}{Type: "artifact", URL: url})
+	if err != nil {
+		return nil, err
+	}
+	path := "repositories.-1"
+	if repositories.IsObject() {
+		path = "repositories.universal-packages"
+	}
+	return sjson.SetRawBytes(data, path, repository)
+}
+
+// readComposerArchiveManifest returns the name and contents of the composer.json in a package archive,
+// which is either at the root or within a single top-level directory.
+func readComposerArchiveManifest(archivePath string) (string, []byte, error) {
+	r, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return "", nil, fmt.Errorf("reading %s: %w", filepath.Base(archivePath), err)
+	}
+	defer func() {
+		_ = r.Close()
+	}()
+
+	var manifest *zip.File
+	for _, f := range r.File {
+		name := strings.TrimPrefix(f.Name, "./")
+		if name == "composer.json" {
+			manifest = f
+			break
+		}
+		if strings.Count(name, "/") == 1 && strings.HasSuffix(name, "/composer.json") && manifest == nil {
+			manifest = f
+		}
+	}
+	if manifest == nil {
+		return "", nil, fmt.Errorf("no composer.json found in %s", filepath.Base(archivePath))
+	}
+	rc, err := manifest.Open()
+	if err != nil {
+		return "", nil, err
+	}
+	defer func() {
+		_ = rc.Close()
+	}()
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		return "", nil, err
+	}
+	return manifest.Name, data, nil
+}
+
+// setComposerArchiveVersion copies the package archive at src to dst, adding version to its composer.json.
+func setComposerArchiveVersion(src string, dst string, version string) error {
+	manifestName, manifest, err := readComposerArchiveManifest(src)
+	if err != nil {
+		return err
+	}
+	manifest, err = sjson.SetBytes(manifest, "version", version)
+	if err != nil {
+		return err
+	}
+
+	r, err := zip.OpenReader(src)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = r.Close()
+	}()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	w := zip.NewWriter(out)
+	for _, f := range r.File {
+		if f.Name != manifestName {
+			err = w.Copy(f)
+		} else {
+			var fw io.Writer
+			fw, err = w.CreateHeader(&zip.FileHeader{Name: f.Name, Method: zip.Deflate, Modified: f.Modified})
+			if err == nil {
+				_, err = fw.Write(manifest)
+			}
+		}
+		if err != nil {
+			_ = out.Close()
+			return fmt.Errorf("writing %s: %w", filepath.Base(dst), err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,220 @@
+package packages
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+func TestComposerLocatePackage(t *testing.T) {
+	handler := &ComposerHandler{}
+	dir := "../../testdata"
+
+	tempDir, err := os.MkdirTemp(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tempDir); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to remove temp folder: %v\n", err)
+		}
+	}() // Clean up
+
+	// Mimic the output of `composer archive --format=zip`
+	versionedPath := filepath.Join(tempDir, "acme-storefront-sdk-1.2.0-0a1b2c.zip")
+	writeTestZip(t, versionedPath, map[string]string{
+		"composer.json": `{"name": "acme/storefront-sdk", "version": "1.2.0"}`,
+	})
+	unversionedPath := filepath.Join(tempDir, "acme-storefront-sdk-1.3.0.zip")
+	writeTestZip(t, unversionedPath, map[string]string{
+		"composer.json":  `{"name": "acme/storefront-sdk"}`,
+		"src/Client.php": "<?php\n",
+	})
+	writeTestZip(t, filepath.Join(tempDir, "acme-storefront-sdk-extras-1.2.0.zip"), map[string]string{
+		"composer.json": `{"name": "acme/storefront-sdk-extras", "version": "1.2.0"}`,
+	})
+
+	testCases := []struct {
+		name            string
+		packageName     string
+		packageVersion  string
+		expectedPath    string
+		expectedVersion string
+		expectedError   bool
+	}{
+		{
+			name:            "find archive with version",
+			packageName:     "acme/storefront-sdk",
+			packageVersion:  "1.2.0",
+			expectedPath:    versionedPath,
+			expectedVersion: "1.2.0",
+		},
+		{
+			name:            "find archive by package name without vendor",
+			packageName:     "storefront-sdk",
+			packageVersion:  "1.2.0",
+			expectedPath:    versionedPath,
+			expectedVersion: "1.2.0",
+		},
+		{
+			name:            "add version to archive without one",
+			packageName:     "acme/storefront-sdk",
+			packageVersion:  "1.3.0",
+			expectedVersion: "1.3.0",
+		},
+		{
+			name:           "fail on non-existing archive",
+			packageName:    "acme/storefront-sdk",
+			packageVersion: "2.0.0",
+			expectedError:  true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			filePath, err := handler.LocatePackage(tempDir, testCase.packageName, testCase.packageVersion)
+			if testCase.expectedError {
+				if err == nil {
+					t.Fatal("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if testCase.expectedPath != "" && filePath != testCase.expectedPath {
+				t.Errorf("expected %s, got %s", testCase.expectedPath, filePath)
+			}
+			_, manifest, err := readComposerArchiveManifest(filePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if version := gjson.GetBytes(manifest, "version").String(); version != testCase.expectedVersion {
+				t.Errorf("expected version %s, got %s", testCase.expectedVersion, version)
+			}
+		})
+	}
+}
+
+func TestComposerUpdatePackageRef(t *testing.T) {
+	testCases := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name: "adds repository and requirement",
+			input: `{
+  "name": "acme/storefront",
+  "require": {
+    "php": "^8.2"
+  }
+}`,
+			expected: `{
+  "name": "acme/storefront",
+  "require": {
+    "php": "^8.2",
+    "acme/storefront-sdk": "1.2.0"
+  },
+  "repositories": [
+    {"type": "artifact", "url": ".universal-packages/composer-artifacts/"}
+  ]
+}`,
+		},
+		{
+			name: "keeps existing artifact repository and updates requirement",
+			input: `{
+  "repositories": [
+    {"type": "vcs", "url": "https://github.com/acme/legacy"},
+    {"type": "artifact", "url": ".universal-packages/composer-artifacts"}
+  ],
+  "require": {
+    "acme/storefront-sdk": "^1.0"
+  }
+}`,
+			expected: `{
+  "repositories": [
+    {"type": "vcs", "url": "https://github.com/acme/legacy"},
+    {"type": "artifact", "url": ".universal-packages/composer-artifacts"}
+  ],
+  "require": {
+    "acme/storefront-sdk": "1.2.0"
+  }
+}`,
+		},
+		{
+			name: "adds named repository",
+			input: `{
+  "repositories": {
+    "legacy": {"type": "vcs", "url": "https://github.com/acme/legacy"}
+  },
+  "require": {}
+}`,
+			expected: `{
+  "repositories": {
+    "legacy": {"type": "vcs", "url": "https://github.com/acme/legacy"},
+    "universal-packages": {"type": "artifact", "url": ".universal-packages/composer-artifacts/"}
+  },
+  "require": {
+    "acme/storefront-sdk": "1.2.0"
+  }
+}`,
+		},
+	}
+
+	handler := &ComposerHandler{}
+	dir := "../../testdata"
+
+	installTempDir, err := os.MkdirTemp(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(installTempDir); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to remove temp folder: %v\n", err)
+		}
+	}() // Clean up
+
+	packageLocation := filepath.Join(installTempDir, ".upkg", "acme-storefront-sdk-1.2.0.zip")
+	writeTestZip(t, packageLocation, map[string]string{
+		"composer.json": `{"name": "acme/storefront-sdk", "version": "1.2.0"}`,
+	})
+	composerJSONPath := filepath.Join(installTempDir, "composer.json")
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if err := os.WriteFile(composerJSONPath, []byte(testCase.input), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := handler.UpdatePackageRef("acme/storefront-sdk", packageLocation, installTempDir); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			updated, err := os.ReadFile(composerJSONPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Normalize JSON for comparison (in case of field order)
+			var got, expected map[string]interface{}
+			if err := json.Unmarshal(updated, &got); err != nil {
+				t.Fatal(err)
+			}
+			if err := json.Unmarshal([]byte(testCase.expected), &expected); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, expected) {
+				t.Errorf("expected %v, got %v", expected, got)
+			}
+		})
+	}
+
+	if !fileExists(filepath.Join(installTempDir, InstallDir, "composer-artifacts", "acme-storefront-sdk-1.2.0.zip")) {
+		t.Error("expected archive in artifacts directory")
+	}
+}
@@ -0,0 +1,660 @@
+package packages
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+// Conan 1 keeps its local cache as plain directories under <CONAN_USER_HOME>/.conan/data, one per recipe
+// reference: data/<name>/<version>/<user>/<channel>/ holds the exported recipe (export/, export_source/ and
+// metadata.json) and a package/<package_id>/ folder per binary package. Packages are pushed as one archive of
+// the recipe folders and one per binary package, each with paths relative to data/, and restored into a
+// project-local cache on install.
+//
+// Conan 2 keeps its cache in a database that only Conan itself can read and write, so packages are exported
+// from it with `conan cache save` into a single archive, and restored with `conan cache restore` into a
+// project-local Conan home on install.
+
+type ConanHandler struct{}
+
+var (
+	conanClassPattern        = regexp.MustCompile(`^class\s+\w+\s*\(.*ConanFile.*\)\s*:`)
+	conanRequirementsPattern = regexp.MustCompile(`^(\s*)def\s+requirements\s*\(\s*self\s*\)\s*:`)
+	conanRequiresPattern     = regexp.MustCompile(`^(\s+)requires\s*=\s*`)
+	// Requirements in a conanfile.py are the arguments of self.requires() and the value of the requires attribute
+	conanSelfRequiresPattern = regexp.MustCompile(`\bself\.requires\s*\(`)
+	conanRequiresAttrPattern = regexp.MustCompile(`(?m)^[ \t]+requires[ \t]*=[ \t]*`)
+)
+
+// conanReference is a recipe reference. Recipes without a user and channel have "_" for both.
+type conanReference struct {
+	Name    string
+	Version string
+	User    string
+	Channel string
+}
+
+// String returns the reference as written in conanfile requires.
+func (r conanReference) String() string {
+	if r.User == "_" && r.Channel == "_" {
+		return r.Name + "/" + r.Version
+	}
+	return r.Name + "/" + r.Version + "@" + r.User + "/" + r.Channel
+}
+
+// path returns the slash-separated folder of the reference relative to the cache's data directory.
+func (r conanReference) path() string {
+	return path.Join(r.Name, r.Version, r.User, r.Channel)
+}
+
+// LocatePackage finds the recipe archive of the package, or its Conan 2 cache archive. See LocatePackageFiles.
+func (c *ConanHandler) LocatePackage(dir string, packageName string, packageVersion string) (string, error) {
+	files, err := c.LocatePackageFiles(dir, packageName, packageVersion)
+	if err != nil {
+		return "", err
+	}
+	return files[0], nil
+}
+
+// LocatePackageFiles finds <name>-<version>-recipe.tgz and the <name>-<version>-package-<package_id>.tgz
+// archives next to it in the specified directory, or a Conan 2 cache archive <name>-<version>.tgz. If they are
+// not present, they are built into a temporary directory from the recipe and binary packages in the local
+// Conan 1 cache, which must have been created with `conan create` or `conan export-pkg`. If the Conan 1 cache
+// has no such recipe, the recipe and all of its binary packages are saved from the Conan 2 cache instead. The
+// package name may be given as name@user/channel; otherwise the recipe must be in the cache under a single
+// user and channel.
+func (c *ConanHandler) LocatePackageFiles(dir string, packageName string, packageVersion string) ([]string, error) {
+	name, _, _ := strings.Cut(packageName, "@")
+	stem := name + "-" + packageVersion
+	recipePath := filepath.Join(dir, stem+"-recipe.tgz")
+	if fileExists(recipePath) {
+		return append([]string{recipePath}, conanPackageArchives(recipePath)...), nil
+	}
+	if archivePath := filepath.Join(dir, stem+".tgz"); fileExists(archivePath) {
+		return []string{archivePath}, nil
+	}
+
+	dataDir, err := conanDataDir()
+	if err != nil {
+		return nil, err
+	}
+	if info, err := os.Stat(filepath.Join(dataDir, name, packageVersion)); err != nil || !info.IsDir() {
+		archivePath, err := saveConanPackage(packageName, packageVersion)
+		if err != nil {
+			return nil, err
+		}
+		return []string{archivePath}, nil
+	}
+	ref, err := findConanReference(dataDir, packageName, packageVersion)
+	if err != nil {
+		return nil, err
+	}
+	refDir := filepath.Join(dataDir, filepath.FromSlash(ref.path()))
+	entries, err := os.ReadDir(filepath.Join(refDir, "package"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	var packageIDs []string
+	for _, entry := range entries {
+		if entry.IsDir() && fileExists(filepath.Join(refDir, "package", entry.Name(), "conaninfo.txt")) {
+			packageIDs = append(packageIDs, entry.Name())
+		}
+	}
+	if len(packageIDs) == 0 {
+		return nil, fmt.Errorf("no binary packages of %s found in the Conan cache", ref)
+	}
+
+	outDir, err := newOutputDir("conan")
+	if err != nil {
+		return nil, err
+	}
+	files := []string{filepath.Join(outDir, stem+"-recipe.tgz")}
+	recipeFolders := []string{ref.path() + "/export", ref.path() + "/export_source", ref.path() + "/metadata.json"}
+	if err := createTarGz(dataDir, files[0], skipConanEntry(recipeFolders...)); err != nil {
+		return nil, err
+	}
+	for _, packageID := range packageIDs {
+		packagePath := filepath.Join(outDir, stem+"-package-"+packageID+".tgz")
+		if err := createTarGz(dataDir, packagePath, skipConanEntry(ref.path()+"/package/"+packageID)); err != nil {
+			return nil, err
+		}
+		files = append(files, packagePath)
+	}
+	return files, nil
+}
+
+// UpdatePackageRef restores the pulled recipe and binary packages into a project-local Conan cache next to the
+// nearest conanfile.py or conanfile.txt, and adds the reference to its requires, replacing any requirement on
+// another version of the package. The project must then be built with CONAN_USER_HOME pointing at
+// <project>/.universal-packages/conan, or for a Conan 2 cache archive, with CONAN_HOME pointing at
+// <project>/.universal-packages/conan2.
+func (c *ConanHandler) UpdatePackageRef(packageName string, packageFilePath string, packageRefFilePath string) error {
+	conanfilePath, err := findFileUp(packageRefFilePath, "conanfile.py", "conanfile.txt")
+	if err != nil {
+		return fmt.Errorf("finding conanfile: %w", err)
+	}
+
+	var ref conanReference
+	projectDir := filepath.Dir(conanfilePath)
+	if strings.HasSuffix(packageFilePath, "-recipe.tgz") {
+		ref, err = restoreConanPackage(filepath.Join(projectDir, InstallDir, "conan", ".conan", "data"), packageFilePath)
+	} else {
+		ref, err = restoreConanCacheArchive(filepath.Join(projectDir, InstallDir, "conan2"), packageFilePath)
+	}
+	if err != nil {
+		return err
+	}
+	if name, _, _ := strings.Cut(packageName, "@"); name != ref.Name {
+		return fmt.Errorf("package %s does not match recipe %s", packageName, ref)
+	}
+
+	data, err := os.ReadFile(conanfilePath)
+	if err != nil {
+		return err
+	}
+	var updated string
+	if filepath.Base(conanfilePath) == "conanfile.py" {
+		updated = setConanfilePyRequire(string(data), ref)
+	} else {
+		updated = setConanfileTxtRequire(string(data), ref)
+	}
+	return os.WriteFile(conanfilePath, []byte(updated), 0644)
+}
+
+// conanDataDir returns the data directory of the local Conan cache: CONAN_STORAGE_PATH, or the storage path
+// set in conan.conf, or data/ in the Conan home at $CONAN_USER_HOME/.conan or ~/.conan.
+func conanDataDir() (string, error) {
+	if storage := os.Getenv("CONAN_STORAGE_PATH"); storage != "" {
+		return storage, nil
+	}
+	userHome := os.Getenv("CONAN_USER_HOME")
+	if userHome == "" {
+		var err error
+		if userHome, err = os.UserHomeDir(); err != nil {
+			return "", fmt.Errorf("finding Conan home: %w", err)
+		}
+	}
+	conanHome := filepath.Join(userHome, ".conan")
+
+	storage := "./data"
+	if data, err := os.ReadFile(filepath.Join(conanHome, "conan.conf")); err == nil {
+		section := ""
+		for _, line := range strings.Split(string(data), "\n") {
+			line = strings.TrimSpace(line)
+			if strings.HasPrefix(line, "[") {
+				section = line
+				continue
+			}
+			if key, value, ok := strings.Cut(line, "="); ok && section == "[storage]" && strings.TrimSpace(key) == "path" {
+				storage = strings.TrimSpace(value)
+			}
+		}
+	}
+	if rest, ok := strings.CutPrefix(storage, "~"); ok {
+		return filepath.Join(userHome, rest), nil
+	}
+	if filepath.IsAbs(storage) {
+		return storage, nil
+	}
+	return filepath.Join(conanHome, storage), nil
+}
+
+// findConanReference finds the recipe of the package in the cache at dataDir.
+func findConanReference(dataDir string, packageName string, packageVersion string) (conanReference, error) {
+	name, userChannel, hasUserChannel := strings.Cut(packageName, "@")
+	if hasUserChannel {
+		user, channel, ok := strings.Cut(userChannel, "/")
+		if !ok {
+			return conanReference{}, fmt.Errorf("invalid package name %s, expected name@user/channel", packageName)
+		}
+		ref := conanReference{Name: name, Version: packageVersion, User: user, Channel: channel}
+		if !fileExists(filepath.Join(dataDir, filepath.FromSlash(ref.path()), "export", "conanfile.py")) {
+			return conanReference{}, fmt.Errorf("recipe %s not found in the Conan cache at %s", ref, dataDir)
+		}
+		return ref, nil
+	}
+
+	matches, err := filepath.Glob(filepath.Join(dataDir, name, packageVersion, "*", "*", "export", "conanfile.py"))
+	if err != nil {
+		return conanReference{}, err
+	}
+	switch len(matches) {
+	case 0:
+		return conanReference{}, fmt.Errorf("recipe %s/%s not found in the Conan cache at %s", name, packageVersion, dataDir)
+	case 1:
+		channelDir := filepath.Dir(filepath.Dir(matches[0]))
+		return conanReference{
+			Name:    name,
+			Version: packageVersion,
+			User:    filepath.Base(filepath.Dir(channelDir)),
+			Channel: filepath.Base(channelDir),
+		}, nil
+	default:
+		return conanReference{}, fmt.Errorf("recipe %s/%s is in the Conan cache under several users and channels, give the package name as %s@user/channel", name, packageVersion, name)
+	}
+}
+
+// skipConanEntry returns a createTarGz filter that keeps only the given slash-separated paths relative to the
+// cache's data directory, along with the directories leading to them.
+func skipConanEntry(keep ...string) func(rel string, entry os.DirEntry) bool {
+	return func(rel string, entry os.DirEntry) bool {
+		for _, k := range keep {
+			if rel == k || strings.HasPrefix(rel, k+"/") || strings.HasPrefix(k, rel+"/") {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// conanPackageArchives returns the binary package archives pulled alongside a recipe archive.
+func conanPackageArchives(recipePath string) []string {
+	stem := strings.TrimSuffix(filepath.Base(recipePath), "-recipe.tgz")
+	matches, _ := filepath.Glob(filepath.Join(filepath.Dir(recipePath), stem+"-package-*.tgz"))
+	sort.Strings(matches)
+	return matches
+}
+
+// restoreConanPackage extracts the recipe archive and the binary package archives next to it into the cache at
+// dataDir, replacing the recipe's folder, and returns the recipe reference.
+func restoreConanPackage(dataDir string, recipePath string) (conanReference, error) {
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		return conanReference{}, fmt.Errorf("creating Conan cache: %w", err)
+	}
+	// Stage next to the cache so that folders can be moved into it
+	stagingDir, err := os.MkdirTemp(filepath.Dir(dataDir), "upkg-")
+	if err != nil {
+		return conanReference{}, err
+	}
+	defer func() {
+		_ = os.RemoveAll(stagingDir)
+	}()
+
+	recipeDir := filepath.Join(stagingDir, "recipe")
+	if err := extractTarGz(recipePath, recipeDir, 0); err != nil {
+		return conanReference{}, fmt.Errorf("extracting recipe: %w", err)
+	}
+	metadata, _ := filepath.Glob(filepath.Join(recipeDir, "*", "*", "*", "*", "metadata.json"))
+	if len(metadata) != 1 {
+		return conanReference{}, fmt.Errorf("%s is not a Conan recipe archive", filepath.Base(recipePath))
+	}
+	refPath, err := filepath.Rel(recipeDir, filepath.Dir(metadata[0]))
+	if err != nil {
+		return conanReference{}, err
+	}
+	parts := strings.Split(filepath.ToSlash(refPath), "/")
+	ref := conanReference{Name: parts[0], Version: parts[1], User: parts[2], Channel: parts[3]}
+
+	refDir := filepath.Join(dataDir, refPath)
+	if err := os.RemoveAll(refDir); err != nil {
+		return conanReference{}, err
+	}
+	if err := os.MkdirAll(filepath.Join(refDir, "package"), 0755); err != nil {
+		return conanReference{}, err
+	}
+	entries, err := os.ReadDir(filepath.Dir(metadata[0]))
+	if err != nil {
+		return conanReference{}, err
+	}
+	for _, entry := range entries {
+		if err := os.Rename(filepath.Join(filepath.Dir(metadata[0]), entry.Name()), filepath.Join(refDir, entry.Name())); err != nil {
+			return conanReference{}, fmt.Errorf("restoring recipe: %w", err)
+		}
+	}
+
+	for i, packagePath := range conanPackageArchives(recipePath) {
+		packageDir := filepath.Join(stagingDir, fmt.Sprintf("package-%d", i))
+		if err := extractTarGz(packagePath, packageDir, 0); err != nil {
+			return conanReference{}, fmt.Errorf("extracting binary package: %w", err)
+		}
+		folders, _ := filepath.Glob(filepath.Join(packageDir, refPath, "package", "*"))
+		if len(folders) != 1 {
+			return conanReference{}, fmt.Errorf("%s is not a Conan package archive of %s", filepath.Base(packagePath), ref)
+		}
+		if err := os.Rename(folders[0], filepath.Join(refDir, "package", filepath.Base(folders[0]))); err != nil {
+			return conanReference{}, fmt.Errorf("restoring binary package: %w", err)
+		}
+	}
+	return ref, nil
+}
+
+// saveConanPackage saves the recipe of the package and all of its binary packages from the Conan 2 cache into
+// a temporary archive with `conan cache save`.
+func saveConanPackage(packageName string, packageVersion string) (string, error) {
+	name, userChannel, hasUserChannel := strings.Cut(packageName, "@")
+	pattern := name + "/" + packageVersion
+	if hasUserChannel {
+		pattern += "@" + userChannel
+	}
+
+	outDir, err := newOutputDir("conan")
+	if err != nil {
+		return "", err
+	}
+	archivePath := filepath.Join(outDir, name+"-"+packageVersion+".tgz")
+	if err := runConan(nil, "cache", "save", pattern+":*", "--file", archivePath); err != nil {
+		return "", fmt.Errorf("saving %s from the Conan cache: %w", pattern, err)
+	}
+	if _, err := conanCacheArchiveReference(archivePath); err != nil {
+		return "", err
+	}
+	return archivePath, nil
+}
+
+// restoreConanCacheArchive restores a Conan 2 cache archive into the Conan home at conanHome with
+// `conan cache restore`, and returns the recipe reference.
+func restoreConanCacheArchive(conanHome string, archivePath string) (conanReference, error) {
+	ref, err := conanCacheArchiveReference(archivePath)
+	if err != nil {
+		return conanReference{}, err
+	}
+	conanHome, err = filepath.Abs(conanHome)
+	if err != nil {
+		return conanReference{}, err
+	}
+	archivePath, err = filepath.Abs(archivePath)
+	if err != nil {
+		return conanReference{}, err
+	}
+	if err := os.MkdirAll(conanHome, 0755); err != nil {
+		return conanReference{}, fmt.Errorf("creating Conan home: %w", err)
+	}
+	if err := runConan([]string{"CONAN_HOME=" + conanHome}, "cache", "restore", archivePath); err != nil {
+		return conanReference{}, fmt.Errorf("restoring %s: %w", ref, err)
+	}
+	return ref, nil
+}
+
+// conanCacheArchiveReference returns the recipe reference listed in the pkglist.json of a Conan 2 cache
+// archive, which must hold a single recipe.
+func conanCacheArchiveReference(archivePath string) (conanReference, error) {
+	data, err := readTarGzFile(archivePath, "pkglist.json")
+	if err != nil {
+		return conanReference{}, err
+	}
+	var refs []string
+	gjson.GetBytes(data, "Local Cache").ForEach(func(key, _ gjson.Result) bool {
+		refs = append(refs, key.String())
+		return true
+	})
+	if len(refs) != 1 {
+		return conanReference{}, fmt.Errorf("%s holds %d recipes, expected 1", filepath.Base(archivePath), len(refs))
+	}
+
+	ref, _, _ := strings.Cut(refs[0], "#")
+	nameVersion, userChannel, hasUserChannel := strings.Cut(ref, "@")
+	name, version, ok := strings.Cut(nameVersion, "/")
+	if !ok {
+		return conanReference{}, fmt.Errorf("invalid recipe reference %s in %s", refs[0], filepath.Base(archivePath))
+	}
+	user, channel := "_", "_"
+	if hasUserChannel {
+		if user, channel, ok = strings.Cut(userChannel, "/"); !ok {
+			return conanReference{}, fmt.Errorf("invalid recipe reference %s in %s", refs[0], filepath.Base(archivePath))
+		}
+	}
+	return conanReference{Name: name, Version: version, User: user, Channel: channel}, nil
+}
+
+// runConan runs the conan command found on the PATH with the given variables added to its environment,
+// including its output in the error if it fails.
+func runConan(env []string, args ...string) error {
+	cmd := exec.Command("conan", args...)
+	cmd.Env = append(os.Environ(), env...)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("conan %s: %w: %s", strings.Join(args, " "), err, bytes.TrimSpace(output))
+	}
+	return nil
+}
+
+// conanRequirementName returns the recipe name of a reference such as "zlib/1.2.13@user/channel".
+func conanRequirementName(ref string) string {
+	name, _, _ := strings.Cut(strings.TrimSpace(ref), "/")
+	return name
+}
+
+// setConanfileTxtRequire replaces the requirement on the package in the [requires] section of a conanfile.txt,
+// removing any other requirement on it, or adds it to the end of the section. A [requires] section is added
+// at the top of the file if there is none.
+func setConanfileTxtRequire(content string, ref conanReference) string {
+	lines := strings.Split(content, "\n")
+	start, end := -1, len(lines)
+	for i, line := range lines {
+		if trimmed := strings.TrimSpace(line); strings.HasPrefix(trimmed, "[") {
+			if start != -1 {
+				end = i
+				break
+			}
+			if trimmed == "[requires]" {
+				start = i
+			}
+		}
+	}
+	if start == -1 {
+		return strings.Join(append([]string{"[requires]", ref.String(), ""}, lines...), "\n")
+	}
+
+	replaced := false
+	last := start
+	for i := start + 1; i < end; i++ {
+		value, _, _ := strings.Cut(lines[i], "#")
+		if strings.TrimSpace(value) == "" {
+			continue
+		}
+		if conanRequirementName(value) != ref.Name {
+			last = i
+			continue
+		}
+		if replaced {
+			lines = append(lines[:i], lines[i+1:]...)
+			i--
+			end--
+			continue
+		}
+		indent := lines[i][:len(lines[i])-len(strings.TrimLeft(lines[i], " \t"))]
+		comment := ""
+		if j := strings.Index(lines[i], "#"); j != -1 {
+			comment = " " + lines[i][j:]
+		}
+		lines[i] = indent + ref.String() + comment
+		replaced = true
+		last = i
+	}
+	if replaced {
+		return strings.Join(lines, "\n")
+	}
+	return strings.Join(insertLine(lines, last+1, ref.String()), "\n")
+}
+
+// conanfileRequirements returns the parts of a conanfile.py that hold requirements: the argument lists of
+// self.requires() calls and the values of requires attributes, which may be a string or a bracketed tuple or
+// list. code is the conanfile with its comments masked. The parts are in source order.
+func conanfileRequirements(code string) []sourceSpan {
+	var spans []sourceSpan
+	for _, loc := range conanSelfRequiresPattern.FindAllStringIndex(code, -1) {
+		if end := closingBracket(code, loc[1]-1); end != -1 {
+			spans = append(spans, sourceSpan{Start: loc[1] - 1, End: end + 1})
+		}
+	}
+	for _, loc := range conanRequiresAttrPattern.FindAllStringIndex(code, -1) {
+		start, end := loc[1], len(code)
+		if i := strings.IndexByte(code[start:], '\n'); i != -1 {
+			end = start + i
+		}
+		if start < len(code) && (code[start] == '(' || code[start] == '[') {
+			if closing := closingBracket(code, start); closing != -1 {
+				end = closing + 1
+			}
+		}
+		spans = append(spans, sourceSpan{Start: start, End: end})
+	}
+	sort.Slice(spans, func(i, j int) bool { return spans[i].Start < spans[j].Start })
+	return spans
+}
+
+// setConanfilePyRequire updates the requirement on the package in a conanfile.py. Every string literal
+// referencing another version of the package in the arguments of self.requires() or in the requires attribute is
+// replaced, leaving others such as tool_requires, exports_sources and paths alone; otherwise the requirement is
+// added to the requirements() method, or to the requires attribute, or as a new requires attribute of the
+// ConanFile class.
+func setConanfilePyRequire(content string, ref conanReference) string {
+	literal := regexp.MustCompile(`"` + regexp.QuoteMeta(ref.Name) + `/[^"\s]*"|'` + regexp.QuoteMeta(ref.Name) + `/[^'\s]*'`)
+	code := maskHashComments(content)
+	var literals []sourceSpan
+	for _, span := range conanfileRequirements(code) {
+		for _, loc := range literal.FindAllStringIndex(code[span.Start:span.End], -1) {
+			literals = append(literals, sourceSpan{Start: span.Start + loc[0], End: span.Start + loc[1]})
+		}
+	}
+	if len(literals) > 0 {
+		// Literals are replaced from the end so the positions of earlier ones stay valid
+		for i := len(literals) - 1; i >= 0; i-- {
+			quote := content[literals[i].Start : literals[i].Start+1]
+			content = content[:literals[i].Start] + quote + ref.String() + quote + content[literals[i].End:]
+		}
+		return content
+	}
+
+	lines := strings.Split(content, "\n")
+	for i, line := range lines {
+		m := conanRequirementsPattern.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		// Append to the end of the method body
+		defIndent := len(m[1])
+		bodyIndent, last := defIndent+4, i
+		for j := i + 1; j < len(lines); j++ {
+			if strings.TrimSpace(lines[j]) == "" {
+				continue
+			}
+			indent := len(lines[j]) - len(strings.TrimLeft(lines[j], " \t"))
+			if indent <= defIndent {
+				break
+			}
+			if last == i {
+				bodyIndent = indent
+			}
+			last = j
+		}
+		requireLine := strings.Repeat(" ", bodyIndent) + `self.requires("` + ref.String() + `")`
+		return strings.Join(insertLine(lines, last+1, requireLine), "\n")
+	}
+
+	for i, line := range lines {
+		m := conanRequiresPattern.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		return strings.Join(addConanRequiresItem(lines, i, len(m[0]), ref.String()), "\n")
+	}
+
+	for i, line := range lines {
+		if !conanClassPattern.MatchString(line) {
+			continue
+		}
+		indent := 4
+		for j := i + 1; j < len(lines); j++ {
+			if strings.TrimSpace(lines[j]) != "" {
+				indent = len(lines[j]) - len(strings.TrimLeft(lines[j], " \t"))
+				break
+			}
+		}
+		return strings.Join(insertLine(lines, i+1, strings.Repeat(" ", indent)+`requires = "`+ref.String()+`"`), "\n")
+	}
+	return content
+}
+
+// addConanRequiresItem adds ref to the requires attribute at lines[idx], whose value starts at valueStart:
+// a single string, a tuple without parentheses, or a tuple or list over one or more lines.
+func addConanRequiresItem(lines []string, idx int, valueStart int, ref string) []string {
+	item := `"` + ref + `"`
+	line := lines[idx]
+	value := line[valueStart:]
+	if value == "" || (value[0] != '(' && value[0] != '[') {
+		// requires = "a/1.0" or requires = "a/1.0", "b/2.0"
+		code, comment := splitPythonComment(line)
+		lines[idx] = strings.TrimRight(code, " ") + ", " + item + comment
+		return lines
+	}
+
+	close := map[byte]byte{'(': ')', '[': ']'}[value[0]]
+	for j := idx; j < len(lines); j++ {
+		code, comment := splitPythonComment(lines[j])
+		closeIdx := strings.LastIndexByte(code, close)
+		if closeIdx == -1 || (j == idx && closeIdx < valueStart) {
+			continue
+		}
+		if j == idx {
+			// Single line
+			inner := strings.TrimSpace(code[valueStart+1 : closeIdx])
+			switch {
+			case inner == "" && close == ')':
+				inner = item + ","
+			case inner == "":
+				inner = item
+			case strings.HasSuffix(inner, ","):
+				inner += " " + item
+			default:
+				inner += ", " + item
+			}
+			lines[idx] = code[:valueStart+1] + inner + code[closeIdx:] + comment
+			return lines
+		}
+		if strings.TrimSpace(code[:closeIdx]) != "" {
+			// The last item shares a line with the closing bracket
+			lines[j] = strings.TrimRight(code[:closeIdx], " ") + ", " + item + code[closeIdx:] + comment
+			return lines
+		}
+		// One item per line: add a line before the closing bracket
+		prev := j - 1
+		for prev > idx && strings.TrimSpace(lines[prev]) == "" {
+			prev--
+		}
+		indent := lines[prev][:len(lines[prev])-len(strings.TrimLeft(lines[prev], " \t"))]
+		if prev == idx {
+			indent = lines[j][:len(lines[j])-len(strings.TrimLeft(lines[j], " \t"))] + "    "
+		} else if prevCode, prevComment := splitPythonComment(lines[prev]); !strings.HasSuffix(strings.TrimSpace(prevCode), ",") {
+			lines[prev] = strings.TrimRight(prevCode, " ") + "," + prevComment
+		}
+		return insertLine(lines, j, indent+item+",")
+	}
+	return lines
+}
+
+// splitPythonComment splits a line of Python into its code and trailing comment, including the whitespace
+// before the comment.
+func splitPythonComment(line string) (string, string) {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#':
+			code := strings.TrimRight(line[:i], " \t")
+			return code, line[len(code):]
+		}
+	}
+	return line, ""
+}
+
+// insertLine inserts line into lines before index at.
+func insertLine(lines []string, at int, line string) []string {
+	return append(lines[:at], append([]string{line}, lines[at:]...)...)
+}
@@ -0,0 +1,578 @@
+package packages
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeTestConanCache writes the given files, relative to the data directory, into a Conan 1 cache under
+// userHome and points CONAN_USER_HOME at it.
+func writeTestConanCache(t *testing.T, userHome string, files map[string]string) string {
+	t.Helper()
+	dataDir := filepath.Join(userHome, ".conan", "data")
+	for name, content := range files {
+		path := filepath.Join(dataDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("CONAN_USER_HOME", userHome)
+	t.Setenv("CONAN_STORAGE_PATH", "")
+	return dataDir
+}
+
+// testConanPkglist is the package list of a Conan 2 cache archive of fwcore/2.3.0@acme/stable.
+const testConanPkglist = `{
+  "Local Cache": {
+    "fwcore/2.3.0@acme/stable": {
+      "revisions": {
+        "5f2b9c1e": {"packages": {"0a1b": {"revisions": {"77aa": {}}}}}
+      }
+    }
+  }
+}`
+
+// writeFakeConan puts a conan script in binDir on the PATH, which logs its arguments and CONAN_HOME to the
+// returned file, and writes an archive holding $FAKE_CONAN_PKGLIST to the --file of `conan cache save`.
+func writeFakeConan(t *testing.T, binDir string) string {
+	t.Helper()
+	binDir, err := filepath.Abs(binDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(binDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	logPath := filepath.Join(binDir, "conan.log")
+	script := `#!/bin/sh
+if [ -n "$CONAN_HOME" ]; then echo "CONAN_HOME=$CONAN_HOME" >> "` + logPath + `"; fi
+echo "$@" >> "` + logPath + `"
+if [ "$1 $2" = "cache save" ]; then
+	printf '%s' "$FAKE_CONAN_PKGLIST" > "` + filepath.Join(binDir, "pkglist.json") + `"
+	tar -czf "$5" -C "` + binDir + `" pkglist.json
+fi
+`
+	if err := os.WriteFile(filepath.Join(binDir, "conan"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("CONAN_HOME", "")
+	t.Setenv("FAKE_CONAN_PKGLIST", testConanPkglist)
+	return logPath
+}
+
+func TestConanLocatePackageFiles(t *testing.T) {
+	handler := &ConanHandler{}
+	dir := "../../testdata"
+
+	tempDir, err := os.MkdirTemp(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tempDir); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to remove temp folder: %v\n", err)
+		}
+	}() // Clean up
+
+	files := map[string]string{
+		"fwcore/2.1.0/acme/stable/export/conanfile.py":           "",
+		"fwcore/2.1.0/acme/stable/export/conanmanifest.txt":      "",
+		"fwcore/2.1.0/acme/stable/export_source/src/core.cpp":    "",
+		"fwcore/2.1.0/acme/stable/metadata.json":                 "{}",
+		"fwcore/2.1.0/acme/stable/source/src/core.cpp":           "",
+		"fwcore/2.1.0/acme/stable/build/0a1b/CMakeCache.txt":     "",
+		"fwcore/2.1.0/acme/stable/package/0a1b/conaninfo.txt":    "",
+		"fwcore/2.1.0/acme/stable/package/0a1b/lib/libfwcore.a":  "",
+		"fwcore/2.1.0/acme/stable/package/9f8e/conaninfo.txt":    "",
+		"fwcore/2.1.0/acme/stable/package/9f8e/include/fwcore.h": "",
+		"fwcore/2.1.0/acme/testing/export/conanfile.py":          "",
+		"fwcore/2.2.0/_/_/export/conanfile.py":                   "",
+		"fwcore/2.2.0/_/_/metadata.json":                         "{}",
+		"drivers/1.0.0/acme/stable/export/conanfile.py":          "",
+		"drivers/1.0.0/acme/stable/package/0a1b/conaninfo.txt":   "",
+	}
+	dataDir := writeTestConanCache(t, filepath.Join(tempDir, "home"), files)
+
+	prebuiltDir := filepath.Join(tempDir, "pulled")
+	for _, name := range []string{"fwcore-2.0.0-recipe.tgz", "fwcore-2.0.0-package-0a1b.tgz", "fwcore-2.0.0-package-9f8e.tgz"} {
+		writeTestTarGz(t, filepath.Join(prebuiltDir, name), map[string]string{"metadata.json": ""})
+	}
+
+	t.Run("find pulled archives", func(t *testing.T) {
+		found, err := handler.LocatePackageFiles(prebuiltDir, "fwcore", "2.0.0")
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		expected := []string{
+			filepath.Join(prebuiltDir, "fwcore-2.0.0-recipe.tgz"),
+			filepath.Join(prebuiltDir, "fwcore-2.0.0-package-0a1b.tgz"),
+			filepath.Join(prebuiltDir, "fwcore-2.0.0-package-9f8e.tgz"),
+		}
+		if fmt.Sprint(found) != fmt.Sprint(expected) {
+			t.Errorf("expected %v, got %v", expected, found)
+		}
+	})
+
+	t.Run("build from cache", func(t *testing.T) {
+		found, err := handler.LocatePackageFiles(tempDir, "fwcore@acme/stable", "2.1.0")
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		defer func() {
+			_ = os.RemoveAll(filepath.Dir(found[0]))
+		}()
+		if len(found) != 3 {
+			t.Fatalf("expected a recipe and 2 package archives, got %v", found)
+		}
+
+		expected := map[string][]string{
+			"fwcore-2.1.0-recipe.tgz": {
+				"fwcore/2.1.0/acme/stable/export/conanfile.py",
+				"fwcore/2.1.0/acme/stable/export/conanmanifest.txt",
+				"fwcore/2.1.0/acme/stable/export_source/src/core.cpp",
+				"fwcore/2.1.0/acme/stable/metadata.json",
+			},
+			"fwcore-2.1.0-package-0a1b.tgz": {
+				"fwcore/2.1.0/acme/stable/package/0a1b/conaninfo.txt",
+				"fwcore/2.1.0/acme/stable/package/0a1b/lib/libfwcore.a",
+			},
+			"fwcore-2.1.0-package-9f8e.tgz": {
+				"fwcore/2.1.0/acme/stable/package/9f8e/conaninfo.txt",
+				"fwcore/2.1.0/acme/stable/package/9f8e/include/fwcore.h",
+			},
+		}
+		for _, archive := range found {
+			extractDir := filepath.Join(tempDir, "extracted")
+			if err := extractTarGz(archive, extractDir, 0); err != nil {
+				t.Fatal(err)
+			}
+			for name := range files {
+				want := false
+				for _, e := range expected[filepath.Base(archive)] {
+					want = want || e == name
+				}
+				if fileExists(filepath.Join(extractDir, filepath.FromSlash(name))) != want {
+					t.Errorf("expected %s in %s: %v", name, filepath.Base(archive), want)
+				}
+			}
+		}
+	})
+
+	t.Run("fail with several users and channels", func(t *testing.T) {
+		if _, err := handler.LocatePackageFiles(tempDir, "fwcore", "2.1.0"); err == nil {
+			t.Fatal("expected error, got nil")
+		}
+	})
+
+	t.Run("fail without binary packages", func(t *testing.T) {
+		if _, err := handler.LocatePackageFiles(tempDir, "fwcore", "2.2.0"); err == nil {
+			t.Fatal("expected error, got nil")
+		}
+	})
+
+	t.Run("find pulled Conan 2 archive", func(t *testing.T) {
+		archivePath := filepath.Join(prebuiltDir, "fwcore-2.3.0.tgz")
+		writeTestTarGz(t, archivePath, map[string]string{"pkglist.json": testConanPkglist})
+		found, err := handler.LocatePackageFiles(prebuiltDir, "fwcore", "2.3.0")
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if fmt.Sprint(found) != fmt.Sprint([]string{archivePath}) {
+			t.Errorf("expected %v, got %v", []string{archivePath}, found)
+		}
+	})
+
+	t.Run("save from Conan 2 cache", func(t *testing.T) {
+		logPath := writeFakeConan(t, filepath.Join(tempDir, "conan2"))
+		found, err := handler.LocatePackageFiles(tempDir, "fwcore@acme/stable", "2.3.0")
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		defer func() {
+			_ = os.RemoveAll(filepath.Dir(found[0]))
+		}()
+		if len(found) != 1 || filepath.Base(found[0]) != "fwcore-2.3.0.tgz" {
+			t.Fatalf("expected fwcore-2.3.0.tgz, got %v", found)
+		}
+		log, err := os.ReadFile(logPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := "cache save fwcore/2.3.0@acme/stable:* --file " + found[0] + "\n"; string(log) != expected {
+			t.Errorf("expected conan to be run as:\n%s\ngot:\n%s", expected, log)
+		}
+	})
+
+	t.Run("fail without Conan 2 recipe", func(t *testing.T) {
+		writeFakeConan(t, filepath.Join(tempDir, "conan2-empty"))
+		t.Setenv("FAKE_CONAN_PKGLIST", `{"Local Cache": {}}`)
+		if _, err := handler.LocatePackageFiles(tempDir, "fwcore", "2.4.0"); err == nil {
+			t.Fatal("expected error, got nil")
+		}
+	})
+
+	t.Run("storage path from conan.conf", func(t *testing.T) {
+		conf := "[general]\ndefault_profile = default\n\n[storage]\npath = ./cache-data\n"
+		confPath := filepath.Join(tempDir, "home", ".conan", "conan.conf")
+		if err := os.WriteFile(confPath, []byte(conf), 0644); err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			_ = os.Remove(confPath)
+		}()
+		got, err := conanDataDir()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := filepath.Join(filepath.Dir(dataDir), "cache-data"); got != expected {
+			t.Errorf("expected %s, got %s", expected, got)
+		}
+	})
+}
+
+func TestConanUpdatePackageRef(t *testing.T) {
+	testCases := []struct {
+		name      string
+		conanfile string
+		input     string
+		expected  string
+	}{
+		{
+			name:      "conanfile.txt replaces requirement",
+			conanfile: "conanfile.txt",
+			input: `[requires]
+fmt/10.2.1
+fwcore/2.0.0@acme/stable # shared firmware core
+
+[generators]
+CMakeDeps
+`,
+			expected: `[requires]
+fmt/10.2.1
+fwcore/2.1.0@acme/stable # shared firmware core
+
+[generators]
+CMakeDeps
+`,
+		},
+		{
+			name:      "conanfile.txt adds requirement",
+			conanfile: "conanfile.txt",
+			input: `[requires]
+fmt/10.2.1
+
+[generators]
+CMakeDeps
+`,
+			expected: `[requires]
+fmt/10.2.1
+fwcore/2.1.0@acme/stable
+
+[generators]
+CMakeDeps
+`,
+		},
+		{
+			name:      "conanfile.txt adds requires section",
+			conanfile: "conanfile.txt",
+			input: `[generators]
+CMakeDeps
+`,
+			expected: `[requires]
+fwcore/2.1.0@acme/stable
+
+[generators]
+CMakeDeps
+`,
+		},
+		{
+			name:      "conanfile.py replaces requirement",
+			conanfile: "conanfile.py",
+			input: `from conans import ConanFile
+
+class AppConan(ConanFile):
+    settings = "os", "arch"
+
+    def requirements(self):
+        self.requires('fwcore/[>=2.0]@acme/stable')
+`,
+			expected: `from conans import ConanFile
+
+class AppConan(ConanFile):
+    settings = "os", "arch"
+
+    def requirements(self):
+        self.requires('fwcore/2.1.0@acme/stable')
+`,
+		},
+		{
+			name:      "conanfile.py adds to requirements method",
+			conanfile: "conanfile.py",
+			input: `from conans import ConanFile
+
+class AppConan(ConanFile):
+    def requirements(self):
+        self.requires("fmt/10.2.1")
+        if self.settings.os == "Linux":
+            self.requires("libudev/1.0")
+
+    def build(self):
+        pass
+`,
+			expected: `from conans import ConanFile
+
+class AppConan(ConanFile):
+    def requirements(self):
+        self.requires("fmt/10.2.1")
+        if self.settings.os == "Linux":
+            self.requires("libudev/1.0")
+        self.requires("fwcore/2.1.0@acme/stable")
+
+    def build(self):
+        pass
+`,
+		},
+		{
+			name:      "conanfile.py replaces requirement in requires tuple only",
+			conanfile: "conanfile.py",
+			input: `class AppConan(ConanFile):
+    requires = (
+        "fmt/10.2.1",
+        "fwcore/2.0.0@acme/stable",  # "fwcore/1.0"
+    )
+    tool_requires = "fwcore/1.0"
+    exports_sources = "fwcore/*", "src/*"
+
+    def package(self):
+        copy(self, "*.h", "fwcore/include", self.package_folder)
+`,
+			expected: `class AppConan(ConanFile):
+    requires = (
+        "fmt/10.2.1",
+        "fwcore/2.1.0@acme/stable",  # "fwcore/1.0"
+    )
+    tool_requires = "fwcore/1.0"
+    exports_sources = "fwcore/*", "src/*"
+
+    def package(self):
+        copy(self, "*.h", "fwcore/include", self.package_folder)
+`,
+		},
+		{
+			name:      "conanfile.py adds requirement next to other literals naming the package",
+			conanfile: "conanfile.py",
+			input: `class AppConan(ConanFile):
+    exports_sources = "fwcore/*", "src/*"
+
+    def build_requirements(self):
+        self.tool_requires("fwcore/1.0")
+
+    def requirements(self):
+        self.requires("fmt/10.2.1")
+
+    def layout(self):
+        self.folders.source = "fwcore/src"
+`,
+			expected: `class AppConan(ConanFile):
+    exports_sources = "fwcore/*", "src/*"
+
+    def build_requirements(self):
+        self.tool_requires("fwcore/1.0")
+
+    def requirements(self):
+        self.requires("fmt/10.2.1")
+        self.requires("fwcore/2.1.0@acme/stable")
+
+    def layout(self):
+        self.folders.source = "fwcore/src"
+`,
+		},
+		{
+			name:      "conanfile.py adds to requires string",
+			conanfile: "conanfile.py",
+			input: `class AppConan(ConanFile):
+    requires = "fmt/10.2.1"  # formatting
+`,
+			expected: `class AppConan(ConanFile):
+    requires = "fmt/10.2.1", "fwcore/2.1.0@acme/stable"  # formatting
+`,
+		},
+		{
+			name:      "conanfile.py adds to requires tuple",
+			conanfile: "conanfile.py",
+			input: `class AppConan(ConanFile):
+    requires = ("fmt/10.2.1", "spdlog/1.13.0")
+`,
+			expected: `class AppConan(ConanFile):
+    requires = ("fmt/10.2.1", "spdlog/1.13.0", "fwcore/2.1.0@acme/stable")
+`,
+		},
+		{
+			name:      "conanfile.py adds to multi-line requires list",
+			conanfile: "conanfile.py",
+			input: `class AppConan(ConanFile):
+    requires = [
+        "fmt/10.2.1",
+        "spdlog/1.13.0"
+    ]
+`,
+			expected: `class AppConan(ConanFile):
+    requires = [
+        "fmt/10.2.1",
+        "spdlog/1.13.0",
+        "fwcore/2.1.0@acme/stable",
+    ]
+`,
+		},
+		{
+			name:      "conanfile.py adds requires attribute",
+			conanfile: "conanfile.py",
+			input: `from conans import ConanFile
+
+
+class AppConan(ConanFile):
+    settings = "os", "arch"
+`,
+			expected: `from conans import ConanFile
+
+
+class AppConan(ConanFile):
+    requires = "fwcore/2.1.0@acme/stable"
+    settings = "os", "arch"
+`,
+		},
+	}
+
+	handler := &ConanHandler{}
+	dir := "../../testdata"
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			tempDir, err := os.MkdirTemp(dir, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				if err := os.RemoveAll(tempDir); err != nil {
+					fmt.Fprintf(os.Stderr, "warning: failed to remove temp folder: %v\n", err)
+				}
+			}() // Clean up
+
+			// Build the archives from a producer's cache
+			writeTestConanCache(t, filepath.Join(tempDir, "home"), map[string]string{
+				"fwcore/2.1.0/acme/stable/export/conanfile.py":          "class FwCoreConan(ConanFile):\n",
+				"fwcore/2.1.0/acme/stable/metadata.json":                "{}",
+				"fwcore/2.1.0/acme/stable/package/0a1b/conaninfo.txt":   "",
+				"fwcore/2.1.0/acme/stable/package/0a1b/lib/libfwcore.a": "",
+			})
+			archives, err := handler.LocatePackageFiles(tempDir, "fwcore", "2.1.0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				_ = os.RemoveAll(filepath.Dir(archives[0]))
+			}()
+
+			projectDir := filepath.Join(tempDir, "app")
+			conanfilePath := filepath.Join(projectDir, testCase.conanfile)
+			if err := os.MkdirAll(projectDir, 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(conanfilePath, []byte(testCase.input), 0644); err != nil {
+				t.Fatal(err)
+			}
+			// A stale package from a previous install must be removed
+			staleInfo := filepath.Join(projectDir, InstallDir, "conan", ".conan", "data", "fwcore", "2.1.0", "acme", "stable", "package", "dead", "conaninfo.txt")
+			if err := os.MkdirAll(filepath.Dir(staleInfo), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(staleInfo, nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := handler.UpdatePackageRef("fwcore", archives[0], projectDir); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			got, err := os.ReadFile(conanfilePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != testCase.expected {
+				t.Errorf("expected %s:\n%s\ngot:\n%s", testCase.conanfile, testCase.expected, got)
+			}
+
+			refDir := filepath.Join(projectDir, InstallDir, "conan", ".conan", "data", "fwcore", "2.1.0", "acme", "stable")
+			for _, name := range []string{"export/conanfile.py", "metadata.json", "package/0a1b/conaninfo.txt", "package/0a1b/lib/libfwcore.a"} {
+				if !fileExists(filepath.Join(refDir, filepath.FromSlash(name))) {
+					t.Errorf("expected %s to be restored into the project cache", name)
+				}
+			}
+			if fileExists(staleInfo) {
+				t.Error("expected stale package to be removed")
+			}
+		})
+	}
+}
+
+func TestConanUpdatePackageRefConan2(t *testing.T) {
+	handler := &ConanHandler{}
+	dir := "../../testdata"
+
+	tempDir, err := os.MkdirTemp(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tempDir); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to remove temp folder: %v\n", err)
+		}
+	}() // Clean up
+
+	logPath := writeFakeConan(t, filepath.Join(tempDir, "bin"))
+	archivePath := filepath.Join(tempDir, "fwcore-2.3.0.tgz")
+	writeTestTarGz(t, archivePath, map[string]string{"pkglist.json": testConanPkglist})
+
+	projectDir := filepath.Join(tempDir, "app")
+	if err := os.MkdirAll(projectDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	conanfilePath := filepath.Join(projectDir, "conanfile.txt")
+	if err := os.WriteFile(conanfilePath, []byte("[requires]\nfwcore/2.1.0@acme/stable\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := handler.UpdatePackageRef("fwcore", archivePath, projectDir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	got, err := os.ReadFile(conanfilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "[requires]\nfwcore/2.3.0@acme/stable\n"; string(got) != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, got)
+	}
+
+	conanHome, err := filepath.Abs(filepath.Join(projectDir, InstallDir, "conan2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	absArchivePath, err := filepath.Abs(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "CONAN_HOME=" + conanHome + "\ncache restore " + absArchivePath + "\n"; string(log) != expected {
+		t.Errorf("expected conan to be run as:\n%s\ngot:\n%s", expected, log)
+	}
+}
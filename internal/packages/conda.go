@@ -0,0 +1,357 @@
+package packages
+
+import (
+	"archive/zip"
+	"compress/bzip2"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/pretty"
+	"github.com/tidwall/sjson"
+)
+
+type CondaHandler struct{}
+
+var (
+	// condaExtensions are the file extensions of conda packages, in order of preference.
+	condaExtensions      = []string{".conda", ".tar.bz2"}
+	condaSpecNamePattern = regexp.MustCompile(`^(?:[^\s:]+::)?([A-Za-z0-9_][A-Za-z0-9_.-]*)`)
+)
+
+// condaRepodata is the repodata.json of a channel subdirectory, listing the packages in it.
+type condaRepodata struct {
+	Info struct {
+		Subdir string `json:"subdir"`
+	} `json:"info"`
+	Packages        map[string]json.RawMessage `json:"packages"`
+	PackagesConda   map[string]json.RawMessage `json:"packages.conda"`
+	Removed         []string                   `json:"removed"`
+	RepodataVersion int                        `json:"repodata_version"`
+}
+
+// LocatePackage finds a build of the package, <name>-<version>-<build>.conda or .tar.bz2, in the specified
+// directory or in one of its subdirectories, where conda-build writes its output per platform. The .conda
+// format is preferred, but distinct builds of the version are ambiguous.
+func (c *CondaHandler) LocatePackage(dir string, packageName string, packageVersion string) (string, error) {
+	searchDirs := []string{dir}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", fmt.Errorf("error reading package directory: %w", err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() && entry.Name() != InstallDir {
+			searchDirs = append(searchDirs, filepath.Join(dir, entry.Name()))
+		}
+	}
+
+	builds := map[string]string{}
+	for _, searchDir := range searchDirs {
+		entries, err := os.ReadDir(searchDir)
+		if err != nil {
+			return "", fmt.Errorf("error reading package directory: %w", err)
+		}
+		for _, entry := range entries {
+			name, version, build, ok := parseCondaFilename(entry.Name())
+			if entry.IsDir() || !ok || name != packageName || version != packageVersion {
+				continue
+			}
+			if existing, found := builds[build]; !found || (strings.HasSuffix(entry.Name(), ".conda") && !strings.HasSuffix(existing, ".conda")) {
+				builds[build] = filepath.Join(searchDir, entry.Name())
+			}
+		}
+	}
+
+	if len(builds) == 0 {
+		return "", fmt.Errorf("expected package file not found: %s-%s-*.conda or %s-%s-*.tar.bz2", packageName, packageVersion, packageName, packageVersion)
+	}
+	var packagePaths []string
+	for _, packagePath := range builds {
+		packagePaths = append(packagePaths, packagePath)
+	}
+	if len(packagePaths) > 1 {
+		sort.Strings(packagePaths)
+		return "", fmt.Errorf("found several builds of %s %s: %s", packageName, packageVersion, strings.Join(packagePaths, ", "))
+	}
+	return packagePaths[0], nil
+}
+
+// UpdatePackageRef adds the package to a channel next to the nearest environment.yml, replacing other builds
+// of it, and regenerates the channel's repodata.json. The channel is added first to the environment's
+// channels, and the exact build to its dependencies, replacing any other spec of the package.
+func (c *CondaHandler) UpdatePackageRef(packageName string, packageFilePath string, packageRefFilePath string) error {
+	envPath, err := findFileUp(packageRefFilePath, "environment.yml", "environment.yaml")
+	if err != nil {
+		return fmt.Errorf("finding environment.yml: %w", err)
+	}
+	projectDir := filepath.Dir(envPath)
+
+	index, err := readCondaIndex(packageFilePath)
+	if err != nil {
+		return err
+	}
+	if name := gjson.GetBytes(index, "name").String(); name != packageName {
+		return fmt.Errorf("%s contains package %q, not %s", filepath.Base(packageFilePath), name, packageName)
+	}
+	subdir := gjson.GetBytes(index, "subdir").String()
+	if subdir == "" {
+		subdir = "noarch"
+	}
+
+	channelDir := filepath.Join(projectDir, InstallDir, "conda-channel")
+	subdirPath := filepath.Join(channelDir, subdir)
+	// Other builds of the package are removed, so the channel only offers the one the environment pins
+	err = removeMatchingFiles(subdirPath, func(filename string) bool {
+		name, _, _, ok := parseCondaFilename(filename)
+		return ok && name == packageName && filename != filepath.Base(packageFilePath)
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := copyFile(packageFilePath, subdirPath); err != nil {
+		return fmt.Errorf("storing package: %w", err)
+	}
+	// conda requires every channel to have a noarch subdirectory
+	subdirs := []string{subdir}
+	if subdir != "noarch" {
+		subdirs = append(subdirs, "noarch")
+	}
+	for _, dir := range subdirs {
+		if err := writeCondaRepodata(filepath.Join(channelDir, dir), dir); err != nil {
+			return err
+		}
+	}
+
+	relPath, err := filepath.Rel(projectDir, channelDir)
+	if err != nil {
+		return fmt.Errorf("calculating relative path: %w", err)
+	}
+	spec := fmt.Sprintf("%s=%s=%s", packageName, gjson.GetBytes(index, "version").String(), gjson.GetBytes(index, "build").String())
+
+	data, err := os.ReadFile(envPath)
+	if err != nil {
+		return err
+	}
+	// conda resolves channel paths starting with ./ relative to the working directory
+	updated, err := setCondaEnvironmentDependency(string(data), "./"+filepath.ToSlash(relPath), packageName, spec)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(envPath, []byte(updated), 0644)
+}
+
+// parseCondaFilename splits the filename of a conda package into the package's name, version and build string.
+// Versions and build strings cannot contain dashes, while names can.
+func parseCondaFilename(filename string) (string, string, string, bool) {
+	for _, ext := range condaExtensions {
+		base, ok := strings.CutSuffix(filename, ext)
+		if !ok {
+			continue
+		}
+		parts := strings.Split(base, "-")
+		if len(parts) < 3 {
+			return "", "", "", false
+		}
+		n := len(parts)
+		return strings.Join(parts[:n-2], "-"), parts[n-2], parts[n-1], true
+	}
+	return "", "", "", false
+}
+
+// readCondaIndex returns the info/index.json of a conda package. In the .conda format, it is in a
+// zstd-compressed tarball inside the zip archive; a .tar.bz2 package holds it directly.
+func readCondaIndex(packagePath string) ([]byte, error) {
+	archiveName := filepath.Base(packagePath)
+	if strings.HasSuffix(archiveName, ".tar.bz2") {
+		f, err := os.Open(packagePath)
+		if err != nil {
+			return nil, err
+		}
+		defer func() {
+			_ = f.Close()
+		}()
+		return readTarFile(bzip2.NewReader(f), archiveName, "info/index.json")
+	}
+
+	r, err := zip.OpenReader(packagePath)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", archiveName, err)
+	}
+	defer func() {
+		_ = r.Close()
+	}()
+	for _, f := range r.File {
+		if !strings.HasPrefix(f.Name, "info-") || !strings.HasSuffix(f.Name, ".tar.zst") {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer func() {
+			_ = rc.Close()
+		}()
+		zr, err := zstd.NewReader(rc)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", f.Name, err)
+		}
+		defer zr.Close()
+		return readTarFile(zr, f.Name, "info/index.json")
+	}
+	return nil, fmt.Errorf("%s contains no info-*.tar.zst", archiveName)
+}
+
+// writeCondaRepodata writes the repodata.json of a channel subdirectory, like `conda index`: each package's
+// info/index.json with the checksums and size of the package file.
+func writeCondaRepodata(dir string, subdir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", dir, err)
+	}
+
+	repodata := condaRepodata{
+		Packages:        map[string]json.RawMessage{},
+		PackagesConda:   map[string]json.RawMessage{},
+		Removed:         []string{},
+		RepodataVersion: 1,
+	}
+	repodata.Info.Subdir = subdir
+	for _, entry := range entries {
+		if _, _, _, ok := parseCondaFilename(entry.Name()); entry.IsDir() || !ok {
+			continue
+		}
+		packagePath := filepath.Join(dir, entry.Name())
+		record, err := readCondaIndex(packagePath)
+		if err != nil {
+			return err
+		}
+		if !gjson.ValidBytes(record) {
+			return fmt.Errorf("parsing info/index.json of %s: invalid JSON", entry.Name())
+		}
+		data, err := os.ReadFile(packagePath)
+		if err != nil {
+			return err
+		}
+		md5Sum, sha256Sum := md5.Sum(data), sha256.Sum256(data)
+		if record, err = sjson.SetBytes(record, "md5", hex.EncodeToString(md5Sum[:])); err != nil {
+			return err
+		}
+		if record, err = sjson.SetBytes(record, "sha256", hex.EncodeToString(sha256Sum[:])); err != nil {
+			return err
+		}
+		if record, err = sjson.SetBytes(record, "size", len(data)); err != nil {
+			return err
+		}
+		if strings.HasSuffix(entry.Name(), ".conda") {
+			repodata.PackagesConda[entry.Name()] = record
+		} else {
+			repodata.Packages[entry.Name()] = record
+		}
+	}
+
+	raw, err := marshalJSON(repodata)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, "repodata.json"), pretty.PrettyOptions([]byte(raw), &pretty.Options{Indent: "  "}), 0644)
+}
+
+// setCondaEnvironmentDependency adds the channel first to the channels of an environment.yml, unless it is
+// already there, and sets the package's spec in its dependencies, replacing any other spec of the package.
+// New specs go before pip's nested requirements.
+func setCondaEnvironmentDependency(content string, channel string, packageName string, spec string) (string, error) {
+	lines := strings.Split(content, "\n")
+
+	keyIdx := yamlFindKey(lines, 0, len(lines), 0, "channels")
+	if keyIdx == -1 {
+		at := yamlFindKey(lines, 0, len(lines), 0, "dependencies")
+		if at == -1 {
+			at = len(lines)
+			if lines[at-1] == "" {
+				at--
+			}
+		}
+		lines = yamlInsertLines(lines, at, "channels:", "  - "+yamlQuote(channel))
+	} else {
+		if _, raw, _ := yamlKeyValue(lines[keyIdx], 0); yamlScalar(raw) == "[]" {
+			lines[keyIdx] = "channels:"
+		} else if yamlScalar(raw) != "" {
+			return "", fmt.Errorf("channels in environment.yml must be a block sequence")
+		}
+		items := yamlSequenceItems(lines, keyIdx+1, yamlBlockEnd(lines, keyIdx))
+		found := false
+		for _, item := range items {
+			if yamlScalar(lines[item.Start][yamlItemKeyIndent(lines, item):]) == channel {
+				found = true
+			}
+		}
+		if !found {
+			prefix, at := "  - ", keyIdx+1
+			if len(items) > 0 {
+				prefix = lines[items[0].Start][:yamlItemKeyIndent(lines, items[0])]
+				at = items[0].Start
+			}
+			lines = yamlInsertLines(lines, at, prefix+yamlQuote(channel))
+		}
+	}
+
+	keyIdx = yamlFindKey(lines, 0, len(lines), 0, "dependencies")
+	if keyIdx == -1 {
+		at := len(lines)
+		if lines[at-1] == "" {
+			at--
+		}
+		return strings.Join(yamlInsertLines(lines, at, "dependencies:", "  - "+spec), "\n"), nil
+	}
+	if _, raw, _ := yamlKeyValue(lines[keyIdx], 0); yamlScalar(raw) == "[]" {
+		lines[keyIdx] = "dependencies:"
+	} else if yamlScalar(raw) != "" {
+		return "", fmt.Errorf("dependencies in environment.yml must be a block sequence")
+	}
+	end := yamlBlockEnd(lines, keyIdx)
+	items := yamlSequenceItems(lines, keyIdx+1, end)
+	var matches []yamlItem
+	at := end
+	for _, item := range items {
+		keyIndent := yamlItemKeyIndent(lines, item)
+		if _, _, ok := yamlKeyValue(lines[item.Start], keyIndent); ok {
+			// A nested mapping, such as pip's requirements
+			at = min(at, item.Start)
+			continue
+		}
+		match := condaSpecNamePattern.FindStringSubmatch(yamlScalar(lines[item.Start][keyIndent:]))
+		if match != nil && strings.EqualFold(match[1], packageName) {
+			matches = append(matches, item)
+		}
+	}
+	if len(matches) > 0 {
+		// Items are replaced from the end so the positions of earlier ones stay valid
+		for i := len(matches) - 1; i >= 0; i-- {
+			item := matches[i]
+			var entry []string
+			if i == 0 {
+				entry = []string{lines[item.Start][:yamlItemKeyIndent(lines, item)] + spec}
+			}
+			lines = append(lines[:item.Start], append(entry, lines[item.End:]...)...)
+		}
+		return strings.Join(lines, "\n"), nil
+	}
+
+	prefix := "  - "
+	if len(items) > 0 {
+		prefix = lines[items[0].Start][:yamlItemKeyIndent(lines, items[0])]
+	}
+	return strings.Join(yamlInsertLines(lines, at, prefix+spec), "\n"), nil
+}
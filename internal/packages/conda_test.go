@@ -0,0 +1,334 @@
+package packages
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// testCondaIndex is the info/index.json of the package used by the tests.
+const testCondaIndex = `{
+  "arch": null,
+  "build": "py_0",
+  "build_number": 0,
+  "depends": [
+    "numpy >=1.24",
+    "python >=3.9"
+  ],
+  "license": "MIT",
+  "name": "acme-features",
+  "noarch": "python",
+  "platform": null,
+  "subdir": "noarch",
+  "timestamp": 1718000000000,
+  "version": "1.2.0"
+}`
+
+// writeTestCondaPackage writes a package in the .conda format with the given info/index.json, creating the
+// parent directories of path as needed.
+func writeTestCondaPackage(t *testing.T, path string, index string) {
+	t.Helper()
+	var tarBuf bytes.Buffer
+	tw := tar.NewWriter(&tarBuf)
+	if err := tw.WriteHeader(&tar.Header{Name: "info/index.json", Mode: 0644, Size: int64(len(index)), Typeflag: tar.TypeReg}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tw.Write([]byte(index)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	zw, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	infoTarball := zw.EncodeAll(tarBuf.Bytes(), nil)
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	base := strings.TrimSuffix(filepath.Base(path), ".conda")
+	w := zip.NewWriter(f)
+	for name, content := range map[string][]byte{
+		"metadata.json":             []byte(`{"conda_pkg_format_version": 2}`),
+		"info-" + base + ".tar.zst": infoTarball,
+	} {
+		fw, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fw.Write(content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCondaLocatePackage(t *testing.T) {
+	handler := &CondaHandler{}
+	dir := "../../testdata"
+
+	tempDir, err := os.MkdirTemp(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tempDir); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to remove temp folder: %v\n", err)
+		}
+	}() // Clean up
+
+	// Mimic the output directory of conda-build, which writes both formats of a build
+	for _, name := range []string{
+		"noarch/acme-features-1.2.0-py_0.conda",
+		"noarch/acme-features-1.2.0-py_0.tar.bz2",
+		"noarch/acme-features-extra-1.2.0-py_0.conda",
+		"linux-64/acme-features-1.3.0-py311_0.tar.bz2",
+		"linux-64/acme-features-1.3.0-py312_0.tar.bz2",
+	} {
+		path := filepath.Join(tempDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	testCases := []struct {
+		name           string
+		packageVersion string
+		expectedPath   string
+		expectedError  bool
+	}{
+		{
+			name:           "prefer conda format of build",
+			packageVersion: "1.2.0",
+			expectedPath:   filepath.Join(tempDir, "noarch", "acme-features-1.2.0-py_0.conda"),
+		},
+		{
+			name:           "fail on several builds",
+			packageVersion: "1.3.0",
+			expectedError:  true,
+		},
+		{
+			name:           "fail on non-existing package",
+			packageVersion: "1.4.0",
+			expectedError:  true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			filePath, err := handler.LocatePackage(tempDir, "acme-features", testCase.packageVersion)
+			if testCase.expectedError {
+				if err == nil {
+					t.Fatal("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if filePath != testCase.expectedPath {
+				t.Errorf("expected %s, got %s", testCase.expectedPath, filePath)
+			}
+		})
+	}
+}
+
+func TestCondaUpdatePackageRef(t *testing.T) {
+	testCases := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name: "adds channel and replaces spec",
+			input: `name: churn-model
+channels:
+  - conda-forge
+dependencies:
+  - python=3.11
+  - conda-forge::acme-features>=1.1  # feature store client
+  - pip
+  - pip:
+      - mlflow==2.12.1
+`,
+			expected: `name: churn-model
+channels:
+  - ./.universal-packages/conda-channel
+  - conda-forge
+dependencies:
+  - python=3.11
+  - acme-features=1.2.0=py_0
+  - pip
+  - pip:
+      - mlflow==2.12.1
+`,
+		},
+		{
+			name: "adds channels and spec before pip requirements",
+			input: `name: churn-model
+dependencies:
+- python=3.11
+- pip:
+  - mlflow==2.12.1
+`,
+			expected: `name: churn-model
+channels:
+  - ./.universal-packages/conda-channel
+dependencies:
+- python=3.11
+- acme-features=1.2.0=py_0
+- pip:
+  - mlflow==2.12.1
+`,
+		},
+		{
+			name: "keeps channel and adds dependencies",
+			input: `name: churn-model
+channels:
+  - ./.universal-packages/conda-channel
+  - defaults
+`,
+			expected: `name: churn-model
+channels:
+  - ./.universal-packages/conda-channel
+  - defaults
+dependencies:
+  - acme-features=1.2.0=py_0
+`,
+		},
+	}
+
+	handler := &CondaHandler{}
+	dir := "../../testdata"
+
+	installTempDir, err := os.MkdirTemp(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(installTempDir); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to remove temp folder: %v\n", err)
+		}
+	}() // Clean up
+
+	packageLocation := filepath.Join(installTempDir, ".upkg", "acme-features-1.2.0-py_0.conda")
+	writeTestCondaPackage(t, packageLocation, testCondaIndex)
+	envPath := filepath.Join(installTempDir, "environment.yml")
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if err := os.WriteFile(envPath, []byte(testCase.input), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := handler.UpdatePackageRef("acme-features", packageLocation, installTempDir); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			updated, err := os.ReadFile(envPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(updated) != testCase.expected {
+				t.Errorf("expected:\n%s\ngot:\n%s", testCase.expected, updated)
+			}
+		})
+	}
+}
+
+func TestCondaUpdatePackageRefChannel(t *testing.T) {
+	handler := &CondaHandler{}
+	dir := "../../testdata"
+
+	installTempDir, err := os.MkdirTemp(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(installTempDir); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to remove temp folder: %v\n", err)
+		}
+	}() // Clean up
+
+	if err := os.WriteFile(filepath.Join(installTempDir, "environment.yml"), []byte("name: churn-model\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	channelDir := filepath.Join(installTempDir, InstallDir, "conda-channel")
+	oldPackage := filepath.Join(channelDir, "noarch", "acme-features-1.1.0-py_0.conda")
+	writeTestCondaPackage(t, oldPackage, strings.ReplaceAll(testCondaIndex, "1.2.0", "1.1.0"))
+	packageLocation := filepath.Join(installTempDir, InstallDir, "acme-features", "acme-features-1.2.0-py_0.conda")
+	writeTestCondaPackage(t, packageLocation, testCondaIndex)
+
+	if err := handler.UpdatePackageRef("acme-features", packageLocation, installTempDir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if fileExists(oldPackage) {
+		t.Error("expected previous version to be removed")
+	}
+	data, err := os.ReadFile(filepath.Join(channelDir, "noarch", "acme-features-1.2.0-py_0.conda"))
+	if err != nil {
+		t.Fatalf("expected package to be stored: %v", err)
+	}
+	expected := fmt.Sprintf(`{
+  "info": {
+    "subdir": "noarch"
+  },
+  "packages": {},
+  "packages.conda": {
+    "acme-features-1.2.0-py_0.conda": {
+      "arch": null,
+      "build": "py_0",
+      "build_number": 0,
+      "depends": [
+        "numpy >=1.24",
+        "python >=3.9"
+      ],
+      "license": "MIT",
+      "name": "acme-features",
+      "noarch": "python",
+      "platform": null,
+      "subdir": "noarch",
+      "timestamp": 1718000000000,
+      "version": "1.2.0",
+      "md5": "%x",
+      "sha256": "%x",
+      "size": %d
+    }
+  },
+  "removed": [],
+  "repodata_version": 1
+}
+`, md5.Sum(data), sha256.Sum256(data), len(data))
+
+	repodata, err := os.ReadFile(filepath.Join(channelDir, "noarch", "repodata.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(repodata) != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, repodata)
+	}
+}
@@ -0,0 +1,267 @@
+package packages
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/pretty"
+	"github.com/tidwall/sjson"
+)
+
+type DenoHandler struct{}
+
+// denoConfigFiles are the files declaring a Deno package's name and exports, in order of preference.
+var denoConfigFiles = []string{"deno.json", "deno.jsonc", "jsr.json"}
+
+// LocatePackage finds <name>-<version>.tar.gz in the specified directory, with a scoped name such as @acme/sdk
+// written as acme-sdk. If it is not present but the directory holds a deno.json or jsr.json, the package
+// directory itself is packaged into a temporary archive.
+func (d *DenoHandler) LocatePackage(dir string, packageName string, packageVersion string) (string, error) {
+	filename := fmt.Sprintf("%s-%s.tar.gz", denoFilePrefix(packageName), packageVersion)
+	packagePath := filepath.Join(dir, filename)
+	if fileExists(packagePath) {
+		return packagePath, nil
+	}
+	found := false
+	for _, name := range denoConfigFiles {
+		found = found || fileExists(filepath.Join(dir, name))
+	}
+	if !found {
+		return "", fmt.Errorf("expected package file not found: %s", filename)
+	}
+
+	outDir, err := newOutputDir("deno")
+	if err != nil {
+		return "", err
+	}
+	packagePath = filepath.Join(outDir, filename)
+	if err := createTarGz(dir, packagePath, skipDenoEntry); err != nil {
+		return "", err
+	}
+	return packagePath, nil
+}
+
+// UpdatePackageRef extracts the package next to the nearest deno.json, or import_map.json, and maps each of the
+// package's exports to its module there in the imports of the import map: the package name to the main module,
+// and name/path to the module of the ./path export. A deno.json whose importMap points to a file has the imports
+// added to that file instead. Any other mapping of the package, such as to jsr: or npm:, is replaced, and its
+// entries are removed from deno.lock.
+func (d *DenoHandler) UpdatePackageRef(packageName string, packageFilePath string, packageRefFilePath string) error {
+	configPath, err := findFileUp(packageRefFilePath, "deno.json", "deno.jsonc", "import_map.json")
+	if err != nil {
+		return fmt.Errorf("finding deno.json or import_map.json: %w", err)
+	}
+	projectDir := filepath.Dir(configPath)
+	importMapPath := configPath
+	if filepath.Base(configPath) != "import_map.json" {
+		data, err := os.ReadFile(configPath)
+		if err != nil {
+			return err
+		}
+		if !gjson.ValidBytes(data) {
+			return fmt.Errorf("parsing %s: invalid JSON, comments are not supported", filepath.Base(configPath))
+		}
+		if importMap := gjson.GetBytes(data, "importMap").String(); importMap != "" {
+			if strings.Contains(importMap, "://") {
+				return fmt.Errorf("import map %s of %s is not a local file", importMap, filepath.Base(configPath))
+			}
+			importMapPath = filepath.Join(projectDir, filepath.FromSlash(importMap))
+		}
+	}
+
+	prefix, config, err := readDenoPackageConfig(packageFilePath)
+	if err != nil {
+		return err
+	}
+	if name := gjson.GetBytes(config, "name").String(); name != "" && name != packageName {
+		return fmt.Errorf("%s contains package %q, not %s", filepath.Base(packageFilePath), name, packageName)
+	}
+
+	packageDir := filepath.Join(projectDir, InstallDir, "deno-packages", denoFilePrefix(packageName))
+	strip := 0
+	if prefix != "" {
+		strip = 1
+	}
+	if err := extractTarGz(packageFilePath, packageDir, strip); err != nil {
+		return fmt.Errorf("extracting package: %w", err)
+	}
+
+	exports := map[string]string{}
+	switch value := gjson.GetBytes(config, "exports"); {
+	case value.Type == gjson.String:
+		exports["."] = value.String()
+	case value.IsObject():
+		value.ForEach(func(key, value gjson.Result) bool {
+			exports[key.String()] = value.String()
+			return true
+		})
+	case fileExists(filepath.Join(packageDir, "mod.ts")):
+		exports["."] = "./mod.ts"
+	default:
+		return fmt.Errorf("package %s declares no exports and has no mod.ts", packageName)
+	}
+
+	relPath, err := filepath.Rel(filepath.Dir(importMapPath), packageDir)
+	if err != nil {
+		return fmt.Errorf("calculating relative path: %w", err)
+	}
+	imports := map[string]string{}
+	for key, module := range exports {
+		imports[packageName+strings.TrimPrefix(key, ".")] = "./" + path.Join(filepath.ToSlash(relPath), module)
+	}
+	if err := setDenoImports(importMapPath, packageName, imports); err != nil {
+		return err
+	}
+
+	lockPath := filepath.Join(projectDir, "deno.lock")
+	if !fileExists(lockPath) {
+		return nil
+	}
+	return removeDenoLockEntries(lockPath, packageName)
+}
+
+// denoFilePrefix returns the package name as used in file names, with a scoped name such as @acme/sdk written
+// as acme-sdk.
+func denoFilePrefix(packageName string) string {
+	return strings.ReplaceAll(strings.TrimPrefix(packageName, "@"), "/", "-")
+}
+
+// skipDenoEntry reports whether a file or directory of a package should be left out of its archive.
+func skipDenoEntry(rel string, entry os.DirEntry) bool {
+	name := path.Base(rel)
+	if entry.IsDir() {
+		return name == ".git" || name == "node_modules" || name == InstallDir
+	}
+	return false
+}
+
+// readDenoPackageConfig returns the deno.json or jsr.json of a package archive, along with the name of the
+// top-level directory holding it ("" for the root).
+func readDenoPackageConfig(packageFilePath string) (string, []byte, error) {
+	for _, name := range denoConfigFiles {
+		prefix, data, err := readTarGzRootFile(packageFilePath, name)
+		if err != nil {
+			continue
+		}
+		if !gjson.ValidBytes(data) {
+			return "", nil, fmt.Errorf("parsing %s of %s: invalid JSON", name, filepath.Base(packageFilePath))
+		}
+		return prefix, data, nil
+	}
+	return "", nil, fmt.Errorf("%s contains no %s", filepath.Base(packageFilePath), strings.Join(denoConfigFiles, " or "))
+}
+
+// setDenoImports replaces the mappings of the package in the imports of a deno.json or import map, the package
+// name itself and any name/path, with the given ones. The imports are kept sorted, like `deno add` does.
+func setDenoImports(importMapPath string, packageName string, imports map[string]string) error {
+	data := []byte("{}")
+	if fileExists(importMapPath) {
+		var err error
+		if data, err = os.ReadFile(importMapPath); err != nil {
+			return err
+		}
+		if !gjson.ValidBytes(data) {
+			return fmt.Errorf("parsing %s: invalid JSON", filepath.Base(importMapPath))
+		}
+	}
+
+	var members []jsonMember
+	for _, member := range jsonObjectMembers(gjson.GetBytes(data, "imports")) {
+		if member.Key != packageName && !strings.HasPrefix(member.Key, packageName+"/") {
+			members = append(members, member)
+		}
+	}
+	keys := make([]string, 0, len(imports))
+	for key := range imports {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		raw, err := marshalJSON(imports[key])
+		if err != nil {
+			return err
+		}
+		members = setJSONMember(members, key, raw)
+	}
+
+	data, err := sjson.SetRawBytes(data, "imports", []byte(jsonObjectRaw(members)))
+	if err != nil {
+		return err
+	}
+	// Like `deno fmt`, objects are written one member per line and short arrays on a single line
+	return os.WriteFile(importMapPath, pretty.PrettyOptions(data, &pretty.Options{Indent: "  ", Width: 80}), 0644)
+}
+
+// denoSpecifierName returns the package name of a deno.lock specifier or package, such as @std/assert for
+// jsr:@std/assert@^1.0.0 and @std/assert@1.0.6, leaving out the registry and the version.
+func denoSpecifierName(specifier string) string {
+	specifier = strings.TrimPrefix(strings.TrimPrefix(specifier, "jsr:"), "npm:")
+	// The @ of a scope is part of the name
+	start := 0
+	if strings.HasPrefix(specifier, "@") {
+		start = 1
+	}
+	if i := strings.Index(specifier[start:], "@"); i != -1 {
+		return specifier[:start+i]
+	}
+	return specifier
+}
+
+// removeDenoLockEntries removes the entries of a deno.lock that resolve the package from JSR or npm, so that
+// the lockfile matches the import map, unless other locked packages depend on it. The package is removed from
+// the workspace's dependencies either way. The file is left untouched if there is nothing to remove.
+func removeDenoLockEntries(lockPath string, packageName string) error {
+	data, err := os.ReadFile(lockPath)
+	if err != nil {
+		return err
+	}
+	if !gjson.ValidBytes(data) {
+		return fmt.Errorf("parsing %s: invalid JSON", filepath.Base(lockPath))
+	}
+
+	isPackageSpecifier := func(specifier string) bool {
+		return (strings.HasPrefix(specifier, "jsr:") || strings.HasPrefix(specifier, "npm:")) && denoSpecifierName(specifier) == packageName
+	}
+	var paths []string
+	required := false
+	for _, section := range []string{"specifiers", "jsr", "npm"} {
+		gjson.GetBytes(data, section).ForEach(func(key, value gjson.Result) bool {
+			if isPackageSpecifier(key.String()) || (section != "specifiers" && denoSpecifierName(key.String()) == packageName) {
+				paths = append(paths, section+"."+escapeJSONPath(key.String()))
+				return true
+			}
+			// Dependencies are a list of specifiers, or for npm packages in older lockfiles a map of names to them
+			value.Get("dependencies").ForEach(func(name, dependency gjson.Result) bool {
+				required = required || denoSpecifierName(dependency.String()) == packageName || name.String() == packageName
+				return !required
+			})
+			return true
+		})
+	}
+	// Resolutions the package's dependents still need are kept
+	if required {
+		paths = nil
+	}
+	dependencies := gjson.GetBytes(data, "workspace.dependencies").Array()
+	// Array elements are removed from the end so the indexes of earlier ones stay valid
+	for i := len(dependencies) - 1; i >= 0; i-- {
+		if isPackageSpecifier(dependencies[i].String()) {
+			paths = append(paths, fmt.Sprintf("workspace.dependencies.%d", i))
+		}
+	}
+	if len(paths) == 0 {
+		return nil
+	}
+	for _, p := range paths {
+		if data, err = sjson.DeleteBytes(data, p); err != nil {
+			return err
+		}
+	}
+	// Deno writes the lockfile with two-space indentation and no single-line arrays
+	return os.WriteFile(lockPath, pretty.PrettyOptions(data, &pretty.Options{Indent: "  "}), 0644)
+}
@@ -0,0 +1,382 @@
+package packages
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testDenoConfig is the deno.json of the package used by the tests.
+const testDenoConfig = `{
+  "name": "@acme/sdk",
+  "version": "1.2.0",
+  "exports": {
+    ".": "./mod.ts",
+    "./auth": "./src/auth.ts"
+  }
+}`
+
+func TestDenoLocatePackage(t *testing.T) {
+	handler := &DenoHandler{}
+	dir := "../../testdata"
+
+	tempDir, err := os.MkdirTemp(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tempDir); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to remove temp folder: %v\n", err)
+		}
+	}() // Clean up
+
+	packagePath := filepath.Join(tempDir, "acme-sdk-1.2.0.tar.gz")
+	writeTestTarGz(t, packagePath, map[string]string{"deno.json": testDenoConfig})
+
+	packageDir := filepath.Join(tempDir, "sdk")
+	for name, content := range map[string]string{
+		"deno.json":                  testDenoConfig,
+		"mod.ts":                     "",
+		"node_modules/.deno/lock.ts": "",
+	} {
+		path := filepath.Join(packageDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	testCases := []struct {
+		name           string
+		dir            string
+		packageVersion string
+		expectedPath   string
+		expectedFiles  []string
+		expectedError  bool
+	}{
+		{
+			name:           "find package archive",
+			dir:            tempDir,
+			packageVersion: "1.2.0",
+			expectedPath:   packagePath,
+		},
+		{
+			name:           "package source directory",
+			dir:            packageDir,
+			packageVersion: "1.3.0",
+			expectedFiles:  []string{"deno.json", "mod.ts"},
+		},
+		{
+			name:           "fail on non-existing archive",
+			dir:            tempDir,
+			packageVersion: "1.3.0",
+			expectedError:  true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			filePath, err := handler.LocatePackage(testCase.dir, "@acme/sdk", testCase.packageVersion)
+			if testCase.expectedError {
+				if err == nil {
+					t.Fatal("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if testCase.expectedPath != "" && filePath != testCase.expectedPath {
+				t.Errorf("expected %s, got %s", testCase.expectedPath, filePath)
+			}
+			if testCase.expectedFiles == nil {
+				return
+			}
+			defer func() {
+				_ = os.RemoveAll(filepath.Dir(filePath))
+			}()
+			if filepath.Base(filePath) != "acme-sdk-1.3.0.tar.gz" {
+				t.Errorf("unexpected archive name %s", filepath.Base(filePath))
+			}
+			extracted := filepath.Join(tempDir, "extracted")
+			if err := extractTarGz(filePath, extracted, 0); err != nil {
+				t.Fatal(err)
+			}
+			for _, name := range testCase.expectedFiles {
+				if !fileExists(filepath.Join(extracted, filepath.FromSlash(name))) {
+					t.Errorf("expected %s in archive", name)
+				}
+			}
+			if fileExists(filepath.Join(extracted, "node_modules")) {
+				t.Error("expected node_modules to be left out of archive")
+			}
+		})
+	}
+}
+
+func TestDenoUpdatePackageRef(t *testing.T) {
+	testCases := []struct {
+		name          string
+		files         map[string]string
+		packageConfig string
+		prefix        string
+		importMap     string
+		expected      string
+	}{
+		{
+			name: "replaces jsr imports in deno.json",
+			files: map[string]string{"deno.json": `{
+  "tasks": {
+    "dev": "deno run --watch main.ts"
+  },
+  "imports": {
+    "@acme/sdk": "jsr:@acme/sdk@^1.1.0",
+    "@acme/sdk/auth": "jsr:@acme/sdk@^1.1.0/auth",
+    "@std/assert": "jsr:@std/assert@^1.0.0"
+  },
+  "lint": {
+    "rules": { "tags": ["recommended"] }
+  }
+}
+`},
+			packageConfig: testDenoConfig,
+			prefix:        "sdk/",
+			importMap:     "deno.json",
+			expected: `{
+  "tasks": {
+    "dev": "deno run --watch main.ts"
+  },
+  "imports": {
+    "@acme/sdk": "./.universal-packages/deno-packages/acme-sdk/mod.ts",
+    "@acme/sdk/auth": "./.universal-packages/deno-packages/acme-sdk/src/auth.ts",
+    "@std/assert": "jsr:@std/assert@^1.0.0"
+  },
+  "lint": {
+    "rules": {
+      "tags": ["recommended"]
+    }
+  }
+}
+`,
+		},
+		{
+			name: "adds imports to import map of deno.json",
+			files: map[string]string{
+				"deno.json": `{
+  "importMap": "./import_map.json"
+}
+`,
+				"import_map.json": `{
+  "imports": {
+    "std/": "https://deno.land/std@0.224.0/"
+  }
+}
+`,
+			},
+			packageConfig: `{"name": "@acme/sdk", "exports": "./index.ts"}`,
+			importMap:     "import_map.json",
+			expected: `{
+  "imports": {
+    "@acme/sdk": "./.universal-packages/deno-packages/acme-sdk/index.ts",
+    "std/": "https://deno.land/std@0.224.0/"
+  }
+}
+`,
+		},
+		{
+			name: "adds imports to deno.json without exports",
+			files: map[string]string{"deno.json": `{
+  "compilerOptions": {
+    "strict": true
+  }
+}
+`},
+			packageConfig: `{"name": "@acme/sdk"}`,
+			importMap:     "deno.json",
+			expected: `{
+  "compilerOptions": {
+    "strict": true
+  },
+  "imports": {
+    "@acme/sdk": "./.universal-packages/deno-packages/acme-sdk/mod.ts"
+  }
+}
+`,
+		},
+	}
+
+	handler := &DenoHandler{}
+	dir := "../../testdata"
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			installTempDir, err := os.MkdirTemp(dir, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				if err := os.RemoveAll(installTempDir); err != nil {
+					fmt.Fprintf(os.Stderr, "warning: failed to remove temp folder: %v\n", err)
+				}
+			}() // Clean up
+
+			for name, content := range testCase.files {
+				if err := os.WriteFile(filepath.Join(installTempDir, name), []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			packageLocation := filepath.Join(installTempDir, ".upkg", "acme-sdk-1.2.0.tar.gz")
+			writeTestTarGz(t, packageLocation, map[string]string{
+				testCase.prefix + "deno.json":   testCase.packageConfig,
+				testCase.prefix + "mod.ts":      "",
+				testCase.prefix + "src/auth.ts": "",
+			})
+
+			if err := handler.UpdatePackageRef("@acme/sdk", packageLocation, installTempDir); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			updated, err := os.ReadFile(filepath.Join(installTempDir, testCase.importMap))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(updated) != testCase.expected {
+				t.Errorf("expected:\n%s\ngot:\n%s", testCase.expected, updated)
+			}
+			if !fileExists(filepath.Join(installTempDir, InstallDir, "deno-packages", "acme-sdk", "src", "auth.ts")) {
+				t.Error("expected package to be extracted")
+			}
+		})
+	}
+}
+
+func TestDenoUpdatePackageRefLock(t *testing.T) {
+	handler := &DenoHandler{}
+	dir := "../../testdata"
+
+	installTempDir, err := os.MkdirTemp(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(installTempDir); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to remove temp folder: %v\n", err)
+		}
+	}() // Clean up
+
+	config := `{
+  "imports": {
+    "@acme/sdk": "jsr:@acme/sdk@^1.1.0",
+    "@std/assert": "jsr:@std/assert@^1.0.0"
+  }
+}
+`
+	if err := os.WriteFile(filepath.Join(installTempDir, "deno.json"), []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	lock := `{
+  "version": "4",
+  "specifiers": {
+    "jsr:@acme/cli@^1.0.0": "1.0.0",
+    "jsr:@acme/sdk-extra@2": "2.0.0",
+    "jsr:@acme/sdk@^1.1.0": "1.1.0",
+    "jsr:@std/assert@1": "1.0.6",
+    "jsr:@std/assert@^1.0.0": "1.0.6",
+    "jsr:@std/internal@^1.0.4": "1.0.4"
+  },
+  "jsr": {
+    "@acme/cli@1.0.0": {
+      "integrity": "0c9d8e7f",
+      "dependencies": [
+        "jsr:@acme/sdk-extra@2"
+      ]
+    },
+    "@acme/sdk-extra@2.0.0": {
+      "integrity": "a1b2c3d4"
+    },
+    "@acme/sdk@1.1.0": {
+      "integrity": "5f2ab3c4",
+      "dependencies": [
+        "jsr:@std/assert@1"
+      ]
+    },
+    "@std/assert@1.0.6": {
+      "integrity": "1904c05f",
+      "dependencies": [
+        "jsr:@std/internal"
+      ]
+    },
+    "@std/internal@1.0.4": {
+      "integrity": "62e0b6c6"
+    }
+  },
+  "workspace": {
+    "dependencies": [
+      "jsr:@acme/cli@^1.0.0",
+      "jsr:@acme/sdk@^1.1.0",
+      "jsr:@std/assert@^1.0.0"
+    ]
+  }
+}
+`
+	lockPath := filepath.Join(installTempDir, "deno.lock")
+	if err := os.WriteFile(lockPath, []byte(lock), 0644); err != nil {
+		t.Fatal(err)
+	}
+	packageLocation := filepath.Join(installTempDir, ".upkg", "acme-sdk-1.2.0.tar.gz")
+	writeTestTarGz(t, packageLocation, map[string]string{"deno.json": testDenoConfig, "mod.ts": ""})
+
+	if err := handler.UpdatePackageRef("@acme/sdk", packageLocation, installTempDir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The resolutions of the package's own dependencies are left for deno to prune, and a dependency on a
+	// package whose name only starts with the package's does not keep its entries
+	expected := `{
+  "version": "4",
+  "specifiers": {
+    "jsr:@acme/cli@^1.0.0": "1.0.0",
+    "jsr:@acme/sdk-extra@2": "2.0.0",
+    "jsr:@std/assert@1": "1.0.6",
+    "jsr:@std/assert@^1.0.0": "1.0.6",
+    "jsr:@std/internal@^1.0.4": "1.0.4"
+  },
+  "jsr": {
+    "@acme/cli@1.0.0": {
+      "integrity": "0c9d8e7f",
+      "dependencies": [
+        "jsr:@acme/sdk-extra@2"
+      ]
+    },
+    "@acme/sdk-extra@2.0.0": {
+      "integrity": "a1b2c3d4"
+    },
+    "@std/assert@1.0.6": {
+      "integrity": "1904c05f",
+      "dependencies": [
+        "jsr:@std/internal"
+      ]
+    },
+    "@std/internal@1.0.4": {
+      "integrity": "62e0b6c6"
+    }
+  },
+  "workspace": {
+    "dependencies": [
+      "jsr:@acme/cli@^1.0.0",
+      "jsr:@std/assert@^1.0.0"
+    ]
+  }
+}
+`
+	updated, err := os.ReadFile(lockPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(updated) != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, updated)
+	}
+}
@@ -0,0 +1,259 @@
+package packages
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type GemHandler struct{}
+
+var (
+	// gemSourceOptionPattern matches Gemfile options that choose where a gem comes from, which a path
+	// declaration replaces. Other options (require:, group:, platforms:, ...) are kept.
+	gemSourceOptionPattern = regexp.MustCompile(`^:?(path|git|github|gitlab|bitbucket|branch|ref|tag|source|submodules|glob)(:|\s*=>)`)
+)
+
+// LocatePackage finds <name>-<version>.gem in the specified directory, as written by `gem build`.
+func (g *GemHandler) LocatePackage(dir string, packageName string, packageVersion string) (string, error) {
+	filename := fmt.Sprintf("%s-%s.gem", packageName, packageVersion)
+	packagePath := filepath.Join(dir, filename)
+	if !fileExists(packagePath) {
+		return "", fmt.Errorf("expected package file not found: %s", filename)
+	}
+	return packagePath, nil
+}
+
+// UpdatePackageRef unpacks the gem into a vendor directory next to the nearest Gemfile and declares it there
+// as a path gem, replacing any existing declaration for the same gem. Bundler reads a path gem's dependencies
+// from its gemspec; gems rarely package their own, so one is generated from the gem's metadata if needed.
+func (g *GemHandler) UpdatePackageRef(packageName string, packageFilePath string, packageRefFilePath string) error {
+	gemfilePath, err := findFileUp(packageRefFilePath, "Gemfile", "gems.rb")
+	if err != nil {
+		return fmt.Errorf("finding Gemfile: %w", err)
+	}
+
+	filename := filepath.Base(packageFilePath)
+	version, ok := strings.CutPrefix(strings.TrimSuffix(filename, ".gem"), packageName+"-")
+	if !ok || version == "" {
+		return fmt.Errorf("unexpected package file name %s for gem %s", filename, packageName)
+	}
+
+	gemDir := filepath.Join(filepath.Dir(gemfilePath), InstallDir, "gems", packageName)
+	metadata, err := unpackGem(packageFilePath, gemDir)
+	if err != nil {
+		return fmt.Errorf("unpacking gem: %w", err)
+	}
+	if !fileExists(filepath.Join(gemDir, packageName+".gemspec")) {
+		gemspec := gemspecFromMetadata(packageName, version, metadata)
+		if err := os.WriteFile(filepath.Join(gemDir, packageName+".gemspec"), []byte(gemspec), 0644); err != nil {
+			return err
+		}
+	}
+
+	relPath, err := filepath.Rel(filepath.Dir(gemfilePath), gemDir)
+	if err != nil {
+		return fmt.Errorf("calculating relative path: %w", err)
+	}
+	data, err := os.ReadFile(gemfilePath)
+	if err != nil {
+		return err
+	}
+	updated := updateGemfile(string(data), packageName, filepath.ToSlash(relPath))
+	return os.WriteFile(gemfilePath, []byte(updated), 0644)
+}
+
+// unpackGem extracts the files of a .gem (a tar holding data.tar.gz and metadata.gz) into destDir,
+// and returns the gem's YAML metadata.
+func unpackGem(gemPath string, destDir string) (string, error) {
+	f, err := os.Open(gemPath)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	var metadata string
+	unpacked := false
+	tr := tar.NewReader(f)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("reading %s: %w", filepath.Base(gemPath), err)
+		}
+		if header.Name != "data.tar.gz" && header.Name != "metadata.gz" {
+			continue
+		}
+
+		gz, err := gzip.NewReader(tr)
+		if err != nil {
+			return "", fmt.Errorf("reading %s: %w", header.Name, err)
+		}
+		if header.Name == "data.tar.gz" {
+			err = extractTar(gz, destDir, 0)
+			unpacked = true
+		} else {
+			var data []byte
+			data, err = io.ReadAll(gz)
+			metadata = string(data)
+		}
+		_ = gz.Close()
+		if err != nil {
+			return "", err
+		}
+	}
+	if !unpacked {
+		return "", fmt.Errorf("%s contains no data.tar.gz", filepath.Base(gemPath))
+	}
+	return metadata, nil
+}
+
+// gemspecFromMetadata generates a gemspec declaring the name, version, require paths and runtime
+// dependencies found in a gem's YAML metadata.
+func gemspecFromMetadata(name string, version string, metadata string) string {
+	lines := strings.Split(metadata, "\n")
+	summary := name
+	if idx := yamlFindKey(lines, 0, len(lines), 0, "summary"); idx != -1 {
+		_, raw, _ := yamlKeyValue(lines[idx], 0)
+		summary = yamlScalar(raw)
+	}
+	requirePaths := []string{strconv.Quote("lib")}
+	if idx := yamlFindKey(lines, 0, len(lines), 0, "require_paths"); idx != -1 {
+		requirePaths = nil
+		for _, item := range yamlSequenceItems(lines, idx+1, yamlBlockEnd(lines, idx)) {
+			path := strings.TrimPrefix(strings.TrimSpace(lines[item.Start]), "-")
+			requirePaths = append(requirePaths, strconv.Quote(yamlScalar(path)))
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString("# Generated by universal-packages from the gem's metadata\n")
+	b.WriteString("Gem::Specification.new do |s|\n")
+	fmt.Fprintf(&b, "  s.name = %s\n", strconv.Quote(name))
+	fmt.Fprintf(&b, "  s.version = %s\n", strconv.Quote(version))
+	fmt.Fprintf(&b, "  s.summary = %s\n", strconv.Quote(summary))
+	b.WriteString("  s.files = Dir.glob(\"**/*\", base: __dir__)\n")
+	fmt.Fprintf(&b, "  s.require_paths = [%s]\n", strings.Join(requirePaths, ", "))
+
+	if idx := yamlFindKey(lines, 0, len(lines), 0, "dependencies"); idx != -1 {
+		for _, item := range yamlSequenceItems(lines, idx+1, yamlBlockEnd(lines, idx)) {
+			_, depName := yamlItemValue(lines, item, "name")
+			if _, depType := yamlItemValue(lines, item, "type"); depName == "" || depType != ":runtime" {
+				continue
+			}
+			args := []string{strconv.Quote(depName)}
+			for _, requirement := range gemRequirements(lines, item) {
+				args = append(args, strconv.Quote(requirement))
+			}
+			fmt.Fprintf(&b, "  s.add_dependency %s\n", strings.Join(args, ", "))
+		}
+	}
+	b.WriteString("end\n")
+	return b.String()
+}
+
+// gemRequirements returns the version requirements (such as ">= 2.0") of a dependency in gem metadata,
+// which are listed as [operator, version] pairs under its requirement key.
+func gemRequirements(lines []string, item yamlItem) []string {
+	idx, _ := yamlItemValue(lines, item, "requirement")
+	if idx == -1 {
+		return nil
+	}
+	var requirements []string
+	operator := ""
+	for i := idx + 1; i < yamlBlockEnd(lines, idx) && i < item.End; i++ {
+		trimmed := strings.TrimSpace(lines[i])
+		switch {
+		case strings.HasPrefix(trimmed, "- - "):
+			operator = yamlScalar(strings.TrimPrefix(trimmed, "- - "))
+		case strings.HasPrefix(trimmed, "version:") && operator != "":
+			requirements = append(requirements, operator+" "+yamlScalar(strings.TrimPrefix(trimmed, "version:")))
+			operator = ""
+		}
+	}
+	return requirements
+}
+
+// updateGemfile points every declaration of the gem at path, dropping its version constraints and source
+// options but keeping the rest, or appends a declaration if there is none.
+func updateGemfile(content string, gemName string, path string) string {
+	declaration := regexp.MustCompile(`^(\s*)gem\s*\(?\s*["']` + regexp.QuoteMeta(gemName) + `["']`)
+	lines := strings.Split(content, "\n")
+	found := false
+	for i := 0; i < len(lines); i++ {
+		match := declaration.FindStringSubmatch(lines[i])
+		if match == nil {
+			continue
+		}
+		// Declarations continue onto the next line after a trailing comma
+		end := i + 1
+		for end < len(lines) && strings.HasSuffix(strings.TrimSpace(stripRubyComment(lines[end-1])), ",") {
+			end++
+		}
+		code := make([]string, 0, end-i)
+		for _, line := range lines[i:end] {
+			code = append(code, stripRubyComment(line))
+		}
+		rest := strings.Join(code, " ")[len(match[0]):]
+		comment := lines[end-1][len(code[len(code)-1]):]
+
+		args := []string{strconv.Quote(gemName), "path: " + strconv.Quote(path)}
+		for _, arg := range splitRubyArgs(strings.TrimSuffix(strings.TrimSpace(rest), ")")) {
+			if arg == "" || arg[0] == '"' || arg[0] == '\'' || gemSourceOptionPattern.MatchString(arg) {
+				continue
+			}
+			args = append(args, arg)
+		}
+		replacement := match[1] + "gem " + strings.Join(args, ", ") + comment
+		lines = append(lines[:i], append([]string{replacement}, lines[end:]...)...)
+		found = true
+	}
+	if found {
+		return strings.Join(lines, "\n")
+	}
+
+	content = strings.TrimRight(content, "\n")
+	if content != "" {
+		content += "\n\n"
+	}
+	return content + "gem " + strconv.Quote(gemName) + ", path: " + strconv.Quote(path) + "\n"
+}
+
+// splitRubyArgs splits a Ruby argument list on top-level commas, skipping over strings and brackets.
+// Each argument is trimmed of surrounding whitespace.
+func splitRubyArgs(s string) []string {
+	var args []string
+	var quote byte
+	depth, start := 0, 0
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[' || c == '{' || c == '(':
+			depth++
+		case c == ']' || c == '}' || c == ')':
+			depth--
+		case c == ',' && depth == 0:
+			args = append(args, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+	return append(args, strings.TrimSpace(s[start:]))
+}
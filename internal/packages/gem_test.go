@@ -0,0 +1,255 @@
+package packages
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testGemMetadata = `--- !ruby/object:Gem::Specification
+name: billing-client
+version: !ruby/object:Gem::Version
+  version: 2.1.0
+platform: ruby
+summary: Client for the billing API
+require_paths:
+- lib
+dependencies:
+- !ruby/object:Gem::Dependency
+  name: faraday
+  requirement: !ruby/object:Gem::Requirement
+    requirements:
+    - - ">="
+      - !ruby/object:Gem::Version
+        version: '2.0'
+    - - "<"
+      - !ruby/object:Gem::Version
+        version: '3'
+  type: :runtime
+  prerelease: false
+  version_requirements: !ruby/object:Gem::Requirement
+    requirements:
+    - - ">="
+      - !ruby/object:Gem::Version
+        version: '2.0'
+- !ruby/object:Gem::Dependency
+  name: rspec
+  requirement: !ruby/object:Gem::Requirement
+    requirements:
+    - - "~>"
+      - !ruby/object:Gem::Version
+        version: '3.0'
+  type: :development
+  prerelease: false
+`
+
+// writeTestGem writes a .gem at path: a tar holding the given files as data.tar.gz, and the metadata.
+func writeTestGem(t *testing.T, path string, files map[string]string, metadata string) {
+	t.Helper()
+	dataPath := path + ".data.tar.gz"
+	writeTestTarGz(t, dataPath, files)
+	data, err := os.ReadFile(dataPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(dataPath); err != nil {
+		t.Fatal(err)
+	}
+
+	var metadataGz bytes.Buffer
+	gz := gzip.NewWriter(&metadataGz)
+	if _, err := gz.Write([]byte(metadata)); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var gem bytes.Buffer
+	tw := tar.NewWriter(&gem)
+	for _, entry := range []struct {
+		name string
+		data []byte
+	}{{"metadata.gz", metadataGz.Bytes()}, {"data.tar.gz", data}} {
+		if err := tw.WriteHeader(&tar.Header{Name: entry.name, Mode: 0444, Size: int64(len(entry.data)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(entry.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, gem.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGemLocatePackage(t *testing.T) {
+	handler := &GemHandler{}
+	dir := "../../testdata"
+
+	tempDir, err := os.MkdirTemp(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tempDir); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to remove temp folder: %v\n", err)
+		}
+	}() // Clean up
+
+	// Mimic the output of `gem build`
+	packagePath := filepath.Join(tempDir, "billing-client-2.1.0.gem")
+	writeTestGem(t, packagePath, map[string]string{"lib/billing_client.rb": ""}, testGemMetadata)
+
+	testCases := []struct {
+		name           string
+		packageName    string
+		packageVersion string
+		expectedPath   string
+		expectedError  bool
+	}{
+		{
+			name:           "find built gem",
+			packageName:    "billing-client",
+			packageVersion: "2.1.0",
+			expectedPath:   packagePath,
+		},
+		{
+			name:           "fail on non-existing gem",
+			packageName:    "billing-client",
+			packageVersion: "2.2.0",
+			expectedError:  true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			filePath, err := handler.LocatePackage(tempDir, testCase.packageName, testCase.packageVersion)
+			if testCase.expectedError {
+				if err == nil {
+					t.Fatal("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if filePath != testCase.expectedPath {
+				t.Errorf("expected %s, got %s", testCase.expectedPath, filePath)
+			}
+		})
+	}
+}
+
+func TestGemUpdatePackageRef(t *testing.T) {
+	testCases := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name: "appends declaration",
+			input: `source "https://rubygems.org"
+
+gem "rails", "~> 7.1"
+`,
+			expected: `source "https://rubygems.org"
+
+gem "rails", "~> 7.1"
+
+gem "billing-client", path: ".universal-packages/gems/billing-client"
+`,
+		},
+		{
+			name: "replaces existing declaration keeping options",
+			input: `source "https://rubygems.org"
+
+group :production do
+  gem 'billing-client', '~> 1.0', require: 'billing', # internal
+      git: 'git@example.com:billing-client.git' # pinned
+end
+gem "billing-client-extras"
+`,
+			expected: `source "https://rubygems.org"
+
+group :production do
+  gem "billing-client", path: ".universal-packages/gems/billing-client", require: 'billing' # pinned
+end
+gem "billing-client-extras"
+`,
+		},
+		{
+			name: "replaces previous path declaration",
+			input: `gem("billing-client", path: "../billing-client", platforms: [:ruby, :jruby])
+`,
+			expected: `gem "billing-client", path: ".universal-packages/gems/billing-client", platforms: [:ruby, :jruby]
+`,
+		},
+	}
+
+	handler := &GemHandler{}
+	dir := "../../testdata"
+
+	installTempDir, err := os.MkdirTemp(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(installTempDir); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to remove temp folder: %v\n", err)
+		}
+	}() // Clean up
+
+	packageLocation := filepath.Join(installTempDir, ".upkg", "billing-client-2.1.0.gem")
+	writeTestGem(t, packageLocation, map[string]string{"lib/billing_client.rb": "module BillingClient; end\n"}, testGemMetadata)
+	gemfilePath := filepath.Join(installTempDir, "Gemfile")
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if err := os.WriteFile(gemfilePath, []byte(testCase.input), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := handler.UpdatePackageRef("billing-client", packageLocation, installTempDir); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			updated, err := os.ReadFile(gemfilePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(updated) != testCase.expected {
+				t.Errorf("expected:\n%s\ngot:\n%s", testCase.expected, updated)
+			}
+		})
+	}
+
+	gemDir := filepath.Join(installTempDir, InstallDir, "gems", "billing-client")
+	if !fileExists(filepath.Join(gemDir, "lib", "billing_client.rb")) {
+		t.Error("expected gem to be unpacked")
+	}
+	gemspec, err := os.ReadFile(filepath.Join(gemDir, "billing-client.gemspec"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedGemspec := `# Generated by universal-packages from the gem's metadata
+Gem::Specification.new do |s|
+  s.name = "billing-client"
+  s.version = "2.1.0"
+  s.summary = "Client for the billing API"
+  s.files = Dir.glob("**/*", base: __dir__)
+  s.require_paths = ["lib"]
+  s.add_dependency "faraday", ">= 2.0", "< 3"
+end
+`
+	if string(gemspec) != expectedGemspec {
+		t.Errorf("expected gemspec:\n%s\ngot:\n%s", expectedGemspec, gemspec)
+	}
+}
@@ -0,0 +1,262 @@
+package packages
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+	"golang.org/x/mod/sumdb/dirhash"
+	modzip "golang.org/x/mod/zip"
+)
+
+type GoHandler struct{}
+
+// LocatePackage finds the module zip for the given version. See LocatePackageFiles.
+func (g *GoHandler) LocatePackage(dir string, packageName string, packageVersion string) (string, error) {
+	files, err := g.LocatePackageFiles(dir, packageName, packageVersion)
+	if err != nil {
+		return "", err
+	}
+	return files[0], nil
+}
+
+// LocatePackageFiles finds the <version>.zip, <version>.mod and <version>.info files that make up a module
+// version in the GOPROXY layout. If they are not present but the directory holds a go.mod, they are built
+// from the module source into a temporary directory. The module path is always read from go.mod, so the
+// package name is only used for reporting.
+func (g *GoHandler) LocatePackageFiles(dir string, packageName string, packageVersion string) ([]string, error) {
+	version, err := goModuleVersion(packageVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	files := goProxyFiles(dir, version)
+	missing := ""
+	for _, f := range files {
+		if _, err := os.Stat(f); err != nil {
+			missing = filepath.Base(f)
+			break
+		}
+	}
+	if missing == "" {
+		return files, nil
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "go.mod")); err != nil {
+		return nil, fmt.Errorf("expected package file not found: %s", missing)
+	}
+	return buildGoModule(dir, version)
+}
+
+// UpdatePackageRef copies the pulled module files into a project-local GOPROXY directory next to the nearest
+// go.mod, adds the require directive to go.mod and records the module hashes in go.sum.
+// The project must then be built with GOPROXY pointing at file://<project>/.universal-packages/goproxy.
+func (g *GoHandler) UpdatePackageRef(packageName string, packageFilePath string, packageRefFilePath string) error {
+	goModPath, err := findFileUp(packageRefFilePath, "go.mod")
+	if err != nil {
+		return fmt.Errorf("finding go.mod: %w", err)
+	}
+
+	pulledDir := filepath.Dir(packageFilePath)
+	escapedVersion := strings.TrimSuffix(filepath.Base(packageFilePath), ".zip")
+	version, err := module.UnescapeVersion(escapedVersion)
+	if err != nil {
+		return fmt.Errorf("unexpected module file name %s: %w", filepath.Base(packageFilePath), err)
+	}
+	modData, err := os.ReadFile(filepath.Join(pulledDir, escapedVersion+".mod"))
+	if err != nil {
+		return err
+	}
+	modulePath := modfile.ModulePath(modData)
+	if modulePath == "" {
+		return fmt.Errorf("no module directive found in %s.mod", escapedVersion)
+	}
+
+	// Materialise the module in the GOPROXY file layout: <module>/@v/<version>.{zip,mod,info}
+	escapedPath, err := module.EscapePath(modulePath)
+	if err != nil {
+		return fmt.Errorf("invalid module path %q: %w", modulePath, err)
+	}
+	versionDir := filepath.Join(filepath.Dir(goModPath), InstallDir, "goproxy", filepath.FromSlash(escapedPath), "@v")
+	for _, f := range goProxyFiles(pulledDir, version) {
+		if _, err := copyFile(f, versionDir); err != nil {
+			return fmt.Errorf("adding module to local proxy: %w", err)
+		}
+	}
+	if err := addGoProxyVersion(filepath.Join(versionDir, "list"), version); err != nil {
+		return err
+	}
+
+	if err := addGoRequire(goModPath, modulePath, version); err != nil {
+		return err
+	}
+
+	zipHash, err := dirhash.HashZip(packageFilePath, dirhash.Hash1)
+	if err != nil {
+		return fmt.Errorf("hashing module zip: %w", err)
+	}
+	modHash, err := dirhash.Hash1([]string{"go.mod"}, func(string) (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(modData)), nil
+	})
+	if err != nil {
+		return fmt.Errorf("hashing go.mod: %w", err)
+	}
+	return updateGoSum(filepath.Join(filepath.Dir(goModPath), "go.sum"), map[string]string{
+		modulePath + " " + version:             zipHash,
+		modulePath + " " + version + "/go.mod": modHash,
+	})
+}
+
+// buildGoModule creates the zip, .mod and .info files for the module in dir, as served by a module proxy.
+func buildGoModule(dir string, version string) ([]string, error) {
+	modData, err := os.ReadFile(filepath.Join(dir, "go.mod"))
+	if err != nil {
+		return nil, err
+	}
+	modulePath := modfile.ModulePath(modData)
+	if modulePath == "" {
+		return nil, fmt.Errorf("no module directive found in go.mod")
+	}
+	mod := module.Version{Path: modulePath, Version: version}
+	if err := module.Check(mod.Path, mod.Version); err != nil {
+		return nil, err
+	}
+
+	outDir, err := newOutputDir("go")
+	if err != nil {
+		return nil, err
+	}
+	files := goProxyFiles(outDir, version)
+
+	zipFile, err := os.Create(files[0])
+	if err != nil {
+		return nil, err
+	}
+	if err := modzip.CreateFromDir(zipFile, mod, dir); err != nil {
+		_ = zipFile.Close()
+		return nil, fmt.Errorf("creating module zip: %w", err)
+	}
+	if err := zipFile.Close(); err != nil {
+		return nil, err
+	}
+
+	if err := os.WriteFile(files[1], modData, 0644); err != nil {
+		return nil, err
+	}
+	info, err := json.Marshal(struct {
+		Version string
+		Time    time.Time
+	}{Version: version, Time: time.Now().UTC().Truncate(time.Second)})
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(files[2], info, 0644); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// goProxyFiles returns the paths of the zip, .mod and .info files for a version within dir.
+func goProxyFiles(dir string, version string) []string {
+	escaped, err := module.EscapeVersion(version)
+	if err != nil {
+		escaped = version
+	}
+	return []string{
+		filepath.Join(dir, escaped+".zip"),
+		filepath.Join(dir, escaped+".mod"),
+		filepath.Join(dir, escaped+".info"),
+	}
+}
+
+// goModuleVersion turns an OCI tag such as "1.2.0" into a canonical module version such as "v1.2.0".
+func goModuleVersion(tag string) (string, error) {
+	version := tag
+	if !strings.HasPrefix(version, "v") {
+		version = "v" + version
+	}
+	if !semver.IsValid(version) {
+		return "", fmt.Errorf("invalid module version %q: must be a semantic version", tag)
+	}
+	return version, nil
+}
+
+// addGoProxyVersion adds version to the proxy's list file for the module, keeping it sorted.
+func addGoProxyVersion(listPath string, version string) error {
+	data, err := os.ReadFile(listPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	versions := strings.Fields(string(data))
+	for _, v := range versions {
+		if v == version {
+			return nil
+		}
+	}
+	versions = append(versions, version)
+	semver.Sort(versions)
+	return os.WriteFile(listPath, []byte(strings.Join(versions, "\n")+"\n"), 0644)
+}
+
+// addGoRequire adds or updates the require directive for the module in go.mod.
+func addGoRequire(goModPath string, modulePath string, version string) error {
+	data, err := os.ReadFile(goModPath)
+	if err != nil {
+		return err
+	}
+	f, err := modfile.Parse(goModPath, data, nil)
+	if err != nil {
+		return err
+	}
+	if err := f.AddRequire(modulePath, version); err != nil {
+		return fmt.Errorf("adding require for %s: %w", modulePath, err)
+	}
+	f.Cleanup()
+	updated, err := f.Format()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(goModPath, updated, 0644)
+}
+
+// updateGoSum sets the given "<module> <version>" hash lines in go.sum, replacing stale hashes.
+// Lines are kept in the order the go command writes them.
+func updateGoSum(goSumPath string, hashes map[string]string) error {
+	data, err := os.ReadFile(goSumPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	sums := make(map[module.Version]string)
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			continue
+		}
+		sums[module.Version{Path: fields[0], Version: fields[1]}] = fields[2]
+	}
+	for key, hash := range hashes {
+		path, version, _ := strings.Cut(key, " ")
+		sums[module.Version{Path: path, Version: version}] = hash
+	}
+
+	mods := make([]module.Version, 0, len(sums))
+	for mod := range sums {
+		mods = append(mods, mod)
+	}
+	module.Sort(mods)
+
+	var buf strings.Builder
+	for _, mod := range mods {
+		fmt.Fprintf(&buf, "%s %s %s\n", mod.Path, mod.Version, sums[mod])
+	}
+	return os.WriteFile(goSumPath, []byte(buf.String()), 0644)
+}
@@ -0,0 +1,207 @@
+package packages
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeGoModule writes a minimal module with the given path into dir.
+func writeGoModule(t *testing.T, dir string, modulePath string) {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module "+modulePath+"\n\ngo 1.24\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "sdk.go"), []byte("package sdk\n\nconst Name = \"sdk\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGoLocatePackageFiles(t *testing.T) {
+	handler := &GoHandler{}
+	dir := "../../testdata"
+
+	tempDir, err := os.MkdirTemp(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tempDir); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to remove temp folder: %v\n", err)
+		}
+	}() // Clean up
+
+	moduleDir := filepath.Join(tempDir, "module")
+	writeGoModule(t, moduleDir, "example.com/org/sdk")
+
+	// Mimic a pulled artifact
+	pulledDir := filepath.Join(tempDir, "pulled")
+	if err := os.MkdirAll(pulledDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"v1.2.0.zip", "v1.2.0.mod", "v1.2.0.info"} {
+		if err := os.WriteFile(filepath.Join(pulledDir, name), []byte("fake content"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	testCases := []struct {
+		name           string
+		dir            string
+		packageVersion string
+		expectedFiles  []string
+		expectedError  bool
+	}{
+		{
+			name:           "find pulled proxy files",
+			dir:            pulledDir,
+			packageVersion: "1.2.0",
+			expectedFiles:  []string{"v1.2.0.zip", "v1.2.0.mod", "v1.2.0.info"},
+		},
+		{
+			name:           "build proxy files from module source",
+			dir:            moduleDir,
+			packageVersion: "v1.3.0",
+			expectedFiles:  []string{"v1.3.0.zip", "v1.3.0.mod", "v1.3.0.info"},
+		},
+		{
+			name:           "fail on missing files without go.mod",
+			dir:            pulledDir,
+			packageVersion: "1.3.0",
+			expectedError:  true,
+		},
+		{
+			name:           "fail on non-semver version",
+			dir:            moduleDir,
+			packageVersion: "latest",
+			expectedError:  true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			files, err := handler.LocatePackageFiles(testCase.dir, "sdk", testCase.packageVersion)
+			if testCase.expectedError {
+				if err == nil {
+					t.Fatal("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if len(files) != len(testCase.expectedFiles) {
+				t.Fatalf("expected %d files, got %v", len(testCase.expectedFiles), files)
+			}
+			for i, f := range files {
+				if filepath.Base(f) != testCase.expectedFiles[i] {
+					t.Errorf("expected %s, got %s", testCase.expectedFiles[i], filepath.Base(f))
+				}
+				if _, err := os.Stat(f); err != nil {
+					t.Errorf("expected file to exist: %v", err)
+				}
+			}
+
+			if err := RemoveOutputDirs(); err != nil {
+				t.Fatal(err)
+			}
+			// Files built from source are removed, while files found in the directory are kept
+			built := filepath.Dir(files[0]) != testCase.dir
+			if _, err := os.Stat(files[0]); (err == nil) == built {
+				t.Errorf("expected %s to exist: %t", files[0], !built)
+			}
+		})
+	}
+}
+
+func TestGoUpdatePackageRef(t *testing.T) {
+	handler := &GoHandler{}
+	dir := "../../testdata"
+
+	tempDir, err := os.MkdirTemp(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tempDir); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to remove temp folder: %v\n", err)
+		}
+	}() // Clean up
+
+	// Build the artifact that would have been pushed
+	writeGoModule(t, filepath.Join(tempDir, "module"), "example.com/Org/sdk")
+	files, err := handler.LocatePackageFiles(filepath.Join(tempDir, "module"), "sdk", "1.2.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pulledDir := filepath.Join(tempDir, "project", ".upkg")
+	for _, f := range files {
+		if _, err := copyFile(f, pulledDir); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	projectDir := filepath.Join(tempDir, "project")
+	goMod := `module example.com/service
+
+go 1.24
+
+// pinned for compatibility
+require example.com/Org/sdk v1.0.0
+`
+	if err := os.WriteFile(filepath.Join(projectDir, "go.mod"), []byte(goMod), 0644); err != nil {
+		t.Fatal(err)
+	}
+	goSum := "example.com/Org/sdk v1.0.0 h1:old=\nexample.com/Org/sdk v1.0.0/go.mod h1:old=\n"
+	if err := os.WriteFile(filepath.Join(projectDir, "go.sum"), []byte(goSum), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := handler.UpdatePackageRef("sdk", filepath.Join(pulledDir, "v1.2.0.zip"), projectDir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	updatedGoMod, err := os.ReadFile(filepath.Join(projectDir, "go.mod"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedGoMod := `module example.com/service
+
+go 1.24
+
+// pinned for compatibility
+require example.com/Org/sdk v1.2.0
+`
+	if string(updatedGoMod) != expectedGoMod {
+		t.Errorf("expected go.mod:\n%s\ngot:\n%s", expectedGoMod, updatedGoMod)
+	}
+
+	updatedGoSum, err := os.ReadFile(filepath.Join(projectDir, "go.sum"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(updatedGoSum)), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[2], "example.com/Org/sdk v1.2.0 h1:") || !strings.HasPrefix(lines[3], "example.com/Org/sdk v1.2.0/go.mod h1:") {
+		t.Errorf("unexpected go.sum:\n%s", updatedGoSum)
+	}
+
+	// Module paths are case-encoded in the proxy layout
+	versionDir := filepath.Join(projectDir, InstallDir, "goproxy", "example.com", "!org", "sdk", "@v")
+	for _, name := range []string{"v1.2.0.zip", "v1.2.0.mod", "v1.2.0.info"} {
+		if _, err := os.Stat(filepath.Join(versionDir, name)); err != nil {
+			t.Errorf("expected %s in local proxy: %v", name, err)
+		}
+	}
+	list, err := os.ReadFile(filepath.Join(versionDir, "list"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(list) != "v1.2.0\n" {
+		t.Errorf("expected list to contain v1.2.0, got %q", list)
+	}
+}
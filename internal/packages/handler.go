@@ -1,9 +1,16 @@
 package packages
 
 import (
+	"errors"
 	"fmt"
+	"os"
+	"sort"
+	"sync"
 )
 
+// InstallDir is the project-local directory that pulled packages are stored under.
+const InstallDir = ".universal-packages"
+
 type PackageHandler interface {
 	// LocatePackage finds the package file in the specified directory
 	// based on the package name and version.
@@ -14,10 +21,96 @@ type PackageHandler interface {
 	UpdatePackageRef(packageName string, packageFilePath string, packageRefFilePath string) error
 }
 
+// MultiFilePackageHandler is implemented by handlers whose packages are made up of several files,
+// which are pushed as separate layers of a single OCI artifact.
+type MultiFilePackageHandler interface {
+	PackageHandler
+	// LocatePackageFiles finds every file that makes up the package in the specified directory.
+	// The first file is the one LocatePackage returns; on install the others are pulled alongside it.
+	LocatePackageFiles(dir string, packageName string, packageVersion string) ([]string, error)
+}
+
+// InstallOptions holds optional settings for an install, set from flags of the install command.
+// The zero value means no options were given.
+type InstallOptions struct {
+	// Workspace is the name (or directory) of the workspace package to add the dependency to,
+	// for ecosystems with monorepo workspaces.
+	Workspace string
+	// Section is the section of the package manifest to add the dependency to, such as devDependencies for npm,
+	// instead of the ecosystem's default.
+	Section string
+	// Override is how the dependency is pinned to the pulled package, for ecosystems that support more than one
+	// way, such as archive for a Bazel archive_override instead of a local_path_override.
+	Override string
+	// Reference is the OCI reference the package was pulled from, such as ghcr.io/myorg/rules_acme:1.2.0, for
+	// handlers that point the dependency back at the registry.
+	Reference string
+}
+
+// ConfigurablePackageHandler is implemented by handlers that support install options.
+type ConfigurablePackageHandler interface {
+	PackageHandler
+	// UpdatePackageRefWithOptions is UpdatePackageRef with the given install options applied.
+	UpdatePackageRefWithOptions(packageName string, packageFilePath string, packageRefFilePath string, options InstallOptions) error
+}
+
+// Directories created by newOutputDir, until RemoveOutputDirs removes them
+var (
+	outputDirsMu sync.Mutex
+	outputDirs   []string
+)
+
+// newOutputDir creates a temporary directory for the files LocatePackage builds from a project, such as an
+// archive of its sources. It is removed by RemoveOutputDirs once the files have been pushed.
+func newOutputDir(packageType string) (string, error) {
+	dir, err := os.MkdirTemp("", "upkg-"+packageType+"-")
+	if err != nil {
+		return "", fmt.Errorf("creating output directory: %w", err)
+	}
+	outputDirsMu.Lock()
+	defer outputDirsMu.Unlock()
+	outputDirs = append(outputDirs, dir)
+	return dir, nil
+}
+
+// RemoveOutputDirs removes the temporary directories holding the package files that handlers have built.
+func RemoveOutputDirs() error {
+	outputDirsMu.Lock()
+	defer outputDirsMu.Unlock()
+	var errs []error
+	for _, dir := range outputDirs {
+		if err := os.RemoveAll(dir); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	outputDirs = nil
+	return errors.Join(errs...)
+}
+
 // Registry of supported handlers by name
 var handlers = map[string]PackageHandler{
-	"npm": &NpmHandler{},
-	// "pypi": &PyPiHandler{},
+	"npm":       &NpmHandler{},
+	"pip":       &PipHandler{},
+	"nuget":     &NuGetHandler{},
+	"go":        &GoHandler{},
+	"maven":     &MavenHandler{},
+	"cargo":     &CargoHandler{},
+	"helm":      &HelmHandler{},
+	"terraform": &TerraformHandler{},
+	"gem":       &GemHandler{},
+	"composer":  &ComposerHandler{},
+	"pub":       &PubHandler{},
+	"conan":     &ConanHandler{},
+	"ansible":   &AnsibleHandler{},
+	"hex":       &HexHandler{},
+	"r":         &RHandler{},
+	"bazel":     &BazelHandler{},
+	"swift":     &SwiftHandler{},
+	"conda":     &CondaHandler{},
+	"deno":      &DenoHandler{},
+	"cabal":     &CabalHandler{},
+	"luarocks":  &LuaRocksHandler{},
+	"zig":       &ZigHandler{},
 	// Add more here
 }
 
@@ -27,3 +120,13 @@ func GetHandler(packageType string) (PackageHandler, error) {
 	}
 	return nil, fmt.Errorf("unsupported package type: %s", packageType)
 }
+
+// SupportedTypes returns the names of all registered handlers in alphabetical order.
+func SupportedTypes() []string {
+	types := make([]string, 0, len(handlers))
+	for t := range handlers {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return types
+}
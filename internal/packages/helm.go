@@ -0,0 +1,118 @@
+package packages
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type HelmHandler struct{}
+
+// LocatePackage finds the packaged chart in the specified directory based on the chart name and version,
+// as written by `helm package`.
+func (h *HelmHandler) LocatePackage(dir string, packageName string, packageVersion string) (string, error) {
+	filename := fmt.Sprintf("%s-%s.tgz", packageName, packageVersion)
+	packagePath := filepath.Join(dir, filename)
+	if !fileExists(packagePath) {
+		return "", fmt.Errorf("expected package file not found: %s", filename)
+	}
+	return packagePath, nil
+}
+
+// UpdatePackageRef places the packaged chart in the charts/ directory of the nearest Chart.yaml, replacing
+// other versions of it, and adds or updates the matching entry in Chart.yaml dependencies. The chart is also
+// unpacked into a project-local directory that the entry's file:// repository points at, so that
+// `helm dependency update` keeps working. Comments and ordering in Chart.yaml are preserved.
+func (h *HelmHandler) UpdatePackageRef(packageName string, packageFilePath string, packageRefFilePath string) error {
+	chartPath, err := findFileUp(packageRefFilePath, "Chart.yaml")
+	if err != nil {
+		return fmt.Errorf("finding Chart.yaml: %w", err)
+	}
+	chartDir := filepath.Dir(chartPath)
+
+	filename := filepath.Base(packageFilePath)
+	version, ok := strings.CutPrefix(strings.TrimSuffix(filename, ".tgz"), packageName+"-")
+	if !ok || version == "" {
+		return fmt.Errorf("unexpected package file name %s for chart %s", filename, packageName)
+	}
+
+	chartsDir := filepath.Join(chartDir, "charts")
+	// Other packaged versions of the chart are removed, so Helm does not see the dependency twice
+	if err := removeOtherVersions(chartsDir, packageName+"-", ".tgz", filename); err != nil {
+		return err
+	}
+	if _, err := copyFile(packageFilePath, chartsDir); err != nil {
+		return fmt.Errorf("adding chart to charts/: %w", err)
+	}
+
+	// Packaged charts contain a single <name>/ directory
+	sourceDir := filepath.Join(chartDir, InstallDir, "helm-charts", packageName)
+	if err := extractTarGz(packageFilePath, sourceDir, 1); err != nil {
+		return fmt.Errorf("unpacking chart: %w", err)
+	}
+	relPath, err := filepath.Rel(chartDir, sourceDir)
+	if err != nil {
+		return fmt.Errorf("calculating relative path: %w", err)
+	}
+
+	data, err := os.ReadFile(chartPath)
+	if err != nil {
+		return err
+	}
+	updated := updateChartDependencies(string(data), packageName, version, "file://"+filepath.ToSlash(relPath))
+	return os.WriteFile(chartPath, []byte(updated), 0644)
+}
+
+// updateChartDependencies sets the version and repository of every dependency entry with the chart's name
+// (there may be several under different aliases), or appends a new entry.
+func updateChartDependencies(content string, chartName string, version string, repository string) string {
+	lines := strings.Split(content, "\n")
+
+	keyIdx := yamlFindKey(lines, 0, len(lines), 0, "dependencies")
+	if keyIdx == -1 {
+		at := len(lines)
+		if lines[at-1] == "" {
+			at--
+		}
+		return strings.Join(yamlInsertLines(lines, at,
+			"dependencies:",
+			"  - name: "+yamlQuote(chartName),
+			"    version: "+yamlQuote(version),
+			"    repository: "+yamlQuote(repository),
+		), "\n")
+	}
+	if _, raw, _ := yamlKeyValue(lines[keyIdx], 0); yamlScalar(raw) == "[]" {
+		lines[keyIdx] = "dependencies:"
+	}
+
+	end := yamlBlockEnd(lines, keyIdx)
+	items := yamlSequenceItems(lines, keyIdx+1, end)
+	found := false
+	for i := len(items) - 1; i >= 0; i-- {
+		item := items[i]
+		if _, name := yamlItemValue(lines, item, "name"); name != chartName {
+			continue
+		}
+		for _, kv := range [][2]string{{"version", version}, {"repository", repository}} {
+			before := len(lines)
+			lines = setYAMLItemValue(lines, item, kv[0], kv[1])
+			item.End += len(lines) - before
+		}
+		found = true
+	}
+	if found {
+		return strings.Join(lines, "\n")
+	}
+
+	dashIndent, keyIndent := 2, 4
+	if len(items) > 0 {
+		dashIndent = yamlIndent(lines[items[0].Start])
+		keyIndent = yamlItemKeyIndent(lines, items[0])
+	}
+	return strings.Join(yamlInsertLines(lines, end,
+		strings.Repeat(" ", dashIndent)+"-"+strings.Repeat(" ", keyIndent-dashIndent-1)+"name: "+yamlQuote(chartName),
+		strings.Repeat(" ", keyIndent)+"version: "+yamlQuote(version),
+		strings.Repeat(" ", keyIndent)+"repository: "+yamlQuote(repository),
+	), "\n")
+}
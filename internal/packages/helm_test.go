@@ -0,0 +1,211 @@
+package packages
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHelmLocatePackage(t *testing.T) {
+	handler := &HelmHandler{}
+	dir := "../../testdata"
+
+	tempDir, err := os.MkdirTemp(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tempDir); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to remove temp folder: %v\n", err)
+		}
+	}() // Clean up
+
+	// Mimic the output of `helm package`
+	packagePath := filepath.Join(tempDir, "redis-cluster-1.2.0.tgz")
+	writeTestTarGz(t, packagePath, map[string]string{"redis-cluster/Chart.yaml": "name: redis-cluster\n"})
+
+	testCases := []struct {
+		name           string
+		packageName    string
+		packageVersion string
+		expectedPath   string
+		expectedError  bool
+	}{
+		{
+			name:           "find packaged chart",
+			packageName:    "redis-cluster",
+			packageVersion: "1.2.0",
+			expectedPath:   packagePath,
+		},
+		{
+			name:           "fail on non-existing chart",
+			packageName:    "redis-cluster",
+			packageVersion: "1.3.0",
+			expectedError:  true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			filePath, err := handler.LocatePackage(tempDir, testCase.packageName, testCase.packageVersion)
+			if testCase.expectedError {
+				if err == nil {
+					t.Fatal("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if filePath != testCase.expectedPath {
+				t.Errorf("expected %s, got %s", testCase.expectedPath, filePath)
+			}
+		})
+	}
+}
+
+func TestHelmUpdatePackageRef(t *testing.T) {
+	testCases := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name: "adds dependencies key",
+			input: `apiVersion: v2
+name: gateway
+version: 0.1.0
+`,
+			expected: `apiVersion: v2
+name: gateway
+version: 0.1.0
+dependencies:
+  - name: redis-cluster
+    version: 1.2.0
+    repository: file://.universal-packages/helm-charts/redis-cluster
+`,
+		},
+		{
+			name: "appends dependency after existing items",
+			input: `apiVersion: v2
+name: gateway
+dependencies:
+- name: postgresql # database
+  version: "12.1.0"
+  repository: https://charts.bitnami.com/bitnami
+
+# Maintainers of this chart
+maintainers:
+  - name: platform
+`,
+			expected: `apiVersion: v2
+name: gateway
+dependencies:
+- name: postgresql # database
+  version: "12.1.0"
+  repository: https://charts.bitnami.com/bitnami
+- name: redis-cluster
+  version: 1.2.0
+  repository: file://.universal-packages/helm-charts/redis-cluster
+
+# Maintainers of this chart
+maintainers:
+  - name: platform
+`,
+		},
+		{
+			name: "updates existing dependency and aliases",
+			input: `dependencies:
+  - name: redis-cluster
+    version: ~1.1.0 # pinned for now
+    repository: oci://registry.example.com/charts
+    condition: cache.enabled
+  - name: redis-cluster
+    alias: sessions
+    version: 1.1.0
+type: application
+`,
+			expected: `dependencies:
+  - name: redis-cluster
+    version: 1.2.0 # pinned for now
+    repository: file://.universal-packages/helm-charts/redis-cluster
+    condition: cache.enabled
+  - name: redis-cluster
+    alias: sessions
+    version: 1.2.0
+    repository: file://.universal-packages/helm-charts/redis-cluster
+type: application
+`,
+		},
+		{
+			name: "fills empty dependencies list",
+			input: `name: gateway
+dependencies: []
+`,
+			expected: `name: gateway
+dependencies:
+  - name: redis-cluster
+    version: 1.2.0
+    repository: file://.universal-packages/helm-charts/redis-cluster
+`,
+		},
+	}
+
+	handler := &HelmHandler{}
+	dir := "../../testdata"
+
+	installTempDir, err := os.MkdirTemp(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(installTempDir); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to remove temp folder: %v\n", err)
+		}
+	}() // Clean up
+
+	packageLocation := filepath.Join(installTempDir, ".upkg", "redis-cluster-1.2.0.tgz")
+	writeTestTarGz(t, packageLocation, map[string]string{
+		"redis-cluster/Chart.yaml":         "name: redis-cluster\nversion: 1.2.0\n",
+		"redis-cluster/templates/svc.yaml": "",
+	})
+	chartPath := filepath.Join(installTempDir, "Chart.yaml")
+
+	// An older version of the chart should be replaced in charts/
+	chartsDir := filepath.Join(installTempDir, "charts")
+	writeTestTarGz(t, filepath.Join(chartsDir, "redis-cluster-1.1.0.tgz"), map[string]string{"redis-cluster/Chart.yaml": ""})
+	writeTestTarGz(t, filepath.Join(chartsDir, "redis-cluster-extras-0.1.0.tgz"), map[string]string{"redis-cluster-extras/Chart.yaml": ""})
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if err := os.WriteFile(chartPath, []byte(testCase.input), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := handler.UpdatePackageRef("redis-cluster", packageLocation, installTempDir); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			updated, err := os.ReadFile(chartPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(updated) != testCase.expected {
+				t.Errorf("expected:\n%s\ngot:\n%s", testCase.expected, updated)
+			}
+		})
+	}
+
+	for _, name := range []string{"redis-cluster-1.2.0.tgz", "redis-cluster-extras-0.1.0.tgz"} {
+		if !fileExists(filepath.Join(chartsDir, name)) {
+			t.Errorf("expected charts/%s to exist", name)
+		}
+	}
+	if fileExists(filepath.Join(chartsDir, "redis-cluster-1.1.0.tgz")) {
+		t.Error("expected old chart version to be removed from charts/")
+	}
+	if !fileExists(filepath.Join(installTempDir, InstallDir, "helm-charts", "redis-cluster", "templates", "svc.yaml")) {
+		t.Error("expected chart to be unpacked")
+	}
+}
@@ -0,0 +1,184 @@
+package packages
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type HexHandler struct{}
+
+var (
+	// hexSourceOptionPattern matches mix.exs dependency options that choose where a dependency comes from,
+	// which a path dependency replaces. Other options (only:, runtime:, override:, ...) are kept.
+	hexSourceOptionPattern = regexp.MustCompile(`^(path|git|github|branch|tag|ref|sparse|subdir|submodules|depth|hex|repo|organization|in_umbrella):`)
+	hexMetadataNamePattern = regexp.MustCompile(`\{<<"name">>,\s*<<"([^"]*)">>\}`)
+	mixDepsFunctionPattern = regexp.MustCompile(`(?m)^[ \t]*defp?[ \t]+deps(\(\))?([ \t]+do\b|,[ \t]*do:)`)
+)
+
+// LocatePackage finds <name>-<version>.tar in the specified directory, as written by `mix hex.build`.
+func (h *HexHandler) LocatePackage(dir string, packageName string, packageVersion string) (string, error) {
+	filename := fmt.Sprintf("%s-%s.tar", packageName, packageVersion)
+	packagePath := filepath.Join(dir, filename)
+	if !fileExists(packagePath) {
+		return "", fmt.Errorf("expected package file not found: %s", filename)
+	}
+	return packagePath, nil
+}
+
+// UpdatePackageRef unpacks the package into a vendor directory next to the nearest mix.exs and declares it
+// there as a path dependency, replacing the package's existing entry in the deps list or adding one. The
+// package's entry in mix.lock is removed, as Mix does not lock path dependencies.
+func (h *HexHandler) UpdatePackageRef(packageName string, packageFilePath string, packageRefFilePath string) error {
+	mixPath, err := findFileUp(packageRefFilePath, "mix.exs")
+	if err != nil {
+		return fmt.Errorf("finding mix.exs: %w", err)
+	}
+	projectDir := filepath.Dir(mixPath)
+
+	packageDir := filepath.Join(projectDir, InstallDir, "hex-packages", packageName)
+	metadata, err := unpackHexPackage(packageFilePath, packageDir)
+	if err != nil {
+		return fmt.Errorf("unpacking package: %w", err)
+	}
+	if match := hexMetadataNamePattern.FindStringSubmatch(metadata); match == nil || match[1] != packageName {
+		return fmt.Errorf("%s is not a package of %s", filepath.Base(packageFilePath), packageName)
+	}
+
+	relPath, err := filepath.Rel(projectDir, packageDir)
+	if err != nil {
+		return fmt.Errorf("calculating relative path: %w", err)
+	}
+	data, err := os.ReadFile(mixPath)
+	if err != nil {
+		return err
+	}
+	updated, err := setMixPathDependency(string(data), packageName, filepath.ToSlash(relPath))
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(mixPath, []byte(updated), 0644); err != nil {
+		return err
+	}
+
+	lockPath := filepath.Join(projectDir, "mix.lock")
+	lock, err := os.ReadFile(lockPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return os.WriteFile(lockPath, []byte(removeMixLockEntry(string(lock), packageName)), 0644)
+}
+
+// unpackHexPackage extracts the files of a Hex package (a tar holding contents.tar.gz and metadata.config)
+// into destDir, and returns the package's metadata.
+func unpackHexPackage(packagePath string, destDir string) (string, error) {
+	f, err := os.Open(packagePath)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	var metadata string
+	unpacked := false
+	tr := tar.NewReader(f)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("reading %s: %w", filepath.Base(packagePath), err)
+		}
+
+		switch header.Name {
+		case "contents.tar.gz":
+			gz, err := gzip.NewReader(tr)
+			if err != nil {
+				return "", fmt.Errorf("reading %s: %w", header.Name, err)
+			}
+			err = extractTar(gz, destDir, 0)
+			_ = gz.Close()
+			if err != nil {
+				return "", err
+			}
+			unpacked = true
+		case "metadata.config":
+			data, err := io.ReadAll(tr)
+			if err != nil {
+				return "", fmt.Errorf("reading %s: %w", header.Name, err)
+			}
+			metadata = string(data)
+		}
+	}
+	if !unpacked {
+		return "", fmt.Errorf("%s contains no contents.tar.gz", filepath.Base(packagePath))
+	}
+	return metadata, nil
+}
+
+// setMixPathDependency points every dependency tuple of the package in mix.exs at path, dropping its version
+// requirement and source options but keeping the rest, or adds a tuple to the list returned by deps/0.
+func setMixPathDependency(content string, packageName string, path string) (string, error) {
+	code := maskHashComments(content)
+	declaration := regexp.MustCompile(`\{\s*:` + regexp.QuoteMeta(packageName) + `\s*[,}]`)
+	matches := declaration.FindAllStringIndex(code, -1)
+	// Tuples are replaced from the end so the positions of earlier ones stay valid
+	for i := len(matches) - 1; i >= 0; i-- {
+		open := matches[i][0]
+		end := closingBracket(code, open)
+		if end == -1 {
+			return "", fmt.Errorf("unterminated dependency tuple for %s in mix.exs", packageName)
+		}
+		elements := []string{":" + packageName, "path: " + strconv.Quote(path)}
+		for _, element := range splitRubyArgs(code[open+1 : end])[1:] {
+			if element == "" || element[0] == '"' || hexSourceOptionPattern.MatchString(element) {
+				continue
+			}
+			elements = append(elements, element)
+		}
+		content = content[:open] + "{" + strings.Join(elements, ", ") + "}" + content[end+1:]
+	}
+	if len(matches) > 0 {
+		return content, nil
+	}
+
+	loc := mixDepsFunctionPattern.FindStringIndex(code)
+	if loc == nil {
+		return "", fmt.Errorf("no deps function found in mix.exs")
+	}
+	open := strings.Index(code[loc[1]:], "[")
+	if open == -1 {
+		return "", fmt.Errorf("no deps list found in mix.exs")
+	}
+	open += loc[1]
+	end := closingBracket(code, open)
+	if end == -1 {
+		return "", fmt.Errorf("unterminated deps list in mix.exs")
+	}
+	tuple := "{:" + packageName + ", path: " + strconv.Quote(path) + "}"
+	return appendListItem(content, code, open, end, tuple), nil
+}
+
+// removeMixLockEntry removes the line locking the package from mix.lock.
+func removeMixLockEntry(content string, packageName string) string {
+	prefix := strconv.Quote(packageName) + ":"
+	lines := strings.Split(content, "\n")
+	kept := lines[:0]
+	for _, line := range lines {
+		if !strings.HasPrefix(strings.TrimSpace(line), prefix) {
+			kept = append(kept, line)
+		}
+	}
+	return strings.Join(kept, "\n")
+}
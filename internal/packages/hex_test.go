@@ -0,0 +1,284 @@
+package packages
+
+import (
+	"archive/tar"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeTestHexPackage writes a Hex package in the layout of `mix hex.build`: an uncompressed tar holding the
+// package's metadata and its files in contents.tar.gz.
+func writeTestHexPackage(t *testing.T, path string, name string, files map[string]string) {
+	t.Helper()
+	contentsPath := path + ".contents"
+	writeTestTarGz(t, contentsPath, files)
+	contents, err := os.ReadFile(contentsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(contentsPath); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	tw := tar.NewWriter(f)
+	entries := []struct {
+		name string
+		data []byte
+	}{
+		{"VERSION", []byte("3")},
+		{"metadata.config", []byte(fmt.Sprintf("{<<\"app\">>,<<\"%[1]s\">>}.\n{<<\"name\">>,<<\"%[1]s\">>}.\n{<<\"version\">>,<<\"1.3.0\">>}.\n", name))},
+		{"contents.tar.gz", contents},
+		{"CHECKSUM", []byte("0000")},
+	}
+	for _, entry := range entries {
+		if err := tw.WriteHeader(&tar.Header{Name: entry.name, Mode: 0644, Size: int64(len(entry.data))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(entry.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHexLocatePackage(t *testing.T) {
+	handler := &HexHandler{}
+	dir := "../../testdata"
+
+	tempDir, err := os.MkdirTemp(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tempDir); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to remove temp folder: %v\n", err)
+		}
+	}() // Clean up
+
+	packagePath := filepath.Join(tempDir, "acme_sdk-1.3.0.tar")
+	writeTestHexPackage(t, packagePath, "acme_sdk", map[string]string{"mix.exs": ""})
+
+	testCases := []struct {
+		name           string
+		packageName    string
+		packageVersion string
+		expectedPath   string
+		expectedError  bool
+	}{
+		{
+			name:           "find built package",
+			packageName:    "acme_sdk",
+			packageVersion: "1.3.0",
+			expectedPath:   packagePath,
+		},
+		{
+			name:           "fail on non-existing package",
+			packageName:    "acme_sdk",
+			packageVersion: "1.4.0",
+			expectedError:  true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			filePath, err := handler.LocatePackage(tempDir, testCase.packageName, testCase.packageVersion)
+			if testCase.expectedError {
+				if err == nil {
+					t.Fatal("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if filePath != testCase.expectedPath {
+				t.Errorf("expected %s, got %s", testCase.expectedPath, filePath)
+			}
+		})
+	}
+}
+
+func TestHexUpdatePackageRef(t *testing.T) {
+	testCases := []struct {
+		name          string
+		input         string
+		expected      string
+		expectedError bool
+	}{
+		{
+			name: "replaces hex dependency",
+			input: `defmodule Gateway.MixProject do
+  use Mix.Project
+
+  defp deps do
+    [
+      {:phoenix, "~> 1.7"},
+      {:acme_sdk, "~> 1.2", organization: "acme", only: [:dev, :prod]}, # shared SDK
+      {:jason, "~> 1.4"}
+    ]
+  end
+end
+`,
+			expected: `defmodule Gateway.MixProject do
+  use Mix.Project
+
+  defp deps do
+    [
+      {:phoenix, "~> 1.7"},
+      {:acme_sdk, path: ".universal-packages/hex-packages/acme_sdk", only: [:dev, :prod]}, # shared SDK
+      {:jason, "~> 1.4"}
+    ]
+  end
+end
+`,
+		},
+		{
+			name: "replaces multi-line git dependency",
+			input: `  defp deps do
+    [
+      {:acme_sdk,
+       github: "acme/acme_sdk", # pinned
+       tag: "v1.2.0",
+       override: true}
+    ]
+  end
+`,
+			expected: `  defp deps do
+    [
+      {:acme_sdk, path: ".universal-packages/hex-packages/acme_sdk", override: true}
+    ]
+  end
+`,
+		},
+		{
+			name: "adds dependency",
+			input: `  defp deps do
+    [
+      {:phoenix, "~> 1.7"},
+      {:jason, "~> 1.4"} # JSON
+      # {:acme_sdk, "~> 1.0"}
+    ]
+  end
+`,
+			expected: `  defp deps do
+    [
+      {:phoenix, "~> 1.7"},
+      {:jason, "~> 1.4"}, # JSON
+      # {:acme_sdk, "~> 1.0"}
+      {:acme_sdk, path: ".universal-packages/hex-packages/acme_sdk"}
+    ]
+  end
+`,
+		},
+		{
+			name: "adds dependency to empty list",
+			input: `  defp deps do
+    []
+  end
+`,
+			expected: `  defp deps do
+    [{:acme_sdk, path: ".universal-packages/hex-packages/acme_sdk"}]
+  end
+`,
+		},
+		{
+			name: "adds dependency to inline list",
+			input: `  defp deps, do: [{:jason, "~> 1.4"}]
+`,
+			expected: `  defp deps, do: [{:jason, "~> 1.4"}, {:acme_sdk, path: ".universal-packages/hex-packages/acme_sdk"}]
+`,
+		},
+		{
+			name: "fails without deps function",
+			input: `defmodule Gateway.MixProject do
+  use Mix.Project
+end
+`,
+			expectedError: true,
+		},
+	}
+
+	handler := &HexHandler{}
+	dir := "../../testdata"
+
+	installTempDir, err := os.MkdirTemp(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(installTempDir); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to remove temp folder: %v\n", err)
+		}
+	}() // Clean up
+
+	packageLocation := filepath.Join(installTempDir, ".upkg", "acme_sdk-1.3.0.tar")
+	writeTestHexPackage(t, packageLocation, "acme_sdk", map[string]string{
+		"mix.exs":          "defmodule AcmeSdk.MixProject do\nend\n",
+		"lib/acme_sdk.ex":  "defmodule AcmeSdk do\nend\n",
+		".formatter.exs":   "[]\n",
+		"priv/schema.json": "{}\n",
+	})
+	mixPath := filepath.Join(installTempDir, "mix.exs")
+	lockPath := filepath.Join(installTempDir, "mix.lock")
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if err := os.WriteFile(mixPath, []byte(testCase.input), 0644); err != nil {
+				t.Fatal(err)
+			}
+			lock := "%{\n  \"acme_sdk\": {:hex, :acme_sdk, \"1.2.0\", \"abc\", [:mix], [], \"hexpm\", \"def\"},\n  \"jason\": {:hex, :jason, \"1.4.1\", \"abc\", [:mix], [], \"hexpm\", \"def\"},\n}\n"
+			if err := os.WriteFile(lockPath, []byte(lock), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			err := handler.UpdatePackageRef("acme_sdk", packageLocation, installTempDir)
+			if testCase.expectedError {
+				if err == nil {
+					t.Fatal("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			updated, err := os.ReadFile(mixPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(updated) != testCase.expected {
+				t.Errorf("expected:\n%s\ngot:\n%s", testCase.expected, updated)
+			}
+
+			expectedLock := "%{\n  \"jason\": {:hex, :jason, \"1.4.1\", \"abc\", [:mix], [], \"hexpm\", \"def\"},\n}\n"
+			updatedLock, err := os.ReadFile(lockPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(updatedLock) != expectedLock {
+				t.Errorf("expected mix.lock:\n%s\ngot:\n%s", expectedLock, updatedLock)
+			}
+		})
+	}
+
+	if !fileExists(filepath.Join(installTempDir, InstallDir, "hex-packages", "acme_sdk", "lib", "acme_sdk.ex")) {
+		t.Error("expected package to be extracted")
+	}
+
+	otherLocation := filepath.Join(installTempDir, ".upkg", "acme_cli-1.3.0.tar")
+	writeTestHexPackage(t, otherLocation, "acme_cli", map[string]string{"mix.exs": ""})
+	if err := handler.UpdatePackageRef("acme_sdk", otherLocation, installTempDir); err == nil {
+		t.Error("expected error for package with another name, got nil")
+	}
+}
@@ -0,0 +1,253 @@
+package packages
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// A LuaRocks package is pushed as its rockspec, <name>-<version>-<revision>.rockspec, and the rocks built from
+// it by `luarocks pack`, <name>-<version>-<revision>.<arch>.rock, where arch is src for a source rock, all for
+// a pure Lua rock or a platform such as linux-x86_64. On install they are added to a rocks server in the project,
+// a directory holding rocks and rockspecs and a manifest listing them, which luarocks installs from with
+// --server.
+type LuaRocksHandler struct{}
+
+var (
+	luarocksRockPattern         = regexp.MustCompile(`^(.+)-([^-]+-\d+)\.([^.]+)\.rock$`)
+	luarocksRockspecPattern     = regexp.MustCompile(`^(.+)-([^-]+-\d+)\.rockspec$`)
+	rockspecDependenciesPattern = regexp.MustCompile(`(?m)^dependencies\s*=\s*\{`)
+	rockspecBuildPattern        = regexp.MustCompile(`(?m)^build\s*=`)
+	rockspecDependencyPattern   = regexp.MustCompile(`^["']\s*([^\s"'<>=~]+)`)
+	luaIdentifierPattern        = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// LocatePackage finds the rockspec of the package. See LocatePackageFiles.
+func (l *LuaRocksHandler) LocatePackage(dir string, packageName string, packageVersion string) (string, error) {
+	files, err := l.LocatePackageFiles(dir, packageName, packageVersion)
+	if err != nil {
+		return "", err
+	}
+	return files[0], nil
+}
+
+// LocatePackageFiles finds <name>-<version>.rockspec in the specified directory or in rockspecs/, along with
+// the rocks packed from it in the specified directory. The version may leave out the rockspec revision, as in
+// 1.2.0 for 1.2.0-1, as long as only one revision is present.
+func (l *LuaRocksHandler) LocatePackageFiles(dir string, packageName string, packageVersion string) ([]string, error) {
+	var rockspecs []string
+	for _, searchDir := range []string{dir, filepath.Join(dir, "rockspecs")} {
+		entries, err := os.ReadDir(searchDir)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, fmt.Errorf("error reading package directory: %w", err)
+		}
+		for _, entry := range entries {
+			name, version, arch, ok := parseLuaRocksFilename(entry.Name())
+			if !entry.IsDir() && ok && arch == "rockspec" && name == packageName && luarocksVersionMatches(version, packageVersion) {
+				rockspecs = append(rockspecs, filepath.Join(searchDir, entry.Name()))
+			}
+		}
+	}
+	if len(rockspecs) == 0 {
+		return nil, fmt.Errorf("expected package file not found: %s-%s-*.rockspec", packageName, packageVersion)
+	}
+	if len(rockspecs) > 1 {
+		return nil, fmt.Errorf("found several revisions of %s %s: %s", packageName, packageVersion, strings.Join(rockspecs, ", "))
+	}
+
+	rocks := luarocksRocks(dir, strings.TrimSuffix(filepath.Base(rockspecs[0]), ".rockspec"))
+	if len(rocks) == 0 {
+		return nil, fmt.Errorf("no rock of %s found, run `luarocks pack %s` first", filepath.Base(rockspecs[0]), filepath.Base(rockspecs[0]))
+	}
+	return append(rockspecs, rocks...), nil
+}
+
+// UpdatePackageRef adds the pulled rockspec and rocks to the rocks server at .universal-packages/luarocks next
+// to the nearest rockspec of the project, replacing other versions of the package, and regenerates the server's
+// manifest. The exact version is then added to the dependencies of the project's rockspec, replacing any other
+// constraint on the package.
+func (l *LuaRocksHandler) UpdatePackageRef(packageName string, packageFilePath string, packageRefFilePath string) error {
+	rockspecPath, err := findFileUp(packageRefFilePath, "*.rockspec")
+	if err != nil {
+		return fmt.Errorf("finding rockspec: %w", err)
+	}
+
+	name, version, arch, ok := parseLuaRocksFilename(filepath.Base(packageFilePath))
+	if !ok || arch != "rockspec" {
+		return fmt.Errorf("%s is not a rockspec", filepath.Base(packageFilePath))
+	}
+	if name != packageName {
+		return fmt.Errorf("%s is the rockspec of %s, not %s", filepath.Base(packageFilePath), name, packageName)
+	}
+	rocks := luarocksRocks(filepath.Dir(packageFilePath), strings.TrimSuffix(filepath.Base(packageFilePath), ".rockspec"))
+	if len(rocks) == 0 {
+		return fmt.Errorf("no rock of %s was pulled", filepath.Base(packageFilePath))
+	}
+
+	serverDir := filepath.Join(filepath.Dir(rockspecPath), InstallDir, "luarocks")
+	// The rocks and rockspecs of other versions of the package are removed from the server
+	err = removeMatchingFiles(serverDir, func(filename string) bool {
+		name, v, _, ok := parseLuaRocksFilename(filename)
+		return ok && name == packageName && v != version
+	})
+	if err != nil {
+		return err
+	}
+	for _, file := range append([]string{packageFilePath}, rocks...) {
+		if _, err := copyFile(file, serverDir); err != nil {
+			return fmt.Errorf("storing package: %w", err)
+		}
+	}
+	if err := writeLuaRocksManifest(serverDir); err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(rockspecPath)
+	if err != nil {
+		return err
+	}
+	updated, err := setRockspecDependency(string(data), packageName, packageName+" == "+version)
+	if err != nil {
+		return fmt.Errorf("updating %s: %w", filepath.Base(rockspecPath), err)
+	}
+	return os.WriteFile(rockspecPath, []byte(updated), 0644)
+}
+
+// parseLuaRocksFilename splits the filename of a rock or rockspec into the package's name, its version with the
+// rockspec revision, and the rock's arch, which is "rockspec" for a rockspec as in a rocks server manifest.
+// Versions cannot contain dashes other than the one before the revision, while names can.
+func parseLuaRocksFilename(filename string) (string, string, string, bool) {
+	if m := luarocksRockspecPattern.FindStringSubmatch(filename); m != nil {
+		return m[1], m[2], "rockspec", true
+	}
+	if m := luarocksRockPattern.FindStringSubmatch(filename); m != nil {
+		return m[1], m[2], m[3], true
+	}
+	return "", "", "", false
+}
+
+// luarocksVersionMatches reports whether version, which has a rockspec revision, is packageVersion, which may
+// leave the revision out.
+func luarocksVersionMatches(version string, packageVersion string) bool {
+	return version == packageVersion || version[:strings.LastIndex(version, "-")] == packageVersion
+}
+
+// luarocksRocks returns the rocks in dir packed from the rockspec <stem>.rockspec, sorted by name.
+func luarocksRocks(dir string, stem string) []string {
+	matches, _ := filepath.Glob(filepath.Join(dir, stem+".*.rock"))
+	var rocks []string
+	for _, match := range matches {
+		if _, _, arch, ok := parseLuaRocksFilename(filepath.Base(match)); ok && arch != "rockspec" {
+			rocks = append(rocks, match)
+		}
+	}
+	sort.Strings(rocks)
+	return rocks
+}
+
+// writeLuaRocksManifest writes the manifest of a rocks server, like `luarocks-admin make-manifest`: a Lua table
+// listing the arches available for each version of each package in the directory.
+func writeLuaRocksManifest(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", dir, err)
+	}
+	repository := map[string]map[string][]string{}
+	for _, entry := range entries {
+		name, version, arch, ok := parseLuaRocksFilename(entry.Name())
+		if entry.IsDir() || !ok {
+			continue
+		}
+		if repository[name] == nil {
+			repository[name] = map[string][]string{}
+		}
+		repository[name][version] = append(repository[name][version], arch)
+	}
+
+	var b strings.Builder
+	b.WriteString("commands = {}\nmodules = {}\nrepository = {")
+	for i, name := range sortedKeys(repository) {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		fmt.Fprintf(&b, "\n   %s = {", luaKey(name))
+		for j, version := range sortedKeys(repository[name]) {
+			if j > 0 {
+				b.WriteString(",")
+			}
+			fmt.Fprintf(&b, "\n      %s = {", luaKey(version))
+			arches := repository[name][version]
+			sort.Strings(arches)
+			for k, arch := range arches {
+				if k > 0 {
+					b.WriteString(",")
+				}
+				fmt.Fprintf(&b, "\n         {\n            arch = %q\n         }", arch)
+			}
+			b.WriteString("\n      }")
+		}
+		b.WriteString("\n   }")
+	}
+	if len(repository) > 0 {
+		b.WriteString("\n")
+	}
+	b.WriteString("}\n")
+	return os.WriteFile(filepath.Join(dir, "manifest"), []byte(b.String()), 0644)
+}
+
+// luaKey returns a table key as written by LuaRocks: bare if it is an identifier, and quoted in brackets otherwise.
+func luaKey(key string) string {
+	if luaIdentifierPattern.MatchString(key) {
+		return key
+	}
+	return fmt.Sprintf("[%q]", key)
+}
+
+// setRockspecDependency replaces the first dependency on the package in the dependencies table of a rockspec
+// with the given one, removing any others, or adds it to the end of the table. A dependencies table is added before the build table if the
+// rockspec has none.
+func setRockspecDependency(content string, packageName string, dependency string) (string, error) {
+	code := maskLuaComments(content)
+	quoted := fmt.Sprintf("%q", dependency)
+	loc := rockspecDependenciesPattern.FindStringIndex(code)
+	if loc == nil {
+		table := "dependencies = {\n   " + quoted + "\n}\n"
+		if build := rockspecBuildPattern.FindStringIndex(code); build != nil {
+			return content[:build[0]] + table + "\n" + content[build[0]:], nil
+		}
+		if content != "" && !strings.HasSuffix(content, "\n") {
+			content += "\n"
+		}
+		return content + table, nil
+	}
+
+	open := loc[1] - 1
+	end := closingBracket(code, open)
+	if end == -1 {
+		return "", fmt.Errorf("unterminated dependencies table")
+	}
+	items := listItems(code, open, end)
+	var matches []int
+	for i, item := range items {
+		if m := rockspecDependencyPattern.FindStringSubmatch(code[item.Start:item.End]); m != nil && m[1] == packageName {
+			matches = append(matches, i)
+		}
+	}
+	if len(matches) == 0 {
+		return appendListItem(content, code, open, end, quoted), nil
+	}
+	// Further constraints on the package are removed from the end, along with the separator before them, so the
+	// positions of earlier items stay valid
+	for k := len(matches) - 1; k > 0; k-- {
+		i := matches[k]
+		content = content[:items[i-1].End] + content[items[i].End:]
+	}
+	first := items[matches[0]]
+	return content[:first.Start] + quoted + content[first.End:], nil
+}
@@ -0,0 +1,350 @@
+package packages
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// testRockspec is the rockspec of the package used by the tests.
+const testRockspec = `package = "acme-nginx"
+version = "1.2.0-1"
+source = {
+   url = "git+https://git.example.com/acme/acme-nginx.git",
+   tag = "v1.2.0"
+}
+build = {
+   type = "builtin",
+   modules = {
+      ["acme.nginx"] = "src/acme/nginx.lua"
+   }
+}
+`
+
+func TestLuaRocksLocatePackage(t *testing.T) {
+	handler := &LuaRocksHandler{}
+	dir := "../../testdata"
+
+	tempDir, err := os.MkdirTemp(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tempDir); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to remove temp folder: %v\n", err)
+		}
+	}() // Clean up
+
+	for _, name := range []string{
+		"acme-nginx-1.2.0-1.rockspec",
+		"acme-nginx-1.2.0-1.src.rock",
+		"acme-nginx-1.2.0-1.linux-x86_64.rock",
+		"acme-nginx-extra-1.2.0-1.all.rock",
+		"rockspecs/acme-nginx-1.3.0-1.rockspec",
+		"acme-nginx-1.3.0-1.all.rock",
+		"acme-nginx-1.4.0-1.rockspec",
+		"acme-nginx-1.4.0-2.rockspec",
+		"acme-nginx-1.5.0-1.rockspec",
+	} {
+		path := filepath.Join(tempDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	testCases := []struct {
+		name           string
+		packageVersion string
+		expectedFiles  []string
+		expectedError  bool
+	}{
+		{
+			name:           "find rockspec and rocks",
+			packageVersion: "1.2.0-1",
+			expectedFiles:  []string{"acme-nginx-1.2.0-1.rockspec", "acme-nginx-1.2.0-1.linux-x86_64.rock", "acme-nginx-1.2.0-1.src.rock"},
+		},
+		{
+			name:           "find rockspec in rockspecs directory without revision",
+			packageVersion: "1.3.0",
+			expectedFiles:  []string{"rockspecs/acme-nginx-1.3.0-1.rockspec", "acme-nginx-1.3.0-1.all.rock"},
+		},
+		{
+			name:           "fail on several revisions",
+			packageVersion: "1.4.0",
+			expectedError:  true,
+		},
+		{
+			name:           "fail on rockspec without rock",
+			packageVersion: "1.5.0",
+			expectedError:  true,
+		},
+		{
+			name:           "fail on non-existing rockspec",
+			packageVersion: "1.6.0",
+			expectedError:  true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			files, err := handler.LocatePackageFiles(tempDir, "acme-nginx", testCase.packageVersion)
+			if testCase.expectedError {
+				if err == nil {
+					t.Fatal("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			var expected []string
+			for _, name := range testCase.expectedFiles {
+				expected = append(expected, filepath.Join(tempDir, filepath.FromSlash(name)))
+			}
+			if !reflect.DeepEqual(files, expected) {
+				t.Errorf("expected %v, got %v", expected, files)
+			}
+		})
+	}
+}
+
+func TestLuaRocksUpdatePackageRef(t *testing.T) {
+	testCases := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name: "replaces constraint on package",
+			input: `package = "gateway"
+version = "0.3.0-1"
+dependencies = {
+   "lua >= 5.1",
+   "acme-nginx >= 1.1, < 2.0", -- shared auth modules
+   -- "acme-nginx-extra",
+   "lua-resty-http"
+}
+`,
+			expected: `package = "gateway"
+version = "0.3.0-1"
+dependencies = {
+   "lua >= 5.1",
+   "acme-nginx == 1.2.0-1", -- shared auth modules
+   -- "acme-nginx-extra",
+   "lua-resty-http"
+}
+`,
+		},
+		{
+			name: "replaces several constraints on package with one",
+			input: `package = "gateway"
+dependencies = {
+   "acme-nginx >= 1.0",
+   "lua >= 5.1",
+   "acme-nginx < 2.0",
+   "acme-nginx ~= 1.1.3"
+}
+`,
+			expected: `package = "gateway"
+dependencies = {
+   "acme-nginx == 1.2.0-1",
+   "lua >= 5.1"
+}
+`,
+		},
+		{
+			name: "adds dependency to table",
+			input: `package = "gateway"
+version = "0.3.0-1"
+description = {
+   detailed = [[
+      Gateway plugins -- "acme-nginx" is added by upkg
+   ]]
+}
+dependencies = {
+   "lua >= 5.1",
+   "acme-nginx-extra ~> 1.0",
+}
+`,
+			expected: `package = "gateway"
+version = "0.3.0-1"
+description = {
+   detailed = [[
+      Gateway plugins -- "acme-nginx" is added by upkg
+   ]]
+}
+dependencies = {
+   "lua >= 5.1",
+   "acme-nginx-extra ~> 1.0",
+   "acme-nginx == 1.2.0-1",
+}
+`,
+		},
+		{
+			name: "adds dependency to single-line table",
+			input: `package = "gateway"
+dependencies = { "lua >= 5.1" }
+`,
+			expected: `package = "gateway"
+dependencies = { "lua >= 5.1", "acme-nginx == 1.2.0-1" }
+`,
+		},
+		{
+			name: "adds dependencies table before build",
+			input: `package = "gateway"
+version = "0.3.0-1"
+build_dependencies = {
+   "luafilesystem"
+}
+build = {
+   type = "builtin"
+}
+`,
+			expected: `package = "gateway"
+version = "0.3.0-1"
+build_dependencies = {
+   "luafilesystem"
+}
+dependencies = {
+   "acme-nginx == 1.2.0-1"
+}
+
+build = {
+   type = "builtin"
+}
+`,
+		},
+	}
+
+	handler := &LuaRocksHandler{}
+	dir := "../../testdata"
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			installTempDir, err := os.MkdirTemp(dir, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				if err := os.RemoveAll(installTempDir); err != nil {
+					fmt.Fprintf(os.Stderr, "warning: failed to remove temp folder: %v\n", err)
+				}
+			}() // Clean up
+
+			rockspecPath := filepath.Join(installTempDir, "gateway-0.3.0-1.rockspec")
+			if err := os.WriteFile(rockspecPath, []byte(testCase.input), 0644); err != nil {
+				t.Fatal(err)
+			}
+			pullDir := filepath.Join(installTempDir, ".upkg")
+			if err := os.MkdirAll(pullDir, 0755); err != nil {
+				t.Fatal(err)
+			}
+			packageLocation := filepath.Join(pullDir, "acme-nginx-1.2.0-1.rockspec")
+			if err := os.WriteFile(packageLocation, []byte(testRockspec), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(pullDir, "acme-nginx-1.2.0-1.all.rock"), nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := handler.UpdatePackageRef("acme-nginx", packageLocation, installTempDir); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			updated, err := os.ReadFile(rockspecPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(updated) != testCase.expected {
+				t.Errorf("expected:\n%s\ngot:\n%s", testCase.expected, updated)
+			}
+		})
+	}
+}
+
+func TestLuaRocksUpdatePackageRefManifest(t *testing.T) {
+	handler := &LuaRocksHandler{}
+	dir := "../../testdata"
+
+	installTempDir, err := os.MkdirTemp(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(installTempDir); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to remove temp folder: %v\n", err)
+		}
+	}() // Clean up
+
+	if err := os.WriteFile(filepath.Join(installTempDir, "gateway-0.3.0-1.rockspec"), []byte("package = \"gateway\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	serverDir := filepath.Join(installTempDir, InstallDir, "luarocks")
+	pullDir := filepath.Join(installTempDir, InstallDir, "acme-nginx")
+	for _, path := range []string{
+		filepath.Join(serverDir, "acme-nginx-1.1.0-1.rockspec"),
+		filepath.Join(serverDir, "acme-nginx-1.1.0-1.all.rock"),
+		filepath.Join(serverDir, "lua_cjson-2.1.0-1.linux-x86_64.rock"),
+		filepath.Join(pullDir, "acme-nginx-1.2.0-1.rockspec"),
+		filepath.Join(pullDir, "acme-nginx-1.2.0-1.src.rock"),
+		filepath.Join(pullDir, "acme-nginx-1.2.0-1.linux-x86_64.rock"),
+	} {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(testRockspec), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = handler.UpdatePackageRef("acme-nginx", filepath.Join(pullDir, "acme-nginx-1.2.0-1.rockspec"), installTempDir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if fileExists(filepath.Join(serverDir, "acme-nginx-1.1.0-1.all.rock")) {
+		t.Error("expected previous version to be removed")
+	}
+	for _, name := range []string{"acme-nginx-1.2.0-1.rockspec", "acme-nginx-1.2.0-1.src.rock", "acme-nginx-1.2.0-1.linux-x86_64.rock"} {
+		if !fileExists(filepath.Join(serverDir, name)) {
+			t.Errorf("expected %s to be stored", name)
+		}
+	}
+	expected := `commands = {}
+modules = {}
+repository = {
+   ["acme-nginx"] = {
+      ["1.2.0-1"] = {
+         {
+            arch = "linux-x86_64"
+         },
+         {
+            arch = "rockspec"
+         },
+         {
+            arch = "src"
+         }
+      }
+   },
+   lua_cjson = {
+      ["2.1.0-1"] = {
+         {
+            arch = "linux-x86_64"
+         }
+      }
+   }
+}
+`
+	manifest, err := os.ReadFile(filepath.Join(serverDir, "manifest"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(manifest) != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, manifest)
+	}
+}
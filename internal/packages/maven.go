@@ -0,0 +1,251 @@
+package packages
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type MavenHandler struct{}
+
+// mavenRepositoryID is the id of the project-local repository registered in pom.xml.
+const mavenRepositoryID = "universal-packages"
+
+// mavenClassifiers are the optional attached artifacts pushed alongside the main jar.
+var mavenClassifiers = []string{"sources", "javadoc"}
+
+// pomCoordinates holds the coordinates declared by a pom, which may be inherited from its parent.
+type pomCoordinates struct {
+	GroupID    string `xml:"groupId"`
+	ArtifactID string `xml:"artifactId"`
+	Version    string `xml:"version"`
+	Parent     struct {
+		GroupID string `xml:"groupId"`
+		Version string `xml:"version"`
+	} `xml:"parent"`
+}
+
+// LocatePackage finds the main jar for the package. See LocatePackageFiles.
+func (m *MavenHandler) LocatePackage(dir string, packageName string, packageVersion string) (string, error) {
+	files, err := m.LocatePackageFiles(dir, packageName, packageVersion)
+	if err != nil {
+		return "", err
+	}
+	return files[0], nil
+}
+
+// LocatePackageFiles finds <artifactId>-<version>.jar in the specified directory or in target/ where
+// `mvn package` writes its output, along with the pom and any -sources or -javadoc jars next to it.
+// The pom may be <artifactId>-<version>.pom or the project's own pom.xml.
+// The package name may be an artifactId or groupId:artifactId (groupId/artifactId is also accepted).
+func (m *MavenHandler) LocatePackageFiles(dir string, packageName string, packageVersion string) ([]string, error) {
+	_, artifactID := parseMavenCoordinates(packageName)
+	stem := artifactID + "-" + packageVersion
+
+	for _, searchDir := range []string{dir, filepath.Join(dir, "target")} {
+		jarPath := filepath.Join(searchDir, stem+".jar")
+		if !fileExists(jarPath) {
+			continue
+		}
+
+		pomPath := ""
+		for _, candidate := range []string{filepath.Join(searchDir, stem+".pom"), filepath.Join(searchDir, "pom.xml"), filepath.Join(dir, "pom.xml")} {
+			if fileExists(candidate) {
+				pomPath = candidate
+				break
+			}
+		}
+		if pomPath == "" {
+			return nil, fmt.Errorf("expected pom file not found: %s.pom or pom.xml", stem)
+		}
+
+		files := []string{jarPath, pomPath}
+		for _, classifier := range mavenClassifiers {
+			if classified := filepath.Join(searchDir, stem+"-"+classifier+".jar"); fileExists(classified) {
+				files = append(files, classified)
+			}
+		}
+		return files, nil
+	}
+	return nil, fmt.Errorf("expected package file not found: %s.jar", stem)
+}
+
+// UpdatePackageRef installs the pulled artifacts into a project-local repository next to the nearest pom.xml,
+// laid out as groupId/artifactId/version, registers that repository in <repositories> and adds or updates
+// the <dependency>. The coordinates are read from the pulled pom.
+func (m *MavenHandler) UpdatePackageRef(packageName string, packageFilePath string, packageRefFilePath string) error {
+	projectPomPath, err := findFileUp(packageRefFilePath, "pom.xml")
+	if err != nil {
+		return fmt.Errorf("finding pom.xml: %w", err)
+	}
+
+	pulledDir := filepath.Dir(packageFilePath)
+	pulledStem := strings.TrimSuffix(filepath.Base(packageFilePath), ".jar")
+	artifactPomPath := filepath.Join(pulledDir, pulledStem+".pom")
+	if !fileExists(artifactPomPath) {
+		artifactPomPath = filepath.Join(pulledDir, "pom.xml")
+	}
+	coordinates, err := readPomCoordinates(artifactPomPath)
+	if err != nil {
+		return err
+	}
+	if groupID, _ := parseMavenCoordinates(packageName); strings.ContainsAny(packageName, ":/") && groupID != coordinates.GroupID {
+		return fmt.Errorf("package %s does not match pom groupId %s", packageName, coordinates.GroupID)
+	}
+
+	// Lay the artifacts out as Maven expects: <repo>/<group path>/<artifactId>/<version>/<artifactId>-<version>[-classifier].<ext>
+	repoDir := filepath.Join(filepath.Dir(projectPomPath), InstallDir, "maven-repo")
+	versionDir := filepath.Join(repoDir, filepath.FromSlash(strings.ReplaceAll(coordinates.GroupID, ".", "/")), coordinates.ArtifactID, coordinates.Version)
+	stem := coordinates.ArtifactID + "-" + coordinates.Version
+	artifacts := map[string]string{
+		packageFilePath: stem + ".jar",
+		artifactPomPath: stem + ".pom",
+	}
+	for _, classifier := range mavenClassifiers {
+		if classified := filepath.Join(pulledDir, pulledStem+"-"+classifier+".jar"); fileExists(classified) {
+			artifacts[classified] = stem + "-" + classifier + ".jar"
+		}
+	}
+	for src, name := range artifacts {
+		if err := installMavenArtifact(src, filepath.Join(versionDir, name)); err != nil {
+			return fmt.Errorf("installing %s into local repository: %w", name, err)
+		}
+	}
+
+	repoRef, err := filepath.Rel(filepath.Dir(projectPomPath), repoDir)
+	if err != nil {
+		return fmt.Errorf("calculating relative path: %w", err)
+	}
+	data, err := os.ReadFile(projectPomPath)
+	if err != nil {
+		return err
+	}
+	updated, err := updatePomXML(string(data), coordinates, "file://${project.basedir}/"+filepath.ToSlash(repoRef))
+	if err != nil {
+		return fmt.Errorf("updating %s: %w", projectPomPath, err)
+	}
+	return os.WriteFile(projectPomPath, []byte(updated), 0644)
+}
+
+// parseMavenCoordinates splits "groupId:artifactId" (or "groupId/artifactId", as inferred from a nested
+// OCI repository path) into its parts. A bare artifactId yields an empty groupId.
+func parseMavenCoordinates(packageName string) (string, string) {
+	if i := strings.LastIndexAny(packageName, ":/"); i != -1 {
+		return strings.ReplaceAll(packageName[:i], "/", "."), packageName[i+1:]
+	}
+	return "", packageName
+}
+
+// readPomCoordinates reads the groupId, artifactId and version of a pom, inheriting from <parent> as Maven does.
+func readPomCoordinates(pomPath string) (pomCoordinates, error) {
+	data, err := os.ReadFile(pomPath)
+	if err != nil {
+		return pomCoordinates{}, err
+	}
+	var coordinates pomCoordinates
+	if err := xml.Unmarshal(data, &coordinates); err != nil {
+		return pomCoordinates{}, fmt.Errorf("parsing %s: %w", filepath.Base(pomPath), err)
+	}
+	if coordinates.GroupID == "" {
+		coordinates.GroupID = coordinates.Parent.GroupID
+	}
+	if coordinates.Version == "" {
+		coordinates.Version = coordinates.Parent.Version
+	}
+	if coordinates.GroupID == "" || coordinates.ArtifactID == "" || coordinates.Version == "" {
+		return pomCoordinates{}, fmt.Errorf("incomplete coordinates in %s", filepath.Base(pomPath))
+	}
+	return coordinates, nil
+}
+
+// installMavenArtifact copies src to dst and writes the .sha1 checksum file Maven verifies downloads against.
+func installMavenArtifact(src string, dst string) error {
+	if err := copyFileTo(src, dst); err != nil {
+		return err
+	}
+	data, err := os.ReadFile(dst)
+	if err != nil {
+		return err
+	}
+	sum := sha1.Sum(data)
+	return os.WriteFile(dst+".sha1", []byte(hex.EncodeToString(sum[:])), 0644)
+}
+
+// updatePomXML registers the local repository and sets the dependency in a project pom, editing it in place.
+func updatePomXML(content string, coordinates pomCoordinates, repoURL string) (string, error) {
+	unit := xmlIndentUnit(content)
+
+	repositories, ok := findPomProjectChild(content, "repositories")
+	if !ok {
+		project, ok := findXMLElement(content, "project", 0, len(content))
+		if !ok {
+			return "", fmt.Errorf("no <project> element found")
+		}
+		content = insertXMLChild(content, project, "<repositories></repositories>")
+		repositories, _ = findPomProjectChild(content, "repositories")
+	}
+
+	repository, found := findXMLChildMatching(content, repositories, "repository", func(e xmlElement) bool {
+		return xmlChildText(content, e, "id") == mavenRepositoryID
+	})
+	if found {
+		content = setXMLChildText(content, repository, "url", repoURL)
+	} else {
+		content = insertXMLChild(content, repositories, fmt.Sprintf("<repository>\n%s<id>%s</id>\n%s<url>%s</url>\n</repository>",
+			unit, mavenRepositoryID, unit, html.EscapeString(repoURL)))
+	}
+
+	dependencies, ok := findPomProjectChild(content, "dependencies")
+	if !ok {
+		project, _ := findXMLElement(content, "project", 0, len(content))
+		content = insertXMLChild(content, project, "<dependencies></dependencies>")
+		dependencies, _ = findPomProjectChild(content, "dependencies")
+	}
+
+	dependency, found := findXMLChildMatching(content, dependencies, "dependency", func(e xmlElement) bool {
+		return xmlChildText(content, e, "groupId") == coordinates.GroupID && xmlChildText(content, e, "artifactId") == coordinates.ArtifactID
+	})
+	if found {
+		return setXMLChildText(content, dependency, "version", coordinates.Version), nil
+	}
+	return insertXMLChild(content, dependencies, fmt.Sprintf("<dependency>\n%s<groupId>%s</groupId>\n%s<artifactId>%s</artifactId>\n%s<version>%s</version>\n</dependency>",
+		unit, html.EscapeString(coordinates.GroupID), unit, html.EscapeString(coordinates.ArtifactID), unit, html.EscapeString(coordinates.Version))), nil
+}
+
+// findPomProjectChild finds a direct child of <project>, skipping elements of the same name nested
+// in sections such as <dependencyManagement>, <build> or <profiles>.
+func findPomProjectChild(content string, name string) (xmlElement, bool) {
+	project, ok := findXMLElement(content, "project", 0, len(content))
+	if !ok {
+		return xmlElement{}, false
+	}
+	var nested []xmlElement
+	for _, section := range []string{"parent", "dependencyManagement", "build", "reporting", "profiles"} {
+		if e, ok := findXMLElement(content, section, project.InnerStart, project.InnerEnd); ok {
+			nested = append(nested, e)
+		}
+	}
+
+	for from := project.InnerStart; ; {
+		e, ok := findXMLElement(content, name, from, project.InnerEnd)
+		if !ok {
+			return xmlElement{}, false
+		}
+		inSection := false
+		for _, section := range nested {
+			if e.Start > section.Start && e.End <= section.End {
+				inSection = true
+				break
+			}
+		}
+		if !inSection {
+			return e, true
+		}
+		from = e.End
+	}
+}
@@ -0,0 +1,271 @@
+package packages
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testArtifactPom = `<?xml version="1.0" encoding="UTF-8"?>
+<project xmlns="http://maven.apache.org/POM/4.0.0">
+  <modelVersion>4.0.0</modelVersion>
+  <parent>
+    <groupId>com.example</groupId>
+    <artifactId>parent</artifactId>
+    <version>1.2.0</version>
+  </parent>
+  <artifactId>my-lib</artifactId>
+</project>
+`
+
+func TestMavenLocatePackageFiles(t *testing.T) {
+	handler := &MavenHandler{}
+	dir := "../../testdata"
+
+	tempDir, err := os.MkdirTemp(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tempDir); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to remove temp folder: %v\n", err)
+		}
+	}() // Clean up
+
+	// Mimic the output of `mvn package` with the source plugin enabled
+	targetDir := filepath.Join(tempDir, "target")
+	if err := os.MkdirAll(targetDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"my-lib-1.2.0.jar", "my-lib-1.2.0-sources.jar"} {
+		if err := os.WriteFile(filepath.Join(targetDir, name), []byte("fake jar content"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(tempDir, "pom.xml"), []byte(testArtifactPom), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name          string
+		packageName   string
+		expectedFiles []string
+		expectedError bool
+	}{
+		{
+			name:        "find jar by artifactId",
+			packageName: "my-lib",
+			expectedFiles: []string{
+				filepath.Join(targetDir, "my-lib-1.2.0.jar"),
+				filepath.Join(tempDir, "pom.xml"),
+				filepath.Join(targetDir, "my-lib-1.2.0-sources.jar"),
+			},
+		},
+		{
+			name:        "find jar by groupId:artifactId",
+			packageName: "com.example:my-lib",
+			expectedFiles: []string{
+				filepath.Join(targetDir, "my-lib-1.2.0.jar"),
+				filepath.Join(tempDir, "pom.xml"),
+				filepath.Join(targetDir, "my-lib-1.2.0-sources.jar"),
+			},
+		},
+		{
+			name:          "fail on non-existing artifact",
+			packageName:   "com.example:other-lib",
+			expectedError: true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			files, err := handler.LocatePackageFiles(tempDir, testCase.packageName, "1.2.0")
+			if testCase.expectedError {
+				if err == nil {
+					t.Fatal("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if fmt.Sprint(files) != fmt.Sprint(testCase.expectedFiles) {
+				t.Errorf("expected %v, got %v", testCase.expectedFiles, files)
+			}
+		})
+	}
+}
+
+func TestMavenUpdatePackageRef(t *testing.T) {
+	testCases := []struct {
+		name          string
+		packageName   string
+		inputPom      string
+		expectedPom   string
+		expectedError bool
+	}{
+		{
+			name:        "adds repository and dependency",
+			packageName: "com.example/my-lib",
+			inputPom: `<project>
+    <modelVersion>4.0.0</modelVersion>
+    <dependencyManagement>
+        <dependencies>
+            <dependency>
+                <groupId>com.example</groupId>
+                <artifactId>my-lib</artifactId>
+                <version>1.0.0</version>
+            </dependency>
+        </dependencies>
+    </dependencyManagement>
+    <dependencies>
+        <!-- test -->
+        <dependency>
+            <groupId>junit</groupId>
+            <artifactId>junit</artifactId>
+            <version>4.13.2</version>
+        </dependency>
+    </dependencies>
+</project>
+`,
+			expectedPom: `<project>
+    <modelVersion>4.0.0</modelVersion>
+    <dependencyManagement>
+        <dependencies>
+            <dependency>
+                <groupId>com.example</groupId>
+                <artifactId>my-lib</artifactId>
+                <version>1.0.0</version>
+            </dependency>
+        </dependencies>
+    </dependencyManagement>
+    <dependencies>
+        <!-- test -->
+        <dependency>
+            <groupId>junit</groupId>
+            <artifactId>junit</artifactId>
+            <version>4.13.2</version>
+        </dependency>
+        <dependency>
+            <groupId>com.example</groupId>
+            <artifactId>my-lib</artifactId>
+            <version>1.2.0</version>
+        </dependency>
+    </dependencies>
+    <repositories>
+        <repository>
+            <id>universal-packages</id>
+            <url>file://${project.basedir}/.universal-packages/maven-repo</url>
+        </repository>
+    </repositories>
+</project>
+`,
+		},
+		{
+			name:        "updates existing repository and dependency",
+			packageName: "com.example:my-lib",
+			inputPom: `<project>
+  <repositories>
+    <repository>
+      <id>universal-packages</id>
+      <url>file:///old</url>
+    </repository>
+  </repositories>
+  <dependencies>
+    <dependency>
+      <groupId>com.example</groupId>
+      <artifactId>my-lib</artifactId>
+      <version>1.0.0</version>
+      <scope>compile</scope>
+    </dependency>
+  </dependencies>
+</project>
+`,
+			expectedPom: `<project>
+  <repositories>
+    <repository>
+      <id>universal-packages</id>
+      <url>file://${project.basedir}/.universal-packages/maven-repo</url>
+    </repository>
+  </repositories>
+  <dependencies>
+    <dependency>
+      <groupId>com.example</groupId>
+      <artifactId>my-lib</artifactId>
+      <version>1.2.0</version>
+      <scope>compile</scope>
+    </dependency>
+  </dependencies>
+</project>
+`,
+		},
+		{
+			name:          "fails on nested group mismatch",
+			packageName:   "org.other/my-lib",
+			inputPom:      "<project>\n</project>\n",
+			expectedError: true,
+		},
+	}
+
+	handler := &MavenHandler{}
+	dir := "../../testdata"
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			installTempDir, err := os.MkdirTemp(dir, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				if err := os.RemoveAll(installTempDir); err != nil {
+					fmt.Fprintf(os.Stderr, "warning: failed to remove temp folder: %v\n", err)
+				}
+			}() // Clean up
+
+			pomPath := filepath.Join(installTempDir, "pom.xml")
+			if err := os.WriteFile(pomPath, []byte(testCase.inputPom), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			// Pulled artifact, with the pom pushed as the project's pom.xml
+			pullDir := filepath.Join(installTempDir, ".upkg")
+			if err := os.MkdirAll(pullDir, 0755); err != nil {
+				t.Fatal(err)
+			}
+			packageLocation := filepath.Join(pullDir, "my-lib-1.2.0.jar")
+			if err := os.WriteFile(packageLocation, []byte("fake jar content"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(pullDir, "pom.xml"), []byte(testArtifactPom), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			err = handler.UpdatePackageRef(testCase.packageName, packageLocation, installTempDir)
+			if testCase.expectedError {
+				if err == nil {
+					t.Fatal("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			updated, err := os.ReadFile(pomPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(updated) != testCase.expectedPom {
+				t.Errorf("expected:\n%s\ngot:\n%s", testCase.expectedPom, updated)
+			}
+
+			versionDir := filepath.Join(installTempDir, InstallDir, "maven-repo", "com", "example", "my-lib", "1.2.0")
+			for _, name := range []string{"my-lib-1.2.0.jar", "my-lib-1.2.0.jar.sha1", "my-lib-1.2.0.pom", "my-lib-1.2.0.pom.sha1"} {
+				if _, err := os.Stat(filepath.Join(versionDir, name)); err != nil {
+					t.Errorf("expected %s in local repository: %v", name, err)
+				}
+			}
+		})
+	}
+}
@@ -4,13 +4,18 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 
+	"github.com/tidwall/gjson"
 	"github.com/tidwall/sjson"
 )
 
 type NpmHandler struct{}
 
+// npmDependencySections are the sections of package.json that a dependency can be added to.
+var npmDependencySections = []string{"dependencies", "devDependencies", "optionalDependencies", "peerDependencies", "overrides"}
+
 // LocatePackage finds the package tarball in the specified directory based on the package name and version.
 func (n *NpmHandler) LocatePackage(dir string, packageName string, packageVersion string) (string, error) {
 	// Normalize package name: "@scope/pkg" → "scope-pkg"
@@ -32,49 +37,144 @@ func (n *NpmHandler) LocatePackage(dir string, packageName string, packageVersio
 // UpdatePackageRef updates the package reference in the project's package.json to point to the local file path.
 // It adds or updates the dependency entry for the specified package.
 // It should maintain existing format of package.json, including any existing dependencies.
+// If the project has a package-lock.json, the package's entry is updated to match, so that `npm ci` keeps working.
+// In Yarn 2+ projects the package is extracted and referenced with the portal: protocol instead, and yarn.lock
+// is updated to match, so that `yarn install --immutable` keeps working.
 func (n *NpmHandler) UpdatePackageRef(packageName string, packageFilePath string, packageRefFilePath string) error {
-	// Read entire file as bytes
-	pkgJSONPath, err := FindPackageJSON(packageRefFilePath)
+	return n.UpdatePackageRefWithOptions(packageName, packageFilePath, packageRefFilePath, InstallOptions{})
+}
+
+// UpdatePackageRefWithOptions is UpdatePackageRef with install options. If a workspace is given, the dependency
+// is added to the package.json of that member of the npm, Yarn or pnpm workspace containing packageRefFilePath,
+// rather than the nearest package.json, and the package is stored once at the workspace root.
+// If a section is given, the dependency is added to it instead of dependencies, and removed from any other
+// section it was declared in.
+func (n *NpmHandler) UpdatePackageRefWithOptions(packageName string, packageFilePath string, packageRefFilePath string, options InstallOptions) error {
+	if options.Override != "" {
+		return fmt.Errorf("override %q is not supported for npm packages", options.Override)
+	}
+	section := options.Section
+	if section == "" {
+		section = "dependencies"
+	}
+	if !slices.Contains(npmDependencySections, section) {
+		return fmt.Errorf("unsupported section %q, expected one of: %s", section, strings.Join(npmDependencySections, ", "))
+	}
+	if section == "overrides" && options.Workspace != "" {
+		return fmt.Errorf("overrides are only applied from the workspace root, not from workspace %q", options.Workspace)
+	}
+
+	pkgJSONPath, storeDir, err := npmInstallTarget(packageRefFilePath, options.Workspace)
 	if err != nil {
-		return fmt.Errorf("finding package.json: %w", err)
+		return err
 	}
+	// Read entire file as bytes
 	data, err := os.ReadFile(pkgJSONPath)
 	if err != nil {
 		return err
 	}
 
-	// Build the path string for sjson
-	depPath := "dependencies." + packageName
-
 	// Add or overwrite dependency
-	relPath, err := filepath.Rel(filepath.Dir(pkgJSONPath), packageFilePath)
-	if err != nil {
-		return fmt.Errorf("calculating relative path: %w", err)
+	lockPath, isYarnBerry := yarnBerryLockfile(filepath.Dir(pkgJSONPath))
+	var depRange string
+	if isYarnBerry {
+		// Yarn records dependencies and devDependencies of a workspace alike in yarn.lock
+		if section != "dependencies" && section != "devDependencies" {
+			return fmt.Errorf("section %q is not supported in Yarn 2+ projects", section)
+		}
+		depRange, err = extractYarnPortal(storeDir, pkgJSONPath, packageName, packageFilePath)
+		if err != nil {
+			return err
+		}
+	} else {
+		if options.Workspace != "" {
+			packageFilePath, err = storeNpmTarball(storeDir, packageFilePath)
+			if err != nil {
+				return err
+			}
+		}
+		relPath, err := filepath.Rel(filepath.Dir(pkgJSONPath), packageFilePath)
+		if err != nil {
+			return fmt.Errorf("calculating relative path: %w", err)
+		}
+		depRange = "file:" + filepath.ToSlash(relPath)
+	}
+
+	// Move the dependency to the section from any other, removing sections left empty
+	for _, other := range npmDependencySections {
+		if other == section || !gjson.GetBytes(data, other+"."+escapeJSONPath(packageName)).Exists() {
+			continue
+		}
+		if data, err = sjson.DeleteBytes(data, other+"."+escapeJSONPath(packageName)); err != nil {
+			return err
+		}
+		if len(gjson.GetBytes(data, other).Map()) == 0 {
+			if data, err = sjson.DeleteBytes(data, other); err != nil {
+				return err
+			}
+		}
 	}
 
 	// Update dependency in the JSON bytes
-	updatedData, err := sjson.SetBytes(data, depPath, "file:"+filepath.ToSlash(relPath))
+	updatedData, err := sjson.SetBytes(data, section+"."+escapeJSONPath(packageName), depRange)
 	if err != nil {
 		return err
 	}
 
 	// Write back to file
-	return os.WriteFile(pkgJSONPath, updatedData, 0644)
+	if err := os.WriteFile(pkgJSONPath, updatedData, 0644); err != nil {
+		return err
+	}
+	if isYarnBerry {
+		return updateYarnLockfile(lockPath, pkgJSONPath, packageName, depRange)
+	}
+	return updatePackageLock(pkgJSONPath, packageName, section, depRange, packageFilePath)
 }
 
-// FindPackageJSON searches for the nearest package.json file starting from the given directory and moving up the directory tree.
-func FindPackageJSON(workingDir string) (string, error) {
-	for {
-		p := filepath.Join(workingDir, "package.json")
-		if _, err := os.Stat(p); err == nil {
-			return p, nil
+// npmInstallTarget returns the package.json to add the dependency to, and the directory to store the package
+// under: the nearest package.json and its directory, or the workspace's package.json and the workspace root.
+func npmInstallTarget(workingDir string, workspace string) (string, string, error) {
+	if workspace == "" {
+		pkgJSONPath, err := FindPackageJSON(workingDir)
+		if err != nil {
+			return "", "", fmt.Errorf("finding package.json: %w", err)
 		}
+		return pkgJSONPath, filepath.Dir(pkgJSONPath), nil
+	}
 
-		parent := filepath.Dir(workingDir)
-		if parent == workingDir {
-			break // root reached
-		}
-		workingDir = parent
+	root, patterns, err := findNpmWorkspaceRoot(workingDir)
+	if err != nil {
+		return "", "", err
+	}
+	pkgJSONPath, err := findNpmWorkspacePackage(root, patterns, workspace)
+	if err != nil {
+		return "", "", err
+	}
+	return pkgJSONPath, root, nil
+}
+
+// storeNpmTarball copies the package tarball under the install directory of storeDir, unless it is already
+// there, and returns its path.
+func storeNpmTarball(storeDir string, packageFilePath string) (string, error) {
+	installDir, err := filepath.Abs(filepath.Join(storeDir, InstallDir))
+	if err != nil {
+		return "", err
+	}
+	absPath, err := filepath.Abs(packageFilePath)
+	if err != nil {
+		return "", err
+	}
+	if rel, err := filepath.Rel(installDir, absPath); err == nil && !strings.HasPrefix(rel, "..") {
+		return packageFilePath, nil
 	}
-	return "", fmt.Errorf("package.json not found")
+	stored, err := copyFile(packageFilePath, filepath.Join(storeDir, InstallDir, "npm-packages"))
+	if err != nil {
+		return "", fmt.Errorf("storing package: %w", err)
+	}
+	return stored, nil
+}
+
+// FindPackageJSON searches for the nearest package.json file starting from the given directory and moving up the directory tree.
+func FindPackageJSON(workingDir string) (string, error) {
+	return findFileUp(workingDir, "package.json")
 }
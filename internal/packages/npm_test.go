@@ -245,3 +245,106 @@ func TestFindPackageJSON(t *testing.T) {
 		})
 	}
 }
+
+func TestUpdatePackageRefSection(t *testing.T) {
+	testCases := []struct {
+		name          string
+		inputJSON     string
+		section       string
+		expectedJSON  string
+		expectedError bool
+	}{
+		{
+			name:      "adds to devDependencies",
+			inputJSON: `{"dependencies": {"express": "4.17.1"}}`,
+			section:   "devDependencies",
+			expectedJSON: `{
+  "dependencies": {"express": "4.17.1"},
+  "devDependencies": {"lodash": "file:.upkg/lodash-4.18.0.tgz"}
+}`,
+		},
+		{
+			name:      "moves from dependencies to devDependencies",
+			inputJSON: `{"dependencies": {"express": "4.17.1", "lodash": "4.17.0"}, "devDependencies": {"jest": "29.0.0"}}`,
+			section:   "devDependencies",
+			expectedJSON: `{
+  "dependencies": {"express": "4.17.1"},
+  "devDependencies": {"jest": "29.0.0", "lodash": "file:.upkg/lodash-4.18.0.tgz"}
+}`,
+		},
+		{
+			name:      "moves from peerDependencies to dependencies, removing the emptied section",
+			inputJSON: `{"peerDependencies": {"lodash": "^4.0.0"}}`,
+			expectedJSON: `{
+  "dependencies": {"lodash": "file:.upkg/lodash-4.18.0.tgz"}
+}`,
+		},
+		{
+			name:      "moves to overrides, removing the emptied section",
+			inputJSON: `{"optionalDependencies": {"lodash": "4.17.0"}}`,
+			section:   "overrides",
+			expectedJSON: `{
+  "overrides": {"lodash": "file:.upkg/lodash-4.18.0.tgz"}
+}`,
+		},
+		{
+			name:          "rejects unknown section",
+			inputJSON:     `{}`,
+			section:       "bundleDependencies",
+			expectedError: true,
+		},
+	}
+
+	handler := &NpmHandler{}
+	dir := "../../testdata"
+
+	installTempDir, err := os.MkdirTemp(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(installTempDir); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to remove temp folder: %v\n", err)
+		}
+	}() // Clean up
+	installPackageJSONPath := filepath.Join(installTempDir, "package.json")
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			err = os.WriteFile(installPackageJSONPath, []byte(testCase.inputJSON), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			packageLocation := filepath.Join(installTempDir, ".upkg", "lodash-4.18.0.tgz")
+			err = handler.UpdatePackageRefWithOptions("lodash", packageLocation, installTempDir, InstallOptions{Section: testCase.section})
+			if testCase.expectedError {
+				if err == nil {
+					t.Fatal("expected an error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			updated, err := os.ReadFile(installPackageJSONPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Normalize JSON for comparison (in case of field order)
+			var got, expected map[string]interface{}
+			if err := json.Unmarshal(updated, &got); err != nil {
+				t.Fatal(err)
+			}
+			if err := json.Unmarshal([]byte(testCase.expectedJSON), &expected); err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(got, expected) {
+				t.Errorf("expected %v, got %v", expected, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,188 @@
+package packages
+
+import (
+	"fmt"
+	"html"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type NuGetHandler struct{}
+
+// nugetSourceKey is the key of the folder feed registered in nuget.config.
+const nugetSourceKey = "universal-packages"
+
+const defaultNuGetConfig = `<?xml version="1.0" encoding="utf-8"?>
+<configuration>
+  <packageSources>
+  </packageSources>
+</configuration>
+`
+
+// LocatePackage finds <Id>.<Version>.nupkg in the specified directory, or in bin/Release where
+// `dotnet pack` writes its output. Package IDs are matched case-insensitively, as NuGet does.
+func (n *NuGetHandler) LocatePackage(dir string, packageName string, packageVersion string) (string, error) {
+	filename := fmt.Sprintf("%s.%s.nupkg", packageName, packageVersion)
+
+	for _, searchDir := range []string{dir, filepath.Join(dir, "bin", "Release")} {
+		entries, err := os.ReadDir(searchDir)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return "", fmt.Errorf("error reading package directory: %w", err)
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() && strings.EqualFold(entry.Name(), filename) {
+				return filepath.Join(searchDir, entry.Name()), nil
+			}
+		}
+	}
+	return "", fmt.Errorf("expected package file not found: %s", filename)
+}
+
+// UpdatePackageRef copies the package into a folder feed next to the nearest nuget.config (creating one beside
+// the project if needed), registers the feed in <packageSources> and adds or updates the <PackageReference>
+// in the nearest .csproj. Both files are edited in place so their existing formatting is preserved.
+func (n *NuGetHandler) UpdatePackageRef(packageName string, packageFilePath string, packageRefFilePath string) error {
+	projectPath, err := findFileUp(packageRefFilePath, "*.csproj")
+	if err != nil {
+		return fmt.Errorf("finding .csproj: %w", err)
+	}
+	version, err := nugetVersionFromFilename(packageName, packageFilePath)
+	if err != nil {
+		return err
+	}
+
+	configPath, err := findFileUp(filepath.Dir(projectPath), "nuget.config", "NuGet.Config", "NuGet.config")
+	config := []byte(defaultNuGetConfig)
+	if err != nil {
+		configPath = filepath.Join(filepath.Dir(projectPath), "nuget.config")
+	} else if config, err = os.ReadFile(configPath); err != nil {
+		return err
+	}
+
+	// Place the package into the project-local folder feed
+	feedDir := filepath.Join(filepath.Dir(configPath), InstallDir, "nuget-feed")
+	if _, err := copyFile(packageFilePath, feedDir); err != nil {
+		return fmt.Errorf("adding package to local feed: %w", err)
+	}
+	feedRef, err := filepath.Rel(filepath.Dir(configPath), feedDir)
+	if err != nil {
+		return fmt.Errorf("calculating relative path: %w", err)
+	}
+
+	updatedConfig, err := updateNuGetConfig(string(config), filepath.ToSlash(feedRef), packageName)
+	if err != nil {
+		return fmt.Errorf("updating %s: %w", configPath, err)
+	}
+	if err := os.WriteFile(configPath, []byte(updatedConfig), 0644); err != nil {
+		return err
+	}
+
+	project, err := os.ReadFile(projectPath)
+	if err != nil {
+		return err
+	}
+	updatedProject, err := updateCsprojPackageReference(string(project), packageName, version)
+	if err != nil {
+		return fmt.Errorf("updating %s: %w", projectPath, err)
+	}
+	return os.WriteFile(projectPath, []byte(updatedProject), 0644)
+}
+
+// nugetVersionFromFilename extracts the version from a <Id>.<Version>.nupkg filename.
+func nugetVersionFromFilename(packageName string, packageFilePath string) (string, error) {
+	filename := filepath.Base(packageFilePath)
+	stem, ok := strings.CutSuffix(filename, ".nupkg")
+	prefix := packageName + "."
+	if !ok || len(stem) <= len(prefix) || !strings.EqualFold(stem[:len(prefix)], prefix) {
+		return "", fmt.Errorf("unexpected package file name %s for package %s", filename, packageName)
+	}
+	return stem[len(prefix):], nil
+}
+
+// updateNuGetConfig registers the folder feed under <packageSources>. When package source mapping is
+// enabled the package ID is also mapped to the feed, otherwise restore would refuse to use it.
+func updateNuGetConfig(content string, feedRef string, packageName string) (string, error) {
+	configuration, ok := findXMLElement(content, "configuration", 0, len(content))
+	if !ok {
+		return "", fmt.Errorf("no <configuration> element found")
+	}
+
+	sources, ok := findXMLElement(content, "packageSources", configuration.InnerStart, configuration.InnerEnd)
+	if !ok {
+		content = insertXMLChild(content, configuration, "<packageSources></packageSources>")
+		configuration, _ = findXMLElement(content, "configuration", 0, len(content))
+		sources, _ = findXMLElement(content, "packageSources", configuration.InnerStart, configuration.InnerEnd)
+	}
+
+	source, ok := findXMLElementMatching(content, "add", sources.InnerStart, sources.InnerEnd, hasXMLAttribute("key", nugetSourceKey))
+	if ok {
+		content = setXMLAttribute(content, source.Start, "value", feedRef)
+	} else {
+		content = insertXMLChild(content, sources, fmt.Sprintf(`<add key="%s" value="%s" />`, nugetSourceKey, html.EscapeString(feedRef)))
+	}
+
+	configuration, _ = findXMLElement(content, "configuration", 0, len(content))
+	mapping, ok := findXMLElement(content, "packageSourceMapping", configuration.InnerStart, configuration.InnerEnd)
+	if !ok {
+		return content, nil
+	}
+
+	mappedSource, ok := findXMLElementMatching(content, "packageSource", mapping.InnerStart, mapping.InnerEnd, hasXMLAttribute("key", nugetSourceKey))
+	if !ok {
+		content = insertXMLChild(content, mapping, fmt.Sprintf(`<packageSource key="%s"></packageSource>`, nugetSourceKey))
+		mapping, _ = findXMLElement(content, "packageSourceMapping", 0, len(content))
+		mappedSource, _ = findXMLElementMatching(content, "packageSource", mapping.InnerStart, mapping.InnerEnd, hasXMLAttribute("key", nugetSourceKey))
+	}
+	if _, ok := findXMLElementMatching(content, "package", mappedSource.InnerStart, mappedSource.InnerEnd, hasXMLAttribute("pattern", packageName)); ok {
+		return content, nil
+	}
+	return insertXMLChild(content, mappedSource, fmt.Sprintf(`<package pattern="%s" />`, html.EscapeString(packageName))), nil
+}
+
+// updateCsprojPackageReference sets the version of the package's <PackageReference>, whether it is given as
+// an attribute or a child element. New references are added to the first <ItemGroup> that already holds
+// package references, or to a new <ItemGroup> at the end of the project.
+func updateCsprojPackageReference(content string, packageName string, version string) (string, error) {
+	project, ok := findXMLElement(content, "Project", 0, len(content))
+	if !ok {
+		return "", fmt.Errorf("no <Project> element found")
+	}
+
+	reference, ok := findXMLElementMatching(content, "PackageReference", project.InnerStart, project.InnerEnd, hasXMLAttribute("Include", packageName))
+	if ok {
+		if _, hasAttr := xmlAttribute(content[reference.Start:reference.InnerStart], "Version"); !hasAttr && !reference.SelfClosing() {
+			if child, ok := findXMLElement(content, "Version", reference.InnerStart, reference.InnerEnd); ok {
+				return content[:child.InnerStart] + html.EscapeString(version) + content[child.InnerEnd:], nil
+			}
+		}
+		return setXMLAttribute(content, reference.Start, "Version", version), nil
+	}
+
+	newReference := fmt.Sprintf(`<PackageReference Include="%s" Version="%s" />`, html.EscapeString(packageName), html.EscapeString(version))
+	for from := project.InnerStart; ; {
+		group, ok := findXMLElement(content, "ItemGroup", from, project.InnerEnd)
+		if !ok {
+			break
+		}
+		if strings.Contains(content[group.InnerStart:group.InnerEnd], "<PackageReference") {
+			return insertXMLChild(content, group, newReference), nil
+		}
+		from = group.End
+	}
+
+	content = insertXMLChild(content, project, "<ItemGroup></ItemGroup>")
+	project, _ = findXMLElement(content, "Project", 0, len(content))
+	var group xmlElement
+	for from := project.InnerStart; ; {
+		next, ok := findXMLElement(content, "ItemGroup", from, project.InnerEnd)
+		if !ok {
+			break
+		}
+		group, from = next, next.End
+	}
+	return insertXMLChild(content, group, newReference), nil
+}
@@ -0,0 +1,296 @@
+package packages
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNuGetLocatePackage(t *testing.T) {
+	handler := &NuGetHandler{}
+	dir := "../../testdata"
+
+	tempDir, err := os.MkdirTemp(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tempDir); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to remove temp folder: %v\n", err)
+		}
+	}() // Clean up
+
+	// Mimic the output of `dotnet pack -c Release`
+	releaseDir := filepath.Join(tempDir, "bin", "Release")
+	if err := os.MkdirAll(releaseDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	packagePath := filepath.Join(releaseDir, "Contoso.Sdk.1.2.0.nupkg")
+	if err := os.WriteFile(packagePath, []byte("fake nupkg content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name           string
+		packageName    string
+		packageVersion string
+		expectedPath   string
+		expectedError  bool
+	}{
+		{
+			name:           "find package in bin/Release",
+			packageName:    "Contoso.Sdk",
+			packageVersion: "1.2.0",
+			expectedPath:   packagePath,
+		},
+		{
+			name:           "match package id case-insensitively",
+			packageName:    "contoso.sdk",
+			packageVersion: "1.2.0",
+			expectedPath:   packagePath,
+		},
+		{
+			name:           "fail on non-existing package",
+			packageName:    "Contoso.Other",
+			packageVersion: "1.2.0",
+			expectedError:  true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			filePath, err := handler.LocatePackage(tempDir, testCase.packageName, testCase.packageVersion)
+			if testCase.expectedError {
+				if err == nil {
+					t.Fatal("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if filePath != testCase.expectedPath {
+				t.Errorf("expected %s, got %s", testCase.expectedPath, filePath)
+			}
+		})
+	}
+}
+
+func TestNuGetUpdatePackageRef(t *testing.T) {
+	testCases := []struct {
+		name            string
+		inputCsproj     string
+		inputConfig     string
+		expectedCsproj  string
+		expectedConfig  string
+		packageFileName string
+	}{
+		{
+			name: "adds reference and creates nuget.config",
+			inputCsproj: `<Project Sdk="Microsoft.NET.Sdk">
+
+  <PropertyGroup>
+    <TargetFramework>net8.0</TargetFramework>
+  </PropertyGroup>
+
+  <ItemGroup>
+    <!-- logging -->
+    <PackageReference Include="Serilog" Version="3.1.1" />
+  </ItemGroup>
+
+</Project>
+`,
+			expectedCsproj: `<Project Sdk="Microsoft.NET.Sdk">
+
+  <PropertyGroup>
+    <TargetFramework>net8.0</TargetFramework>
+  </PropertyGroup>
+
+  <ItemGroup>
+    <!-- logging -->
+    <PackageReference Include="Serilog" Version="3.1.1" />
+    <PackageReference Include="Contoso.Sdk" Version="1.2.0" />
+  </ItemGroup>
+
+</Project>
+`,
+			expectedConfig: `<?xml version="1.0" encoding="utf-8"?>
+<configuration>
+  <packageSources>
+    <add key="universal-packages" value=".universal-packages/nuget-feed" />
+  </packageSources>
+</configuration>
+`,
+		},
+		{
+			name: "updates version attribute and existing source",
+			inputCsproj: `<Project Sdk="Microsoft.NET.Sdk">
+	<ItemGroup>
+		<PackageReference Include="contoso.sdk" Version="1.0.0" PrivateAssets="all" />
+	</ItemGroup>
+</Project>
+`,
+			inputConfig: `<?xml version="1.0" encoding="utf-8"?>
+<configuration>
+	<packageSources>
+		<clear />
+		<add key="nuget.org" value="https://api.nuget.org/v3/index.json" />
+		<add key="universal-packages" value="old-feed" />
+	</packageSources>
+</configuration>
+`,
+			expectedCsproj: `<Project Sdk="Microsoft.NET.Sdk">
+	<ItemGroup>
+		<PackageReference Include="contoso.sdk" Version="1.2.0" PrivateAssets="all" />
+	</ItemGroup>
+</Project>
+`,
+			expectedConfig: `<?xml version="1.0" encoding="utf-8"?>
+<configuration>
+	<packageSources>
+		<clear />
+		<add key="nuget.org" value="https://api.nuget.org/v3/index.json" />
+		<add key="universal-packages" value=".universal-packages/nuget-feed" />
+	</packageSources>
+</configuration>
+`,
+		},
+		{
+			name: "updates version element and maps package source",
+			inputCsproj: `<Project Sdk="Microsoft.NET.Sdk">
+  <ItemGroup>
+    <PackageReference Include="Contoso.Sdk">
+      <Version>1.0.0</Version>
+    </PackageReference>
+  </ItemGroup>
+</Project>
+`,
+			inputConfig: `<configuration>
+  <packageSources>
+    <add key="nuget.org" value="https://api.nuget.org/v3/index.json" />
+  </packageSources>
+  <packageSourceMapping>
+    <packageSource key="nuget.org">
+      <package pattern="*" />
+    </packageSource>
+  </packageSourceMapping>
+</configuration>
+`,
+			expectedCsproj: `<Project Sdk="Microsoft.NET.Sdk">
+  <ItemGroup>
+    <PackageReference Include="Contoso.Sdk">
+      <Version>1.2.0</Version>
+    </PackageReference>
+  </ItemGroup>
+</Project>
+`,
+			expectedConfig: `<configuration>
+  <packageSources>
+    <add key="nuget.org" value="https://api.nuget.org/v3/index.json" />
+    <add key="universal-packages" value=".universal-packages/nuget-feed" />
+  </packageSources>
+  <packageSourceMapping>
+    <packageSource key="nuget.org">
+      <package pattern="*" />
+    </packageSource>
+    <packageSource key="universal-packages">
+      <package pattern="Contoso.Sdk" />
+    </packageSource>
+  </packageSourceMapping>
+</configuration>
+`,
+		},
+		{
+			name: "creates item group",
+			inputCsproj: `<Project Sdk="Microsoft.NET.Sdk">
+  <PropertyGroup>
+    <TargetFramework>net8.0</TargetFramework>
+  </PropertyGroup>
+</Project>
+`,
+			inputConfig: `<configuration>
+  <packageSources />
+</configuration>
+`,
+			expectedCsproj: `<Project Sdk="Microsoft.NET.Sdk">
+  <PropertyGroup>
+    <TargetFramework>net8.0</TargetFramework>
+  </PropertyGroup>
+  <ItemGroup>
+    <PackageReference Include="Contoso.Sdk" Version="1.2.0" />
+  </ItemGroup>
+</Project>
+`,
+			expectedConfig: `<configuration>
+  <packageSources>
+    <add key="universal-packages" value=".universal-packages/nuget-feed" />
+  </packageSources>
+</configuration>
+`,
+		},
+	}
+
+	handler := &NuGetHandler{}
+	dir := "../../testdata"
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			installTempDir, err := os.MkdirTemp(dir, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				if err := os.RemoveAll(installTempDir); err != nil {
+					fmt.Fprintf(os.Stderr, "warning: failed to remove temp folder: %v\n", err)
+				}
+			}() // Clean up
+
+			csprojPath := filepath.Join(installTempDir, "Service.csproj")
+			if err := os.WriteFile(csprojPath, []byte(testCase.inputCsproj), 0644); err != nil {
+				t.Fatal(err)
+			}
+			configPath := filepath.Join(installTempDir, "nuget.config")
+			if testCase.inputConfig != "" {
+				if err := os.WriteFile(configPath, []byte(testCase.inputConfig), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// Pulled package
+			pullDir := filepath.Join(installTempDir, ".upkg")
+			if err := os.MkdirAll(pullDir, 0755); err != nil {
+				t.Fatal(err)
+			}
+			packageLocation := filepath.Join(pullDir, "Contoso.Sdk.1.2.0.nupkg")
+			if err := os.WriteFile(packageLocation, []byte("fake nupkg content"), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := handler.UpdatePackageRef("Contoso.Sdk", packageLocation, installTempDir); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			updatedCsproj, err := os.ReadFile(csprojPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(updatedCsproj) != testCase.expectedCsproj {
+				t.Errorf("expected csproj:\n%s\ngot:\n%s", testCase.expectedCsproj, updatedCsproj)
+			}
+
+			updatedConfig, err := os.ReadFile(configPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(updatedConfig) != testCase.expectedConfig {
+				t.Errorf("expected nuget.config:\n%s\ngot:\n%s", testCase.expectedConfig, updatedConfig)
+			}
+
+			feedPackage := filepath.Join(installTempDir, InstallDir, "nuget-feed", "Contoso.Sdk.1.2.0.nupkg")
+			if _, err := os.Stat(feedPackage); err != nil {
+				t.Errorf("expected package in local feed: %v", err)
+			}
+		})
+	}
+}
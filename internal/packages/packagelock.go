@@ -0,0 +1,323 @@
+package packages
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/pretty"
+	"github.com/tidwall/sjson"
+)
+
+// jsonPathSpecialPattern matches the characters that have a special meaning in sjson/gjson paths.
+var jsonPathSpecialPattern = regexp.MustCompile(`[.*?|#@\\]`)
+
+// npmLockPackage is an entry of the packages section of package-lock.json, with its fields in the order npm
+// writes them.
+type npmLockPackage struct {
+	Version              string                           `json:"version"`
+	Resolved             string                           `json:"resolved"`
+	Integrity            string                           `json:"integrity"`
+	Dev                  bool                             `json:"dev,omitempty"`
+	License              string                           `json:"license,omitempty"`
+	Optional             bool                             `json:"optional,omitempty"`
+	Peer                 bool                             `json:"peer,omitempty"`
+	Dependencies         map[string]string                `json:"dependencies,omitempty"`
+	Bin                  map[string]string                `json:"bin,omitempty"`
+	Engines              json.RawMessage                  `json:"engines,omitempty"`
+	OptionalDependencies map[string]string                `json:"optionalDependencies,omitempty"`
+	PeerDependencies     map[string]string                `json:"peerDependencies,omitempty"`
+	PeerDependenciesMeta map[string]npmPeerDependencyMeta `json:"peerDependenciesMeta,omitempty"`
+}
+
+// npmLegacyLockDependency is an entry of the dependencies section that lockfileVersion 2 keeps for npm 6.
+type npmLegacyLockDependency struct {
+	Version   string            `json:"version"`
+	Integrity string            `json:"integrity"`
+	Dev       bool              `json:"dev,omitempty"`
+	Optional  bool              `json:"optional,omitempty"`
+	Peer      bool              `json:"peer,omitempty"`
+	Requires  map[string]string `json:"requires,omitempty"`
+}
+
+// jsonMember is a key of a JSON object with its raw value.
+type jsonMember struct {
+	Key string
+	Raw string
+}
+
+// updatePackageLock records the file: dependency of the package at pkgJSONPath on the tarball at tarballPath in
+// the package-lock.json of the project, so that `npm ci` keeps working. The package's entry is resolved to the
+// tarball, with its integrity and its own dependencies read from the tarball's package.json. Overrides update
+// every entry of the package already in the lockfile instead.
+// Projects without a lockfile, and lockfiles older than lockfileVersion 2, are left alone.
+func updatePackageLock(pkgJSONPath string, packageName string, section string, depRange string, tarballPath string) error {
+	lockPath, err := findFileUp(filepath.Dir(pkgJSONPath), "package-lock.json")
+	if err != nil {
+		return nil
+	}
+	data, err := os.ReadFile(lockPath)
+	if err != nil {
+		return err
+	}
+	if !gjson.ValidBytes(data) {
+		return fmt.Errorf("parsing %s: invalid JSON", lockPath)
+	}
+	lockfileVersion := gjson.GetBytes(data, "lockfileVersion").Int()
+	if lockfileVersion < 2 {
+		return nil
+	}
+
+	// The lockfile of a workspace root holds an entry for every workspace package, keyed by its directory
+	lockDir := filepath.Dir(lockPath)
+	member, err := filepath.Rel(lockDir, filepath.Dir(pkgJSONPath))
+	if err != nil {
+		return fmt.Errorf("calculating relative path: %w", err)
+	}
+	member = filepath.ToSlash(member)
+	if member == "." {
+		member = ""
+	}
+	packages := jsonObjectMembers(gjson.GetBytes(data, "packages"))
+	memberRaw, ok := findJSONMember(packages, member)
+	if !ok {
+		// package-lock.json belongs to another project
+		return nil
+	}
+
+	manifestData, err := readTarGzFile(tarballPath, "package/package.json")
+	if err != nil {
+		return err
+	}
+	var manifest npmManifest
+	if err := json.Unmarshal(manifestData, &manifest); err != nil {
+		return fmt.Errorf("parsing package.json of %s: %w", filepath.Base(tarballPath), err)
+	}
+	integrity, err := sha512Integrity(tarballPath)
+	if err != nil {
+		return err
+	}
+	resolved, err := filepath.Rel(lockDir, tarballPath)
+	if err != nil {
+		return fmt.Errorf("calculating relative path: %w", err)
+	}
+	entry := npmLockPackage{
+		Version:              manifest.Version,
+		Resolved:             "file:" + filepath.ToSlash(resolved),
+		Integrity:            integrity,
+		Dev:                  section == "devDependencies",
+		License:              manifest.License,
+		Optional:             section == "optionalDependencies",
+		Peer:                 section == "peerDependencies",
+		Dependencies:         manifest.Dependencies,
+		Bin:                  npmBinaries(manifest),
+		Engines:              manifest.Engines,
+		OptionalDependencies: manifest.OptionalDependencies,
+		PeerDependencies:     manifest.PeerDependencies,
+		PeerDependenciesMeta: manifest.PeerDependenciesMeta,
+	}
+
+	var locations []string
+	if section == "overrides" {
+		// Overrides apply wherever the package is installed
+		for _, p := range packages {
+			if p.Key == "node_modules/"+packageName || strings.HasSuffix(p.Key, "/node_modules/"+packageName) {
+				locations = append(locations, p.Key)
+			}
+		}
+	} else {
+		// Workspace packages share the root node_modules, unless another version of the package is already there
+		location := "node_modules/" + packageName
+		if member != "" {
+			nested := member + "/node_modules/" + packageName
+			hoisted, hoistedOk := findJSONMember(packages, location)
+			if _, ok := findJSONMember(packages, nested); ok || (hoistedOk && gjson.Get(hoisted, "resolved").String() != entry.Resolved) {
+				location = nested
+			}
+		}
+		locations = []string{location}
+	}
+
+	if memberRaw, err = setNpmLockDeclaration(memberRaw, packageName, section, depRange); err != nil {
+		return err
+	}
+	packages = setJSONMember(packages, member, memberRaw)
+	// lockfileVersion 2 also keeps the dependencies section read by npm 6
+	hasLegacy := lockfileVersion == 2 && gjson.GetBytes(data, "dependencies").IsObject()
+	legacy := jsonObjectMembers(gjson.GetBytes(data, "dependencies"))
+	for _, location := range locations {
+		locationEntry := entry
+		if section == "overrides" {
+			previous, _ := findJSONMember(packages, location)
+			locationEntry.Dev = gjson.Get(previous, "dev").Bool()
+			locationEntry.Optional = gjson.Get(previous, "optional").Bool()
+			locationEntry.Peer = gjson.Get(previous, "peer").Bool()
+		}
+		entryRaw, err := marshalJSON(locationEntry)
+		if err != nil {
+			return err
+		}
+		packages = setJSONMember(packages, location, entryRaw)
+		// Packages nested under the previous version of the package are no longer used
+		kept := packages[:0]
+		for _, p := range packages {
+			if !strings.HasPrefix(p.Key, location+"/node_modules/") {
+				kept = append(kept, p)
+			}
+		}
+		packages = kept
+
+		if hasLegacy && location == "node_modules/"+packageName {
+			requires := map[string]string{}
+			for name, version := range manifest.Dependencies {
+				requires[name] = version
+			}
+			for name, version := range manifest.OptionalDependencies {
+				requires[name] = version
+			}
+			legacyRaw, err := marshalJSON(npmLegacyLockDependency{
+				Version:   locationEntry.Resolved,
+				Integrity: integrity,
+				Dev:       locationEntry.Dev,
+				Optional:  locationEntry.Optional,
+				Peer:      locationEntry.Peer,
+				Requires:  requires,
+			})
+			if err != nil {
+				return err
+			}
+			legacy = setJSONMember(legacy, packageName, legacyRaw)
+		}
+	}
+	if data, err = sjson.SetRawBytes(data, "packages", []byte(jsonObjectRaw(packages))); err != nil {
+		return err
+	}
+	if hasLegacy {
+		if data, err = sjson.SetRawBytes(data, "dependencies", []byte(jsonObjectRaw(legacy))); err != nil {
+			return err
+		}
+	}
+
+	// npm writes the lockfile with two-space indentation and no single-line arrays
+	return os.WriteFile(lockPath, pretty.PrettyOptions(data, &pretty.Options{Indent: "  "}), 0644)
+}
+
+// setNpmLockDeclaration declares the dependency in the given section of a package's lockfile entry, and removes
+// it from the other sections. The lockfile does not record overrides, so they are only removed from the others.
+func setNpmLockDeclaration(raw string, packageName string, section string, depRange string) (string, error) {
+	var err error
+	for _, other := range npmDependencySections {
+		if other == section || other == "overrides" {
+			continue
+		}
+		if !gjson.Get(raw, other+"."+escapeJSONPath(packageName)).Exists() {
+			continue
+		}
+		if raw, err = sjson.Delete(raw, other+"."+escapeJSONPath(packageName)); err != nil {
+			return "", err
+		}
+		if len(gjson.Get(raw, other).Map()) == 0 {
+			if raw, err = sjson.Delete(raw, other); err != nil {
+				return "", err
+			}
+		}
+	}
+	if section == "overrides" {
+		return raw, nil
+	}
+
+	depRangeRaw, err := marshalJSON(depRange)
+	if err != nil {
+		return "", err
+	}
+	dependencies := setJSONMember(jsonObjectMembers(gjson.Get(raw, section)), packageName, depRangeRaw)
+	return sjson.SetRaw(raw, section, jsonObjectRaw(dependencies))
+}
+
+// sha512Integrity returns the subresource integrity string npm records for the file at path.
+func sha512Integrity(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	sum := sha512.Sum512(data)
+	return "sha512-" + base64.StdEncoding.EncodeToString(sum[:]), nil
+}
+
+// escapeJSONPath escapes the characters of a key that have a special meaning in sjson/gjson paths.
+func escapeJSONPath(key string) string {
+	return jsonPathSpecialPattern.ReplaceAllString(key, `\$0`)
+}
+
+// marshalJSON encodes v as compact JSON without escaping HTML characters, which npm leaves as they are
+// in version ranges such as ">=18".
+func marshalJSON(v any) (string, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(v); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
+
+// jsonObjectMembers returns the members of a JSON object in order.
+func jsonObjectMembers(obj gjson.Result) []jsonMember {
+	var members []jsonMember
+	obj.ForEach(func(key, value gjson.Result) bool {
+		members = append(members, jsonMember{Key: key.String(), Raw: value.Raw})
+		return true
+	})
+	return members
+}
+
+// findJSONMember returns the raw value of the member with the given key.
+func findJSONMember(members []jsonMember, key string) (string, bool) {
+	for _, member := range members {
+		if member.Key == key {
+			return member.Raw, true
+		}
+	}
+	return "", false
+}
+
+// setJSONMember replaces the value of the member with the given key, or inserts it before the first member
+// with a greater key, keeping an object sorted like npm's lockfile sections.
+func setJSONMember(members []jsonMember, key string, raw string) []jsonMember {
+	at := len(members)
+	for i, member := range members {
+		if member.Key == key {
+			members[i].Raw = raw
+			return members
+		}
+		if at == len(members) && member.Key > key {
+			at = i
+		}
+	}
+	members = append(members, jsonMember{})
+	copy(members[at+1:], members[at:])
+	members[at] = jsonMember{Key: key, Raw: raw}
+	return members
+}
+
+// jsonObjectRaw returns the compact JSON object with the given members.
+func jsonObjectRaw(members []jsonMember) string {
+	var b strings.Builder
+	b.WriteString("{")
+	for i, member := range members {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		key, _ := marshalJSON(member.Key)
+		b.WriteString(key + ":" + member.Raw)
+	}
+	b.WriteString("}")
+	return b.String()
+}
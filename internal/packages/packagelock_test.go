@@ -0,0 +1,507 @@
+package packages
+
+import (
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPackageLockUpdatePackageRef(t *testing.T) {
+	testCases := []struct {
+		name         string
+		section      string
+		inputLock    string
+		expectedLock string
+	}{
+		{
+			name: "lockfileVersion 3",
+			inputLock: `{
+  "name": "storefront",
+  "version": "1.0.0",
+  "lockfileVersion": 3,
+  "requires": true,
+  "packages": {
+    "": {
+      "name": "storefront",
+      "version": "1.0.0",
+      "dependencies": {
+        "@acme/sdk": "^1.0.0",
+        "react": "^18.0.0"
+      }
+    },
+    "node_modules/@acme/sdk": {
+      "version": "1.0.3",
+      "resolved": "https://registry.npmjs.org/@acme/sdk/-/sdk-1.0.3.tgz",
+      "integrity": "sha512-aaaa",
+      "dependencies": {
+        "ms": "2.0.0"
+      }
+    },
+    "node_modules/@acme/sdk/node_modules/ms": {
+      "version": "2.0.0",
+      "resolved": "https://registry.npmjs.org/ms/-/ms-2.0.0.tgz",
+      "integrity": "sha512-bbbb"
+    },
+    "node_modules/react": {
+      "version": "18.3.1",
+      "resolved": "https://registry.npmjs.org/react/-/react-18.3.1.tgz",
+      "integrity": "sha512-cccc",
+      "license": "MIT"
+    }
+  }
+}
+`,
+			expectedLock: `{
+  "name": "storefront",
+  "version": "1.0.0",
+  "lockfileVersion": 3,
+  "requires": true,
+  "packages": {
+    "": {
+      "name": "storefront",
+      "version": "1.0.0",
+      "dependencies": {
+        "@acme/sdk": "file:.upkg/acme-sdk-1.1.0.tgz",
+        "react": "^18.0.0"
+      }
+    },
+    "node_modules/@acme/sdk": {
+      "version": "1.1.0",
+      "resolved": "file:.upkg/acme-sdk-1.1.0.tgz",
+      "integrity": "%[1]s",
+      "license": "MIT",
+      "dependencies": {
+        "ms": "^2.1.3"
+      },
+      "bin": {
+        "sdk": "bin/cli.js"
+      },
+      "engines": {
+        "node": ">=18"
+      },
+      "peerDependencies": {
+        "react": ">=18"
+      }
+    },
+    "node_modules/react": {
+      "version": "18.3.1",
+      "resolved": "https://registry.npmjs.org/react/-/react-18.3.1.tgz",
+      "integrity": "sha512-cccc",
+      "license": "MIT"
+    }
+  }
+}
+`,
+		},
+		{
+			name: "lockfileVersion 2 adds package",
+			inputLock: `{
+  "name": "storefront",
+  "lockfileVersion": 2,
+  "requires": true,
+  "packages": {
+    "": {
+      "name": "storefront",
+      "dependencies": {
+        "react": "^18.0.0"
+      }
+    },
+    "node_modules/react": {
+      "version": "18.3.1",
+      "resolved": "https://registry.npmjs.org/react/-/react-18.3.1.tgz",
+      "integrity": "sha512-cccc"
+    }
+  },
+  "dependencies": {
+    "react": {
+      "version": "18.3.1",
+      "resolved": "https://registry.npmjs.org/react/-/react-18.3.1.tgz",
+      "integrity": "sha512-cccc"
+    }
+  }
+}
+`,
+			expectedLock: `{
+  "name": "storefront",
+  "lockfileVersion": 2,
+  "requires": true,
+  "packages": {
+    "": {
+      "name": "storefront",
+      "dependencies": {
+        "@acme/sdk": "file:.upkg/acme-sdk-1.1.0.tgz",
+        "react": "^18.0.0"
+      }
+    },
+    "node_modules/@acme/sdk": {
+      "version": "1.1.0",
+      "resolved": "file:.upkg/acme-sdk-1.1.0.tgz",
+      "integrity": "%[1]s",
+      "license": "MIT",
+      "dependencies": {
+        "ms": "^2.1.3"
+      },
+      "bin": {
+        "sdk": "bin/cli.js"
+      },
+      "engines": {
+        "node": ">=18"
+      },
+      "peerDependencies": {
+        "react": ">=18"
+      }
+    },
+    "node_modules/react": {
+      "version": "18.3.1",
+      "resolved": "https://registry.npmjs.org/react/-/react-18.3.1.tgz",
+      "integrity": "sha512-cccc"
+    }
+  },
+  "dependencies": {
+    "@acme/sdk": {
+      "version": "file:.upkg/acme-sdk-1.1.0.tgz",
+      "integrity": "%[1]s",
+      "requires": {
+        "ms": "^2.1.3"
+      }
+    },
+    "react": {
+      "version": "18.3.1",
+      "resolved": "https://registry.npmjs.org/react/-/react-18.3.1.tgz",
+      "integrity": "sha512-cccc"
+    }
+  }
+}
+`,
+		},
+		{
+			name:    "moves to devDependencies",
+			section: "devDependencies",
+			inputLock: `{
+  "name": "storefront",
+  "lockfileVersion": 3,
+  "requires": true,
+  "packages": {
+    "": {
+      "name": "storefront",
+      "dependencies": {
+        "@acme/sdk": "^1.0.0",
+        "react": "^18.0.0"
+      }
+    },
+    "node_modules/@acme/sdk": {
+      "version": "1.0.3",
+      "resolved": "https://registry.npmjs.org/@acme/sdk/-/sdk-1.0.3.tgz",
+      "integrity": "sha512-aaaa"
+    }
+  }
+}
+`,
+			expectedLock: `{
+  "name": "storefront",
+  "lockfileVersion": 3,
+  "requires": true,
+  "packages": {
+    "": {
+      "name": "storefront",
+      "dependencies": {
+        "react": "^18.0.0"
+      },
+      "devDependencies": {
+        "@acme/sdk": "file:.upkg/acme-sdk-1.1.0.tgz"
+      }
+    },
+    "node_modules/@acme/sdk": {
+      "version": "1.1.0",
+      "resolved": "file:.upkg/acme-sdk-1.1.0.tgz",
+      "integrity": "%[1]s",
+      "dev": true,
+      "license": "MIT",
+      "dependencies": {
+        "ms": "^2.1.3"
+      },
+      "bin": {
+        "sdk": "bin/cli.js"
+      },
+      "engines": {
+        "node": ">=18"
+      },
+      "peerDependencies": {
+        "react": ">=18"
+      }
+    }
+  }
+}
+`,
+		},
+		{
+			name:    "overrides installed package",
+			section: "overrides",
+			inputLock: `{
+  "name": "storefront",
+  "lockfileVersion": 3,
+  "requires": true,
+  "packages": {
+    "": {
+      "name": "storefront",
+      "dependencies": {
+        "widgets": "^2.0.0"
+      }
+    },
+    "node_modules/widgets": {
+      "version": "2.0.0",
+      "resolved": "https://registry.npmjs.org/widgets/-/widgets-2.0.0.tgz",
+      "integrity": "sha512-cccc",
+      "dependencies": {
+        "@acme/sdk": "^1.0.0"
+      }
+    },
+    "node_modules/widgets/node_modules/@acme/sdk": {
+      "version": "1.0.3",
+      "resolved": "https://registry.npmjs.org/@acme/sdk/-/sdk-1.0.3.tgz",
+      "integrity": "sha512-aaaa",
+      "optional": true
+    }
+  }
+}
+`,
+			expectedLock: `{
+  "name": "storefront",
+  "lockfileVersion": 3,
+  "requires": true,
+  "packages": {
+    "": {
+      "name": "storefront",
+      "dependencies": {
+        "widgets": "^2.0.0"
+      }
+    },
+    "node_modules/widgets": {
+      "version": "2.0.0",
+      "resolved": "https://registry.npmjs.org/widgets/-/widgets-2.0.0.tgz",
+      "integrity": "sha512-cccc",
+      "dependencies": {
+        "@acme/sdk": "^1.0.0"
+      }
+    },
+    "node_modules/widgets/node_modules/@acme/sdk": {
+      "version": "1.1.0",
+      "resolved": "file:.upkg/acme-sdk-1.1.0.tgz",
+      "integrity": "%[1]s",
+      "license": "MIT",
+      "optional": true,
+      "dependencies": {
+        "ms": "^2.1.3"
+      },
+      "bin": {
+        "sdk": "bin/cli.js"
+      },
+      "engines": {
+        "node": ">=18"
+      },
+      "peerDependencies": {
+        "react": ">=18"
+      }
+    }
+  }
+}
+`,
+		},
+		{
+			name: "leaves lockfileVersion 1 alone",
+			inputLock: `{
+  "name": "storefront",
+  "lockfileVersion": 1,
+  "requires": true,
+  "dependencies": {}
+}
+`,
+			expectedLock: `{
+  "name": "storefront",
+  "lockfileVersion": 1,
+  "requires": true,
+  "dependencies": {}
+}
+`,
+		},
+	}
+
+	handler := &NpmHandler{}
+	dir := "../../testdata"
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			installTempDir, err := os.MkdirTemp(dir, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				if err := os.RemoveAll(installTempDir); err != nil {
+					fmt.Fprintf(os.Stderr, "warning: failed to remove temp folder: %v\n", err)
+				}
+			}() // Clean up
+
+			packageLocation := filepath.Join(installTempDir, ".upkg", "acme-sdk-1.1.0.tgz")
+			writeTestTarGz(t, packageLocation, map[string]string{
+				"package/package.json": `{
+  "name": "@acme/sdk",
+  "version": "1.1.0",
+  "license": "MIT",
+  "bin": "bin/cli.js",
+  "engines": {"node": ">=18"},
+  "dependencies": {"ms": "^2.1.3"},
+  "peerDependencies": {"react": ">=18"}
+}`,
+				"package/bin/cli.js": "",
+			})
+			tarball, err := os.ReadFile(packageLocation)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sum := sha512.Sum512(tarball)
+			integrity := "sha512-" + base64.StdEncoding.EncodeToString(sum[:])
+
+			if err := os.WriteFile(filepath.Join(installTempDir, "package.json"), []byte(`{"name": "storefront", "dependencies": {"react": "^18.0.0"}}`), 0644); err != nil {
+				t.Fatal(err)
+			}
+			lockPath := filepath.Join(installTempDir, "package-lock.json")
+			if err := os.WriteFile(lockPath, []byte(testCase.inputLock), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			// Installing twice must give the same result
+			for i := 0; i < 2; i++ {
+				if err := handler.UpdatePackageRefWithOptions("@acme/sdk", packageLocation, installTempDir, InstallOptions{Section: testCase.section}); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+
+			lock, err := os.ReadFile(lockPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expectedLock := testCase.expectedLock
+			if strings.Contains(expectedLock, "%[1]s") {
+				expectedLock = fmt.Sprintf(expectedLock, integrity)
+			}
+			if string(lock) != expectedLock {
+				t.Errorf("expected package-lock.json:\n%s\ngot:\n%s", expectedLock, lock)
+			}
+		})
+	}
+}
+
+func TestPackageLockWorkspaceUpdatePackageRef(t *testing.T) {
+	dir := "../../testdata"
+	installTempDir, err := os.MkdirTemp(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(installTempDir); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to remove temp folder: %v\n", err)
+		}
+	}() // Clean up
+
+	files := map[string]string{
+		"package.json":              `{"name": "monorepo", "workspaces": ["packages/*"]}`,
+		"packages/web/package.json": `{"name": "web", "dependencies": {"react": "^18.0.0"}}`,
+		"package-lock.json": `{
+  "name": "monorepo",
+  "lockfileVersion": 3,
+  "requires": true,
+  "packages": {
+    "": {
+      "name": "monorepo",
+      "workspaces": [
+        "packages/*"
+      ]
+    },
+    "node_modules/@acme/sdk": {
+      "version": "0.9.0",
+      "resolved": "https://registry.npmjs.org/@acme/sdk/-/sdk-0.9.0.tgz",
+      "integrity": "sha512-aaaa"
+    },
+    "node_modules/web": {
+      "resolved": "packages/web",
+      "link": true
+    },
+    "packages/web": {
+      "dependencies": {
+        "react": "^18.0.0"
+      }
+    }
+  }
+}
+`,
+	}
+	for name, content := range files {
+		path := filepath.Join(installTempDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	packageLocation := filepath.Join(installTempDir, InstallDir, "acme", "acme-sdk-1.1.0.tgz")
+	writeTestTarGz(t, packageLocation, map[string]string{
+		"package/package.json": `{"name": "@acme/sdk", "version": "1.1.0"}`,
+	})
+
+	handler := &NpmHandler{}
+	if err := handler.UpdatePackageRefWithOptions("@acme/sdk", packageLocation, installTempDir, InstallOptions{Workspace: "web"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	integrity, err := sha512Integrity(packageLocation)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Another version of the package is hoisted, so it is installed under the workspace's node_modules
+	expectedLock := fmt.Sprintf(`{
+  "name": "monorepo",
+  "lockfileVersion": 3,
+  "requires": true,
+  "packages": {
+    "": {
+      "name": "monorepo",
+      "workspaces": [
+        "packages/*"
+      ]
+    },
+    "node_modules/@acme/sdk": {
+      "version": "0.9.0",
+      "resolved": "https://registry.npmjs.org/@acme/sdk/-/sdk-0.9.0.tgz",
+      "integrity": "sha512-aaaa"
+    },
+    "node_modules/web": {
+      "resolved": "packages/web",
+      "link": true
+    },
+    "packages/web": {
+      "dependencies": {
+        "@acme/sdk": "file:../../.universal-packages/acme/acme-sdk-1.1.0.tgz",
+        "react": "^18.0.0"
+      }
+    },
+    "packages/web/node_modules/@acme/sdk": {
+      "version": "1.1.0",
+      "resolved": "file:.universal-packages/acme/acme-sdk-1.1.0.tgz",
+      "integrity": "%s"
+    }
+  }
+}
+`, integrity)
+	lock, err := os.ReadFile(filepath.Join(installTempDir, "package-lock.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(lock) != expectedLock {
+		t.Errorf("expected package-lock.json:\n%s\ngot:\n%s", expectedLock, lock)
+	}
+}
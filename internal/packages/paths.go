@@ -0,0 +1,144 @@
+package packages
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// findFileUp searches for the nearest file matching one of the given names, starting from the given
+// directory and moving up the directory tree. Names are checked in order within each directory and
+// may be glob patterns such as "*.csproj", in which case more than one match is an error.
+func findFileUp(workingDir string, names ...string) (string, error) {
+	for {
+		for _, name := range names {
+			p, err := findFileIn(workingDir, name)
+			if err != nil {
+				return "", err
+			}
+			if p != "" {
+				return p, nil
+			}
+		}
+
+		parent := filepath.Dir(workingDir)
+		if parent == workingDir {
+			break // root reached
+		}
+		workingDir = parent
+	}
+	return "", fmt.Errorf("%s not found", strings.Join(names, " or "))
+}
+
+// findFileIn returns the file in dir matching name, or "" if there is none.
+func findFileIn(dir string, name string) (string, error) {
+	if !strings.ContainsAny(name, "*?[") {
+		p := filepath.Join(dir, name)
+		if stat, err := os.Stat(p); err == nil && !stat.IsDir() {
+			return p, nil
+		}
+		return "", nil
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", nil
+	}
+	found := ""
+	for _, entry := range entries {
+		if matched, _ := filepath.Match(name, entry.Name()); !matched || entry.IsDir() {
+			continue
+		}
+		if found != "" {
+			return "", fmt.Errorf("multiple files matching %s found in %s", name, dir)
+		}
+		found = filepath.Join(dir, entry.Name())
+	}
+	return found, nil
+}
+
+// relativeRef returns the path of target relative to baseDir using forward slashes,
+// prefixed with "./" when it does not already start with "../".
+func relativeRef(baseDir string, target string) (string, error) {
+	relPath, err := filepath.Rel(baseDir, target)
+	if err != nil {
+		return "", fmt.Errorf("calculating relative path: %w", err)
+	}
+	relPath = filepath.ToSlash(relPath)
+	if !strings.HasPrefix(relPath, "../") {
+		relPath = "./" + relPath
+	}
+	return relPath, nil
+}
+
+// copyFile copies the file at src into dstDir, keeping its base name, and returns the new path.
+func copyFile(src string, dstDir string) (string, error) {
+	dst := filepath.Join(dstDir, filepath.Base(src))
+	if err := copyFileTo(src, dst); err != nil {
+		return "", err
+	}
+	return dst, nil
+}
+
+// copyFileTo copies the file at src to dst, creating the parent directories of dst as needed.
+func copyFileTo(src string, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return fmt.Errorf("creating directory %s: %w", filepath.Dir(dst), err)
+	}
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(dst, data, 0644)
+}
+
+// fileExists reports whether path exists and is a regular file.
+func fileExists(path string) bool {
+	stat, err := os.Stat(path)
+	return err == nil && !stat.IsDir()
+}
+
+// sha256File returns the hex-encoded sha256 of the file at path.
+func sha256File(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// removeOtherVersions deletes the files <prefix><version><suffix> in dir other than keep, so that a store
+// only holds the version of a package the project refers to. Versions must start with a digit, so that
+// packages whose names extend prefix are left alone.
+func removeOtherVersions(dir string, prefix string, suffix string, keep string) error {
+	return removeMatchingFiles(dir, func(name string) bool {
+		version, ok := strings.CutPrefix(strings.TrimSuffix(name, suffix), prefix)
+		return name != keep && ok && strings.HasSuffix(name, suffix) && version != "" && version[0] >= '0' && version[0] <= '9'
+	})
+}
+
+// removeMatchingFiles deletes the files in dir whose names match reports true for, for stores whose file names
+// do not follow removeOtherVersions' <prefix><version><suffix>. A missing dir has nothing to remove.
+func removeMatchingFiles(dir string, match func(name string) bool) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("reading %s: %w", dir, err)
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !match(name) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, name)); err != nil {
+			return fmt.Errorf("removing %s: %w", name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+package packages
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+type PipHandler struct{}
+
+var (
+	pythonNameSeparatorPattern = regexp.MustCompile(`[-_.]+`)
+	pythonRequirementPattern   = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*`)
+	pythonEggFragmentPattern   = regexp.MustCompile(`#egg=([A-Za-z0-9._-]+)`)
+	requirementsCommentPattern = regexp.MustCompile(`(^|\s)#.*$`)
+)
+
+// LocatePackage finds the wheel or source distribution for the package in the specified directory,
+// or in its dist/ subdirectory where `python -m build` writes its output. Wheels are preferred over sdists.
+func (p *PipHandler) LocatePackage(dir string, packageName string, packageVersion string) (string, error) {
+	normalized := normalizePythonName(packageName)
+
+	sdistPath := ""
+	for _, searchDir := range []string{dir, filepath.Join(dir, "dist")} {
+		entries, err := os.ReadDir(searchDir)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return "", fmt.Errorf("error reading package directory: %w", err)
+		}
+
+		for _, entry := range entries {
+			if entry.IsDir() {
+				continue
+			}
+			name, version, isWheel, ok := parsePythonDistFilename(entry.Name())
+			if !ok || normalizePythonName(name) != normalized || version != packageVersion {
+				continue
+			}
+			packagePath := filepath.Join(searchDir, entry.Name())
+			if isWheel {
+				return packagePath, nil
+			}
+			if sdistPath == "" {
+				sdistPath = packagePath
+			}
+		}
+	}
+
+	if sdistPath == "" {
+		return "", fmt.Errorf("expected package file not found: %s-%s-*.whl or %s-%s.tar.gz", packageName, packageVersion, packageName, packageVersion)
+	}
+	return sdistPath, nil
+}
+
+// UpdatePackageRef points the package at the local distribution file in the nearest requirements.txt or,
+// failing that, in the [project] dependencies of the nearest pyproject.toml.
+// Existing entries for the package are replaced; everything else in the file is left untouched.
+func (p *PipHandler) UpdatePackageRef(packageName string, packageFilePath string, packageRefFilePath string) error {
+	manifestPath, err := findFileUp(packageRefFilePath, "requirements.txt", "pyproject.toml")
+	if err != nil {
+		return fmt.Errorf("finding python project file: %w", err)
+	}
+	data, err := os.ReadFile(manifestPath)
+	if err != nil {
+		return err
+	}
+
+	var updated string
+	if filepath.Base(manifestPath) == "requirements.txt" {
+		// pip accepts a bare path to a distribution file in requirements files
+		ref, err := relativeRef(filepath.Dir(manifestPath), packageFilePath)
+		if err != nil {
+			return err
+		}
+		updated = updateRequirementsTxt(string(data), packageName, ref)
+	} else {
+		// PEP 508 direct references must be URLs, so pyproject.toml gets a file URL rooted at ${PROJECT_ROOT},
+		// which build frontends such as PDM expand to the project directory, keeping the entry portable
+		ref, err := relativeRef(filepath.Dir(manifestPath), packageFilePath)
+		if err != nil {
+			return err
+		}
+		url := "file:///${PROJECT_ROOT}/" + strings.TrimPrefix(ref, "./")
+		updated, err = updatePyprojectDependencies(string(data), packageName, packageName+" @ "+url)
+		if err != nil {
+			return fmt.Errorf("updating %s: %w", manifestPath, err)
+		}
+	}
+
+	return os.WriteFile(manifestPath, []byte(updated), 0644)
+}
+
+// updateRequirementsTxt replaces the requirement line for the package with entry, or appends it.
+// Any further lines requiring the same package are dropped so pip does not see a double requirement.
+func updateRequirementsTxt(content string, packageName string, entry string) string {
+	normalized := normalizePythonName(packageName)
+	lines := strings.Split(content, "\n")
+
+	updated := make([]string, 0, len(lines)+1)
+	found := false
+	for _, line := range lines {
+		if normalizePythonName(requirementName(line)) != normalized {
+			updated = append(updated, line)
+			continue
+		}
+		if !found {
+			updated = append(updated, entry)
+			found = true
+		}
+	}
+
+	if !found {
+		if updated[len(updated)-1] == "" {
+			updated = append(updated[:len(updated)-1], entry, "")
+		} else {
+			updated = append(updated, entry)
+		}
+	}
+	return strings.Join(updated, "\n")
+}
+
+// updatePyprojectDependencies sets the package's entry in the PEP 621 [project] dependencies array,
+// creating the array if the table does not have one yet.
+func updatePyprojectDependencies(content string, packageName string, entry string) (string, error) {
+	start, end, ok := tomlTableBody(content, "project")
+	if !ok {
+		return "", fmt.Errorf("no [project] table found")
+	}
+
+	valueStart := tomlKeyValue(content, start, end, "dependencies")
+	if valueStart == -1 {
+		return content[:start] + "dependencies = [\n    \"" + entry + "\",\n]\n" + content[start:], nil
+	}
+
+	normalized := normalizePythonName(packageName)
+	return setTOMLArrayString(content, valueStart, entry, func(existing string) bool {
+		return normalizePythonName(requirementName(existing)) == normalized
+	})
+}
+
+// requirementName returns the name of the distribution required by a requirements.txt line or
+// PEP 508 string, or "" for blank lines, comments and pip options.
+func requirementName(line string) string {
+	line = strings.TrimSpace(requirementsCommentPattern.ReplaceAllString(line, ""))
+	if line == "" || strings.HasPrefix(line, "-") {
+		return ""
+	}
+
+	field := strings.Fields(line)[0]
+	if name, _, _, ok := parsePythonDistFilename(path.Base(filepath.ToSlash(field))); ok {
+		return name
+	}
+	if m := pythonEggFragmentPattern.FindStringSubmatch(field); m != nil {
+		return m[1]
+	}
+
+	name := pythonRequirementPattern.FindString(line)
+	if name == "" || (len(line) > len(name) && !strings.ContainsRune(" \t[<>=!~;@(,", rune(line[len(name)]))) {
+		return ""
+	}
+	return name
+}
+
+// parsePythonDistFilename extracts the distribution name and version from a wheel or sdist filename.
+func parsePythonDistFilename(filename string) (string, string, bool, bool) {
+	if stem, ok := strings.CutSuffix(filename, ".whl"); ok {
+		parts := strings.Split(stem, "-")
+		if len(parts) < 5 {
+			return "", "", false, false
+		}
+		return parts[0], parts[1], true, true
+	}
+	if stem, ok := strings.CutSuffix(filename, ".tar.gz"); ok {
+		i := strings.LastIndex(stem, "-")
+		if i <= 0 {
+			return "", "", false, false
+		}
+		return stem[:i], stem[i+1:], false, true
+	}
+	return "", "", false, false
+}
+
+// normalizePythonName normalizes a distribution name as described in PEP 503,
+// so that "My_Package", "my-package" and "my.package" compare equal.
+func normalizePythonName(name string) string {
+	return pythonNameSeparatorPattern.ReplaceAllString(strings.ToLower(name), "-")
+}
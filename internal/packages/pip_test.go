@@ -0,0 +1,251 @@
+package packages
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPipLocatePackage(t *testing.T) {
+	handler := &PipHandler{}
+	dir := "../../testdata"
+
+	tempDir, err := os.MkdirTemp(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tempDir); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to remove temp folder: %v\n", err)
+		}
+	}() // Clean up
+
+	// Mimic the output of `python -m build`
+	distDir := filepath.Join(tempDir, "dist")
+	if err := os.MkdirAll(distDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{
+		"my_sdk-1.2.0-py3-none-any.whl",
+		"my_sdk-1.2.0.tar.gz",
+		"other_sdk-2.0.0.tar.gz",
+	} {
+		if err := os.WriteFile(filepath.Join(distDir, name), []byte("fake dist content"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	testCases := []struct {
+		name           string
+		packageName    string
+		packageVersion string
+		expectedPath   string
+		expectedError  bool
+	}{
+		{
+			name:           "prefers wheel over sdist",
+			packageName:    "my-sdk",
+			packageVersion: "1.2.0",
+			expectedPath:   filepath.Join(distDir, "my_sdk-1.2.0-py3-none-any.whl"),
+		},
+		{
+			name:           "falls back to sdist",
+			packageName:    "Other.SDK",
+			packageVersion: "2.0.0",
+			expectedPath:   filepath.Join(distDir, "other_sdk-2.0.0.tar.gz"),
+		},
+		{
+			name:           "fail on non-existing version",
+			packageName:    "my-sdk",
+			packageVersion: "9.9.9",
+			expectedError:  true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			filePath, err := handler.LocatePackage(tempDir, testCase.packageName, testCase.packageVersion)
+			if testCase.expectedError {
+				if err == nil {
+					t.Fatal("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if filePath != testCase.expectedPath {
+				t.Errorf("expected %s, got %s", testCase.expectedPath, filePath)
+			}
+		})
+	}
+}
+
+func TestPipUpdatePackageRefRequirements(t *testing.T) {
+	testCases := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name:     "replaces pinned requirement",
+			input:    "requests==2.31.0\nmy-sdk==1.0.0 # internal\nflask>=3\n",
+			expected: "requests==2.31.0\n./.upkg/my_sdk-1.2.0-py3-none-any.whl\nflask>=3\n",
+		},
+		{
+			name:     "replaces previous local file",
+			input:    "./.upkg/my_sdk-1.1.0-py3-none-any.whl\n",
+			expected: "./.upkg/my_sdk-1.2.0-py3-none-any.whl\n",
+		},
+		{
+			name:     "appends missing requirement",
+			input:    "# deps\n-r base.txt\nrequests\n",
+			expected: "# deps\n-r base.txt\nrequests\n./.upkg/my_sdk-1.2.0-py3-none-any.whl\n",
+		},
+		{
+			name:     "appends to file without trailing newline",
+			input:    "requests",
+			expected: "requests\n./.upkg/my_sdk-1.2.0-py3-none-any.whl",
+		},
+	}
+
+	handler := &PipHandler{}
+	dir := "../../testdata"
+
+	installTempDir, err := os.MkdirTemp(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(installTempDir); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to remove temp folder: %v\n", err)
+		}
+	}() // Clean up
+	requirementsPath := filepath.Join(installTempDir, "requirements.txt")
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if err := os.WriteFile(requirementsPath, []byte(testCase.input), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			packageLocation := filepath.Join(installTempDir, ".upkg", "my_sdk-1.2.0-py3-none-any.whl")
+			if err := handler.UpdatePackageRef("my-sdk", packageLocation, installTempDir); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			updated, err := os.ReadFile(requirementsPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(updated) != testCase.expected {
+				t.Errorf("expected:\n%s\ngot:\n%s", testCase.expected, updated)
+			}
+		})
+	}
+}
+
+func TestPipUpdatePackageRefPyproject(t *testing.T) {
+	handler := &PipHandler{}
+	dir := "../../testdata"
+
+	installTempDir, err := os.MkdirTemp(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(installTempDir); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to remove temp folder: %v\n", err)
+		}
+	}() // Clean up
+	pyprojectPath := filepath.Join(installTempDir, "pyproject.toml")
+
+	packageLocation := filepath.Join(installTempDir, ".upkg", "my_sdk-1.2.0-py3-none-any.whl")
+	entry := `"my-sdk @ file:///${PROJECT_ROOT}/.upkg/my_sdk-1.2.0-py3-none-any.whl"`
+
+	testCases := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name: "replaces entry in multi-line array",
+			input: `[project]
+name = "service"
+dependencies = [
+    "requests>=2",  # http
+    "my-sdk==1.0.0",
+]
+
+[tool.black]
+line-length = 100
+`,
+			expected: `[project]
+name = "service"
+dependencies = [
+    "requests>=2",  # http
+    ` + entry + `,
+]
+
+[tool.black]
+line-length = 100
+`,
+		},
+		{
+			name: "appends to multi-line array",
+			input: `[project]
+dependencies = [
+    "requests>=2"
+]
+`,
+			expected: `[project]
+dependencies = [
+    "requests>=2",
+    ` + entry + `,
+]
+`,
+		},
+		{
+			name: "appends to inline array",
+			input: `[project]
+dependencies = ["requests>=2"]
+`,
+			expected: `[project]
+dependencies = ["requests>=2", ` + entry + `]
+`,
+		},
+		{
+			name: "creates dependencies array",
+			input: `[project]
+name = "service"
+`,
+			expected: `[project]
+dependencies = [
+    ` + entry + `,
+]
+name = "service"
+`,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if err := os.WriteFile(pyprojectPath, []byte(testCase.input), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := handler.UpdatePackageRef("my-sdk", packageLocation, installTempDir); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			updated, err := os.ReadFile(pyprojectPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(updated) != testCase.expected {
+				t.Errorf("expected:\n%s\ngot:\n%s", testCase.expected, updated)
+			}
+		})
+	}
+}
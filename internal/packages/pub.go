@@ -0,0 +1,133 @@
+package packages
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+type PubHandler struct{}
+
+// pubDependencySections are the pubspec.yaml sections a package may already be declared in.
+var pubDependencySections = []string{"dependencies", "dev_dependencies"}
+
+// LocatePackage finds <name>-<version>.tar.gz in the specified directory. If it is not present but the
+// directory holds a pubspec.yaml, the package directory itself is packaged into a temporary archive,
+// leaving out build output and tool state.
+func (p *PubHandler) LocatePackage(dir string, packageName string, packageVersion string) (string, error) {
+	filename := fmt.Sprintf("%s-%s.tar.gz", packageName, packageVersion)
+	packagePath := filepath.Join(dir, filename)
+	if fileExists(packagePath) {
+		return packagePath, nil
+	}
+	if !fileExists(filepath.Join(dir, "pubspec.yaml")) {
+		return "", fmt.Errorf("expected package file not found: %s", filename)
+	}
+
+	outDir, err := newOutputDir("pub")
+	if err != nil {
+		return "", err
+	}
+	packagePath = filepath.Join(outDir, filename)
+	if err := createTarGz(dir, packagePath, skipPubEntry); err != nil {
+		return "", err
+	}
+	return packagePath, nil
+}
+
+// UpdatePackageRef extracts the package next to the nearest pubspec.yaml and declares it as a path dependency.
+// An existing entry for the package (hosted, git or path) in dependencies or dev_dependencies is replaced in
+// place; otherwise the package is added to dependencies.
+func (p *PubHandler) UpdatePackageRef(packageName string, packageFilePath string, packageRefFilePath string) error {
+	pubspecPath, err := findFileUp(packageRefFilePath, "pubspec.yaml")
+	if err != nil {
+		return fmt.Errorf("finding pubspec.yaml: %w", err)
+	}
+
+	packageDir := filepath.Join(filepath.Dir(pubspecPath), InstallDir, "pub-packages", packageName)
+	if err := extractTarGz(packageFilePath, packageDir, 0); err != nil {
+		return fmt.Errorf("extracting package: %w", err)
+	}
+	relPath, err := filepath.Rel(filepath.Dir(pubspecPath), packageDir)
+	if err != nil {
+		return fmt.Errorf("calculating relative path: %w", err)
+	}
+
+	data, err := os.ReadFile(pubspecPath)
+	if err != nil {
+		return err
+	}
+	updated := setPubPathDependency(string(data), packageName, filepath.ToSlash(relPath))
+	return os.WriteFile(pubspecPath, []byte(updated), 0644)
+}
+
+// skipPubEntry reports whether a file or directory of a package should be left out of its archive.
+func skipPubEntry(rel string, entry os.DirEntry) bool {
+	name := path.Base(rel)
+	if entry.IsDir() {
+		return name == ".dart_tool" || name == ".git" || name == InstallDir || rel == "build"
+	}
+	return rel == "pubspec.lock" || name == ".packages"
+}
+
+// setPubPathDependency replaces the first declaration of the package with a path dependency, removing any
+// other declaration of it, or adds one to dependencies.
+func setPubPathDependency(content string, packageName string, packagePath string) string {
+	lines := strings.Split(content, "\n")
+
+	replaced := false
+	for _, section := range pubDependencySections {
+		sectionIdx := yamlFindKey(lines, 0, len(lines), 0, section)
+		if sectionIdx == -1 {
+			continue
+		}
+		childIndent := yamlChildIndent(lines, sectionIdx, 2)
+		entryIdx := yamlFindKey(lines, sectionIdx+1, yamlBlockEnd(lines, sectionIdx), childIndent, packageName)
+		if entryIdx == -1 {
+			continue
+		}
+		entryEnd := yamlBlockEnd(lines, entryIdx)
+		var entry []string
+		if !replaced {
+			entry = pubPathEntry(lines[entryIdx], childIndent, childIndent-yamlIndent(lines[sectionIdx]), packageName, packagePath)
+			replaced = true
+		}
+		lines = append(lines[:entryIdx], append(entry, lines[entryEnd:]...)...)
+	}
+	if replaced {
+		return strings.Join(lines, "\n")
+	}
+
+	sectionIdx := yamlFindKey(lines, 0, len(lines), 0, "dependencies")
+	if sectionIdx == -1 {
+		at := len(lines)
+		if lines[at-1] == "" {
+			at--
+		}
+		entry := pubPathEntry("", 2, 2, packageName, packagePath)
+		return strings.Join(yamlInsertLines(lines, at, append([]string{"dependencies:"}, entry...)...), "\n")
+	}
+	if _, raw, _ := yamlKeyValue(lines[sectionIdx], 0); yamlScalar(raw) == "{}" {
+		lines[sectionIdx] = "dependencies:"
+	}
+	childIndent := yamlChildIndent(lines, sectionIdx, 2)
+	entry := pubPathEntry("", childIndent, childIndent, packageName, packagePath)
+	return strings.Join(yamlInsertLines(lines, yamlBlockEnd(lines, sectionIdx), entry...), "\n")
+}
+
+// pubPathEntry returns the lines of a path dependency declaration, keeping the trailing comment of the
+// declaration it replaces, if any.
+func pubPathEntry(replacing string, indent int, step int, packageName string, packagePath string) []string {
+	comment := ""
+	if _, raw, ok := yamlKeyValue(replacing, indent); ok {
+		if i := yamlCommentIndex(raw); i != -1 {
+			comment = " " + raw[i:]
+		}
+	}
+	return []string{
+		strings.Repeat(" ", indent) + packageName + ":" + comment,
+		strings.Repeat(" ", indent+step) + "path: " + yamlQuote(packagePath),
+	}
+}
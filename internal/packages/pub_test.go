@@ -0,0 +1,231 @@
+package packages
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPubLocatePackage(t *testing.T) {
+	handler := &PubHandler{}
+	dir := "../../testdata"
+
+	tempDir, err := os.MkdirTemp(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tempDir); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to remove temp folder: %v\n", err)
+		}
+	}() // Clean up
+
+	packageDir := filepath.Join(tempDir, "api_sdk")
+	files := map[string]string{
+		"pubspec.yaml":             "name: api_sdk\nversion: 1.4.0\n",
+		"lib/api_sdk.dart":         "",
+		"pubspec.lock":             "",
+		".dart_tool/config.json":   "",
+		"build/output.dill":        "",
+		"lib/src/build/model.dart": "",
+	}
+	for name, content := range files {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(packageDir, name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(packageDir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	prebuiltPath := filepath.Join(tempDir, "api_sdk-1.3.0.tar.gz")
+	writeTestTarGz(t, prebuiltPath, map[string]string{"pubspec.yaml": ""})
+
+	t.Run("find prebuilt archive", func(t *testing.T) {
+		filePath, err := handler.LocatePackage(tempDir, "api_sdk", "1.3.0")
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if filePath != prebuiltPath {
+			t.Errorf("expected %s, got %s", prebuiltPath, filePath)
+		}
+	})
+
+	t.Run("package directory", func(t *testing.T) {
+		filePath, err := handler.LocatePackage(packageDir, "api_sdk", "1.4.0")
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		defer func() {
+			_ = os.RemoveAll(filepath.Dir(filePath))
+		}()
+
+		extractDir := filepath.Join(tempDir, "extracted")
+		if err := extractTarGz(filePath, extractDir, 0); err != nil {
+			t.Fatal(err)
+		}
+		for name := range files {
+			expected := name == "pubspec.yaml" || name == "lib/api_sdk.dart" || name == "lib/src/build/model.dart"
+			if fileExists(filepath.Join(extractDir, name)) != expected {
+				t.Errorf("expected %s to be packaged: %v", name, expected)
+			}
+		}
+	})
+
+	t.Run("fail without pubspec.yaml", func(t *testing.T) {
+		if _, err := handler.LocatePackage(tempDir, "api_sdk", "1.4.0"); err == nil {
+			t.Fatal("expected error, got nil")
+		}
+	})
+}
+
+func TestPubUpdatePackageRef(t *testing.T) {
+	testCases := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name: "replaces hosted dependency",
+			input: `name: mobile_app
+environment:
+  sdk: ^3.3.0
+
+dependencies:
+  flutter:
+    sdk: flutter
+  api_sdk: ^1.2.0 # shared with web
+  http: ^1.2.0
+
+dev_dependencies:
+  api_sdk_mocks:
+    hosted: https://pub.example.com
+    version: ^1.0.0
+`,
+			expected: `name: mobile_app
+environment:
+  sdk: ^3.3.0
+
+dependencies:
+  flutter:
+    sdk: flutter
+  api_sdk: # shared with web
+    path: .universal-packages/pub-packages/api_sdk
+  http: ^1.2.0
+
+dev_dependencies:
+  api_sdk_mocks:
+    hosted: https://pub.example.com
+    version: ^1.0.0
+`,
+		},
+		{
+			name: "replaces hosted entry in dev_dependencies",
+			input: `dependencies:
+    http: ^1.2.0
+dev_dependencies:
+    api_sdk:
+        hosted: https://pub.example.com
+        version: ^1.0.0
+    test: ^1.25.0
+`,
+			expected: `dependencies:
+    http: ^1.2.0
+dev_dependencies:
+    api_sdk:
+        path: .universal-packages/pub-packages/api_sdk
+    test: ^1.25.0
+`,
+		},
+		{
+			name: "removes duplicate declaration",
+			input: `dependencies:
+  api_sdk: any
+dev_dependencies:
+  api_sdk:
+    hosted: https://pub.example.com
+    version: ^1.0.0
+  test: ^1.25.0
+`,
+			expected: `dependencies:
+  api_sdk:
+    path: .universal-packages/pub-packages/api_sdk
+dev_dependencies:
+  test: ^1.25.0
+`,
+		},
+		{
+			name: "adds to dependencies",
+			input: `name: mobile_app
+dependencies:
+  http: ^1.2.0
+
+flutter:
+  uses-material-design: true
+`,
+			expected: `name: mobile_app
+dependencies:
+  http: ^1.2.0
+  api_sdk:
+    path: .universal-packages/pub-packages/api_sdk
+
+flutter:
+  uses-material-design: true
+`,
+		},
+		{
+			name: "adds dependencies section",
+			input: `name: mobile_app
+`,
+			expected: `name: mobile_app
+dependencies:
+  api_sdk:
+    path: .universal-packages/pub-packages/api_sdk
+`,
+		},
+	}
+
+	handler := &PubHandler{}
+	dir := "../../testdata"
+
+	installTempDir, err := os.MkdirTemp(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(installTempDir); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to remove temp folder: %v\n", err)
+		}
+	}() // Clean up
+
+	packageLocation := filepath.Join(installTempDir, ".upkg", "api_sdk-1.4.0.tar.gz")
+	writeTestTarGz(t, packageLocation, map[string]string{
+		"pubspec.yaml":     "name: api_sdk\nversion: 1.4.0\n",
+		"lib/api_sdk.dart": "",
+	})
+	pubspecPath := filepath.Join(installTempDir, "pubspec.yaml")
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if err := os.WriteFile(pubspecPath, []byte(testCase.input), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := handler.UpdatePackageRef("api_sdk", packageLocation, installTempDir); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			updated, err := os.ReadFile(pubspecPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(updated) != testCase.expected {
+				t.Errorf("expected:\n%s\ngot:\n%s", testCase.expected, updated)
+			}
+		})
+	}
+
+	if !fileExists(filepath.Join(installTempDir, InstallDir, "pub-packages", "api_sdk", "lib", "api_sdk.dart")) {
+		t.Error("expected package to be extracted")
+	}
+}
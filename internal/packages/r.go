@@ -0,0 +1,272 @@
+package packages
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/pretty"
+	"github.com/tidwall/sjson"
+)
+
+type RHandler struct{}
+
+var (
+	// rHashFields are the DESCRIPTION fields renv hashes to identify a build of a package, along with every field
+	// starting with Remote.
+	rHashFields = []string{"Package", "Version", "Title", "Author", "Maintainer", "Description", "Depends", "Imports", "Suggests", "LinkingTo"}
+	// rBasePackages ship with R itself, so renv leaves them out of a package's requirements.
+	rBasePackages = map[string]bool{
+		"R": true, "base": true, "compiler": true, "datasets": true, "graphics": true, "grDevices": true, "grid": true,
+		"methods": true, "parallel": true, "splines": true, "stats": true, "stats4": true, "tcltk": true, "tools": true, "utils": true,
+	}
+	rPackageNamePattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9.]*`)
+)
+
+// rLockPackage is an entry of the Packages section of renv.lock for a package installed from a local
+// source tarball, with its fields in the order renv writes them.
+type rLockPackage struct {
+	Package      string   `json:"Package"`
+	Version      string   `json:"Version"`
+	Source       string   `json:"Source"`
+	RemoteType   string   `json:"RemoteType"`
+	RemoteURL    string   `json:"RemoteUrl"`
+	Hash         string   `json:"Hash"`
+	Requirements []string `json:"Requirements,omitempty"`
+}
+
+// dcfField is a field of a Debian control file such as DESCRIPTION, spanning lines[Start:End].
+type dcfField struct {
+	Name  string
+	Value string
+	Start int
+	End   int
+}
+
+// LocatePackage finds <name>_<version>.tar.gz in the specified directory, as written by `R CMD build`.
+func (r *RHandler) LocatePackage(dir string, packageName string, packageVersion string) (string, error) {
+	filename := fmt.Sprintf("%s_%s.tar.gz", packageName, packageVersion)
+	packagePath := filepath.Join(dir, filename)
+	if !fileExists(packagePath) {
+		return "", fmt.Errorf("expected package file not found: %s", filename)
+	}
+	return packagePath, nil
+}
+
+// UpdatePackageRef stores the source tarball in the nearest project with a DESCRIPTION or renv.lock,
+// replacing other versions of it. The package is added to Imports in DESCRIPTION, and recorded in renv.lock
+// as a local source so that `renv::restore()` installs it from the stored tarball.
+func (r *RHandler) UpdatePackageRef(packageName string, packageFilePath string, packageRefFilePath string) error {
+	projectFile, err := findFileUp(packageRefFilePath, "DESCRIPTION", "renv.lock")
+	if err != nil {
+		return fmt.Errorf("finding DESCRIPTION or renv.lock: %w", err)
+	}
+	projectDir := filepath.Dir(projectFile)
+
+	// Source tarballs contain a single <name>/ directory
+	description, err := readTarGzFile(packageFilePath, packageName+"/DESCRIPTION")
+	if err != nil {
+		return err
+	}
+	fields := dcfFields(strings.Split(string(description), "\n"))
+	if dcfValue(fields, "Package") != packageName {
+		return fmt.Errorf("%s is not a source package of %s", filepath.Base(packageFilePath), packageName)
+	}
+
+	storeDir := filepath.Join(projectDir, InstallDir, "r-packages")
+	if err := removeOtherVersions(storeDir, packageName+"_", ".tar.gz", filepath.Base(packageFilePath)); err != nil {
+		return err
+	}
+	storedPath, err := copyFile(packageFilePath, storeDir)
+	if err != nil {
+		return fmt.Errorf("storing package: %w", err)
+	}
+
+	descriptionPath := filepath.Join(projectDir, "DESCRIPTION")
+	if fileExists(descriptionPath) {
+		data, err := os.ReadFile(descriptionPath)
+		if err != nil {
+			return err
+		}
+		updated := addRImport(string(data), packageName)
+		if err := os.WriteFile(descriptionPath, []byte(updated), 0644); err != nil {
+			return err
+		}
+	}
+
+	lockPath := filepath.Join(projectDir, "renv.lock")
+	if !fileExists(lockPath) {
+		return nil
+	}
+	relPath, err := filepath.Rel(projectDir, storedPath)
+	if err != nil {
+		return fmt.Errorf("calculating relative path: %w", err)
+	}
+	return updateRenvLock(lockPath, fields, filepath.ToSlash(relPath))
+}
+
+// updateRenvLock records the package described by fields in renv.lock as installed from the tarball at
+// tarballPath, replacing any other record of it.
+func updateRenvLock(lockPath string, fields []dcfField, tarballPath string) error {
+	data, err := os.ReadFile(lockPath)
+	if err != nil {
+		return err
+	}
+	if !gjson.ValidBytes(data) {
+		return fmt.Errorf("parsing %s: invalid JSON", lockPath)
+	}
+
+	var requirements []string
+	for _, field := range []string{"Depends", "Imports", "LinkingTo"} {
+		for _, name := range rPackageNames(dcfValue(fields, field)) {
+			if !rBasePackages[name] {
+				requirements = append(requirements, name)
+			}
+		}
+	}
+	sort.Strings(requirements)
+	packageName := dcfValue(fields, "Package")
+	record := rLockPackage{
+		Package:      packageName,
+		Version:      dcfValue(fields, "Version"),
+		Source:       "Local",
+		RemoteType:   "local",
+		RemoteURL:    tarballPath,
+		Requirements: requirements,
+	}
+	record.Hash = renvDescriptionHash(fields, map[string]string{"RemoteType": record.RemoteType, "RemoteUrl": record.RemoteURL})
+	entry, err := marshalJSON(record)
+	if err != nil {
+		return err
+	}
+
+	// renv keeps packages sorted by name
+	packages := setJSONMember(jsonObjectMembers(gjson.GetBytes(data, "Packages")), packageName, entry)
+	if data, err = sjson.SetRawBytes(data, "Packages", []byte(jsonObjectRaw(packages))); err != nil {
+		return err
+	}
+	// renv writes the lockfile with two-space indentation and no single-line arrays
+	return os.WriteFile(lockPath, pretty.PrettyOptions(data, &pretty.Options{Indent: "  "}), 0644)
+}
+
+// renvDescriptionHash returns the hash renv records for a package, computed like renv_hash_description does
+// from the DESCRIPTION of the installed package: the md5 of the hashed fields, sorted by name in the C locale and
+// written as "Name: value" lines. remotes are the Remote fields renv adds to that DESCRIPTION on install,
+// replacing any of the source package.
+func renvDescriptionHash(fields []dcfField, remotes map[string]string) string {
+	values := map[string]string{}
+	for _, field := range fields {
+		if slices.Contains(rHashFields, field.Name) || strings.HasPrefix(field.Name, "Remote") {
+			values[field.Name] = field.Value
+		}
+	}
+	for name, value := range remotes {
+		values[name] = value
+	}
+	var b strings.Builder
+	// Byte order is the C locale's collation
+	for _, name := range sortedKeys(values) {
+		b.WriteString(name + ": " + values[name] + "\n")
+	}
+	sum := md5.Sum([]byte(b.String()))
+	return hex.EncodeToString(sum[:])
+}
+
+// addRImport adds the package to the Imports field of a DESCRIPTION, unless it is already imported or
+// attached through Depends. A multi-line field gets a new line indented like the others.
+func addRImport(content string, packageName string) string {
+	lines := strings.Split(content, "\n")
+	fields := dcfFields(lines)
+	for _, name := range append(rPackageNames(dcfValue(fields, "Imports")), rPackageNames(dcfValue(fields, "Depends"))...) {
+		if name == packageName {
+			return content
+		}
+	}
+
+	for _, field := range fields {
+		if field.Name != "Imports" {
+			continue
+		}
+		last := strings.TrimRight(lines[field.End-1], " \t")
+		if field.End-field.Start == 1 {
+			if strings.TrimSpace(field.Value) == "" {
+				lines[field.Start] = "Imports: " + packageName
+			} else {
+				lines[field.Start] = strings.TrimSuffix(last, ",") + ", " + packageName
+			}
+			return strings.Join(lines, "\n")
+		}
+		indent := lines[field.End-1][:len(lines[field.End-1])-len(strings.TrimLeft(lines[field.End-1], " \t"))]
+		if !strings.HasSuffix(last, ",") && !strings.HasSuffix(last, ":") {
+			last += ","
+		}
+		lines[field.End-1] = last
+		return strings.Join(yamlInsertLines(lines, field.End, indent+packageName), "\n")
+	}
+
+	// Imports usually follows Depends
+	at := len(lines)
+	if lines[at-1] == "" {
+		at--
+	}
+	for _, field := range fields {
+		if field.Name == "Depends" {
+			at = field.End
+		}
+	}
+	return strings.Join(yamlInsertLines(lines, at, "Imports:", "    "+packageName), "\n")
+}
+
+// dcfFields parses the fields of a Debian control file. The value of a field spanning several lines is its
+// lines joined by newlines, with the leading whitespace of continuation lines removed, like R's read.dcf.
+func dcfFields(lines []string) []dcfField {
+	var fields []dcfField
+	for i, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			if len(fields) > 0 {
+				field := &fields[len(fields)-1]
+				field.Value += "\n" + strings.TrimSpace(line)
+				field.End = i + 1
+			}
+			continue
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		fields = append(fields, dcfField{Name: name, Value: strings.TrimSpace(value), Start: i, End: i + 1})
+	}
+	return fields
+}
+
+// dcfValue returns the value of the named field, or "" if there is none.
+func dcfValue(fields []dcfField, name string) string {
+	for _, field := range fields {
+		if field.Name == name {
+			return field.Value
+		}
+	}
+	return ""
+}
+
+// rPackageNames returns the package names in a DESCRIPTION dependency field, without version constraints
+// such as "(>= 1.0)".
+func rPackageNames(value string) []string {
+	var names []string
+	for _, entry := range strings.Split(value, ",") {
+		if name := rPackageNamePattern.FindString(strings.TrimSpace(entry)); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
@@ -0,0 +1,321 @@
+package packages
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testRDescription is the DESCRIPTION of the source package used by the tests.
+const testRDescription = `Package: acmehelpers
+Type: Package
+Title: Internal Helpers
+Version: 0.3.0
+Authors@R: person("Ada", "Lovelace", role = c("aut", "cre"))
+Description: Helpers shared by the
+    data science team.
+License: MIT
+Depends: R (>= 4.1)
+Imports:
+    dplyr (>= 1.1.0),
+    stats
+Encoding: UTF-8
+`
+
+func TestRLocatePackage(t *testing.T) {
+	handler := &RHandler{}
+	dir := "../../testdata"
+
+	tempDir, err := os.MkdirTemp(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tempDir); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to remove temp folder: %v\n", err)
+		}
+	}() // Clean up
+
+	// Mimic the output of `R CMD build`
+	packagePath := filepath.Join(tempDir, "acmehelpers_0.3.0.tar.gz")
+	writeTestTarGz(t, packagePath, map[string]string{"acmehelpers/DESCRIPTION": testRDescription})
+
+	testCases := []struct {
+		name           string
+		packageName    string
+		packageVersion string
+		expectedPath   string
+		expectedError  bool
+	}{
+		{
+			name:           "find source package",
+			packageName:    "acmehelpers",
+			packageVersion: "0.3.0",
+			expectedPath:   packagePath,
+		},
+		{
+			name:           "fail on non-existing package",
+			packageName:    "acmehelpers",
+			packageVersion: "0.4.0",
+			expectedError:  true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			filePath, err := handler.LocatePackage(tempDir, testCase.packageName, testCase.packageVersion)
+			if testCase.expectedError {
+				if err == nil {
+					t.Fatal("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if filePath != testCase.expectedPath {
+				t.Errorf("expected %s, got %s", testCase.expectedPath, filePath)
+			}
+		})
+	}
+}
+
+func TestRUpdatePackageRef(t *testing.T) {
+	testCases := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name: "adds to multi-line Imports",
+			input: `Package: churnmodel
+Version: 1.0.0
+Imports:
+    dplyr,
+    tidyr (>= 1.3.0)
+Suggests: testthat
+`,
+			expected: `Package: churnmodel
+Version: 1.0.0
+Imports:
+    dplyr,
+    tidyr (>= 1.3.0),
+    acmehelpers
+Suggests: testthat
+`,
+		},
+		{
+			name: "adds to single-line Imports",
+			input: `Package: churnmodel
+Imports: dplyr, tidyr
+`,
+			expected: `Package: churnmodel
+Imports: dplyr, tidyr, acmehelpers
+`,
+		},
+		{
+			name: "adds Imports after Depends",
+			input: `Package: churnmodel
+Depends:
+    R (>= 4.1)
+License: MIT
+`,
+			expected: `Package: churnmodel
+Depends:
+    R (>= 4.1)
+Imports:
+    acmehelpers
+License: MIT
+`,
+		},
+		{
+			name: "keeps existing import",
+			input: `Package: churnmodel
+Imports: acmehelpers (>= 0.2.0), dplyr
+`,
+			expected: `Package: churnmodel
+Imports: acmehelpers (>= 0.2.0), dplyr
+`,
+		},
+		{
+			name: "keeps package attached through Depends",
+			input: `Package: churnmodel
+Depends: R (>= 4.1), acmehelpers
+`,
+			expected: `Package: churnmodel
+Depends: R (>= 4.1), acmehelpers
+`,
+		},
+	}
+
+	handler := &RHandler{}
+	dir := "../../testdata"
+
+	installTempDir, err := os.MkdirTemp(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(installTempDir); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to remove temp folder: %v\n", err)
+		}
+	}() // Clean up
+
+	packageLocation := filepath.Join(installTempDir, ".upkg", "acmehelpers_0.3.0.tar.gz")
+	writeTestTarGz(t, packageLocation, map[string]string{"acmehelpers/DESCRIPTION": testRDescription})
+	descriptionPath := filepath.Join(installTempDir, "DESCRIPTION")
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if err := os.WriteFile(descriptionPath, []byte(testCase.input), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := handler.UpdatePackageRef("acmehelpers", packageLocation, installTempDir); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			updated, err := os.ReadFile(descriptionPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(updated) != testCase.expected {
+				t.Errorf("expected:\n%s\ngot:\n%s", testCase.expected, updated)
+			}
+		})
+	}
+
+	if !fileExists(filepath.Join(installTempDir, InstallDir, "r-packages", "acmehelpers_0.3.0.tar.gz")) {
+		t.Error("expected package to be stored")
+	}
+}
+
+func TestRUpdatePackageRefRenvLock(t *testing.T) {
+	handler := &RHandler{}
+	dir := "../../testdata"
+
+	installTempDir, err := os.MkdirTemp(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(installTempDir); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to remove temp folder: %v\n", err)
+		}
+	}() // Clean up
+
+	// A project without DESCRIPTION, with an older version of the package installed
+	lock := `{
+  "R": {
+    "Version": "4.3.2",
+    "Repositories": [
+      {
+        "Name": "CRAN",
+        "URL": "https://cloud.r-project.org"
+      }
+    ]
+  },
+  "Packages": {
+    "R6": {
+      "Package": "R6",
+      "Version": "2.5.1",
+      "Source": "Repository",
+      "Repository": "CRAN",
+      "Hash": "470851b6d5d0ac559e9d01bb352b4021"
+    },
+    "acmehelpers": {
+      "Package": "acmehelpers",
+      "Version": "0.2.0",
+      "Source": "Local",
+      "RemoteType": "local",
+      "RemoteUrl": ".universal-packages/r-packages/acmehelpers_0.2.0.tar.gz",
+      "Hash": "00000000000000000000000000000000"
+    },
+    "dplyr": {
+      "Package": "dplyr",
+      "Version": "1.1.4",
+      "Source": "Repository",
+      "Repository": "CRAN",
+      "Requirements": [
+        "R6",
+        "generics"
+      ],
+      "Hash": "fedd9d00c2944ff00a0e2696ccf048ec"
+    }
+  }
+}
+`
+	lockPath := filepath.Join(installTempDir, "renv.lock")
+	if err := os.WriteFile(lockPath, []byte(lock), 0644); err != nil {
+		t.Fatal(err)
+	}
+	oldPackage := filepath.Join(installTempDir, InstallDir, "r-packages", "acmehelpers_0.2.0.tar.gz")
+	writeTestTarGz(t, oldPackage, map[string]string{"acmehelpers/DESCRIPTION": ""})
+	packageLocation := filepath.Join(installTempDir, InstallDir, "acmehelpers", "acmehelpers_0.3.0.tar.gz")
+	writeTestTarGz(t, packageLocation, map[string]string{"acmehelpers/DESCRIPTION": testRDescription + "Remotes: acme/acmeutils\n"})
+
+	if err := handler.UpdatePackageRef("acmehelpers", packageLocation, installTempDir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The hash follows renv's renv_hash_description, over the hashed fields of the DESCRIPTION and every Remote
+	// field, including the RemoteType and RemoteUrl renv adds on install
+	expected := `{
+  "R": {
+    "Version": "4.3.2",
+    "Repositories": [
+      {
+        "Name": "CRAN",
+        "URL": "https://cloud.r-project.org"
+      }
+    ]
+  },
+  "Packages": {
+    "R6": {
+      "Package": "R6",
+      "Version": "2.5.1",
+      "Source": "Repository",
+      "Repository": "CRAN",
+      "Hash": "470851b6d5d0ac559e9d01bb352b4021"
+    },
+    "acmehelpers": {
+      "Package": "acmehelpers",
+      "Version": "0.3.0",
+      "Source": "Local",
+      "RemoteType": "local",
+      "RemoteUrl": ".universal-packages/r-packages/acmehelpers_0.3.0.tar.gz",
+      "Hash": "018dfd74a66fe830274405ea1fa40d20",
+      "Requirements": [
+        "dplyr"
+      ]
+    },
+    "dplyr": {
+      "Package": "dplyr",
+      "Version": "1.1.4",
+      "Source": "Repository",
+      "Repository": "CRAN",
+      "Requirements": [
+        "R6",
+        "generics"
+      ],
+      "Hash": "fedd9d00c2944ff00a0e2696ccf048ec"
+    }
+  }
+}
+`
+
+	updated, err := os.ReadFile(lockPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(updated) != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, updated)
+	}
+	if fileExists(oldPackage) {
+		t.Error("expected previous version to be removed")
+	}
+	if !fileExists(filepath.Join(installTempDir, InstallDir, "r-packages", "acmehelpers_0.3.0.tar.gz")) {
+		t.Error("expected package to be stored")
+	}
+}
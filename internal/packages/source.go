@@ -0,0 +1,254 @@
+package packages
+
+import "strings"
+
+// The helpers in this file edit manifests written in a programming language, such as conanfile.py, mix.exs,
+// MODULE.bazel and Package.swift, as text. They work on a copy of the source with its comments masked out by
+// spaces, so that positions in the copy match positions in the source.
+
+// maskHashComments replaces every # comment in Python, Elixir or Starlark source with spaces, so that positions
+// in the result match positions in content.
+func maskHashComments(content string) string {
+	lines := strings.Split(content, "\n")
+	for i, line := range lines {
+		code := stripRubyComment(line)
+		lines[i] = code + strings.Repeat(" ", len(line)-len(code))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// stripRubyComment removes a trailing # comment (and the whitespace before it) from a line of Ruby.
+func stripRubyComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#':
+			return strings.TrimRight(line[:i], " \t")
+		}
+	}
+	return line
+}
+
+// closingBracket returns the index of the bracket closing the one at code[open], skipping over strings
+// and nested brackets, or -1 if it is unterminated.
+func closingBracket(code string, open int) int {
+	var quote byte
+	depth := 0
+	for i := open; i < len(code); i++ {
+		c := code[i]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[' || c == '{' || c == '(':
+			depth++
+		case c == ']' || c == '}' || c == ')':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// appendListItem adds item as the last element of the bracketed list at code[open:end+1]. In a list spanning
+// several lines, the item gets a line of its own, indented like the first element, and a trailing comma if the
+// last element had one. code is content with its comments masked.
+func appendListItem(content string, code string, open int, end int, item string) string {
+	// The position just past the last element, ignoring comments
+	last := open + 1 + len(strings.TrimRight(code[open+1:end], " \t\r\n"))
+	lineStart := strings.LastIndex(content[:end], "\n") + 1
+	if strings.TrimSpace(content[lineStart:end]) != "" {
+		// The list closes on the line of its last element, e.g. defp deps, do: []
+		if last == open+1 {
+			return content[:last] + item + content[last:]
+		}
+		return content[:last] + ", " + item + content[last:]
+	}
+
+	indent := content[lineStart:end] + "  "
+	if last > open+1 {
+		// Indent like the first element
+		first := end - len(strings.TrimLeft(code[open+1:end], " \t\r\n"))
+		elementLine := content[strings.LastIndex(content[:first], "\n")+1:]
+		indent = elementLine[:len(elementLine)-len(strings.TrimLeft(elementLine, " \t"))]
+		if code[last-1] == ',' {
+			item += ","
+		} else {
+			content = content[:last] + "," + content[last:]
+			lineStart++
+		}
+	}
+	return content[:lineStart] + indent + item + "\n" + content[lineStart:]
+}
+
+// maskSlashComments replaces every // and /* */ comment in Swift source with spaces, so that positions in the
+// result match positions in content.
+func maskSlashComments(content string) string {
+	masked := []byte(content)
+	inString := false
+	for i := 0; i < len(masked); i++ {
+		switch {
+		case inString:
+			if masked[i] == '\\' {
+				i++
+			} else if masked[i] == '"' || masked[i] == '\n' {
+				inString = false
+			}
+		case masked[i] == '"':
+			inString = true
+		case strings.HasPrefix(content[i:], "//"):
+			for ; i < len(masked) && masked[i] != '\n'; i++ {
+				masked[i] = ' '
+			}
+		case strings.HasPrefix(content[i:], "/*"):
+			end := strings.Index(content[i+2:], "*/")
+			if end == -1 {
+				end = len(content)
+			} else {
+				end += i + 4
+			}
+			for ; i < end; i++ {
+				if masked[i] != '\n' {
+					masked[i] = ' '
+				}
+			}
+			i--
+		}
+	}
+	return string(masked)
+}
+
+// maskLuaComments replaces every -- comment in Lua source, such as a rockspec, with spaces, including long
+// comments --[[ ]], so that positions in the result match positions in content.
+func maskLuaComments(content string) string {
+	masked := []byte(content)
+	var quote byte
+	for i := 0; i < len(masked); i++ {
+		switch {
+		case quote != 0:
+			if masked[i] == '\\' {
+				i++
+			} else if masked[i] == quote || masked[i] == '\n' {
+				quote = 0
+			}
+		case masked[i] == '"' || masked[i] == '\'':
+			quote = masked[i]
+		case luaLongBracket(content[i:]) != "":
+			// Long strings are skipped whole, so dashes in them are not taken for comments
+			closing := luaLongBracket(content[i:])
+			if end := strings.Index(content[i+len(closing):], closing); end != -1 {
+				i += len(closing) + end + len(closing) - 1
+			} else {
+				i = len(masked)
+			}
+		case strings.HasPrefix(content[i:], "--"):
+			end := strings.IndexByte(content[i:], '\n')
+			if closing := luaLongBracket(content[i+2:]); closing != "" {
+				end = strings.Index(content[i+2+len(closing):], closing)
+				if end != -1 {
+					end += 2 + 2*len(closing)
+				}
+			}
+			if end == -1 {
+				end = len(content) - i
+			}
+			for j := i; j < i+end; j++ {
+				if masked[j] != '\n' {
+					masked[j] = ' '
+				}
+			}
+			i += end - 1
+		}
+	}
+	return string(masked)
+}
+
+// luaLongBracket returns the bracket closing the Lua long bracket that s starts with, such as ]] for [[ or ]==]
+// for [==[, or "" if s does not start with one.
+func luaLongBracket(s string) string {
+	level := 1
+	for level < len(s) && s[level] == '=' {
+		level++
+	}
+	if !strings.HasPrefix(s, "[") || level >= len(s) || s[level] != '[' {
+		return ""
+	}
+	return "]" + strings.Repeat("=", level-1) + "]"
+}
+
+// sourceSpan is a part of a source file, spanning content[Start:End].
+type sourceSpan struct {
+	Start int
+	End   int
+}
+
+// listItems returns the comma-separated elements of the bracketed list or argument list at code[open:end+1],
+// trimmed of surrounding whitespace.
+func listItems(code string, open int, end int) []sourceSpan {
+	var items []sourceSpan
+	add := func(start int, stop int) {
+		item := strings.TrimSpace(code[start:stop])
+		if item != "" {
+			start += strings.Index(code[start:stop], item)
+			items = append(items, sourceSpan{Start: start, End: start + len(item)})
+		}
+	}
+	var quote byte
+	depth, start := 0, open+1
+	for i := open + 1; i < end; i++ {
+		c := code[i]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[' || c == '{' || c == '(':
+			depth++
+		case c == ']' || c == '}' || c == ')':
+			depth--
+		case c == ',' && depth == 0:
+			add(start, i)
+			start = i + 1
+		}
+	}
+	add(start, end)
+	return items
+}
+
+// labeledArgument finds the argument with the given label ("label: value") among the arguments of the call whose
+// parenthesis is at code[open], closing at code[end]. It returns the positions of the label and of its value, or
+// -1 and -1 if there is no such argument.
+func labeledArgument(code string, open int, end int, label string) (int, int) {
+	for _, item := range listItems(code, open, end) {
+		rest, ok := strings.CutPrefix(code[item.Start:item.End], label)
+		if !ok {
+			continue
+		}
+		value := strings.TrimLeft(rest, " \t\r\n")
+		if strings.HasPrefix(value, ":") {
+			value = strings.TrimLeft(value[1:], " \t\r\n")
+			return item.Start, item.End - len(value)
+		}
+	}
+	return -1, -1
+}
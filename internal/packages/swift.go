@@ -0,0 +1,302 @@
+package packages
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+type SwiftHandler struct{}
+
+var (
+	swiftPackageCall        = regexp.MustCompile(`\bPackage\s*\(`)
+	swiftPackageNamePattern = regexp.MustCompile(`\bPackage\s*\(\s*name\s*:\s*"([^"]+)"`)
+	swiftLibraryPattern     = regexp.MustCompile(`\.library\s*\(\s*name\s*:\s*"([^"]+)"`)
+	swiftTargetPattern      = regexp.MustCompile(`^\.(target|executableTarget)\s*\(`)
+	swiftProductPattern     = regexp.MustCompile(`(\.product\s*\(\s*name\s*:\s*"([^"]*)"\s*,\s*package\s*:\s*)"([^"]*)"`)
+	swiftStringArgPattern   = regexp.MustCompile(`^"([^"]*)"`)
+)
+
+// LocatePackage finds <name>-<version>.tar.gz in the specified directory. If it is not present but the directory
+// holds a Package.swift, the package directory itself is packaged into a temporary archive, leaving out build
+// output and tool state.
+func (s *SwiftHandler) LocatePackage(dir string, packageName string, packageVersion string) (string, error) {
+	filename := fmt.Sprintf("%s-%s.tar.gz", packageName, packageVersion)
+	packagePath := filepath.Join(dir, filename)
+	if fileExists(packagePath) {
+		return packagePath, nil
+	}
+	if !fileExists(filepath.Join(dir, "Package.swift")) {
+		return "", fmt.Errorf("expected package file not found: %s", filename)
+	}
+
+	outDir, err := newOutputDir("swift")
+	if err != nil {
+		return "", err
+	}
+	packagePath = filepath.Join(outDir, filename)
+	if err := createTarGz(dir, packagePath, skipSwiftEntry); err != nil {
+		return "", err
+	}
+	return packagePath, nil
+}
+
+// UpdatePackageRef extracts the package next to the nearest Package.swift and declares it there as a path
+// dependency, replacing an existing dependency on the same package or adding one. The package's library product
+// is added to the dependencies of the first regular or executable target, unless a target already depends on
+// it. As path dependencies are not pinned, the package's pin is removed from Package.resolved.
+func (s *SwiftHandler) UpdatePackageRef(packageName string, packageFilePath string, packageRefFilePath string) error {
+	manifestPath, err := findFileUp(packageRefFilePath, "Package.swift")
+	if err != nil {
+		return fmt.Errorf("finding Package.swift: %w", err)
+	}
+	projectDir := filepath.Dir(manifestPath)
+
+	prefix, manifest, err := readTarGzRootFile(packageFilePath, "Package.swift")
+	if err != nil {
+		return err
+	}
+	product, err := swiftLibraryProduct(string(manifest), packageName)
+	if err != nil {
+		return err
+	}
+
+	// SwiftPM identifies a path dependency by its directory name
+	packageDir := filepath.Join(projectDir, InstallDir, "swift-packages", packageName)
+	strip := 0
+	if prefix != "" {
+		strip = 1
+	}
+	if err := extractTarGz(packageFilePath, packageDir, strip); err != nil {
+		return fmt.Errorf("extracting package: %w", err)
+	}
+	relPath, err := filepath.Rel(projectDir, packageDir)
+	if err != nil {
+		return fmt.Errorf("calculating relative path: %w", err)
+	}
+
+	data, err := os.ReadFile(manifestPath)
+	if err != nil {
+		return err
+	}
+	updated, err := setSwiftPathDependency(string(data), packageName, filepath.ToSlash(relPath), product)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(manifestPath, []byte(updated), 0644); err != nil {
+		return err
+	}
+
+	resolvedPath := filepath.Join(projectDir, "Package.resolved")
+	if !fileExists(resolvedPath) {
+		return nil
+	}
+	resolved, err := os.ReadFile(resolvedPath)
+	if err != nil {
+		return err
+	}
+	updatedResolved, err := removeSwiftPins(resolved, strings.ToLower(packageName))
+	if err != nil {
+		return fmt.Errorf("updating Package.resolved: %w", err)
+	}
+	return os.WriteFile(resolvedPath, updatedResolved, 0644)
+}
+
+// skipSwiftEntry reports whether a file or directory of a package should be left out of its archive.
+func skipSwiftEntry(rel string, entry os.DirEntry) bool {
+	name := path.Base(rel)
+	if entry.IsDir() {
+		return name == ".build" || name == ".swiftpm" || name == ".git" || name == InstallDir
+	}
+	return false
+}
+
+// swiftLibraryProduct returns the library product a project should depend on in the given package manifest:
+// its only library, or else the library named after the package.
+func swiftLibraryProduct(manifest string, packageName string) (string, error) {
+	code := maskSlashComments(manifest)
+	manifestName := ""
+	if match := swiftPackageNamePattern.FindStringSubmatch(code); match != nil {
+		manifestName = match[1]
+	}
+	var libraries []string
+	for _, match := range swiftLibraryPattern.FindAllStringSubmatch(code, -1) {
+		libraries = append(libraries, match[1])
+	}
+	if len(libraries) == 1 {
+		return libraries[0], nil
+	}
+	for _, library := range libraries {
+		if library == manifestName || strings.EqualFold(library, packageName) {
+			return library, nil
+		}
+	}
+	if len(libraries) == 0 {
+		return "", fmt.Errorf("package %s declares no library product", packageName)
+	}
+	return "", fmt.Errorf("package %s declares several library products (%s), none named after the package", packageName, strings.Join(libraries, ", "))
+}
+
+// swiftDependencyIdentity returns the identity SwiftPM gives the package of a .package(...) dependency: the
+// last component of its URL or path, in lower case. Registry dependencies have no such identity.
+func swiftDependencyIdentity(code string) string {
+	open := strings.Index(code, "(")
+	if !strings.HasPrefix(code, ".package") || open == -1 {
+		return ""
+	}
+	end := closingBracket(code, open)
+	if end == -1 {
+		return ""
+	}
+	for _, label := range []string{"url", "path"} {
+		_, valueStart := labeledArgument(code, open, end, label)
+		if valueStart == -1 {
+			continue
+		}
+		match := swiftStringArgPattern.FindStringSubmatch(code[valueStart:])
+		if match == nil {
+			return ""
+		}
+		identity := strings.TrimSuffix(strings.TrimRight(match[1], "/"), ".git")
+		return strings.ToLower(identity[strings.LastIndex(identity, "/")+1:])
+	}
+	return ""
+}
+
+// setSwiftPathDependency declares the package in Package.swift as a path dependency and adds its product to the
+// first target, as described by UpdatePackageRef.
+func setSwiftPathDependency(content string, packageName string, path string, product string) (string, error) {
+	identity := strings.ToLower(packageName)
+	dependency := ".package(path: " + strconv.Quote(path) + ")"
+
+	code := maskSlashComments(content)
+	open, end, err := swiftPackageArguments(code)
+	if err != nil {
+		return "", err
+	}
+	if _, valueStart := labeledArgument(code, open, end, "dependencies"); valueStart != -1 {
+		if code[valueStart] != '[' {
+			return "", fmt.Errorf("dependencies of the package in Package.swift are not an array literal")
+		}
+		listEnd := closingBracket(code, valueStart)
+		replaced := false
+		for _, item := range listItems(code, valueStart, listEnd) {
+			if swiftDependencyIdentity(code[item.Start:item.End]) == identity {
+				content = content[:item.Start] + dependency + content[item.End:]
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			content = appendListItem(content, code, valueStart, listEnd, dependency)
+		}
+	} else {
+		// Package arguments must be in order, and dependencies come just before targets
+		labelStart, _ := labeledArgument(code, open, end, "targets")
+		if labelStart == -1 {
+			return "", fmt.Errorf("no targets found in Package.swift")
+		}
+		line := content[strings.LastIndex(content[:labelStart], "\n")+1 : labelStart]
+		indent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+		if strings.TrimSpace(line) != "" {
+			content = content[:labelStart] + "dependencies: [" + dependency + "], " + content[labelStart:]
+		} else {
+			content = content[:labelStart] + "dependencies: [\n" + indent + "    " + dependency + ",\n" + indent + "],\n" + indent + content[labelStart:]
+		}
+	}
+
+	code = maskSlashComments(content)
+	for _, match := range swiftProductPattern.FindAllStringSubmatch(code, -1) {
+		if match[2] == product && strings.EqualFold(match[3], packageName) {
+			return content, nil
+		}
+	}
+	open, end, err = swiftPackageArguments(code)
+	if err != nil {
+		return "", err
+	}
+	_, valueStart := labeledArgument(code, open, end, "targets")
+	if valueStart == -1 || code[valueStart] != '[' {
+		return "", fmt.Errorf("no targets array found in Package.swift")
+	}
+	productDependency := ".product(name: " + strconv.Quote(product) + ", package: " + strconv.Quote(packageName) + ")"
+	for _, item := range listItems(code, valueStart, closingBracket(code, valueStart)) {
+		target := code[item.Start:item.End]
+		if !swiftTargetPattern.MatchString(target) {
+			continue
+		}
+		targetOpen := item.Start + strings.Index(target, "(")
+		if _, depsStart := labeledArgument(code, targetOpen, item.End-1, "dependencies"); depsStart != -1 && code[depsStart] == '[' {
+			return appendListItem(content, code, depsStart, closingBracket(code, depsStart), productDependency), nil
+		}
+		// Target arguments must be in order, and dependencies come just after the name
+		_, nameStart := labeledArgument(code, targetOpen, item.End-1, "name")
+		if nameStart == -1 || !swiftStringArgPattern.MatchString(code[nameStart:]) {
+			return "", fmt.Errorf("target without a name in Package.swift")
+		}
+		at := nameStart + len(swiftStringArgPattern.FindString(code[nameStart:]))
+		return content[:at] + ", dependencies: [" + productDependency + "]" + content[at:], nil
+	}
+	return "", fmt.Errorf("no target to add product %s to found in Package.swift", product)
+}
+
+// swiftPackageArguments returns the positions of the parentheses around the arguments of the Package
+// initializer in a manifest, which must have its comments masked.
+func swiftPackageArguments(code string) (int, int, error) {
+	loc := swiftPackageCall.FindStringIndex(code)
+	if loc == nil {
+		return 0, 0, fmt.Errorf("no Package found in Package.swift")
+	}
+	end := closingBracket(code, loc[1]-1)
+	if end == -1 {
+		return 0, 0, fmt.Errorf("unterminated Package in Package.swift")
+	}
+	return loc[1] - 1, end, nil
+}
+
+// removeSwiftPins removes the pin of the package with the given identity from a Package.resolved file, in the
+// format of any version. It returns data unchanged if there is none.
+func removeSwiftPins(data []byte, identity string) ([]byte, error) {
+	if !gjson.ValidBytes(data) {
+		return nil, fmt.Errorf("invalid JSON")
+	}
+	pinsPath := "pins"
+	if gjson.GetBytes(data, "version").Int() == 1 {
+		pinsPath = "object.pins"
+	}
+	pins := gjson.GetBytes(data, pinsPath).Array()
+	// Pins are removed from the end so the positions of earlier ones stay valid. The text of a pin is cut out
+	// rather than reformatting the file, as SwiftPM writes JSON with spaces before colons.
+	for i := len(pins) - 1; i >= 0; i-- {
+		pinIdentity := pins[i].Get("identity").String()
+		if pinsPath == "object.pins" {
+			pinIdentity = swiftDependencyIdentity(".package(url: " + strconv.Quote(pins[i].Get("repositoryURL").String()) + ")")
+		}
+		if pinIdentity != identity {
+			continue
+		}
+		start, end := pins[i].Index, pins[i].Index+len(pins[i].Raw)
+		if start <= 0 || end > len(data) || string(data[start:end]) != pins[i].Raw {
+			return nil, fmt.Errorf("locating pin of %s", identity)
+		}
+		if i > 0 {
+			// Remove the separator before the pin along with it
+			start = bytes.LastIndexByte(data[:start], ',')
+		} else if next := bytes.IndexByte(data[end:], ','); next != -1 && len(bytes.TrimSpace(data[end:end+next])) == 0 {
+			// Remove the separator after the first pin, and the whitespace up to the next one
+			end += next + 1
+			end += len(data[end:]) - len(bytes.TrimLeft(data[end:], " \t\r\n"))
+		} else {
+			start = bytes.LastIndexByte(data[:start], '[') + 1
+		}
+		data = append(data[:start:start], data[end:]...)
+	}
+	return data, nil
+}
@@ -0,0 +1,394 @@
+package packages
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testSwiftManifest is the Package.swift of the package used by the tests.
+const testSwiftManifest = `// swift-tools-version:5.9
+import PackageDescription
+
+let package = Package(
+    name: "AcmeKit",
+    products: [
+        .library(name: "AcmeKit", targets: ["AcmeKit"]),
+        .library(name: "AcmeKitTestSupport", targets: ["AcmeKitTestSupport"]),
+    ],
+    targets: [
+        .target(name: "AcmeKit"),
+        .target(name: "AcmeKitTestSupport", dependencies: ["AcmeKit"]),
+    ]
+)
+`
+
+func TestSwiftLocatePackage(t *testing.T) {
+	handler := &SwiftHandler{}
+	dir := "../../testdata"
+
+	tempDir, err := os.MkdirTemp(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tempDir); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to remove temp folder: %v\n", err)
+		}
+	}() // Clean up
+
+	packagePath := filepath.Join(tempDir, "AcmeKit-1.2.0.tar.gz")
+	writeTestTarGz(t, packagePath, map[string]string{"Package.swift": testSwiftManifest})
+
+	packageDir := filepath.Join(tempDir, "AcmeKit")
+	for name, content := range map[string]string{
+		"Package.swift":                    testSwiftManifest,
+		"Sources/AcmeKit/AcmeKit.swift":    "",
+		".build/debug/AcmeKit.swiftmodule": "",
+	} {
+		path := filepath.Join(packageDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	testCases := []struct {
+		name           string
+		dir            string
+		packageVersion string
+		expectedPath   string
+		expectedFiles  []string
+		expectedError  bool
+	}{
+		{
+			name:           "find source archive",
+			dir:            tempDir,
+			packageVersion: "1.2.0",
+			expectedPath:   packagePath,
+		},
+		{
+			name:           "package source directory",
+			dir:            packageDir,
+			packageVersion: "1.3.0",
+			expectedFiles:  []string{"Package.swift", "Sources/AcmeKit/AcmeKit.swift"},
+		},
+		{
+			name:           "fail on non-existing archive",
+			dir:            tempDir,
+			packageVersion: "1.3.0",
+			expectedError:  true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			filePath, err := handler.LocatePackage(testCase.dir, "AcmeKit", testCase.packageVersion)
+			if testCase.expectedError {
+				if err == nil {
+					t.Fatal("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if testCase.expectedPath != "" && filePath != testCase.expectedPath {
+				t.Errorf("expected %s, got %s", testCase.expectedPath, filePath)
+			}
+			if testCase.expectedFiles == nil {
+				return
+			}
+			defer func() {
+				_ = os.RemoveAll(filepath.Dir(filePath))
+			}()
+			extracted := filepath.Join(tempDir, "extracted")
+			if err := extractTarGz(filePath, extracted, 0); err != nil {
+				t.Fatal(err)
+			}
+			for _, name := range testCase.expectedFiles {
+				if !fileExists(filepath.Join(extracted, filepath.FromSlash(name))) {
+					t.Errorf("expected %s in archive", name)
+				}
+			}
+			if fileExists(filepath.Join(extracted, ".build")) {
+				t.Error("expected build output to be left out of archive")
+			}
+		})
+	}
+}
+
+func TestSwiftUpdatePackageRef(t *testing.T) {
+	testCases := []struct {
+		name          string
+		input         string
+		prefix        string
+		expected      string
+		expectedError bool
+	}{
+		{
+			name: "replaces url dependency",
+			input: `// swift-tools-version:5.9
+import PackageDescription
+
+let package = Package(
+    name: "Storefront",
+    dependencies: [
+        .package(url: "https://github.com/apple/swift-log.git", from: "1.5.0"),
+        // Internal
+        .package(url: "https://git.acme.internal/ios/AcmeKit.git", from: "1.1.0"),
+    ],
+    targets: [
+        .executableTarget(
+            name: "Storefront",
+            dependencies: [
+                .product(name: "Logging", package: "swift-log"),
+                .product(name: "AcmeKit", package: "AcmeKit"),
+            ]
+        ),
+    ]
+)
+`,
+			prefix: "AcmeKit-1.2.0/",
+			expected: `// swift-tools-version:5.9
+import PackageDescription
+
+let package = Package(
+    name: "Storefront",
+    dependencies: [
+        .package(url: "https://github.com/apple/swift-log.git", from: "1.5.0"),
+        // Internal
+        .package(path: ".universal-packages/swift-packages/AcmeKit"),
+    ],
+    targets: [
+        .executableTarget(
+            name: "Storefront",
+            dependencies: [
+                .product(name: "Logging", package: "swift-log"),
+                .product(name: "AcmeKit", package: "AcmeKit"),
+            ]
+        ),
+    ]
+)
+`,
+		},
+		{
+			name: "adds dependency and product",
+			input: `// swift-tools-version:5.9
+import PackageDescription
+
+let package = Package(
+    name: "Storefront",
+    dependencies: [
+        .package(url: "https://github.com/apple/swift-log.git", from: "1.5.0")
+    ],
+    targets: [
+        .target(name: "Storefront", dependencies: [.product(name: "Logging", package: "swift-log")]),
+        .testTarget(name: "StorefrontTests", dependencies: ["Storefront"]),
+    ]
+)
+`,
+			expected: `// swift-tools-version:5.9
+import PackageDescription
+
+let package = Package(
+    name: "Storefront",
+    dependencies: [
+        .package(url: "https://github.com/apple/swift-log.git", from: "1.5.0"),
+        .package(path: ".universal-packages/swift-packages/AcmeKit")
+    ],
+    targets: [
+        .target(name: "Storefront", dependencies: [.product(name: "Logging", package: "swift-log"), .product(name: "AcmeKit", package: "AcmeKit")]),
+        .testTarget(name: "StorefrontTests", dependencies: ["Storefront"]),
+    ]
+)
+`,
+		},
+		{
+			name: "adds dependencies before targets",
+			input: `// swift-tools-version:5.9
+import PackageDescription
+
+let package = Package(
+    name: "Storefront",
+    platforms: [.iOS(.v16)],
+    targets: [
+        .target(
+            name: "Storefront",
+            path: "Sources"
+        ),
+    ]
+)
+`,
+			expected: `// swift-tools-version:5.9
+import PackageDescription
+
+let package = Package(
+    name: "Storefront",
+    platforms: [.iOS(.v16)],
+    dependencies: [
+        .package(path: ".universal-packages/swift-packages/AcmeKit"),
+    ],
+    targets: [
+        .target(
+            name: "Storefront", dependencies: [.product(name: "AcmeKit", package: "AcmeKit")],
+            path: "Sources"
+        ),
+    ]
+)
+`,
+		},
+		{
+			name: "fails without targets",
+			input: `// swift-tools-version:5.9
+import PackageDescription
+
+let package = Package(name: "Storefront")
+`,
+			expectedError: true,
+		},
+	}
+
+	handler := &SwiftHandler{}
+	dir := "../../testdata"
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			installTempDir, err := os.MkdirTemp(dir, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				if err := os.RemoveAll(installTempDir); err != nil {
+					fmt.Fprintf(os.Stderr, "warning: failed to remove temp folder: %v\n", err)
+				}
+			}() // Clean up
+
+			manifestPath := filepath.Join(installTempDir, "Package.swift")
+			if err := os.WriteFile(manifestPath, []byte(testCase.input), 0644); err != nil {
+				t.Fatal(err)
+			}
+			packageLocation := filepath.Join(installTempDir, ".upkg", "AcmeKit-1.2.0.tar.gz")
+			writeTestTarGz(t, packageLocation, map[string]string{
+				testCase.prefix + "Package.swift":                 testSwiftManifest,
+				testCase.prefix + "Sources/AcmeKit/AcmeKit.swift": "",
+			})
+
+			err = handler.UpdatePackageRef("AcmeKit", packageLocation, installTempDir)
+			if testCase.expectedError {
+				if err == nil {
+					t.Fatal("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			updated, err := os.ReadFile(manifestPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(updated) != testCase.expected {
+				t.Errorf("expected:\n%s\ngot:\n%s", testCase.expected, updated)
+			}
+			if !fileExists(filepath.Join(installTempDir, InstallDir, "swift-packages", "AcmeKit", "Sources", "AcmeKit", "AcmeKit.swift")) {
+				t.Error("expected package to be extracted")
+			}
+		})
+	}
+}
+
+func TestSwiftUpdatePackageRefResolved(t *testing.T) {
+	handler := &SwiftHandler{}
+	dir := "../../testdata"
+
+	installTempDir, err := os.MkdirTemp(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(installTempDir); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to remove temp folder: %v\n", err)
+		}
+	}() // Clean up
+
+	manifest := `// swift-tools-version:5.9
+import PackageDescription
+
+let package = Package(
+    name: "Storefront",
+    dependencies: [
+        .package(url: "https://git.acme.internal/ios/AcmeKit.git", from: "1.1.0"),
+    ],
+    targets: [
+        .target(name: "Storefront", dependencies: ["AcmeKit"]),
+    ]
+)
+`
+	if err := os.WriteFile(filepath.Join(installTempDir, "Package.swift"), []byte(manifest), 0644); err != nil {
+		t.Fatal(err)
+	}
+	resolved := `{
+  "originHash" : "c2b5e8f1",
+  "pins" : [
+    {
+      "identity" : "acmekit",
+      "kind" : "remoteSourceControl",
+      "location" : "https://git.acme.internal/ios/AcmeKit.git",
+      "state" : {
+        "revision" : "1f2e3d4c",
+        "version" : "1.1.0"
+      }
+    },
+    {
+      "identity" : "swift-log",
+      "kind" : "remoteSourceControl",
+      "location" : "https://github.com/apple/swift-log.git",
+      "state" : {
+        "revision" : "e97a6fcb",
+        "version" : "1.5.4"
+      }
+    }
+  ],
+  "version" : 3
+}
+`
+	resolvedPath := filepath.Join(installTempDir, "Package.resolved")
+	if err := os.WriteFile(resolvedPath, []byte(resolved), 0644); err != nil {
+		t.Fatal(err)
+	}
+	packageLocation := filepath.Join(installTempDir, ".upkg", "AcmeKit-1.2.0.tar.gz")
+	writeTestTarGz(t, packageLocation, map[string]string{"Package.swift": testSwiftManifest})
+
+	if err := handler.UpdatePackageRef("AcmeKit", packageLocation, installTempDir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := `{
+  "originHash" : "c2b5e8f1",
+  "pins" : [
+    {
+      "identity" : "swift-log",
+      "kind" : "remoteSourceControl",
+      "location" : "https://github.com/apple/swift-log.git",
+      "state" : {
+        "revision" : "e97a6fcb",
+        "version" : "1.5.4"
+      }
+    }
+  ],
+  "version" : 3
+}
+`
+	updated, err := os.ReadFile(resolvedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(updated) != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, updated)
+	}
+}
@@ -0,0 +1,352 @@
+package packages
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type TerraformHandler struct{}
+
+var (
+	terraformModuleHeaderPattern = regexp.MustCompile(`(?m)^[ \t]*module[ \t]+"([^"\n]+)"[ \t]*\{`)
+	terraformAttributePattern    = regexp.MustCompile(`^[ \t]*(source|version)[ \t]*=[ \t]*`)
+	hclHeredocPattern            = regexp.MustCompile(`^<<-?([A-Za-z_][A-Za-z0-9_-]*)\r?\n`)
+)
+
+// LocatePackage finds <name>-<version>.tar.gz in the specified directory. If it is not present but the
+// directory holds .tf files, the module directory itself is packaged into a temporary tarball, leaving out
+// Terraform's working files (.terraform/, state and lock files).
+func (t *TerraformHandler) LocatePackage(dir string, packageName string, packageVersion string) (string, error) {
+	filename := fmt.Sprintf("%s-%s.tar.gz", packageName, packageVersion)
+	packagePath := filepath.Join(dir, filename)
+	if fileExists(packagePath) {
+		return packagePath, nil
+	}
+
+	tfFiles, err := filepath.Glob(filepath.Join(dir, "*.tf"))
+	if err != nil || len(tfFiles) == 0 {
+		return "", fmt.Errorf("expected package file not found: %s", filename)
+	}
+
+	outDir, err := newOutputDir("terraform")
+	if err != nil {
+		return "", err
+	}
+	packagePath = filepath.Join(outDir, filename)
+	if err := createTarGz(dir, packagePath, skipTerraformEntry); err != nil {
+		return "", err
+	}
+	return packagePath, nil
+}
+
+// UpdatePackageRef extracts the module into a modules directory under the working directory and points the
+// module blocks named after the package, across the .tf files of the working directory, at it. Blocks that
+// already use the extracted module under a different name are updated too. The version argument is removed,
+// as Terraform does not allow it for local paths. If no module block matches, one is added to main.tf.
+func (t *TerraformHandler) UpdatePackageRef(packageName string, packageFilePath string, packageRefFilePath string) error {
+	tfFiles, err := filepath.Glob(filepath.Join(packageRefFilePath, "*.tf"))
+	if err != nil {
+		return err
+	}
+	if len(tfFiles) == 0 {
+		return fmt.Errorf("no .tf files found in %s", packageRefFilePath)
+	}
+
+	moduleDir := filepath.Join(packageRefFilePath, InstallDir, "terraform-modules", packageName)
+	if err := extractTarGz(packageFilePath, moduleDir, 0); err != nil {
+		return fmt.Errorf("extracting module: %w", err)
+	}
+	source, err := relativeRef(packageRefFilePath, moduleDir)
+	if err != nil {
+		return err
+	}
+
+	updatedAny := false
+	for _, tfFile := range tfFiles {
+		data, err := os.ReadFile(tfFile)
+		if err != nil {
+			return err
+		}
+		updated, ok, err := setTerraformModuleSource(string(data), packageName, source)
+		if err != nil {
+			return fmt.Errorf("updating %s: %w", filepath.Base(tfFile), err)
+		}
+		if !ok {
+			continue
+		}
+		if err := os.WriteFile(tfFile, []byte(updated), 0644); err != nil {
+			return err
+		}
+		updatedAny = true
+	}
+	if updatedAny {
+		return nil
+	}
+
+	mainPath := filepath.Join(packageRefFilePath, "main.tf")
+	data, err := os.ReadFile(mainPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	content := string(data)
+	if content != "" {
+		content = strings.TrimRight(content, "\n") + "\n\n"
+	}
+	content += fmt.Sprintf("module %s {\n  source = %s\n}\n", strconv.Quote(packageName), strconv.Quote(source))
+	return os.WriteFile(mainPath, []byte(content), 0644)
+}
+
+// skipTerraformEntry reports whether a file or directory of a module should be left out of its package.
+func skipTerraformEntry(rel string, entry os.DirEntry) bool {
+	name := path.Base(rel)
+	if entry.IsDir() {
+		return name == ".terraform" || name == ".git" || name == InstallDir
+	}
+	return name == ".terraform.lock.hcl" || strings.HasPrefix(name, "terraform.tfstate") || strings.HasSuffix(name, ".tfstate")
+}
+
+// hclEdit replaces content[Start:End] with Text.
+type hclEdit struct {
+	Start int
+	End   int
+	Text  string
+}
+
+// setTerraformModuleSource sets the source of every module block labelled moduleName, or already using
+// source, and removes their version argument. It reports whether any block was updated.
+func setTerraformModuleSource(content string, moduleName string, source string) (string, bool, error) {
+	var edits []hclEdit
+	for _, loc := range terraformModuleHeaderPattern.FindAllStringSubmatchIndex(content, -1) {
+		open := loc[1] - 1
+		end, err := hclBlockEnd(content, open)
+		if err != nil {
+			return "", false, err
+		}
+
+		label := content[loc[2]:loc[3]]
+		var sourceEdit *hclEdit
+		var blockEdits []hclEdit
+		currentSource := ""
+		for _, lineStart := range hclBlockLines(content, open, end) {
+			match := terraformAttributePattern.FindStringSubmatchIndex(content[lineStart:end])
+			if match == nil {
+				continue
+			}
+			valueStart := lineStart + match[1]
+			valueEnd, err := hclValueEnd(content, valueStart, end-1)
+			if err != nil {
+				return "", false, err
+			}
+			switch content[lineStart+match[2] : lineStart+match[3]] {
+			case "source":
+				currentSource, _ = strconv.Unquote(content[valueStart:valueEnd])
+				sourceEdit = &hclEdit{Start: valueStart, End: valueEnd, Text: strconv.Quote(source)}
+			case "version":
+				lineEnd := strings.IndexByte(content[valueEnd:end], '\n')
+				if lineStart == open+1 || lineEnd == -1 {
+					continue // single-line block
+				}
+				blockEdits = append(blockEdits, hclEdit{Start: lineStart, End: valueEnd + lineEnd + 1})
+			}
+		}
+		if label != moduleName && currentSource != source {
+			continue
+		}
+
+		if sourceEdit == nil {
+			headerStart := strings.LastIndexByte(content[:open], '\n') + 1
+			indent := content[headerStart : headerStart+len(content[headerStart:open])-len(strings.TrimLeft(content[headerStart:open], " \t"))]
+			sourceEdit = &hclEdit{Start: open + 1, End: open + 1, Text: "\n" + indent + "  source = " + strconv.Quote(source)}
+		}
+		edits = append(edits, *sourceEdit)
+		edits = append(edits, blockEdits...)
+	}
+	if len(edits) == 0 {
+		return content, false, nil
+	}
+
+	sort.Slice(edits, func(i, j int) bool { return edits[i].Start > edits[j].Start })
+	for _, edit := range edits {
+		content = content[:edit.Start] + edit.Text + content[edit.End:]
+	}
+	return content, true, nil
+}
+
+// hclBlockLines returns the offsets of the lines directly within the block whose braces are at
+// content[open] and content[end-1], skipping lines that belong to nested blocks or multi-line values.
+// The text following the opening brace counts as the first line.
+func hclBlockLines(content string, open int, end int) []int {
+	lines := []int{open + 1}
+	depth := 0
+	for i := open + 1; i < end-1; i++ {
+		next, err := hclSkip(content, i)
+		if err != nil {
+			return lines
+		}
+		if next != i {
+			if next > i && content[next-1] == '\n' && depth == 0 {
+				lines = append(lines, next)
+			}
+			i = next - 1
+			continue
+		}
+		switch content[i] {
+		case '{', '[', '(':
+			depth++
+		case '}', ']', ')':
+			depth--
+		case '\n':
+			if depth == 0 {
+				lines = append(lines, i+1)
+			}
+		}
+	}
+	return lines
+}
+
+// hclValueEnd returns the offset just past the expression starting at content[valueStart], which ends at
+// the first newline or comment outside of brackets and strings, but not after limit.
+func hclValueEnd(content string, valueStart int, limit int) (int, error) {
+	depth := 0
+	end := valueStart
+	for i := valueStart; i < limit; i++ {
+		if content[i] == '#' || strings.HasPrefix(content[i:], "//") || strings.HasPrefix(content[i:], "/*") {
+			if depth == 0 {
+				return end, nil
+			}
+		}
+		next, err := hclSkip(content, i)
+		if err != nil {
+			return 0, err
+		}
+		if next != i {
+			i = next - 1
+			end = next
+			continue
+		}
+		switch c := content[i]; c {
+		case '{', '[', '(':
+			depth++
+		case '}', ']', ')':
+			depth--
+		case '\n':
+			if depth == 0 {
+				return end, nil
+			}
+		}
+		if c := content[i]; c != ' ' && c != '\t' && c != '\r' && c != '\n' {
+			end = i + 1
+		}
+	}
+	return end, nil
+}
+
+// hclBlockEnd returns the offset just past the closing brace of the block opening at content[open].
+func hclBlockEnd(content string, open int) (int, error) {
+	depth := 0
+	for i := open; i < len(content); i++ {
+		next, err := hclSkip(content, i)
+		if err != nil {
+			return 0, err
+		}
+		if next != i {
+			i = next - 1
+			continue
+		}
+		switch content[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i + 1, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("unterminated block at offset %d", open)
+}
+
+// hclSkip returns the offset just past the string, comment or heredoc starting at content[i],
+// or i if there is none there. Line comments are skipped up to and including their newline.
+func hclSkip(content string, i int) (int, error) {
+	switch {
+	case content[i] == '"':
+		return hclStringEnd(content, i)
+	case content[i] == '#' || strings.HasPrefix(content[i:], "//"):
+		lineEnd := strings.IndexByte(content[i:], '\n')
+		if lineEnd == -1 {
+			return len(content), nil
+		}
+		return i + lineEnd + 1, nil
+	case strings.HasPrefix(content[i:], "/*"):
+		commentEnd := strings.Index(content[i+2:], "*/")
+		if commentEnd == -1 {
+			return 0, fmt.Errorf("unterminated comment at offset %d", i)
+		}
+		return i + 2 + commentEnd + 2, nil
+	case strings.HasPrefix(content[i:], "<<"):
+		match := hclHeredocPattern.FindStringSubmatch(content[i:])
+		if match == nil {
+			return i, nil
+		}
+		offset := i + len(match[0])
+		for offset < len(content) {
+			lineEnd := strings.IndexByte(content[offset:], '\n')
+			if lineEnd == -1 {
+				lineEnd = len(content) - offset
+			}
+			if strings.TrimSpace(content[offset:offset+lineEnd]) == match[1] {
+				return offset + lineEnd, nil
+			}
+			offset += lineEnd + 1
+		}
+		return 0, fmt.Errorf("unterminated heredoc at offset %d", i)
+	}
+	return i, nil
+}
+
+// hclStringEnd returns the offset just past the quoted string starting at content[start], including
+// any ${...} and %{...} template sequences, which may contain strings of their own.
+func hclStringEnd(content string, start int) (int, error) {
+	for i := start + 1; i < len(content); i++ {
+		switch content[i] {
+		case '\\':
+			i++
+		case '\n':
+			return 0, fmt.Errorf("unterminated string at offset %d", start)
+		case '"':
+			return i + 1, nil
+		case '$', '%':
+			// $${ and %%{ are escaped literals
+			if !strings.HasPrefix(content[i+1:], "{") || content[i-1] == content[i] {
+				continue
+			}
+			depth := 0
+			for i++; i < len(content); i++ {
+				if content[i] == '"' {
+					next, err := hclStringEnd(content, i)
+					if err != nil {
+						return 0, err
+					}
+					i = next - 1
+					continue
+				}
+				if content[i] == '{' {
+					depth++
+				} else if content[i] == '}' {
+					depth--
+					if depth == 0 {
+						break
+					}
+				}
+			}
+		}
+	}
+	return 0, fmt.Errorf("unterminated string at offset %d", start)
+}
@@ -0,0 +1,233 @@
+package packages
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTerraformLocatePackage(t *testing.T) {
+	handler := &TerraformHandler{}
+	dir := "../../testdata"
+
+	tempDir, err := os.MkdirTemp(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tempDir); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to remove temp folder: %v\n", err)
+		}
+	}() // Clean up
+
+	moduleDir := filepath.Join(tempDir, "vpc")
+	files := map[string]string{
+		"main.tf":                      "resource \"aws_vpc\" \"this\" {}\n",
+		"modules/subnets/main.tf":      "",
+		".terraform/modules/x.json":    "",
+		".terraform.lock.hcl":          "",
+		"terraform.tfstate":            "",
+		"terraform.tfstate.backup":     "",
+		"examples/simple/variables.tf": "",
+	}
+	for name, content := range files {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(moduleDir, name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(moduleDir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	prebuiltPath := filepath.Join(tempDir, "vpc-1.0.0.tar.gz")
+	writeTestTarGz(t, prebuiltPath, map[string]string{"main.tf": ""})
+
+	t.Run("find prebuilt tarball", func(t *testing.T) {
+		filePath, err := handler.LocatePackage(tempDir, "vpc", "1.0.0")
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if filePath != prebuiltPath {
+			t.Errorf("expected %s, got %s", prebuiltPath, filePath)
+		}
+	})
+
+	t.Run("package module directory", func(t *testing.T) {
+		filePath, err := handler.LocatePackage(moduleDir, "vpc", "1.1.0")
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		defer func() {
+			_ = os.RemoveAll(filepath.Dir(filePath))
+		}()
+		if filepath.Base(filePath) != "vpc-1.1.0.tar.gz" {
+			t.Errorf("unexpected package file name %s", filepath.Base(filePath))
+		}
+
+		extractDir := filepath.Join(tempDir, "extracted")
+		if err := extractTarGz(filePath, extractDir, 0); err != nil {
+			t.Fatal(err)
+		}
+		for name := range files {
+			expected := name == "main.tf" || name == "modules/subnets/main.tf" || name == "examples/simple/variables.tf"
+			if fileExists(filepath.Join(extractDir, name)) != expected {
+				t.Errorf("expected %s to be packaged: %v", name, expected)
+			}
+		}
+	})
+
+	t.Run("fail without .tf files", func(t *testing.T) {
+		if _, err := handler.LocatePackage(filepath.Join(moduleDir, "modules"), "vpc", "1.1.0"); err == nil {
+			t.Fatal("expected error, got nil")
+		}
+	})
+}
+
+func TestTerraformUpdatePackageRef(t *testing.T) {
+	testCases := []struct {
+		name     string
+		input    map[string]string
+		expected map[string]string
+	}{
+		{
+			name: "rewrites registry source and drops version",
+			input: map[string]string{
+				"main.tf": `module "vpc" {
+  source  = "terraform-aws-modules/vpc/aws" # public module
+  version = "~> 5.0"
+
+  name = "main"
+  tags = {
+    source  = "unchanged"
+    version = "unchanged"
+  }
+}
+
+module "other" {
+  source = "./other"
+}
+`,
+				"network.tf": `module "vpc_secondary" {
+  source = "git::https://example.com/vpc.git?ref=v1"
+}
+`,
+			},
+			expected: map[string]string{
+				"main.tf": `module "vpc" {
+  source  = "./.universal-packages/terraform-modules/vpc" # public module
+
+  name = "main"
+  tags = {
+    source  = "unchanged"
+    version = "unchanged"
+  }
+}
+
+module "other" {
+  source = "./other"
+}
+`,
+				"network.tf": `module "vpc_secondary" {
+  source = "git::https://example.com/vpc.git?ref=v1"
+}
+`,
+			},
+		},
+		{
+			name: "updates blocks across files",
+			input: map[string]string{
+				"main.tf": `locals {
+  description = "module \"vpc\" { ${var.x == "a" ? "}" : "{"}"
+}
+`,
+				"network.tf": `# module "vpc" is declared here
+module "vpc" { source = "../vpc" }
+`,
+				"peering.tf": `module "peer_vpc" {
+  source = "./.universal-packages/terraform-modules/vpc"
+  cidr   = <<-EOT
+    }
+  EOT
+}
+`,
+			},
+			expected: map[string]string{
+				"main.tf": `locals {
+  description = "module \"vpc\" { ${var.x == "a" ? "}" : "{"}"
+}
+`,
+				"network.tf": `# module "vpc" is declared here
+module "vpc" { source = "./.universal-packages/terraform-modules/vpc" }
+`,
+				"peering.tf": `module "peer_vpc" {
+  source = "./.universal-packages/terraform-modules/vpc"
+  cidr   = <<-EOT
+    }
+  EOT
+}
+`,
+			},
+		},
+		{
+			name: "adds module block to main.tf",
+			input: map[string]string{
+				"main.tf": `terraform {
+  required_version = ">= 1.5"
+}
+`,
+			},
+			expected: map[string]string{
+				"main.tf": `terraform {
+  required_version = ">= 1.5"
+}
+
+module "vpc" {
+  source = "./.universal-packages/terraform-modules/vpc"
+}
+`,
+			},
+		},
+	}
+
+	handler := &TerraformHandler{}
+	dir := "../../testdata"
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			installTempDir, err := os.MkdirTemp(dir, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				if err := os.RemoveAll(installTempDir); err != nil {
+					fmt.Fprintf(os.Stderr, "warning: failed to remove temp folder: %v\n", err)
+				}
+			}() // Clean up
+
+			packageLocation := filepath.Join(installTempDir, ".upkg", "vpc-1.1.0.tar.gz")
+			writeTestTarGz(t, packageLocation, map[string]string{"main.tf": "", "variables.tf": ""})
+			for name, content := range testCase.input {
+				if err := os.WriteFile(filepath.Join(installTempDir, name), []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := handler.UpdatePackageRef("vpc", packageLocation, installTempDir); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			for name, expected := range testCase.expected {
+				updated, err := os.ReadFile(filepath.Join(installTempDir, name))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(updated) != expected {
+					t.Errorf("%s: expected:\n%s\ngot:\n%s", name, expected, updated)
+				}
+			}
+			if !fileExists(filepath.Join(installTempDir, InstallDir, "terraform-modules", "vpc", "variables.tf")) {
+				t.Error("expected module to be extracted")
+			}
+		})
+	}
+}
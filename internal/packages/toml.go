@@ -0,0 +1,241 @@
+package packages
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// The helpers in this file do just enough TOML scanning to edit manifests in place,
+// so that comments, ordering and formatting of the rest of the file are preserved.
+
+var tomlHeaderPattern = regexp.MustCompile(`^\s*\[`)
+
+// tomlString is a string literal found while scanning a TOML value.
+// Start and End are byte offsets of the literal including its quotes.
+type tomlString struct {
+	Value string
+	Start int
+	End   int
+}
+
+// tomlTableBody returns the byte offsets of the body of the named table, from the end of its
+// header line to the start of the next table header (or the end of the content).
+func tomlTableBody(content string, table string) (int, int, bool) {
+	header := regexp.MustCompile(`^\s*\[\s*` + regexp.QuoteMeta(table) + `\s*\]\s*(#.*)?$`)
+	start := -1
+	offset := 0
+	for _, line := range strings.SplitAfter(content, "\n") {
+		trimmed := strings.TrimRight(line, "\r\n")
+		if start == -1 {
+			if header.MatchString(trimmed) {
+				start = offset + len(line)
+			}
+		} else if tomlHeaderPattern.MatchString(trimmed) {
+			return start, offset, true
+		}
+		offset += len(line)
+	}
+	if start == -1 {
+		return 0, 0, false
+	}
+	return start, len(content), true
+}
+
+// tomlKeyValue finds the value of key within content[start:end]. It returns the offset of the first
+// character of the value, or -1 if the key is not present.
+func tomlKeyValue(content string, start int, end int, key string) int {
+	pattern := regexp.MustCompile(`(?m)^[ \t]*` + regexp.QuoteMeta(key) + `[ \t]*=[ \t]*`)
+	loc := pattern.FindStringIndex(content[start:end])
+	if loc == nil {
+		return -1
+	}
+	return start + loc[1]
+}
+
+// scanTOMLArray scans the array opening at content[open] and returns the top-level string
+// items it contains along with the offset of the closing bracket.
+func scanTOMLArray(content string, open int) ([]tomlString, int, error) {
+	if open >= len(content) || content[open] != '[' {
+		return nil, 0, fmt.Errorf("expected array at offset %d", open)
+	}
+	var items []tomlString
+	depth := 0
+	for i := open + 1; i < len(content); i++ {
+		switch c := content[i]; c {
+		case '#':
+			for i < len(content) && content[i] != '\n' {
+				i++
+			}
+		case '"', '\'':
+			item, err := scanTOMLString(content, i)
+			if err != nil {
+				return nil, 0, err
+			}
+			if depth == 0 {
+				items = append(items, item)
+			}
+			i = item.End - 1
+		case '[', '{':
+			depth++
+		case ']', '}':
+			if depth == 0 {
+				return items, i, nil
+			}
+			depth--
+		}
+	}
+	return nil, 0, fmt.Errorf("unterminated array at offset %d", open)
+}
+
+// scanTOMLString scans the single-line basic or literal string starting at content[start].
+func scanTOMLString(content string, start int) (tomlString, error) {
+	quote := content[start]
+	for i := start + 1; i < len(content); i++ {
+		switch content[i] {
+		case '\\':
+			if quote == '"' {
+				i++
+			}
+		case '\n':
+			return tomlString{}, fmt.Errorf("unterminated string at offset %d", start)
+		case quote:
+			raw := content[start : i+1]
+			value := raw[1 : len(raw)-1]
+			if quote == '"' {
+				if unquoted, err := strconv.Unquote(raw); err == nil {
+					value = unquoted
+				}
+			}
+			return tomlString{Value: value, Start: start, End: i + 1}, nil
+		}
+	}
+	return tomlString{}, fmt.Errorf("unterminated string at offset %d", start)
+}
+
+// setTOMLArrayString replaces the first item of the array opening at content[open] for which
+// match returns true with value, or appends value to the array if no item matches.
+// Multi-line arrays get the new item on its own line, indented like the existing items.
+func setTOMLArrayString(content string, open int, value string, match func(string) bool) (string, error) {
+	items, closeIdx, err := scanTOMLArray(content, open)
+	if err != nil {
+		return "", err
+	}
+	quoted := strconv.Quote(value)
+
+	for _, item := range items {
+		if match(item.Value) {
+			return content[:item.Start] + quoted + content[item.End:], nil
+		}
+	}
+
+	if len(items) == 0 {
+		return content[:closeIdx] + quoted + content[closeIdx:], nil
+	}
+
+	last := items[len(items)-1]
+	between := content[last.End:closeIdx]
+	hasComma := strings.HasPrefix(strings.TrimSpace(between), ",")
+
+	lineStart := strings.LastIndex(content[:closeIdx], "\n") + 1
+	if strings.TrimSpace(content[lineStart:closeIdx]) != "" || lineStart <= open {
+		// Closing bracket shares a line with the items: keep the array inline.
+		if hasComma {
+			comma := last.End + strings.Index(between, ",")
+			return content[:comma+1] + " " + quoted + content[comma+1:], nil
+		}
+		return content[:last.End] + ", " + quoted + content[last.End:], nil
+	}
+
+	lastLineStart := strings.LastIndex(content[:last.Start], "\n") + 1
+	indent := content[lastLineStart:last.Start]
+	if strings.TrimSpace(indent) != "" {
+		indent = content[lineStart:closeIdx] + "    "
+	}
+	updated := content[:lineStart] + indent + quoted + ",\n" + content[lineStart:]
+	if !hasComma {
+		updated = updated[:last.End] + "," + updated[last.End:]
+	}
+	return updated, nil
+}
+
+// tomlValueEnd returns the offset just past the value starting at content[valueStart],
+// excluding any trailing whitespace and comment.
+func tomlValueEnd(content string, valueStart int) (int, error) {
+	if valueStart >= len(content) {
+		return valueStart, nil
+	}
+	switch content[valueStart] {
+	case '"', '\'':
+		s, err := scanTOMLString(content, valueStart)
+		if err != nil {
+			return 0, err
+		}
+		return s.End, nil
+	case '[', '{':
+		depth := 0
+		for i := valueStart; i < len(content); i++ {
+			switch content[i] {
+			case '#':
+				for i < len(content) && content[i] != '\n' {
+					i++
+				}
+			case '"', '\'':
+				s, err := scanTOMLString(content, i)
+				if err != nil {
+					return 0, err
+				}
+				i = s.End - 1
+			case '[', '{':
+				depth++
+			case ']', '}':
+				depth--
+				if depth == 0 {
+					return i + 1, nil
+				}
+			}
+		}
+		return 0, fmt.Errorf("unterminated value at offset %d", valueStart)
+	}
+
+	lineEnd := strings.IndexByte(content[valueStart:], '\n')
+	if lineEnd == -1 {
+		lineEnd = len(content) - valueStart
+	}
+	value := content[valueStart : valueStart+lineEnd]
+	if i := strings.IndexByte(value, '#'); i != -1 {
+		value = value[:i]
+	}
+	return valueStart + len(strings.TrimRight(value, " \t\r")), nil
+}
+
+// setTOMLTableKey sets key to the raw TOML value in the named table. An existing value is replaced in place,
+// a missing key is added after the last entry of the table, and a missing table is appended to the document.
+func setTOMLTableKey(content string, table string, key string, value string) (string, error) {
+	start, end, ok := tomlTableBody(content, table)
+	if !ok {
+		if content != "" && !strings.HasSuffix(content, "\n") {
+			content += "\n"
+		}
+		if content != "" {
+			content += "\n"
+		}
+		return content + "[" + table + "]\n" + key + " = " + value + "\n", nil
+	}
+
+	if valueStart := tomlKeyValue(content, start, end, key); valueStart != -1 {
+		valueEnd, err := tomlValueEnd(content, valueStart)
+		if err != nil {
+			return "", err
+		}
+		return content[:valueStart] + value + content[valueEnd:], nil
+	}
+
+	body := strings.TrimRight(content[start:end], " \t\r\n")
+	if body == "" {
+		return content[:start] + key + " = " + value + "\n" + content[start:], nil
+	}
+	insertAt := start + len(body)
+	return content[:insertAt] + "\n" + key + " = " + value + content[insertAt:], nil
+}
@@ -0,0 +1,143 @@
+package packages
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+// findNpmWorkspaceRoot finds the root of the npm, Yarn or pnpm workspace containing dir, starting from dir and
+// moving up the directory tree, and returns it with the workspace's package patterns. pnpm workspaces are
+// declared in pnpm-workspace.yaml; npm and Yarn workspaces in the "workspaces" field of package.json.
+func findNpmWorkspaceRoot(dir string) (string, []string, error) {
+	for {
+		if data, err := os.ReadFile(filepath.Join(dir, "pnpm-workspace.yaml")); err == nil {
+			return dir, pnpmWorkspacePatterns(string(data)), nil
+		}
+		if data, err := os.ReadFile(filepath.Join(dir, "package.json")); err == nil {
+			workspaces := gjson.GetBytes(data, "workspaces")
+			if workspaces.IsObject() {
+				// Yarn 1 also accepts {"packages": [...], "nohoist": [...]}
+				workspaces = workspaces.Get("packages")
+			}
+			if workspaces.IsArray() {
+				var patterns []string
+				for _, pattern := range workspaces.Array() {
+					patterns = append(patterns, pattern.String())
+				}
+				return dir, patterns, nil
+			}
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", nil, fmt.Errorf("no npm, Yarn or pnpm workspace found")
+		}
+		dir = parent
+	}
+}
+
+// pnpmWorkspacePatterns returns the package patterns listed under packages in pnpm-workspace.yaml.
+func pnpmWorkspacePatterns(content string) []string {
+	lines := strings.Split(content, "\n")
+	idx := yamlFindKey(lines, 0, len(lines), 0, "packages")
+	if idx == -1 {
+		return nil
+	}
+	var patterns []string
+	for _, item := range yamlSequenceItems(lines, idx+1, yamlBlockEnd(lines, idx)) {
+		patterns = append(patterns, yamlScalar(strings.TrimPrefix(strings.TrimSpace(lines[item.Start]), "-")))
+	}
+	return patterns
+}
+
+// findNpmWorkspacePackage returns the package.json of the workspace package with the given name or directory
+// (relative to the workspace root), among the packages of the workspace at root matching patterns.
+func findNpmWorkspacePackage(root string, patterns []string, workspace string) (string, error) {
+	var include, exclude []*regexp.Regexp
+	for _, pattern := range patterns {
+		if negated, ok := strings.CutPrefix(pattern, "!"); ok {
+			exclude = append(exclude, workspaceGlobPattern(negated))
+		} else {
+			include = append(include, workspaceGlobPattern(pattern))
+		}
+	}
+	wantDir := path.Clean(filepath.ToSlash(workspace))
+
+	found := ""
+	err := filepath.WalkDir(root, func(p string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.IsDir() {
+			return err
+		}
+		name := entry.Name()
+		if p != root && (name == "node_modules" || name == InstallDir || strings.HasPrefix(name, ".")) {
+			return filepath.SkipDir
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		data, err := os.ReadFile(filepath.Join(p, "package.json"))
+		if err != nil {
+			return nil
+		}
+		if rel != "." && (!matchesAny(include, rel) || matchesAny(exclude, rel)) {
+			return nil
+		}
+		if gjson.GetBytes(data, "name").String() == workspace || (rel != "." && rel == wantDir) {
+			found = filepath.Join(p, "package.json")
+			return filepath.SkipAll
+		}
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("searching workspace packages: %w", err)
+	}
+	if found == "" {
+		return "", fmt.Errorf("workspace %q not found in %s", workspace, root)
+	}
+	return found, nil
+}
+
+// workspaceGlobPattern converts a workspace package glob such as "packages/*" or "apps/**" into a regular
+// expression matching slash-separated directories relative to the workspace root.
+func workspaceGlobPattern(glob string) *regexp.Regexp {
+	glob = strings.TrimSuffix(strings.TrimPrefix(path.Clean(glob), "./"), "/")
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; {
+		case strings.HasPrefix(glob[i:], "**/"):
+			b.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.MustCompile(b.String())
+}
+
+// matchesAny reports whether s matches any of the patterns.
+func matchesAny(patterns []*regexp.Regexp, s string) bool {
+	for _, pattern := range patterns {
+		if pattern.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,168 @@
+package packages
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestNpmWorkspaceUpdatePackageRef(t *testing.T) {
+	testCases := []struct {
+		name              string
+		files             map[string]string
+		workspace         string
+		workingDir        string
+		expectedPkgJSON   string
+		expectedDepRange  string
+		expectedStoredTgz bool
+		expectedError     bool
+	}{
+		{
+			name: "pnpm workspace by name",
+			files: map[string]string{
+				"package.json":                 `{"name": "monorepo", "private": true}`,
+				"pnpm-workspace.yaml":          "packages:\n  - 'packages/*'\n  - \"!packages/legacy\"\n",
+				"packages/web/package.json":    `{"name": "@shop/web", "dependencies": {"react": "^18.0.0"}}`,
+				"packages/legacy/package.json": `{"name": "@shop/web-legacy"}`,
+			},
+			workspace:         "@shop/web",
+			expectedPkgJSON:   "packages/web/package.json",
+			expectedDepRange:  "file:../../.universal-packages/acme/acme-sdk-1.1.0.tgz",
+			expectedStoredTgz: false,
+		},
+		{
+			name: "npm workspaces by directory",
+			files: map[string]string{
+				"package.json":               `{"name": "monorepo", "workspaces": ["apps/**"]}`,
+				"apps/admin/ui/package.json": `{"name": "admin-ui"}`,
+			},
+			workspace:         "./apps/admin/ui/",
+			expectedPkgJSON:   "apps/admin/ui/package.json",
+			expectedDepRange:  "file:../../../.universal-packages/acme/acme-sdk-1.1.0.tgz",
+			expectedStoredTgz: false,
+		},
+		{
+			name: "Yarn workspaces object form from a member directory",
+			files: map[string]string{
+				"package.json":           `{"name": "monorepo", "workspaces": {"packages": ["libs/*"], "nohoist": ["**/react"]}}`,
+				"libs/core/package.json": `{"name": "core"}`,
+				"libs/ui/package.json":   `{"name": "ui"}`,
+			},
+			workspace:         "ui",
+			workingDir:        "libs/core",
+			expectedPkgJSON:   "libs/ui/package.json",
+			expectedDepRange:  "file:../../.universal-packages/npm-packages/acme-sdk-1.1.0.tgz",
+			expectedStoredTgz: true,
+		},
+		{
+			name: "excluded workspace",
+			files: map[string]string{
+				"pnpm-workspace.yaml":          "packages:\n  - packages/*\n  - '!packages/legacy'\n",
+				"packages/legacy/package.json": `{"name": "legacy"}`,
+			},
+			workspace:     "legacy",
+			expectedError: true,
+		},
+		{
+			name: "no workspace",
+			files: map[string]string{
+				"package.json": `{"name": "app"}`,
+			},
+			workspace:     "app",
+			expectedError: true,
+		},
+	}
+
+	handler := &NpmHandler{}
+	dir := "../../testdata"
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			installTempDir, err := os.MkdirTemp(dir, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				if err := os.RemoveAll(installTempDir); err != nil {
+					fmt.Fprintf(os.Stderr, "warning: failed to remove temp folder: %v\n", err)
+				}
+			}() // Clean up
+
+			for name, content := range testCase.files {
+				path := filepath.Join(installTempDir, filepath.FromSlash(name))
+				if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			workingDir := filepath.Join(installTempDir, filepath.FromSlash(testCase.workingDir))
+			packageLocation := filepath.Join(workingDir, InstallDir, "acme", "acme-sdk-1.1.0.tgz")
+			writeTestTarGz(t, packageLocation, map[string]string{
+				"package/package.json": `{"name": "@acme/sdk", "version": "1.1.0"}`,
+			})
+
+			// Installing twice must give the same result
+			for i := 0; i < 2; i++ {
+				err = handler.UpdatePackageRefWithOptions("@acme/sdk", packageLocation, workingDir, InstallOptions{Workspace: testCase.workspace})
+				if testCase.expectedError {
+					if err == nil {
+						t.Fatal("expected an error, got nil")
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+
+			var pkgJSON map[string]any
+			data, err := os.ReadFile(filepath.Join(installTempDir, filepath.FromSlash(testCase.expectedPkgJSON)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := json.Unmarshal(data, &pkgJSON); err != nil {
+				t.Fatalf("invalid package.json: %v", err)
+			}
+			deps, _ := pkgJSON["dependencies"].(map[string]any)
+			if !reflect.DeepEqual(deps["@acme/sdk"], testCase.expectedDepRange) {
+				t.Errorf("expected dependency %q, got %v", testCase.expectedDepRange, deps["@acme/sdk"])
+			}
+
+			storedTgz := filepath.Join(installTempDir, InstallDir, "npm-packages", "acme-sdk-1.1.0.tgz")
+			if fileExists(storedTgz) != testCase.expectedStoredTgz {
+				t.Errorf("expected tarball copied to workspace root: %v", testCase.expectedStoredTgz)
+			}
+		})
+	}
+}
+
+func TestWorkspaceGlobPattern(t *testing.T) {
+	testCases := []struct {
+		glob     string
+		dir      string
+		expected bool
+	}{
+		{glob: "packages/*", dir: "packages/web", expected: true},
+		{glob: "packages/*", dir: "packages/web/src", expected: false},
+		{glob: "./packages/*/", dir: "packages/web", expected: true},
+		{glob: "apps/**", dir: "apps/admin/ui", expected: true},
+		{glob: "**/ui", dir: "ui", expected: true},
+		{glob: "**/ui", dir: "apps/admin/ui", expected: true},
+		{glob: "lib?", dir: "lib1", expected: true},
+		{glob: "lib?", dir: "lib10", expected: false},
+		{glob: "tools", dir: "tools", expected: true},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.glob+" "+testCase.dir, func(t *testing.T) {
+			if got := workspaceGlobPattern(testCase.glob).MatchString(testCase.dir); got != testCase.expected {
+				t.Errorf("expected %v, got %v", testCase.expected, got)
+			}
+		})
+	}
+}
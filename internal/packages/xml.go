@@ -0,0 +1,193 @@
+package packages
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+// The helpers in this file edit XML documents as text rather than round-tripping them through
+// encoding/xml, so that formatting, comments and attribute order are preserved.
+
+// xmlElement describes the location of an element within a document.
+// Start/End span the whole element, InnerStart/InnerEnd span its content.
+// For self-closing elements InnerStart == InnerEnd == End.
+type xmlElement struct {
+	Start      int
+	InnerStart int
+	InnerEnd   int
+	End        int
+}
+
+// SelfClosing reports whether the element was written as <name ... />.
+func (e xmlElement) SelfClosing() bool {
+	return e.InnerEnd == e.End
+}
+
+// findXMLElement finds the first element with the given tag name in content[from:to].
+// Elements of the same name nested inside it are not supported.
+func findXMLElement(content string, name string, from int, to int) (xmlElement, bool) {
+	return findXMLElementMatching(content, name, from, to, func(string) bool { return true })
+}
+
+// findXMLElementMatching finds the first element with the given tag name in content[from:to]
+// whose opening tag satisfies match.
+func findXMLElementMatching(content string, name string, from int, to int, match func(openTag string) bool) (xmlElement, bool) {
+	open := regexp.MustCompile(`<` + regexp.QuoteMeta(name) + `(\s[^>]*)?>`)
+	for _, loc := range open.FindAllStringIndex(content[from:to], -1) {
+		start, openEnd := from+loc[0], from+loc[1]
+		if !match(content[start:openEnd]) {
+			continue
+		}
+		if content[openEnd-2] == '/' {
+			return xmlElement{Start: start, InnerStart: openEnd, InnerEnd: openEnd, End: openEnd}, true
+		}
+
+		closeTag := "</" + name + ">"
+		closeIdx := strings.Index(content[openEnd:to], closeTag)
+		if closeIdx == -1 {
+			return xmlElement{}, false
+		}
+		innerEnd := openEnd + closeIdx
+		return xmlElement{Start: start, InnerStart: openEnd, InnerEnd: innerEnd, End: innerEnd + len(closeTag)}, true
+	}
+	return xmlElement{}, false
+}
+
+// xmlAttribute returns the unescaped value of attr in the given opening tag.
+func xmlAttribute(openTag string, attr string) (string, bool) {
+	attrPattern := regexp.MustCompile(`\s` + regexp.QuoteMeta(attr) + `\s*=\s*("[^"]*"|'[^']*')`)
+	m := attrPattern.FindStringSubmatch(openTag)
+	if m == nil {
+		return "", false
+	}
+	return html.UnescapeString(m[1][1 : len(m[1])-1]), true
+}
+
+// xmlChildText returns the trimmed, unescaped text of the first child element with the given name.
+func xmlChildText(content string, element xmlElement, name string) string {
+	child, ok := findXMLElement(content, name, element.InnerStart, element.InnerEnd)
+	if !ok {
+		return ""
+	}
+	return html.UnescapeString(strings.TrimSpace(content[child.InnerStart:child.InnerEnd]))
+}
+
+// findXMLChildMatching finds the first child element of parent with the given name that satisfies match.
+func findXMLChildMatching(content string, parent xmlElement, name string, match func(xmlElement) bool) (xmlElement, bool) {
+	for from := parent.InnerStart; ; {
+		e, ok := findXMLElement(content, name, from, parent.InnerEnd)
+		if !ok {
+			return xmlElement{}, false
+		}
+		if match(e) {
+			return e, true
+		}
+		from = e.End
+	}
+}
+
+// setXMLChildText sets the text of the named child element, adding the child if it does not exist.
+func setXMLChildText(content string, element xmlElement, name string, text string) string {
+	escaped := html.EscapeString(text)
+	if child, ok := findXMLElement(content, name, element.InnerStart, element.InnerEnd); ok && !child.SelfClosing() {
+		return content[:child.InnerStart] + escaped + content[child.InnerEnd:]
+	}
+	return insertXMLChild(content, element, "<"+name+">"+escaped+"</"+name+">")
+}
+
+// hasXMLAttribute returns a matcher for opening tags whose attr equals value, ignoring case.
+func hasXMLAttribute(attr string, value string) func(string) bool {
+	return func(openTag string) bool {
+		v, ok := xmlAttribute(openTag, attr)
+		return ok && strings.EqualFold(v, value)
+	}
+}
+
+// insertXMLChild appends child as the last child of element, on its own line and indented
+// to match its siblings (or one level deeper than the element if it has none).
+// Continuation lines of a multi-line child are indented relative to its first line.
+func insertXMLChild(content string, element xmlElement, child string) string {
+	if element.SelfClosing() {
+		name := regexp.MustCompile(`^<([^\s/>]+)`).FindStringSubmatch(content[element.Start:element.End])[1]
+		indent := lineIndent(content, element.Start)
+		childIndent := indent + xmlIndentUnit(content)
+		expanded := "<" + name + ">\n" + childIndent + indentLines(child, childIndent) + "\n" + indent + "</" + name + ">"
+		return content[:element.Start] + expanded + content[element.End:]
+	}
+
+	closeIndent := lineIndent(content, element.InnerEnd)
+	inner := content[element.InnerStart:element.InnerEnd]
+	if !strings.Contains(inner, "\n") {
+		// <name>...</name> on a single line: break it open
+		parentIndent := lineIndent(content, element.Start)
+		childIndent := parentIndent + xmlIndentUnit(content)
+		newInner := "\n"
+		if strings.TrimSpace(inner) != "" {
+			newInner += childIndent + strings.TrimSpace(inner) + "\n"
+		}
+		newInner += childIndent + indentLines(child, childIndent) + "\n" + parentIndent
+		return content[:element.InnerStart] + newInner + content[element.InnerEnd:]
+	}
+
+	childIndent := closeIndent + xmlIndentUnit(content)
+	lines := strings.Split(strings.TrimRight(inner, " \t"), "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		if strings.TrimSpace(lines[i]) != "" {
+			childIndent = lines[i][:len(lines[i])-len(strings.TrimLeft(lines[i], " \t"))]
+			break
+		}
+	}
+
+	// Insert before the indentation of the closing tag so it stays on its own line
+	closeLineStart := element.InnerEnd - len(closeIndent)
+	if strings.TrimSpace(content[closeLineStart:element.InnerEnd]) != "" || (closeLineStart > 0 && content[closeLineStart-1] != '\n') {
+		return content[:element.InnerEnd] + "\n" + childIndent + indentLines(child, childIndent) + "\n" + closeIndent + content[element.InnerEnd:]
+	}
+	return content[:closeLineStart] + childIndent + indentLines(child, childIndent) + "\n" + content[closeLineStart:]
+}
+
+// setXMLAttribute sets the value of attr on the opening tag starting at content[tagStart],
+// adding the attribute before the end of the tag if it is not present.
+func setXMLAttribute(content string, tagStart int, attr string, value string) string {
+	tagEnd := tagStart + strings.Index(content[tagStart:], ">")
+	tag := content[tagStart:tagEnd]
+	escaped := html.EscapeString(value)
+
+	attrPattern := regexp.MustCompile(`(\s` + regexp.QuoteMeta(attr) + `\s*=\s*)("[^"]*"|'[^']*')`)
+	if loc := attrPattern.FindStringSubmatchIndex(tag); loc != nil {
+		return content[:tagStart+loc[4]] + `"` + escaped + `"` + content[tagStart+loc[5]:]
+	}
+
+	insertAt := tagEnd
+	if strings.HasSuffix(tag, "/") {
+		insertAt--
+	}
+	for insertAt > tagStart && (content[insertAt-1] == ' ' || content[insertAt-1] == '\t') {
+		insertAt--
+	}
+	return content[:insertAt] + " " + attr + `="` + escaped + `"` + content[insertAt:]
+}
+
+// indentLines prefixes every line of text after the first with indent.
+func indentLines(text string, indent string) string {
+	return strings.ReplaceAll(text, "\n", "\n"+indent)
+}
+
+// lineIndent returns the leading whitespace of the line containing content[offset].
+func lineIndent(content string, offset int) string {
+	lineStart := strings.LastIndex(content[:offset], "\n") + 1
+	line := content[lineStart:]
+	return line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+}
+
+// xmlIndentUnit guesses the indentation used by the document, defaulting to two spaces.
+func xmlIndentUnit(content string) string {
+	for _, line := range strings.Split(content, "\n") {
+		trimmed := strings.TrimLeft(line, " \t")
+		if trimmed != "" && len(trimmed) < len(line) && strings.HasPrefix(trimmed, "<") {
+			return line[:len(line)-len(trimmed)]
+		}
+	}
+	return "  "
+}
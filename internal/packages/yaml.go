@@ -0,0 +1,252 @@
+package packages
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// The helpers in this file edit block-style YAML documents line by line, so that comments, ordering and
+// formatting of the rest of the document are preserved. Flow collections and multi-line scalars are only
+// ever skipped over, never edited.
+
+var (
+	yamlPlainScalarPattern = regexp.MustCompile(`^[A-Za-z_./][A-Za-z0-9_./+@~:-]*$|^[0-9]+\.[0-9]+\.[0-9][A-Za-z0-9_.+-]*$`)
+	yamlReservedPattern    = regexp.MustCompile(`^(?i:true|false|yes|no|on|off|y|n|null|~)$`)
+)
+
+// yamlItem is an entry of a block sequence, spanning lines[Start:End].
+type yamlItem struct {
+	Start int
+	End   int
+}
+
+// yamlIndent returns the number of leading spaces of line.
+func yamlIndent(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
+
+// yamlIsBlank reports whether line holds no content, only whitespace or a comment.
+func yamlIsBlank(line string) bool {
+	trimmed := strings.TrimSpace(line)
+	return trimmed == "" || strings.HasPrefix(trimmed, "#")
+}
+
+// yamlFindKey returns the index of the line within lines[from:to] that holds key at the given indent, or -1.
+func yamlFindKey(lines []string, from int, to int, indent int, key string) int {
+	for i := from; i < to; i++ {
+		if k, _, ok := yamlKeyValue(lines[i], indent); ok && k == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// yamlKeyValue splits a "key: value" line at the given indent (optionally after a "- " sequence marker
+// starting at indent-2) into its key and raw value.
+func yamlKeyValue(line string, indent int) (string, string, bool) {
+	if yamlIsBlank(line) || len(line) <= indent {
+		return "", "", false
+	}
+	prefix, rest := line[:indent], line[indent:]
+	if strings.TrimLeft(prefix, " ") != "" && !strings.HasSuffix(strings.TrimRight(prefix, " "), "-") {
+		return "", "", false
+	}
+	if rest[0] == ' ' || rest[0] == '-' || rest[0] == '#' {
+		return "", "", false
+	}
+	i := strings.Index(rest, ":")
+	if rest[0] == '"' || rest[0] == '\'' {
+		// Quoted keys may contain colons
+		i = yamlQuotedEnd(rest)
+		if i == -1 || i >= len(rest) || rest[i] != ':' {
+			return "", "", false
+		}
+	}
+	if i <= 0 || (i+1 < len(rest) && rest[i+1] != ' ') {
+		return "", "", false
+	}
+	return yamlUnquote(rest[:i]), strings.TrimSpace(rest[i+1:]), true
+}
+
+// yamlBlockEnd returns the index just past the block that belongs to the key at lines[keyIdx],
+// not counting trailing blank and comment lines. Sequences written at the key's own indentation
+// ("key:\n- item") belong to the block.
+func yamlBlockEnd(lines []string, keyIdx int) int {
+	indent := yamlIndent(lines[keyIdx])
+	end := keyIdx + 1
+	for i := keyIdx + 1; i < len(lines); i++ {
+		if yamlIsBlank(lines[i]) {
+			continue
+		}
+		lineIndent := yamlIndent(lines[i])
+		if lineIndent < indent || (lineIndent == indent && !strings.HasPrefix(lines[i][lineIndent:], "-")) {
+			break
+		}
+		end = i + 1
+	}
+	return end
+}
+
+// yamlSequenceItems returns the items of the block sequence in lines[from:to].
+func yamlSequenceItems(lines []string, from int, to int) []yamlItem {
+	var items []yamlItem
+	dashIndent := -1
+	for i := from; i < to; i++ {
+		if yamlIsBlank(lines[i]) {
+			continue
+		}
+		indent := yamlIndent(lines[i])
+		isItem := strings.HasPrefix(lines[i][indent:], "-") && (len(lines[i]) == indent+1 || lines[i][indent+1] == ' ')
+		if dashIndent == -1 && isItem {
+			dashIndent = indent
+		}
+		if isItem && indent == dashIndent {
+			if len(items) > 0 {
+				items[len(items)-1].End = yamlTrimBlankTail(lines, items[len(items)-1].Start, i)
+			}
+			items = append(items, yamlItem{Start: i, End: to})
+		}
+	}
+	if len(items) > 0 {
+		items[len(items)-1].End = yamlTrimBlankTail(lines, items[len(items)-1].Start, to)
+	}
+	return items
+}
+
+// yamlItemKeyIndent returns the indentation of the keys of a mapping sequence item ("- key: value").
+func yamlItemKeyIndent(lines []string, item yamlItem) int {
+	line := lines[item.Start]
+	dash := yamlIndent(line)
+	return dash + 1 + len(line[dash+1:]) - len(strings.TrimLeft(line[dash+1:], " "))
+}
+
+// yamlItemValue returns the line index and unquoted scalar value of key within a mapping sequence item.
+func yamlItemValue(lines []string, item yamlItem, key string) (int, string) {
+	indent := yamlItemKeyIndent(lines, item)
+	idx := yamlFindKey(lines, item.Start, item.End, indent, key)
+	if idx == -1 {
+		return -1, ""
+	}
+	_, value, _ := yamlKeyValue(lines[idx], indent)
+	return idx, yamlScalar(value)
+}
+
+// setYAMLItemValue sets key to value within a mapping sequence item, replacing the existing value
+// (keeping any trailing comment) or adding the key as the item's last line.
+func setYAMLItemValue(lines []string, item yamlItem, key string, value string) []string {
+	indent := yamlItemKeyIndent(lines, item)
+	if idx := yamlFindKey(lines, item.Start, item.End, indent, key); idx != -1 {
+		lines[idx] = setYAMLLineValue(lines[idx], value)
+		return lines
+	}
+	return yamlInsertLines(lines, item.End, strings.Repeat(" ", indent)+key+": "+yamlQuote(value))
+}
+
+// setYAMLLineValue replaces the scalar value of a "key: value" line, keeping any trailing comment.
+func setYAMLLineValue(line string, value string) string {
+	colon := strings.Index(line, ":")
+	rest := line[colon+1:]
+	comment := ""
+	if i := yamlCommentIndex(rest); i != -1 {
+		comment = " " + strings.TrimSpace(rest[i:])
+	}
+	return line[:colon+1] + " " + yamlQuote(value) + comment
+}
+
+// yamlQuotedEnd returns the index just past the quoted scalar at the start of s, or -1 if it is unterminated.
+func yamlQuotedEnd(s string) int {
+	quote := s[0]
+	for i := 1; i < len(s); i++ {
+		switch {
+		case quote == '"' && s[i] == '\\':
+			i++
+		case quote == '\'' && s[i] == '\'' && i+1 < len(s) && s[i+1] == '\'':
+			i++
+		case s[i] == quote:
+			return i + 1
+		}
+	}
+	return -1
+}
+
+// yamlCommentIndex returns the index of a trailing " #" comment in a raw value, ignoring quoted text.
+func yamlCommentIndex(value string) int {
+	var quote byte
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || value[i-1] == ' ' || value[i-1] == '\t'):
+			return i
+		}
+	}
+	return -1
+}
+
+// yamlScalar returns the unquoted value of a raw scalar, without any trailing comment.
+func yamlScalar(raw string) string {
+	if i := yamlCommentIndex(raw); i != -1 {
+		raw = raw[:i]
+	}
+	return yamlUnquote(strings.TrimSpace(raw))
+}
+
+// yamlUnquote removes single or double quotes around a scalar.
+func yamlUnquote(s string) string {
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		if unquoted, err := strconv.Unquote(s); err == nil {
+			return unquoted
+		}
+	}
+	if len(s) >= 2 && s[0] == '\'' && s[len(s)-1] == '\'' {
+		return strings.ReplaceAll(s[1:len(s)-1], "''", "'")
+	}
+	return s
+}
+
+// yamlQuote returns s as a YAML scalar, quoting it unless it is unambiguously a plain string.
+// Versions such as "1.0" are quoted so they are not read as numbers.
+func yamlQuote(s string) string {
+	if yamlPlainScalarPattern.MatchString(s) && !yamlReservedPattern.MatchString(s) && !strings.HasSuffix(s, ":") {
+		return s
+	}
+	return strconv.Quote(s)
+}
+
+// yamlInsertLines inserts new lines before lines[at].
+func yamlInsertLines(lines []string, at int, newLines ...string) []string {
+	updated := make([]string, 0, len(lines)+len(newLines))
+	updated = append(updated, lines[:at]...)
+	updated = append(updated, newLines...)
+	return append(updated, lines[at:]...)
+}
+
+// yamlTrimBlankTail moves end back over blank and comment lines, but not before start+1.
+func yamlTrimBlankTail(lines []string, start int, end int) int {
+	for end > start+1 && yamlIsBlank(lines[end-1]) {
+		end--
+	}
+	return end
+}
+
+// yamlChildIndent returns the indentation of the entries of the mapping under the key at lines[keyIdx],
+// or the key's indentation plus defaultStep if it has none.
+func yamlChildIndent(lines []string, keyIdx int, defaultStep int) int {
+	indent := yamlIndent(lines[keyIdx])
+	for i := keyIdx + 1; i < len(lines); i++ {
+		if yamlIsBlank(lines[i]) {
+			continue
+		}
+		if childIndent := yamlIndent(lines[i]); childIndent > indent {
+			return childIndent
+		}
+		break
+	}
+	return indent + defaultStep
+}
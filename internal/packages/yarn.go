@@ -0,0 +1,376 @@
+package packages
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Yarn 2+ ("Berry") projects cannot use file: tarball dependencies without the lockfile holding a checksum of
+// Yarn's own cache archive, so pulled packages are extracted and linked with the portal: protocol instead.
+// Portal lockfile entries carry no checksum, which lets yarn.lock be brought up to date without running Yarn.
+
+var (
+	yarnBerryLockPattern = regexp.MustCompile(`(?m)^__metadata:`)
+	yarnProtocolPattern  = regexp.MustCompile(`^[a-z][a-z0-9+.-]*:`)
+)
+
+// npmManifest holds the fields of a package.json needed to describe a package in a lockfile.
+type npmManifest struct {
+	Name                 string                           `json:"name"`
+	Version              string                           `json:"version"`
+	Dependencies         map[string]string                `json:"dependencies"`
+	OptionalDependencies map[string]string                `json:"optionalDependencies"`
+	PeerDependencies     map[string]string                `json:"peerDependencies"`
+	PeerDependenciesMeta map[string]npmPeerDependencyMeta `json:"peerDependenciesMeta"`
+	Bin                  json.RawMessage                  `json:"bin"`
+	License              string                           `json:"license"`
+	Engines              json.RawMessage                  `json:"engines"`
+}
+
+// npmPeerDependencyMeta is an entry of peerDependenciesMeta.
+type npmPeerDependencyMeta struct {
+	Optional bool `json:"optional"`
+}
+
+// yarnLockEntry is a top-level entry of a Berry yarn.lock, spanning lines[Start:End].
+type yarnLockEntry struct {
+	Start       int
+	End         int
+	Descriptors []string
+}
+
+// yarnBerryLockfile returns the path of the yarn.lock of the Yarn 2+ project containing dir, and whether
+// there is one. The project root is the nearest directory holding a yarn.lock or .yarnrc.yml; Yarn 1
+// lockfiles have no __metadata. The lockfile of a new project may not exist yet.
+func yarnBerryLockfile(dir string) (string, bool) {
+	for {
+		lockPath := filepath.Join(dir, "yarn.lock")
+		if data, err := os.ReadFile(lockPath); err == nil {
+			return lockPath, yarnBerryLockPattern.Match(data)
+		}
+		if fileExists(filepath.Join(dir, ".yarnrc.yml")) {
+			return lockPath, true
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", false
+		}
+		dir = parent
+	}
+}
+
+// extractYarnPortal extracts the package tarball under the install directory of storeDir and returns the
+// portal: range that refers to it from package.json.
+func extractYarnPortal(storeDir string, pkgJSONPath string, packageName string, packageFilePath string) (string, error) {
+	portalDir := filepath.Join(storeDir, InstallDir, "yarn-portals", filepath.FromSlash(packageName))
+	// npm tarballs contain a single package/ directory
+	if err := extractTarGz(packageFilePath, portalDir, 1); err != nil {
+		return "", fmt.Errorf("extracting package: %w", err)
+	}
+	relPath, err := relativeRef(filepath.Dir(pkgJSONPath), portalDir)
+	if err != nil {
+		return "", err
+	}
+	return "portal:" + relPath, nil
+}
+
+// updateYarnLockfile records the portal dependency of the workspace at pkgJSONPath in yarn.lock: the workspace
+// entry's dependency is updated, a lock entry for the portal is added, and the entry the dependency previously
+// resolved to is dropped if nothing else uses it.
+func updateYarnLockfile(lockPath string, pkgJSONPath string, packageName string, portalRange string) error {
+	data, err := os.ReadFile(lockPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var workspace, portal npmManifest
+	if err := readNpmManifest(pkgJSONPath, &workspace); err != nil {
+		return err
+	}
+	portalDir := filepath.Join(filepath.Dir(pkgJSONPath), filepath.FromSlash(strings.TrimPrefix(portalRange, "portal:")))
+	if err := readNpmManifest(filepath.Join(portalDir, "package.json"), &portal); err != nil {
+		return err
+	}
+
+	cwd, err := filepath.Rel(filepath.Dir(lockPath), filepath.Dir(pkgJSONPath))
+	if err != nil {
+		return fmt.Errorf("calculating relative path: %w", err)
+	}
+	updated := updateYarnLock(string(data), filepath.ToSlash(cwd), workspace.Name, packageName, portalRange, portal)
+	return os.WriteFile(lockPath, []byte(updated), 0644)
+}
+
+// readNpmManifest reads the package.json at path into manifest.
+func readNpmManifest(path string, manifest *npmManifest) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return nil
+}
+
+// updateYarnLock applies the edits described by updateYarnLockfile to the content of a Berry yarn.lock.
+func updateYarnLock(content string, cwd string, workspaceName string, packageName string, portalRange string, portal npmManifest) string {
+	lines := strings.Split(content, "\n")
+	lockVersion := 0
+	if idx := yamlFindKey(lines, 0, len(lines), 0, "__metadata"); idx != -1 {
+		if versionIdx := yamlFindKey(lines, idx+1, yamlBlockEnd(lines, idx), 2, "version"); versionIdx != -1 {
+			_, raw, _ := yamlKeyValue(lines[versionIdx], 2)
+			lockVersion, _ = strconv.Atoi(yamlScalar(raw))
+		}
+	}
+
+	// Point the workspace at the portal
+	locator := workspaceName + "@workspace:" + cwd
+	oldRange := ""
+	for _, entry := range yarnLockEntries(lines) {
+		idx := yamlFindKey(lines, entry.Start+1, entry.End, 2, "resolution")
+		if idx == -1 {
+			continue
+		}
+		_, raw, _ := yamlKeyValue(lines[idx], 2)
+		resolution := yamlScalar(raw)
+		if !strings.HasSuffix(resolution, "@workspace:"+cwd) {
+			continue
+		}
+		locator = resolution
+		lines, oldRange = setYarnLockDependency(lines, entry, packageName, portalRange)
+		break
+	}
+
+	// Drop the entry the workspace used to resolve the package to, unless another package depends on it
+	if oldRange != "" && oldRange != portalRange && !yarnLockReferences(lines, packageName, oldRange) {
+		stale := packageName + "@" + oldRange
+		if !yarnProtocolPattern.MatchString(oldRange) {
+			stale = packageName + "@npm:" + oldRange
+		}
+		lines = removeYarnLockDescriptor(lines, stale)
+	}
+
+	descriptor := packageName + "@" + portalRange + "::locator=" + encodeURIComponent(locator)
+	entryLines := yarnPortalEntry(descriptor, portal, lockVersion)
+	entries := yarnLockEntries(lines)
+	for _, entry := range entries {
+		for _, d := range entry.Descriptors {
+			if d == descriptor {
+				updated := append(append(append([]string{}, lines[:entry.Start]...), entryLines...), lines[entry.End:]...)
+				return strings.Join(updated, "\n")
+			}
+		}
+	}
+	at := len(lines)
+	for _, entry := range entries {
+		if entry.Descriptors[0] > descriptor {
+			at = entry.Start
+			break
+		}
+	}
+	if at == len(lines) {
+		// Append after the last entry, which ends with a newline
+		for at > 0 && strings.TrimSpace(lines[at-1]) == "" {
+			at--
+		}
+		return strings.Join(yamlInsertLines(lines, at, append([]string{""}, entryLines...)...), "\n")
+	}
+	return strings.Join(yamlInsertLines(lines, at, append(entryLines, "")...), "\n")
+}
+
+// yarnLockEntries returns the package entries of a Berry yarn.lock in order.
+func yarnLockEntries(lines []string) []yarnLockEntry {
+	var entries []yarnLockEntry
+	for i := 0; i < len(lines); i++ {
+		key, _, ok := yamlKeyValue(lines[i], 0)
+		if !ok || key == "__metadata" {
+			continue
+		}
+		end := yamlBlockEnd(lines, i)
+		entries = append(entries, yarnLockEntry{Start: i, End: end, Descriptors: strings.Split(key, ", ")})
+		i = end - 1
+	}
+	return entries
+}
+
+// setYarnLockDependency sets a dependency of a lock entry, keeping the dependencies sorted,
+// and returns the previous range.
+func setYarnLockDependency(lines []string, entry yarnLockEntry, name string, value string) ([]string, string) {
+	newLine := "    " + yarnLockQuote(name) + ": " + yarnLockQuote(value)
+
+	depsIdx := yamlFindKey(lines, entry.Start+1, entry.End, 2, "dependencies")
+	if depsIdx == -1 {
+		at := entry.Start + 1
+		if idx := yamlFindKey(lines, entry.Start+1, entry.End, 2, "resolution"); idx != -1 {
+			at = idx + 1
+		}
+		return yamlInsertLines(lines, at, "  dependencies:", newLine), ""
+	}
+
+	end := yamlBlockEnd(lines, depsIdx)
+	for i := depsIdx + 1; i < end; i++ {
+		key, raw, ok := yamlKeyValue(lines[i], 4)
+		if !ok {
+			continue
+		}
+		if key == name {
+			lines[i] = newLine
+			return lines, yamlScalar(raw)
+		}
+		if key > name {
+			return yamlInsertLines(lines, i, newLine), ""
+		}
+	}
+	return yamlInsertLines(lines, end, newLine), ""
+}
+
+// yarnLockReferences reports whether any lock entry depends on the package with the given range.
+func yarnLockReferences(lines []string, name string, depRange string) bool {
+	for _, line := range lines {
+		if key, raw, ok := yamlKeyValue(line, 4); ok && key == name && yamlScalar(raw) == depRange {
+			return true
+		}
+	}
+	return false
+}
+
+// removeYarnLockDescriptor removes a descriptor (and bound variants of it) from the key of its lock entry,
+// removing the entry entirely if it has no other descriptors.
+func removeYarnLockDescriptor(lines []string, descriptor string) []string {
+	for _, entry := range yarnLockEntries(lines) {
+		var kept []string
+		for _, d := range entry.Descriptors {
+			if d != descriptor && !strings.HasPrefix(d, descriptor+"::") {
+				kept = append(kept, d)
+			}
+		}
+		if len(kept) == len(entry.Descriptors) {
+			continue
+		}
+		if len(kept) > 0 {
+			lines[entry.Start] = yarnLockQuote(strings.Join(kept, ", ")) + ":"
+			return lines
+		}
+		end := entry.End
+		if end < len(lines) && strings.TrimSpace(lines[end]) == "" {
+			end++
+		}
+		return append(lines[:entry.Start], lines[end:]...)
+	}
+	return lines
+}
+
+// yarnPortalEntry returns the lock entry for a portal package, with its fields in the order Yarn writes them.
+func yarnPortalEntry(descriptor string, manifest npmManifest, lockVersion int) []string {
+	lines := []string{
+		yarnLockQuote(descriptor) + ":",
+		"  version: 0.0.0-use.local",
+		"  resolution: " + yarnLockQuote(descriptor),
+	}
+
+	dependencies := map[string]string{}
+	optional := map[string]bool{}
+	for name, depRange := range manifest.Dependencies {
+		dependencies[name] = depRange
+	}
+	for name, depRange := range manifest.OptionalDependencies {
+		dependencies[name] = depRange
+		optional[name] = true
+	}
+	for name, depRange := range dependencies {
+		if lockVersion >= 8 && !yarnProtocolPattern.MatchString(depRange) {
+			dependencies[name] = "npm:" + depRange
+		}
+	}
+	peerOptional := map[string]bool{}
+	for name, meta := range manifest.PeerDependenciesMeta {
+		if meta.Optional {
+			peerOptional[name] = true
+		}
+	}
+
+	lines = append(lines, yarnLockMap("dependencies", dependencies)...)
+	lines = append(lines, yarnLockMap("peerDependencies", manifest.PeerDependencies)...)
+	lines = append(lines, yarnLockOptionalMap("dependenciesMeta", optional)...)
+	lines = append(lines, yarnLockOptionalMap("peerDependenciesMeta", peerOptional)...)
+	lines = append(lines, yarnLockMap("bin", npmBinaries(manifest))...)
+	return append(lines, "  languageName: node", "  linkType: soft")
+}
+
+// yarnLockMap returns the lines of a sorted map field of a lock entry, or nothing if it is empty.
+func yarnLockMap(field string, values map[string]string) []string {
+	if len(values) == 0 {
+		return nil
+	}
+	lines := []string{"  " + field + ":"}
+	for _, name := range sortedKeys(values) {
+		lines = append(lines, "    "+yarnLockQuote(name)+": "+yarnLockQuote(values[name]))
+	}
+	return lines
+}
+
+// yarnLockOptionalMap returns the lines of a dependency meta field marking the given packages optional.
+func yarnLockOptionalMap(field string, optional map[string]bool) []string {
+	if len(optional) == 0 {
+		return nil
+	}
+	lines := []string{"  " + field + ":"}
+	for _, name := range sortedKeys(optional) {
+		lines = append(lines, "    "+yarnLockQuote(name)+":", "      optional: true")
+	}
+	return lines
+}
+
+// npmBinaries returns the executables declared by a package.json bin field, which is either a path
+// (named after the package) or a map of names to paths.
+func npmBinaries(manifest npmManifest) map[string]string {
+	var path string
+	if json.Unmarshal(manifest.Bin, &path) == nil && path != "" {
+		name := manifest.Name
+		if i := strings.LastIndex(name, "/"); i != -1 {
+			name = name[i+1:]
+		}
+		return map[string]string{name: path}
+	}
+	var binaries map[string]string
+	_ = json.Unmarshal(manifest.Bin, &binaries)
+	return binaries
+}
+
+// yarnLockQuote returns s as a yarn.lock scalar, quoting it unless Yarn would write it plain.
+func yarnLockQuote(s string) string {
+	if s == "" || strings.ContainsAny(s[:1], "-?:,][{}#&*!|>'\"%@` ") || strings.ContainsAny(s, ",][{}:#\t\r\n") || strings.HasSuffix(s, " ") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// encodeURIComponent escapes s like JavaScript's encodeURIComponent.
+func encodeURIComponent(s string) string {
+	escaped := strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+	for encoded, char := range map[string]string{"%21": "!", "%27": "'", "%28": "(", "%29": ")", "%2A": "*"} {
+		escaped = strings.ReplaceAll(escaped, encoded, char)
+	}
+	return escaped
+}
+
+// sortedKeys returns the keys of m in ascending order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
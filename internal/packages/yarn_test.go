@@ -0,0 +1,238 @@
+package packages
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testYarnLockHeader = `# This file is generated by running "yarn install" inside your project.
+# Manual changes might be lost - proceed with caution!
+
+__metadata:
+  version: 8
+  cacheKey: 10c0
+`
+
+func TestYarnBerryUpdatePackageRef(t *testing.T) {
+	testCases := []struct {
+		name            string
+		yarnrc          bool
+		inputLock       string
+		expectedLock    string
+		expectedPkgJSON string
+	}{
+		{
+			name: "replaces npm dependency with portal",
+			inputLock: testYarnLockHeader + `
+"@acme/sdk@npm:^1.0.0":
+  version: 1.0.3
+  resolution: "@acme/sdk@npm:1.0.3"
+  dependencies:
+    lodash: "npm:^4.17.21"
+  checksum: 10c0/aaaa
+  languageName: node
+  linkType: hard
+
+"lodash@npm:^4.17.21":
+  version: 4.17.21
+  resolution: "lodash@npm:4.17.21"
+  checksum: 10c0/bbbb
+  languageName: node
+  linkType: hard
+
+"storefront@workspace:.":
+  version: 0.0.0-use.local
+  resolution: "storefront@workspace:."
+  dependencies:
+    "@acme/sdk": "npm:^1.0.0"
+    lodash: "npm:^4.17.21"
+  languageName: unknown
+  linkType: soft
+`,
+			expectedLock: testYarnLockHeader + `
+"@acme/sdk@portal:./.universal-packages/yarn-portals/@acme/sdk::locator=storefront%40workspace%3A.":
+  version: 0.0.0-use.local
+  resolution: "@acme/sdk@portal:./.universal-packages/yarn-portals/@acme/sdk::locator=storefront%40workspace%3A."
+  dependencies:
+    lodash: "npm:^4.17.21"
+  peerDependencies:
+    react: ">=18"
+  peerDependenciesMeta:
+    react:
+      optional: true
+  bin:
+    sdk: bin/cli.js
+  languageName: node
+  linkType: soft
+
+"lodash@npm:^4.17.21":
+  version: 4.17.21
+  resolution: "lodash@npm:4.17.21"
+  checksum: 10c0/bbbb
+  languageName: node
+  linkType: hard
+
+"storefront@workspace:.":
+  version: 0.0.0-use.local
+  resolution: "storefront@workspace:."
+  dependencies:
+    "@acme/sdk": "portal:./.universal-packages/yarn-portals/@acme/sdk"
+    lodash: "npm:^4.17.21"
+  languageName: unknown
+  linkType: soft
+`,
+			expectedPkgJSON: `{"name": "storefront", "dependencies": {"@acme/sdk": "portal:./.universal-packages/yarn-portals/@acme/sdk"}}`,
+		},
+		{
+			name: "adds workspace dependencies and keeps entries still in use",
+			inputLock: testYarnLockHeader + `
+"@acme/sdk@npm:^1.0.0":
+  version: 1.0.3
+  resolution: "@acme/sdk@npm:1.0.3"
+  checksum: 10c0/aaaa
+  languageName: node
+  linkType: hard
+
+"storefront@workspace:.":
+  version: 0.0.0-use.local
+  resolution: "storefront@workspace:."
+  languageName: unknown
+  linkType: soft
+
+"widgets@npm:2.0.0":
+  version: 2.0.0
+  resolution: "widgets@npm:2.0.0"
+  dependencies:
+    "@acme/sdk": "npm:^1.0.0"
+  checksum: 10c0/cccc
+  languageName: node
+  linkType: hard
+`,
+			expectedLock: testYarnLockHeader + `
+"@acme/sdk@npm:^1.0.0":
+  version: 1.0.3
+  resolution: "@acme/sdk@npm:1.0.3"
+  checksum: 10c0/aaaa
+  languageName: node
+  linkType: hard
+
+"@acme/sdk@portal:./.universal-packages/yarn-portals/@acme/sdk::locator=storefront%40workspace%3A.":
+  version: 0.0.0-use.local
+  resolution: "@acme/sdk@portal:./.universal-packages/yarn-portals/@acme/sdk::locator=storefront%40workspace%3A."
+  dependencies:
+    lodash: "npm:^4.17.21"
+  peerDependencies:
+    react: ">=18"
+  peerDependenciesMeta:
+    react:
+      optional: true
+  bin:
+    sdk: bin/cli.js
+  languageName: node
+  linkType: soft
+
+"storefront@workspace:.":
+  version: 0.0.0-use.local
+  resolution: "storefront@workspace:."
+  dependencies:
+    "@acme/sdk": "portal:./.universal-packages/yarn-portals/@acme/sdk"
+  languageName: unknown
+  linkType: soft
+
+"widgets@npm:2.0.0":
+  version: 2.0.0
+  resolution: "widgets@npm:2.0.0"
+  dependencies:
+    "@acme/sdk": "npm:^1.0.0"
+  checksum: 10c0/cccc
+  languageName: node
+  linkType: hard
+`,
+			expectedPkgJSON: `{"name": "storefront", "dependencies": {"@acme/sdk": "portal:./.universal-packages/yarn-portals/@acme/sdk"}}`,
+		},
+		{
+			name:            "uses portal before the first install",
+			yarnrc:          true,
+			expectedPkgJSON: `{"name": "storefront", "dependencies": {"@acme/sdk": "portal:./.universal-packages/yarn-portals/@acme/sdk"}}`,
+		},
+		{
+			name:            "keeps file reference for Yarn 1",
+			inputLock:       "# THIS IS AN AUTOGENERATED FILE. DO NOT EDIT THIS FILE DIRECTLY.\n# yarn lockfile v1\n",
+			expectedLock:    "# THIS IS AN AUTOGENERATED FILE. DO NOT EDIT THIS FILE DIRECTLY.\n# yarn lockfile v1\n",
+			expectedPkgJSON: `{"name": "storefront", "dependencies": {"@acme/sdk": "file:.upkg/acme-sdk-1.1.0.tgz"}}`,
+		},
+	}
+
+	handler := &NpmHandler{}
+	dir := "../../testdata"
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			installTempDir, err := os.MkdirTemp(dir, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				if err := os.RemoveAll(installTempDir); err != nil {
+					fmt.Fprintf(os.Stderr, "warning: failed to remove temp folder: %v\n", err)
+				}
+			}() // Clean up
+
+			packageLocation := filepath.Join(installTempDir, ".upkg", "acme-sdk-1.1.0.tgz")
+			writeTestTarGz(t, packageLocation, map[string]string{
+				"package/package.json": `{
+  "name": "@acme/sdk",
+  "version": "1.1.0",
+  "bin": "bin/cli.js",
+  "dependencies": {"lodash": "^4.17.21"},
+  "peerDependencies": {"react": ">=18"},
+  "peerDependenciesMeta": {"react": {"optional": true}}
+}`,
+				"package/bin/cli.js": "",
+			})
+			pkgJSONPath := filepath.Join(installTempDir, "package.json")
+			if err := os.WriteFile(pkgJSONPath, []byte(`{"name": "storefront", "dependencies": {"@acme/sdk": "^1.0.0"}}`), 0644); err != nil {
+				t.Fatal(err)
+			}
+			lockPath := filepath.Join(installTempDir, "yarn.lock")
+			if testCase.inputLock != "" {
+				if err := os.WriteFile(lockPath, []byte(testCase.inputLock), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if testCase.yarnrc {
+				if err := os.WriteFile(filepath.Join(installTempDir, ".yarnrc.yml"), []byte("nodeLinker: pnp\n"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// Installing twice must give the same result
+			for i := 0; i < 2; i++ {
+				if err := handler.UpdatePackageRef("@acme/sdk", packageLocation, installTempDir); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+
+			pkgJSON, err := os.ReadFile(pkgJSONPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(pkgJSON) != testCase.expectedPkgJSON {
+				t.Errorf("expected package.json:\n%s\ngot:\n%s", testCase.expectedPkgJSON, pkgJSON)
+			}
+			if testCase.expectedLock != "" {
+				lock, err := os.ReadFile(lockPath)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(lock) != testCase.expectedLock {
+					t.Errorf("expected yarn.lock:\n%s\ngot:\n%s", testCase.expectedLock, lock)
+				}
+			} else if fileExists(lockPath) {
+				t.Error("expected no yarn.lock to be created")
+			}
+		})
+	}
+}
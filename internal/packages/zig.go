@@ -0,0 +1,424 @@
+package packages
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type ZigHandler struct{}
+
+var (
+	zigFieldPattern      = regexp.MustCompile(`^\.(@"(?:[^"\\]|\\.)*"|[A-Za-z_][A-Za-z0-9_]*)\s*=`)
+	zigIdentifierPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// zigArchiveEntry is a file or symlink of a package archive, with its path relative to the package root.
+type zigArchiveEntry struct {
+	path string
+	link string
+	data []byte
+}
+
+// LocatePackage finds <name>-<version>.tar.gz in the specified directory. If it is not present but the directory
+// holds a build.zig.zon, the package directory itself is packaged into a temporary archive.
+func (z *ZigHandler) LocatePackage(dir string, packageName string, packageVersion string) (string, error) {
+	filename := fmt.Sprintf("%s-%s.tar.gz", packageName, packageVersion)
+	packagePath := filepath.Join(dir, filename)
+	if fileExists(packagePath) {
+		return packagePath, nil
+	}
+	if !fileExists(filepath.Join(dir, "build.zig.zon")) {
+		return "", fmt.Errorf("expected package file not found: %s", filename)
+	}
+
+	outDir, err := newOutputDir("zig")
+	if err != nil {
+		return "", err
+	}
+	packagePath = filepath.Join(outDir, filename)
+	if err := createTarGz(dir, packagePath, skipZigEntry); err != nil {
+		return "", err
+	}
+	return packagePath, nil
+}
+
+// UpdatePackageRef extracts the package next to the nearest build.zig.zon and adds it to the dependencies there
+// as a .path dependency on the extracted package. See UpdatePackageRefWithOptions.
+func (z *ZigHandler) UpdatePackageRef(packageName string, packageFilePath string, packageRefFilePath string) error {
+	return z.UpdatePackageRefWithOptions(packageName, packageFilePath, packageRefFilePath, InstallOptions{})
+}
+
+// UpdatePackageRefWithOptions is UpdatePackageRef with install options. With the url override, the archive is
+// stored next to build.zig.zon instead and added as a .url dependency on a file URL relative to build.zig.zon,
+// which zig opens from the project's directory, with the .hash that zig computes from the package's contents.
+// Any other dependency of the same name is replaced, keeping its .lazy.
+func (z *ZigHandler) UpdatePackageRefWithOptions(packageName string, packageFilePath string, packageRefFilePath string, options InstallOptions) error {
+	if options.Workspace != "" || options.Section != "" {
+		return fmt.Errorf("workspace and section are not supported for Zig packages")
+	}
+	if options.Override != "" && options.Override != "path" && options.Override != "url" {
+		return fmt.Errorf("unsupported override %q, expected path or url", options.Override)
+	}
+
+	zonPath, err := findFileUp(packageRefFilePath, "build.zig.zon")
+	if err != nil {
+		return fmt.Errorf("finding build.zig.zon: %w", err)
+	}
+	projectDir := filepath.Dir(zonPath)
+
+	prefix, entries, err := readZigArchive(packageFilePath)
+	if err != nil {
+		return err
+	}
+	storeDir := filepath.Join(projectDir, InstallDir, "zig-packages")
+	var fields []string
+	if options.Override == "url" {
+		if err := removeOtherVersions(storeDir, packageName+"-", ".tar.gz", filepath.Base(packageFilePath)); err != nil {
+			return err
+		}
+		storedPath, err := copyFile(packageFilePath, storeDir)
+		if err != nil {
+			return fmt.Errorf("storing package: %w", err)
+		}
+		relPath, err := filepath.Rel(projectDir, storedPath)
+		if err != nil {
+			return fmt.Errorf("calculating relative path: %w", err)
+		}
+		fields = []string{
+			".url = " + strconv.Quote("file:"+filepath.ToSlash(relPath)),
+			".hash = " + strconv.Quote(zigPackageHash(entries)),
+		}
+	} else {
+		packageDir := filepath.Join(storeDir, packageName)
+		strip := 0
+		if prefix != "" {
+			strip = 1
+		}
+		if err := extractTarGz(packageFilePath, packageDir, strip); err != nil {
+			return fmt.Errorf("extracting package: %w", err)
+		}
+		relPath, err := filepath.Rel(projectDir, packageDir)
+		if err != nil {
+			return fmt.Errorf("calculating relative path: %w", err)
+		}
+		fields = []string{".path = " + strconv.Quote(filepath.ToSlash(relPath))}
+	}
+
+	data, err := os.ReadFile(zonPath)
+	if err != nil {
+		return err
+	}
+	updated, err := setZigDependency(string(data), packageName, fields)
+	if err != nil {
+		return fmt.Errorf("updating build.zig.zon: %w", err)
+	}
+	return os.WriteFile(zonPath, []byte(updated), 0644)
+}
+
+// skipZigEntry reports whether a file or directory of a package should be left out of its archive.
+func skipZigEntry(rel string, entry os.DirEntry) bool {
+	name := path.Base(rel)
+	if entry.IsDir() {
+		return name == ".git" || name == ".zig-cache" || name == "zig-cache" || name == "zig-out" || name == InstallDir
+	}
+	return false
+}
+
+// readZigArchive returns the files and symlinks of a package archive, relative to the package root. Like zig,
+// the root is the archive's top-level directory if every entry is inside it, whose name is returned too.
+func readZigArchive(archivePath string) (string, []zigArchiveEntry, error) {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return "", nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return "", nil, fmt.Errorf("reading %s: %w", filepath.Base(archivePath), err)
+	}
+	defer func() {
+		_ = gz.Close()
+	}()
+
+	var entries []zigArchiveEntry
+	prefix, shared := "", true
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", nil, fmt.Errorf("reading %s: %w", filepath.Base(archivePath), err)
+		}
+		name := path.Clean(strings.TrimPrefix(header.Name, "/"))
+		if name == "." || header.Typeflag == tar.TypeXGlobalHeader {
+			continue
+		}
+		first, rest, _ := strings.Cut(name, "/")
+		if prefix == "" {
+			prefix = first
+		}
+		// A file at the root, or a second top-level directory, means the archive has no top-level directory
+		shared = shared && first == prefix && (rest != "" || header.Typeflag == tar.TypeDir)
+
+		entry := zigArchiveEntry{path: name}
+		switch header.Typeflag {
+		case tar.TypeReg:
+			if entry.data, err = io.ReadAll(tr); err != nil {
+				return "", nil, fmt.Errorf("reading %s: %w", filepath.Base(archivePath), err)
+			}
+		case tar.TypeSymlink:
+			entry.link = header.Linkname
+		default:
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	if !shared {
+		prefix = ""
+	}
+	if prefix != "" {
+		for i := range entries {
+			entries[i].path = strings.TrimPrefix(entries[i].path, prefix+"/")
+		}
+	}
+	return prefix, entries, nil
+}
+
+// zigPackageHash returns the hash Zig 0.12 and 0.13 compute for a package, as written in the .hash of a
+// dependency: a multihash of the SHA-256 of the hashes of the package's files, sorted by path. Each file is
+// hashed from its path, two zero bytes and its contents, and each symlink from its path and target. The second
+// byte was meant to be the executable bit, but these versions always hash it as zero (ziglang/zig#17463). Only
+// the files under the .paths of the package's build.zig.zon are included.
+func zigPackageHash(entries []zigArchiveEntry) string {
+	var paths []string
+	for _, entry := range entries {
+		if entry.path == "build.zig.zon" && entry.link == "" {
+			paths = zigPackagePaths(string(entry.data))
+		}
+	}
+	included := func(p string) bool {
+		if len(paths) == 0 {
+			return true
+		}
+		for _, include := range paths {
+			if include == "" || p == include || strings.HasPrefix(p, include+"/") {
+				return true
+			}
+		}
+		return false
+	}
+
+	type fileHash struct {
+		path string
+		sum  [sha256.Size]byte
+	}
+	var hashes []fileHash
+	for _, entry := range entries {
+		if !included(entry.path) {
+			continue
+		}
+		h := sha256.New()
+		h.Write([]byte(entry.path))
+		if entry.link != "" {
+			h.Write([]byte(entry.link))
+		} else {
+			h.Write([]byte{0, 0})
+			h.Write(entry.data)
+		}
+		hash := fileHash{path: entry.path}
+		copy(hash.sum[:], h.Sum(nil))
+		hashes = append(hashes, hash)
+	}
+	sort.Slice(hashes, func(i, j int) bool { return hashes[i].path < hashes[j].path })
+
+	h := sha256.New()
+	for _, hash := range hashes {
+		h.Write(hash.sum[:])
+	}
+	// The multihash header: 0x12 for SHA-256, followed by the digest length of 0x20 bytes
+	return "1220" + hex.EncodeToString(h.Sum(nil))
+}
+
+// zigPackagePaths returns the .paths of a build.zig.zon, with any trailing slash removed, or nil if it has none.
+func zigPackagePaths(content string) []string {
+	code := maskSlashComments(content)
+	open, end, _ := zigRootStruct(code)
+	if open == -1 {
+		return nil
+	}
+	for _, item := range listItems(code, open, end) {
+		value, ok := zigFieldValue(code, item, "paths")
+		if !ok || !strings.HasPrefix(value.text, ".{") {
+			continue
+		}
+		listOpen := value.start + 1
+		var paths []string
+		for _, p := range listItems(code, listOpen, closingBracket(code, listOpen)) {
+			if unquoted, err := strconv.Unquote(code[p.Start:p.End]); err == nil {
+				paths = append(paths, strings.TrimSuffix(unquoted, "/"))
+			}
+		}
+		return paths
+	}
+	return nil
+}
+
+// zigValue is the value of a field of a ZON struct, starting at code[start].
+type zigValue struct {
+	start int
+	text  string
+}
+
+// zigRootStruct returns the positions of the braces of the anonymous struct a ZON file consists of, along with
+// its fields, or -1 and -1 if there is none.
+func zigRootStruct(code string) (int, int, []sourceSpan) {
+	start := strings.Index(code, ".{")
+	if start == -1 {
+		return -1, -1, nil
+	}
+	open := start + 1
+	end := closingBracket(code, open)
+	if end == -1 {
+		return -1, -1, nil
+	}
+	return open, end, listItems(code, open, end)
+}
+
+// zigFieldName returns the name of the ZON field code[item.Start:item.End], unquoting @"" identifiers.
+func zigFieldName(code string, item sourceSpan) (string, bool) {
+	m := zigFieldPattern.FindStringSubmatch(code[item.Start:item.End])
+	if m == nil {
+		return "", false
+	}
+	if strings.HasPrefix(m[1], "@") {
+		name, err := strconv.Unquote(m[1][1:])
+		return name, err == nil
+	}
+	return m[1], true
+}
+
+// zigFieldValue returns the value of the ZON field code[item.Start:item.End] if it is named name.
+func zigFieldValue(code string, item sourceSpan, name string) (zigValue, bool) {
+	if fieldName, ok := zigFieldName(code, item); !ok || fieldName != name {
+		return zigValue{}, false
+	}
+	text := code[item.Start:item.End]
+	eq := strings.Index(text, "=")
+	value := strings.TrimLeft(text[eq+1:], " \t\r\n")
+	return zigValue{start: item.End - len(value), text: value}, true
+}
+
+// zigFieldKey returns the ZON field name for a dependency, quoted as @"" unless it is an identifier.
+func zigFieldKey(name string) string {
+	if zigIdentifierPattern.MatchString(name) {
+		return "." + name
+	}
+	return ".@" + strconv.Quote(name)
+}
+
+// setZigDependency sets the dependency named name in the .dependencies of a build.zig.zon to a struct with the
+// given fields, keeping the .lazy field of a dependency it replaces. A .dependencies field is added before .paths
+// if there is none. Structs are written one field per line, like `zig fmt` and `zig fetch --save` do.
+func setZigDependency(content string, name string, fields []string) (string, error) {
+	code := maskSlashComments(content)
+	rootOpen, rootEnd, rootItems := zigRootStruct(code)
+	if rootOpen == -1 {
+		return "", fmt.Errorf("no anonymous struct found")
+	}
+	unit := "    "
+	if len(rootItems) > 0 {
+		unit = lineIndent(content, rootItems[0].Start)
+	}
+	key := zigFieldKey(name)
+
+	for _, rootItem := range rootItems {
+		deps, ok := zigFieldValue(code, rootItem, "dependencies")
+		if !ok {
+			continue
+		}
+		if !strings.HasPrefix(deps.text, ".{") {
+			return "", fmt.Errorf(".dependencies is not a struct")
+		}
+		depsOpen := deps.start + 1
+		depsEnd := closingBracket(code, depsOpen)
+		items := listItems(code, depsOpen, depsEnd)
+		for _, item := range items {
+			value, ok := zigFieldValue(code, item, name)
+			if !ok {
+				continue
+			}
+			valueFields := fields
+			if strings.HasPrefix(value.text, ".{") {
+				for _, field := range listItems(code, value.start+1, item.End-1) {
+					if _, ok := zigFieldValue(code, field, "lazy"); ok {
+						valueFields = append(valueFields, content[field.Start:field.End])
+					}
+				}
+			}
+			return content[:value.start] + zigStruct(lineIndent(content, item.Start), unit, valueFields) + content[item.End:], nil
+		}
+
+		indent := lineIndent(content, rootItem.Start) + unit
+		if len(items) > 0 {
+			indent = lineIndent(content, items[0].Start)
+		}
+		dependency := key + " = " + zigStruct(indent, unit, fields)
+		if len(items) == 0 && !strings.Contains(content[depsOpen:depsEnd], "\n") {
+			// An empty .{} is expanded to hold the dependency
+			return content[:depsOpen+1] + "\n" + indent + dependency + ",\n" + lineIndent(content, rootItem.Start) + content[depsEnd:], nil
+		}
+		return insertZigField(content, code, depsOpen, depsEnd, indent, dependency), nil
+	}
+
+	dependencies := ".dependencies = .{\n" + unit + unit + key + " = " + zigStruct(unit+unit, unit, fields) + ",\n" + unit + "}"
+	for _, rootItem := range rootItems {
+		if _, ok := zigFieldValue(code, rootItem, "paths"); ok {
+			lineStart := strings.LastIndex(content[:rootItem.Start], "\n") + 1
+			return content[:lineStart] + unit + dependencies + ",\n" + content[lineStart:], nil
+		}
+	}
+	return insertZigField(content, code, rootOpen, rootEnd, unit, dependencies), nil
+}
+
+// zigStruct returns an anonymous struct literal with the given fields, one per line indented by unit past indent,
+// and the closing brace at indent.
+func zigStruct(indent string, unit string, fields []string) string {
+	var b strings.Builder
+	b.WriteString(".{\n")
+	for _, field := range fields {
+		b.WriteString(indent + unit + field + ",\n")
+	}
+	b.WriteString(indent + "}")
+	return b.String()
+}
+
+// insertZigField adds field as the last field of the struct whose braces are at code[open] and code[end], on a
+// line of its own at indent with a trailing comma, adding a comma after the previous field if needed.
+func insertZigField(content string, code string, open int, end int, indent string, field string) string {
+	lineStart := strings.LastIndex(content[:end], "\n") + 1
+	if strings.TrimSpace(content[lineStart:end]) != "" {
+		// The struct closes on the line of its last field
+		return appendListItem(content, code, open, end, field)
+	}
+	last := open + 1 + len(strings.TrimRight(code[open+1:end], " \t\r\n"))
+	if last > open+1 && code[last-1] != ',' {
+		content = content[:last] + "," + content[last:]
+		lineStart++
+	}
+	return content[:lineStart] + indent + field + ",\n" + content[lineStart:]
+}
@@ -0,0 +1,355 @@
+package packages
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testZigManifest is the build.zig.zon of the package used by the tests.
+const testZigManifest = `.{
+    .name = "acme",
+    .version = "1.2.0",
+    .paths = .{
+        "build.zig",
+        "build.zig.zon",
+        "src/",
+    },
+}
+`
+
+func TestZigLocatePackage(t *testing.T) {
+	handler := &ZigHandler{}
+	dir := "../../testdata"
+
+	tempDir, err := os.MkdirTemp(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tempDir); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to remove temp folder: %v\n", err)
+		}
+	}() // Clean up
+
+	packagePath := filepath.Join(tempDir, "acme-1.2.0.tar.gz")
+	writeTestTarGz(t, packagePath, map[string]string{"acme-1.2.0/build.zig.zon": testZigManifest})
+
+	packageDir := filepath.Join(tempDir, "acme")
+	for name, content := range map[string]string{
+		"build.zig.zon":         testZigManifest,
+		"build.zig":             "",
+		"src/root.zig":          "",
+		".zig-cache/h/abc.txt":  "",
+		"zig-out/lib/libacme.a": "",
+	} {
+		path := filepath.Join(packageDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	testCases := []struct {
+		name           string
+		dir            string
+		packageVersion string
+		expectedPath   string
+		expectedFiles  []string
+		expectedError  bool
+	}{
+		{
+			name:           "find package archive",
+			dir:            tempDir,
+			packageVersion: "1.2.0",
+			expectedPath:   packagePath,
+		},
+		{
+			name:           "package source directory",
+			dir:            packageDir,
+			packageVersion: "1.3.0",
+			expectedFiles:  []string{"build.zig.zon", "build.zig", "src/root.zig"},
+		},
+		{
+			name:           "fail on non-existing archive",
+			dir:            tempDir,
+			packageVersion: "1.3.0",
+			expectedError:  true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			filePath, err := handler.LocatePackage(testCase.dir, "acme", testCase.packageVersion)
+			if testCase.expectedError {
+				if err == nil {
+					t.Fatal("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if testCase.expectedPath != "" && filePath != testCase.expectedPath {
+				t.Errorf("expected %s, got %s", testCase.expectedPath, filePath)
+			}
+			if testCase.expectedFiles == nil {
+				return
+			}
+			defer func() {
+				_ = os.RemoveAll(filepath.Dir(filePath))
+			}()
+			extracted := filepath.Join(tempDir, "extracted")
+			if err := extractTarGz(filePath, extracted, 0); err != nil {
+				t.Fatal(err)
+			}
+			for _, name := range testCase.expectedFiles {
+				if !fileExists(filepath.Join(extracted, filepath.FromSlash(name))) {
+					t.Errorf("expected %s in archive", name)
+				}
+			}
+			if fileExists(filepath.Join(extracted, ".zig-cache")) || fileExists(filepath.Join(extracted, "zig-out")) {
+				t.Error("expected build output to be left out of archive")
+			}
+		})
+	}
+}
+
+func TestZigUpdatePackageRef(t *testing.T) {
+	testCases := []struct {
+		name        string
+		packageName string
+		input       string
+		expected    string
+	}{
+		{
+			name:        "replaces url dependency keeping lazy",
+			packageName: "acme",
+			input: `.{
+    .name = "gateway",
+    .version = "0.1.0",
+    .dependencies = .{
+        // shared allocators
+        .acme = .{
+            .url = "https://git.example.com/acme/archive/v1.1.0.tar.gz",
+            .hash = "1220aaaa",
+            .lazy = true,
+        },
+        .zap = .{ .path = "../zap" },
+    },
+    .paths = .{""},
+}
+`,
+			expected: `.{
+    .name = "gateway",
+    .version = "0.1.0",
+    .dependencies = .{
+        // shared allocators
+        .acme = .{
+            .path = ".universal-packages/zig-packages/acme",
+            .lazy = true,
+        },
+        .zap = .{ .path = "../zap" },
+    },
+    .paths = .{""},
+}
+`,
+		},
+		{
+			name:        "adds quoted dependency to end of dependencies",
+			packageName: "acme-core",
+			input: `.{
+    .name = "gateway",
+    .version = "0.1.0",
+    .dependencies = .{
+        .zap = .{
+            .path = "../zap",
+        }
+    },
+}
+`,
+			expected: `.{
+    .name = "gateway",
+    .version = "0.1.0",
+    .dependencies = .{
+        .zap = .{
+            .path = "../zap",
+        },
+        .@"acme-core" = .{
+            .path = ".universal-packages/zig-packages/acme-core",
+        },
+    },
+}
+`,
+		},
+		{
+			name:        "expands empty dependencies",
+			packageName: "acme",
+			input: `.{
+    .name = "gateway",
+    .version = "0.1.0",
+    .dependencies = .{},
+    .paths = .{""},
+}
+`,
+			expected: `.{
+    .name = "gateway",
+    .version = "0.1.0",
+    .dependencies = .{
+        .acme = .{
+            .path = ".universal-packages/zig-packages/acme",
+        },
+    },
+    .paths = .{""},
+}
+`,
+		},
+		{
+			name:        "adds dependencies before paths",
+			packageName: "acme",
+			input: `.{
+  .name = "gateway",
+  .version = "0.1.0",
+  .paths = .{""},
+}
+`,
+			expected: `.{
+  .name = "gateway",
+  .version = "0.1.0",
+  .dependencies = .{
+    .acme = .{
+      .path = ".universal-packages/zig-packages/acme",
+    },
+  },
+  .paths = .{""},
+}
+`,
+		},
+	}
+
+	handler := &ZigHandler{}
+	dir := "../../testdata"
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			installTempDir, err := os.MkdirTemp(dir, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				if err := os.RemoveAll(installTempDir); err != nil {
+					fmt.Fprintf(os.Stderr, "warning: failed to remove temp folder: %v\n", err)
+				}
+			}() // Clean up
+
+			zonPath := filepath.Join(installTempDir, "build.zig.zon")
+			if err := os.WriteFile(zonPath, []byte(testCase.input), 0644); err != nil {
+				t.Fatal(err)
+			}
+			packageLocation := filepath.Join(installTempDir, ".upkg", testCase.packageName+"-1.2.0.tar.gz")
+			writeTestTarGz(t, packageLocation, map[string]string{
+				"acme-1.2.0/build.zig.zon": testZigManifest,
+				"acme-1.2.0/src/root.zig":  "",
+			})
+
+			if err := handler.UpdatePackageRef(testCase.packageName, packageLocation, installTempDir); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			updated, err := os.ReadFile(zonPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(updated) != testCase.expected {
+				t.Errorf("expected:\n%s\ngot:\n%s", testCase.expected, updated)
+			}
+			if !fileExists(filepath.Join(installTempDir, InstallDir, "zig-packages", testCase.packageName, "src", "root.zig")) {
+				t.Error("expected package to be extracted")
+			}
+		})
+	}
+}
+
+func TestZigUpdatePackageRefHash(t *testing.T) {
+	handler := &ZigHandler{}
+	dir := "../../testdata"
+
+	installTempDir, err := os.MkdirTemp(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(installTempDir); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to remove temp folder: %v\n", err)
+		}
+	}() // Clean up
+
+	zonPath := filepath.Join(installTempDir, "build.zig.zon")
+	if err := os.WriteFile(zonPath, []byte(".{\n    .name = \"gateway\",\n    .version = \"0.1.0\",\n}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// The expected hash was computed outside this package with Zig 0.13's algorithm. It leaves out examples/,
+	// which is not under the package's .paths, and does not depend on gen.sh being executable.
+	packageDir := filepath.Join(installTempDir, "acme-1.2.0")
+	for name, content := range map[string]string{
+		"build.zig":      "const std = @import(\"std\");\n",
+		"build.zig.zon":  testZigManifest,
+		"src/root.zig":   "pub fn add(a: i32, b: i32) i32 {\n    return a + b;\n}\n",
+		"src/gen.sh":     "#!/bin/sh\necho generated\n",
+		"examples/a.zig": "",
+	} {
+		path := filepath.Join(packageDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Chmod(filepath.Join(packageDir, "src", "gen.sh"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	oldPackage := filepath.Join(installTempDir, InstallDir, "zig-packages", "acme-1.1.0.tar.gz")
+	writeTestTarGz(t, oldPackage, map[string]string{"acme-1.1.0/build.zig.zon": testZigManifest})
+	packageLocation := filepath.Join(installTempDir, InstallDir, "acme", "acme-1.2.0.tar.gz")
+	if err := os.MkdirAll(filepath.Dir(packageLocation), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := createTarGz(packageDir, packageLocation, skipZigEntry); err != nil {
+		t.Fatal(err)
+	}
+
+	err = handler.UpdatePackageRefWithOptions("acme", packageLocation, installTempDir, InstallOptions{Override: "url"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if fileExists(oldPackage) {
+		t.Error("expected previous version to be removed")
+	}
+	if !fileExists(filepath.Join(installTempDir, InstallDir, "zig-packages", "acme-1.2.0.tar.gz")) {
+		t.Error("expected package to be stored")
+	}
+	expected := `.{
+    .name = "gateway",
+    .version = "0.1.0",
+    .dependencies = .{
+        .acme = .{
+            .url = "file:.universal-packages/zig-packages/acme-1.2.0.tar.gz",
+            .hash = "122001e85e5311570b21432a8dc7c0792243b07094932205bdee52cd25e0b1d99419",
+        },
+    },
+}
+`
+
+	updated, err := os.ReadFile(zonPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(updated) != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, updated)
+	}
+}